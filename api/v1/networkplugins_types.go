@@ -30,6 +30,9 @@ type NetworkPluginsSpec struct {
 
 	Plugins  *Plugins `json:"plugins,omitempty"`
 	Registry string   `json:"privateRegistryBase,omitempty"`
+	// BundleVersion pins the plugin images to a released bundle. If empty the
+	// active bundle is kept, or the latest bundle is used on a fresh install
+	BundleVersion string `json:"bundleVersion,omitempty"`
 }
 
 type Plugins struct {
@@ -49,13 +52,31 @@ type Ovs struct {
 	CNIImage        string `json:"cniImage,omitempty"`
 	MarkerImage     string `json:"markerImage,omitempty"`
 	DPDK            *Dpdk  `json:"dpdk,omitempty"`
+	// OtherConfig is set as other_config:<key>=<value> on the Open_vSwitch table
+	OtherConfig map[string]string `json:"otherConfig,omitempty"`
+	// ExternalIds is set as external_ids:<key>=<value> on the Open_vSwitch table,
+	// e.g. ovn-bridge-mappings or ovn-encap-ip
+	ExternalIds map[string]string `json:"externalIds,omitempty"`
 }
 
 type Dpdk struct {
-	LcoreMask      string `json:"lcoreMask"`
-	SocketMem      string `json:"socketMem"`
-	PmdCpuMask     string `json:"pmdCpuMask"`
-	HugepageMemory string `json:"hugepageMemory"`
+	LcoreMask string `json:"lcoreMask"`
+	// SocketMem is the raw dpdk-socket-mem string, e.g. "1024,2048".
+	// Either SocketMem or SocketMemory must be set.
+	SocketMem string `json:"socketMem,omitempty"`
+	// SocketMemory is the hugepage memory in MB to pre-allocate per NUMA socket
+	SocketMemory   []DpdkSocketMemory `json:"socketMemory,omitempty"`
+	PmdCpuMask     string             `json:"pmdCpuMask"`
+	HugepageMemory string             `json:"hugepageMemory"`
+	// ExtraArgs is passed as-is to other_config:dpdk-extra
+	ExtraArgs string `json:"dpdkExtra,omitempty"`
+	// VhostSockDir is the host directory for vhost-user sockets, defaults to /var/lib/vhost_sockets
+	VhostSockDir string `json:"vhostSockDir,omitempty"`
+}
+
+type DpdkSocketMemory struct {
+	Socket   int `json:"socket"`
+	MemoryMB int `json:"memoryMB"`
 }
 
 type NodeFeatureDiscovery struct {
@@ -99,16 +120,71 @@ type DhcpController struct {
 	DhcpControllerImage   string `json:"DHCPControllerImage,omitempty"`
 	KubemacpoolRangeStart string `json:"kubemacpoolRangeStart,omitempty"`
 	KubemacpoolRangeEnd   string `json:"kubemacpoolRangeEnd,omitempty"`
+	// KubemacpoolRanges supersedes KubemacpoolRangeStart/KubemacpoolRangeEnd.
+	// KubeMacPool allocates from a single range, so the ranges are merged into
+	// one. Ranges that overlap, or leave a gap between them, are rejected and
+	// reported in the KubemacpoolRangesValid condition.
+	KubemacpoolRanges []MacRange `json:"kubemacpoolRanges,omitempty"`
+	// PodNamespaceSelector selects the namespaces whose Pods get a MAC allocated.
+	// Defaults to namespaces labelled mutatepods.kubemacpool.io=allocate (opt-in)
+	PodNamespaceSelector *metav1.LabelSelector `json:"podNamespaceSelector,omitempty"`
+	// VmNamespaceSelector selects the namespaces whose VirtualMachines get a MAC allocated.
+	// Defaults to namespaces not labelled mutatevirtualmachines.kubemacpool.io=ignore (opt-out)
+	VmNamespaceSelector *metav1.LabelSelector `json:"vmNamespaceSelector,omitempty"`
+	// MacAllocationWaitTime is the time in seconds a MAC stays reserved for a
+	// VirtualMachine whose creation has not been confirmed, defaults to 300
+	MacAllocationWaitTime *int `json:"macAllocationWaitTime,omitempty"`
+}
+
+type MacRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
 }
 
 // NetworkPluginsStatus defines the observed state of NetworkPlugins
 type NetworkPluginsStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	Kubemacpool *KubemacpoolStatus `json:"kubemacpool,omitempty"`
+	// Conditions holds KubemacpoolRangesValid while dhcpController is set
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// ActiveBundle is the plugin bundle version last applied successfully
+	ActiveBundle      string `json:"activeBundle,omitempty"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+}
+
+// ConditionKubemacpoolRangesValid is False when the KubeMacPool ranges cannot
+// be merged into a single range, which leaves the DHCP controller unchanged
+const ConditionKubemacpoolRangesValid = "KubemacpoolRangesValid"
+
+type KubemacpoolStatus struct {
+	Ranges []MacRangeStatus `json:"ranges,omitempty"`
+	// Collisions are the MACs requested by more than one Pod or VirtualMachine
+	Collisions  []MacCollision `json:"collisions,omitempty"`
+	LastUpdated metav1.Time    `json:"lastUpdated,omitempty"`
+}
+
+type MacCollision struct {
+	Mac string `json:"mac"`
+	// Owners are the objects requesting the MAC, as "Pod <namespace>/<name>"
+	// or "VirtualMachine <namespace>/<name>"
+	Owners []string `json:"owners"`
+}
+
+type MacRangeStatus struct {
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Total     int64  `json:"total"`
+	Allocated int64  `json:"allocated"`
+	// Utilization is the percentage of the range allocated, e.g. "12.50%"
+	Utilization string `json:"utilization"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Bundle",type=string,JSONPath=`.status.activeBundle`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // NetworkPlugins is the Schema for the networkplugins API
 type NetworkPlugins struct {
@@ -21,12 +21,33 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DhcpController) DeepCopyInto(out *DhcpController) {
 	*out = *in
+	if in.KubemacpoolRanges != nil {
+		in, out := &in.KubemacpoolRanges, &out.KubemacpoolRanges
+		*out = make([]MacRange, len(*in))
+		copy(*out, *in)
+	}
+	if in.PodNamespaceSelector != nil {
+		in, out := &in.PodNamespaceSelector, &out.PodNamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VmNamespaceSelector != nil {
+		in, out := &in.VmNamespaceSelector, &out.VmNamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MacAllocationWaitTime != nil {
+		in, out := &in.MacAllocationWaitTime, &out.MacAllocationWaitTime
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DhcpController.
@@ -42,6 +63,11 @@ func (in *DhcpController) DeepCopy() *DhcpController {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Dpdk) DeepCopyInto(out *Dpdk) {
 	*out = *in
+	if in.SocketMemory != nil {
+		in, out := &in.SocketMemory, &out.SocketMemory
+		*out = make([]DpdkSocketMemory, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Dpdk.
@@ -54,6 +80,21 @@ func (in *Dpdk) DeepCopy() *Dpdk {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DpdkSocketMemory) DeepCopyInto(out *DpdkSocketMemory) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DpdkSocketMemory.
+func (in *DpdkSocketMemory) DeepCopy() *DpdkSocketMemory {
+	if in == nil {
+		return nil
+	}
+	out := new(DpdkSocketMemory)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostPlumber) DeepCopyInto(out *HostPlumber) {
 	*out = *in
@@ -69,6 +110,84 @@ func (in *HostPlumber) DeepCopy() *HostPlumber {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubemacpoolStatus) DeepCopyInto(out *KubemacpoolStatus) {
+	*out = *in
+	if in.Ranges != nil {
+		in, out := &in.Ranges, &out.Ranges
+		*out = make([]MacRangeStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Collisions != nil {
+		in, out := &in.Collisions, &out.Collisions
+		*out = make([]MacCollision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.LastUpdated.DeepCopyInto(&out.LastUpdated)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubemacpoolStatus.
+func (in *KubemacpoolStatus) DeepCopy() *KubemacpoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KubemacpoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MacCollision) DeepCopyInto(out *MacCollision) {
+	*out = *in
+	if in.Owners != nil {
+		in, out := &in.Owners, &out.Owners
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MacCollision.
+func (in *MacCollision) DeepCopy() *MacCollision {
+	if in == nil {
+		return nil
+	}
+	out := new(MacCollision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MacRange) DeepCopyInto(out *MacRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MacRange.
+func (in *MacRange) DeepCopy() *MacRange {
+	if in == nil {
+		return nil
+	}
+	out := new(MacRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MacRangeStatus) DeepCopyInto(out *MacRangeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MacRangeStatus.
+func (in *MacRangeStatus) DeepCopy() *MacRangeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MacRangeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Multus) DeepCopyInto(out *Multus) {
 	*out = *in
@@ -90,7 +209,7 @@ func (in *NetworkPlugins) DeepCopyInto(out *NetworkPlugins) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkPlugins.
@@ -166,6 +285,18 @@ func (in *NetworkPluginsSpec) DeepCopy() *NetworkPluginsSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NetworkPluginsStatus) DeepCopyInto(out *NetworkPluginsStatus) {
 	*out = *in
+	if in.Kubemacpool != nil {
+		in, out := &in.Kubemacpool, &out.Kubemacpool
+		*out = new(KubemacpoolStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkPluginsStatus.
@@ -199,7 +330,21 @@ func (in *Ovs) DeepCopyInto(out *Ovs) {
 	if in.DPDK != nil {
 		in, out := &in.DPDK, &out.DPDK
 		*out = new(Dpdk)
-		**out = **in
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OtherConfig != nil {
+		in, out := &in.OtherConfig, &out.OtherConfig
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ExternalIds != nil {
+		in, out := &in.ExternalIds, &out.ExternalIds
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
 	}
 }
 
@@ -249,7 +394,7 @@ func (in *Plugins) DeepCopyInto(out *Plugins) {
 	if in.DhcpController != nil {
 		in, out := &in.DhcpController, &out.DhcpController
 		*out = new(DhcpController)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 }
 
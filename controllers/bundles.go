@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// Keys of the images section of a bundle manifest
+	MultusImageKey         = "multus"
+	WhereaboutsImageKey    = "whereabouts"
+	SriovCniImageKey       = "sriovCni"
+	SriovDpImageKey        = "sriovDevicePlugin"
+	OvsImageKey            = "ovs"
+	OvsCniImageKey         = "ovsCni"
+	OvsMarkerImageKey      = "ovsMarker"
+	HostPlumberImageKey    = "hostPlumber"
+	DhcpControllerImageKey = "dhcpController"
+	KubemacpoolImageKey    = "kubemacpool"
+	KubeRbacProxyImageKey  = "kubeRbacProxy"
+	NfdImageKey            = "nfd"
+)
+
+var bundleImageKeys = []string{
+	MultusImageKey, WhereaboutsImageKey, SriovCniImageKey, SriovDpImageKey,
+	OvsImageKey, OvsCniImageKey, OvsMarkerImageKey, HostPlumberImageKey,
+	DhcpControllerImageKey, KubemacpoolImageKey, KubeRbacProxyImageKey, NfdImageKey,
+}
+
+// PluginBundle pins the image of every plugin for one release, so upgrading
+// the operator does not upgrade the plugins unless a new bundle is selected
+type PluginBundle struct {
+	Version    string            `json:"version"`
+	Kubernetes KubernetesRange   `json:"kubernetes,omitempty"`
+	Images     map[string]string `json:"images"`
+}
+
+// KubernetesRange is the range of Kubernetes minor versions a bundle supports.
+// An empty MinVersion or MaxVersion leaves that end unbounded.
+type KubernetesRange struct {
+	MinVersion string `json:"minVersion,omitempty"`
+	MaxVersion string `json:"maxVersion,omitempty"`
+}
+
+// Image returns the bundle image for key with the registry replaced
+func (b *PluginBundle) Image(key, registry string) string {
+	return ReplaceContainerRegistry(b.Images[key], registry)
+}
+
+func (b *PluginBundle) validate() error {
+	if b.Version == "" {
+		return fmt.Errorf("bundle has no version")
+	}
+	for _, key := range bundleImageKeys {
+		if b.Images[key] == "" {
+			return fmt.Errorf("bundle %s is missing image %s", b.Version, key)
+		}
+	}
+	for _, v := range []string{b.Kubernetes.MinVersion, b.Kubernetes.MaxVersion} {
+		if v == "" {
+			continue
+		}
+		if _, err := version.ParseGeneric(v); err != nil {
+			return fmt.Errorf("bundle %s has invalid kubernetes version %s: %w", b.Version, v, err)
+		}
+	}
+	return nil
+}
+
+// CheckKubernetesVersion returns an error if the server's major.minor version
+// is outside of the bundle's supported range
+func (b *PluginBundle) CheckKubernetesVersion(serverVersion string) error {
+	server, err := version.ParseGeneric(serverVersion)
+	if err != nil {
+		return err
+	}
+	serverMinor := version.MajorMinor(server.Major(), server.Minor())
+
+	if b.Kubernetes.MinVersion != "" {
+		min, _ := version.ParseGeneric(b.Kubernetes.MinVersion)
+		if serverMinor.LessThan(version.MajorMinor(min.Major(), min.Minor())) {
+			return fmt.Errorf("bundle %s requires Kubernetes >= %s, server is %s", b.Version, b.Kubernetes.MinVersion, serverVersion)
+		}
+	}
+	if b.Kubernetes.MaxVersion != "" {
+		max, _ := version.ParseGeneric(b.Kubernetes.MaxVersion)
+		if version.MajorMinor(max.Major(), max.Minor()).LessThan(serverMinor) {
+			return fmt.Errorf("bundle %s supports Kubernetes <= %s, server is %s", b.Version, b.Kubernetes.MaxVersion, serverVersion)
+		}
+	}
+	return nil
+}
+
+// LoadPluginBundles reads every bundle manifest in dir, keyed by version
+func LoadPluginBundles(dir string) (map[string]*PluginBundle, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := make(map[string]*PluginBundle)
+	for _, file := range files {
+		fd, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		bundle := &PluginBundle{}
+		err = yaml.NewYAMLOrJSONDecoder(fd, 4096).Decode(bundle)
+		fd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode bundle %s: %w", file, err)
+		}
+		if err := bundle.validate(); err != nil {
+			return nil, fmt.Errorf("invalid bundle %s: %w", file, err)
+		}
+		if _, ok := bundles[bundle.Version]; ok {
+			return nil, fmt.Errorf("bundle version %s defined more than once", bundle.Version)
+		}
+		bundles[bundle.Version] = bundle
+	}
+	if len(bundles) == 0 {
+		return nil, fmt.Errorf("no plugin bundles found in %s", dir)
+	}
+	return bundles, nil
+}
+
+// LatestBundleVersion returns the highest bundle version
+func LatestBundleVersion(bundles map[string]*PluginBundle) string {
+	var versions []string
+	for v := range bundles {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		vi, erri := version.ParseGeneric(versions[i])
+		vj, errj := version.ParseGeneric(versions[j])
+		if erri != nil || errj != nil {
+			return versions[i] < versions[j]
+		}
+		return vi.LessThan(vj)
+	})
+	return versions[len(versions)-1]
+}
+
+// SelectBundle picks the bundle to apply: the requested version if set, else
+// the currently active one so an operator upgrade keeps the running plugins,
+// else the latest bundle for a fresh install. An active bundle this operator
+// no longer ships is an error rather than an upgrade: nothing is applied, and
+// the deployed images stay, until bundleVersion is set.
+func SelectBundle(bundles map[string]*PluginBundle, requested, active string) (*PluginBundle, error) {
+	if requested != "" {
+		bundle, ok := bundles[requested]
+		if !ok {
+			return nil, fmt.Errorf("unknown bundleVersion %s", requested)
+		}
+		return bundle, nil
+	}
+	if active == "" {
+		return bundles[LatestBundleVersion(bundles)], nil
+	}
+	bundle, ok := bundles[active]
+	if !ok {
+		return nil, fmt.Errorf("active bundle %s is no longer available, set bundleVersion to choose the bundle to apply", active)
+	}
+	return bundle, nil
+}
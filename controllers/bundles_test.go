@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestSelectBundle(t *testing.T) {
+	bundles := map[string]*PluginBundle{
+		"1.0.0":  {Version: "1.0.0"},
+		"1.2.0":  {Version: "1.2.0"},
+		"1.10.0": {Version: "1.10.0"},
+	}
+	tests := []struct {
+		name      string
+		requested string
+		active    string
+		want      string
+		wantErr   bool
+	}{
+		{name: "fresh install", want: "1.10.0"},
+		{name: "active kept", active: "1.0.0", want: "1.0.0"},
+		{name: "requested", requested: "1.2.0", active: "1.0.0", want: "1.2.0"},
+		{name: "unknown requested", requested: "2.0.0", active: "1.0.0", wantErr: true},
+		{name: "active removed", active: "0.9.0", wantErr: true},
+		{name: "active removed, requested", requested: "1.10.0", active: "0.9.0", want: "1.10.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle, err := SelectBundle(bundles, tt.requested, tt.active)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && bundle.Version != tt.want {
+				t.Errorf("SelectBundle() = %s, want %s", bundle.Version, tt.want)
+			}
+		})
+	}
+}
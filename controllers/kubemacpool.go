@@ -0,0 +1,364 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	plumberv1 "github.com/platform9/luigi/api/v1"
+)
+
+const (
+	KubemacpoolWaitTime       = 300
+	KubemacpoolStatusInterval = 5 * time.Minute
+	PodNetworksAnnotation     = "k8s.v1.cni.cncf.io/networks"
+	// virtLauncherLabel is set to virt-launcher on the Pods running VMs
+	virtLauncherLabel = "kubevirt.io"
+)
+
+var (
+	// Namespaces in these run levels are never handed to KubeMacPool
+	runlevelExclusions = []metav1.LabelSelectorRequirement{
+		{Key: "runlevel", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"0", "1"}},
+		{Key: "openshift.io/run-level", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"0", "1"}},
+	}
+	defaultPodNamespaceSelector = metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "mutatepods.kubemacpool.io", Operator: metav1.LabelSelectorOpIn, Values: []string{"allocate"}},
+		},
+	}
+	defaultVmNamespaceSelector = metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "mutatevirtualmachines.kubemacpool.io", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"ignore"}},
+		},
+	}
+	virtualMachineListGVK = schema.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineList"}
+)
+
+func parseMac(mac string) (uint64, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return 0, err
+	}
+	if len(hw) != 6 {
+		return 0, fmt.Errorf("%s is not a 48-bit MAC address", mac)
+	}
+	var val uint64
+	for _, b := range hw {
+		val = val<<8 | uint64(b)
+	}
+	return val, nil
+}
+
+func formatMac(val uint64) string {
+	hw := make(net.HardwareAddr, 6)
+	for i := 5; i >= 0; i-- {
+		hw[i] = byte(val & 0xff)
+		val >>= 8
+	}
+	return hw.String()
+}
+
+// kubemacpoolRanges returns the configured MAC ranges, falling back to the
+// single start/end fields and then to the defaults
+func kubemacpoolRanges(dhcpControllerConfig *plumberv1.DhcpController) []plumberv1.MacRange {
+	if len(dhcpControllerConfig.KubemacpoolRanges) > 0 {
+		return dhcpControllerConfig.KubemacpoolRanges
+	}
+
+	macRange := plumberv1.MacRange{Start: KubemacpoolRangeStart, End: KubemacpoolRangeEnd}
+	if dhcpControllerConfig.KubemacpoolRangeStart != "" {
+		macRange.Start = dhcpControllerConfig.KubemacpoolRangeStart
+	}
+	if dhcpControllerConfig.KubemacpoolRangeEnd != "" {
+		macRange.End = dhcpControllerConfig.KubemacpoolRangeEnd
+	}
+	return []plumberv1.MacRange{macRange}
+}
+
+// macRangeError is a set of ranges KubeMacPool cannot be configured with.
+// Reason is the reason of the KubemacpoolRangesValid condition.
+type macRangeError struct {
+	Reason  string
+	Message string
+}
+
+func (e *macRangeError) Error() string {
+	return e.Message
+}
+
+// mergeMacRanges validates the ranges and merges them into the single range
+// KubeMacPool is configured with. Ranges must follow each other once sorted:
+// KubeMacPool would allocate from a gap between two ranges, and overlapping
+// ranges are most likely a mistake.
+func mergeMacRanges(ranges []plumberv1.MacRange) (string, string, error) {
+	type span struct {
+		start, end uint64
+		macRange   plumberv1.MacRange
+	}
+	var spans []span
+	for _, macRange := range ranges {
+		start, err := parseMac(macRange.Start)
+		if err != nil {
+			return "", "", &macRangeError{"InvalidRange", fmt.Sprintf("invalid MAC range start %s: %v", macRange.Start, err)}
+		}
+		end, err := parseMac(macRange.End)
+		if err != nil {
+			return "", "", &macRangeError{"InvalidRange", fmt.Sprintf("invalid MAC range end %s: %v", macRange.End, err)}
+		}
+		if start > end {
+			return "", "", &macRangeError{"InvalidRange", fmt.Sprintf("MAC range start %s is after end %s", macRange.Start, macRange.End)}
+		}
+		// The I/G bit is the lowest bit of the first octet
+		if (start>>40)&1 == 1 || (end>>40)&1 == 1 {
+			return "", "", &macRangeError{"InvalidRange", fmt.Sprintf("MAC range %s-%s contains multicast addresses", macRange.Start, macRange.End)}
+		}
+		spans = append(spans, span{start, end, macRange})
+	}
+	if len(spans) == 0 {
+		return "", "", &macRangeError{"InvalidRange", "no MAC ranges specified"}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	prev := spans[0]
+	end := prev.end
+	for _, s := range spans[1:] {
+		if s.start <= end {
+			return "", "", &macRangeError{"OverlappingRanges", fmt.Sprintf("MAC ranges %s-%s and %s-%s overlap",
+				prev.macRange.Start, prev.macRange.End, s.macRange.Start, s.macRange.End)}
+		}
+		if s.start != end+1 {
+			return "", "", &macRangeError{"DisjointRanges", fmt.Sprintf("MAC ranges %s-%s and %s-%s leave a gap from %s to %s, "+
+				"KubeMacPool allocates from a single range", prev.macRange.Start, prev.macRange.End, s.macRange.Start, s.macRange.End,
+				formatMac(end+1), formatMac(s.start-1))}
+		}
+		prev, end = s, s.end
+	}
+	return formatMac(spans[0].start), formatMac(end), nil
+}
+
+// kubemacpoolRangesCondition returns the KubemacpoolRangesValid condition of
+// the ranges, and the error that makes it False
+func kubemacpoolRangesCondition(dhcpControllerConfig *plumberv1.DhcpController, generation int64) (metav1.Condition, error) {
+	condition := metav1.Condition{
+		Type:               plumberv1.ConditionKubemacpoolRangesValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Merged",
+		ObservedGeneration: generation,
+	}
+	start, end, err := mergeMacRanges(kubemacpoolRanges(dhcpControllerConfig))
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidRange"
+		var rangeErr *macRangeError
+		if errors.As(err, &rangeErr) {
+			condition.Reason = rangeErr.Reason
+		}
+		condition.Message = err.Error()
+		return condition, err
+	}
+	condition.Message = fmt.Sprintf("KubeMacPool allocates from %s to %s", start, end)
+	return condition, nil
+}
+
+// namespaceSelector returns the selector KubeMacPool's webhook uses, always
+// excluding the privileged run levels
+func namespaceSelector(selector *metav1.LabelSelector, defaultSelector metav1.LabelSelector) metav1.LabelSelector {
+	if selector == nil {
+		selector = &defaultSelector
+	}
+	return metav1.LabelSelector{
+		MatchLabels:      selector.MatchLabels,
+		MatchExpressions: append(append([]metav1.LabelSelectorRequirement{}, runlevelExclusions...), selector.MatchExpressions...),
+	}
+}
+
+// namespaceSelectorJSON renders the webhook namespaceSelector
+func namespaceSelectorJSON(selector *metav1.LabelSelector, defaultSelector metav1.LabelSelector) (string, error) {
+	rendered := namespaceSelector(selector, defaultSelector)
+	if _, err := metav1.LabelSelectorAsSelector(&rendered); err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(rendered)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// selectedNamespaces returns the names of the namespaces matching the
+// KubeMacPool webhook selector
+func selectedNamespaces(namespaces []corev1.Namespace, selector *metav1.LabelSelector, defaultSelector metav1.LabelSelector) ([]string, error) {
+	rendered := namespaceSelector(selector, defaultSelector)
+	sel, err := metav1.LabelSelectorAsSelector(&rendered)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ns := range namespaces {
+		if sel.Matches(labels.Set(ns.Labels)) {
+			names = append(names, ns.Name)
+		}
+	}
+	return names, nil
+}
+
+// macOwners records the objects requesting each MAC, each object once
+type macOwners map[uint64][]string
+
+func (m macOwners) add(mac uint64, owner string) {
+	for _, o := range m[mac] {
+		if o == owner {
+			return
+		}
+	}
+	m[mac] = append(m[mac], owner)
+}
+
+// getAllocatedMacs returns every MAC requested by Pods on secondary networks
+// and by KubeVirt VirtualMachines in the namespaces KubeMacPool manages, with
+// the objects requesting it
+func (r *NetworkPluginsReconciler) getAllocatedMacs(ctx context.Context, dhcpControllerConfig *plumberv1.DhcpController) (macOwners, error) {
+	macs := make(macOwners)
+
+	nsList := &corev1.NamespaceList{}
+	if err := r.List(ctx, nsList); err != nil {
+		return nil, err
+	}
+	podNamespaces, err := selectedNamespaces(nsList.Items, dhcpControllerConfig.PodNamespaceSelector, defaultPodNamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid podNamespaceSelector: %w", err)
+	}
+	vmNamespaces, err := selectedNamespaces(nsList.Items, dhcpControllerConfig.VmNamespaceSelector, defaultVmNamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vmNamespaceSelector: %w", err)
+	}
+
+	for _, ns := range podNamespaces {
+		podList := &corev1.PodList{}
+		if err := r.List(ctx, podList, client.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+		for _, pod := range podList.Items {
+			addPodMacs(macs, &pod)
+		}
+	}
+
+	for _, ns := range vmNamespaces {
+		vmList := &unstructured.UnstructuredList{}
+		vmList.SetGroupVersionKind(virtualMachineListGVK)
+		if err := r.List(ctx, vmList, client.InNamespace(ns)); err != nil {
+			if meta.IsNoMatchError(err) {
+				// KubeVirt is not installed
+				return macs, nil
+			}
+			return nil, err
+		}
+		for i := range vmList.Items {
+			addVmMacs(macs, &vmList.Items[i])
+		}
+	}
+	return macs, nil
+}
+
+// addPodMacs records the MACs a Pod requests in its networks annotation. The
+// virt-launcher Pods of VMs are skipped, like KubeMacPool does, their MACs
+// are the ones of the VirtualMachine.
+func addPodMacs(macs macOwners, pod *corev1.Pod) {
+	if pod.Labels[virtLauncherLabel] == "virt-launcher" {
+		return
+	}
+	networks, ok := pod.Annotations[PodNetworksAnnotation]
+	if !ok {
+		return
+	}
+	var elements []nettypes.NetworkSelectionElement
+	if err := json.Unmarshal([]byte(networks), &elements); err != nil {
+		// Short form "net1,net2" annotations carry no MACs
+		return
+	}
+	for _, element := range elements {
+		if mac, err := parseMac(element.MacRequest); err == nil {
+			macs.add(mac, fmt.Sprintf("Pod %s/%s", pod.Namespace, pod.Name))
+		}
+	}
+}
+
+// addVmMacs records the MACs of the interfaces of a VirtualMachine
+func addVmMacs(macs macOwners, vm *unstructured.Unstructured) {
+	interfaces, _, _ := unstructured.NestedSlice(vm.Object, "spec", "template", "spec", "domain", "devices", "interfaces")
+	for _, iface := range interfaces {
+		ifaceMap, ok := iface.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		macStr, _, _ := unstructured.NestedString(ifaceMap, "macAddress")
+		if mac, err := parseMac(macStr); err == nil {
+			macs.add(mac, fmt.Sprintf("VirtualMachine %s/%s", vm.GetNamespace(), vm.GetName()))
+		}
+	}
+}
+
+// kubemacpoolStatus computes the utilization of each range and lists the
+// MACs requested more than once, sorted
+func kubemacpoolStatus(ranges []plumberv1.MacRange, macs macOwners) (*plumberv1.KubemacpoolStatus, error) {
+	status := &plumberv1.KubemacpoolStatus{LastUpdated: metav1.Now()}
+	for _, macRange := range ranges {
+		start, err := parseMac(macRange.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseMac(macRange.End)
+		if err != nil {
+			return nil, err
+		}
+
+		rangeStatus := plumberv1.MacRangeStatus{
+			Start: macRange.Start,
+			End:   macRange.End,
+			Total: int64(end - start + 1),
+		}
+		for mac := range macs {
+			if mac >= start && mac <= end {
+				rangeStatus.Allocated++
+			}
+		}
+		rangeStatus.Utilization = fmt.Sprintf("%.2f%%", float64(rangeStatus.Allocated)*100/float64(rangeStatus.Total))
+		status.Ranges = append(status.Ranges, rangeStatus)
+	}
+
+	var collisions []uint64
+	for mac, owners := range macs {
+		if len(owners) > 1 {
+			collisions = append(collisions, mac)
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i] < collisions[j] })
+	for _, mac := range collisions {
+		owners := append([]string{}, macs[mac]...)
+		sort.Strings(owners)
+		status.Collisions = append(status.Collisions, plumberv1.MacCollision{Mac: formatMac(mac), Owners: owners})
+	}
+	return status, nil
+}
+
+func (r *NetworkPluginsReconciler) getKubemacpoolStatus(ctx context.Context, dhcpControllerConfig *plumberv1.DhcpController) (*plumberv1.KubemacpoolStatus, error) {
+	macs, err := r.getAllocatedMacs(ctx, dhcpControllerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubemacpoolStatus(kubemacpoolRanges(dhcpControllerConfig), macs)
+}
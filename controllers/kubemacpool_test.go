@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	plumberv1 "github.com/platform9/luigi/api/v1"
+)
+
+func TestMergeMacRanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		ranges     []plumberv1.MacRange
+		wantStart  string
+		wantEnd    string
+		wantReason string
+	}{
+		{
+			name:      "single range",
+			ranges:    []plumberv1.MacRange{{Start: "02:55:43:00:00:00", End: "02:55:43:FF:FF:FF"}},
+			wantStart: "02:55:43:00:00:00",
+			wantEnd:   "02:55:43:ff:ff:ff",
+		},
+		{
+			name: "contiguous ranges out of order",
+			ranges: []plumberv1.MacRange{
+				{Start: "02:55:43:80:00:00", End: "02:55:43:FF:FF:FF"},
+				{Start: "02:55:43:00:00:00", End: "02:55:43:7F:FF:FF"},
+			},
+			wantStart: "02:55:43:00:00:00",
+			wantEnd:   "02:55:43:ff:ff:ff",
+		},
+		{
+			name:       "no range",
+			wantReason: "InvalidRange",
+		},
+		{
+			name: "disjoint ranges",
+			ranges: []plumberv1.MacRange{
+				{Start: "02:55:43:00:00:00", End: "02:55:43:7F:FF:FF"},
+				{Start: "02:55:44:00:00:00", End: "02:55:44:FF:FF:FF"},
+			},
+			wantReason: "DisjointRanges",
+		},
+		{
+			name: "overlapping ranges",
+			ranges: []plumberv1.MacRange{
+				{Start: "02:55:43:00:00:00", End: "02:55:43:80:00:00"},
+				{Start: "02:55:43:80:00:00", End: "02:55:43:FF:FF:FF"},
+			},
+			wantReason: "OverlappingRanges",
+		},
+		{
+			name:       "start after end",
+			ranges:     []plumberv1.MacRange{{Start: "02:55:43:FF:00:00", End: "02:55:43:00:00:00"}},
+			wantReason: "InvalidRange",
+		},
+		{
+			name:       "multicast",
+			ranges:     []plumberv1.MacRange{{Start: "03:55:43:00:00:00", End: "03:55:43:FF:FF:FF"}},
+			wantReason: "InvalidRange",
+		},
+		{
+			name:       "invalid MAC",
+			ranges:     []plumberv1.MacRange{{Start: "02:55:43:00:00", End: "02:55:43:FF:FF:FF"}},
+			wantReason: "InvalidRange",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := mergeMacRanges(tt.ranges)
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("mergeMacRanges() = %s-%s, want %s-%s", start, end, tt.wantStart, tt.wantEnd)
+			}
+
+			condition, condErr := kubemacpoolRangesCondition(&plumberv1.DhcpController{KubemacpoolRanges: tt.ranges}, 3)
+			if tt.wantReason == "" {
+				if err != nil || condErr != nil || condition.Status != metav1.ConditionTrue {
+					t.Fatalf("unexpected error %v, condition %+v", err, condition)
+				}
+				return
+			}
+			var rangeErr *macRangeError
+			if !errors.As(err, &rangeErr) || rangeErr.Reason != tt.wantReason {
+				t.Fatalf("got error %v, want reason %s", err, tt.wantReason)
+			}
+			if tt.ranges == nil {
+				// Without ranges the condition is computed on the defaults
+				return
+			}
+			if condErr == nil || condition.Status != metav1.ConditionFalse || condition.Reason != tt.wantReason ||
+				condition.Message != err.Error() || condition.ObservedGeneration != 3 {
+				t.Errorf("unexpected condition %+v", condition)
+			}
+		})
+	}
+}
+
+func TestKubemacpoolStatus(t *testing.T) {
+	macs := make(macOwners)
+	pod := func(name string, labels map[string]string, networks string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "ns",
+			Name:        name,
+			Labels:      labels,
+			Annotations: map[string]string{PodNetworksAnnotation: networks},
+		}}
+	}
+	addPodMacs(macs, pod("a", nil, `[{"name":"net1","mac":"02:00:00:00:00:01"},{"name":"net2","mac":"02:00:00:00:00:01"}]`))
+	addPodMacs(macs, pod("b", nil, `[{"name":"net1","mac":"02:00:00:00:00:01"},{"name":"net1","mac":"02:00:00:00:00:02"}]`))
+	addPodMacs(macs, pod("short", nil, "net1,net2"))
+	addPodMacs(macs, pod("virt-launcher-vm", map[string]string{"kubevirt.io": "virt-launcher"}, `[{"name":"net1","mac":"02:00:00:00:00:03"}]`))
+	vm := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{"domain": map[string]interface{}{
+			"devices": map[string]interface{}{"interfaces": []interface{}{
+				map[string]interface{}{"name": "default", "macAddress": "02:00:00:00:00:03"},
+				map[string]interface{}{"name": "net1", "macAddress": "02:00:00:00:00:02"},
+			}},
+		}}}},
+	}}
+	vm.SetNamespace("ns")
+	vm.SetName("vm")
+	addVmMacs(macs, vm)
+
+	status, err := kubemacpoolStatus([]plumberv1.MacRange{{Start: "02:00:00:00:00:00", End: "02:00:00:00:00:03"}}, macs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status.Ranges[0]; got.Total != 4 || got.Allocated != 3 || got.Utilization != "75.00%" {
+		t.Errorf("unexpected range status %+v", got)
+	}
+	want := []plumberv1.MacCollision{
+		{Mac: "02:00:00:00:00:01", Owners: []string{"Pod ns/a", "Pod ns/b"}},
+		{Mac: "02:00:00:00:00:02", Owners: []string{"Pod ns/b", "VirtualMachine ns/vm"}},
+	}
+	if !reflect.DeepEqual(status.Collisions, want) {
+		t.Errorf("got collisions %+v, want %+v", status.Collisions, want)
+	}
+}
+
+func TestSelectedNamespaces(t *testing.T) {
+	namespace := func(name string, labels map[string]string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	namespaces := []corev1.Namespace{
+		namespace("default", nil),
+		namespace("pods", map[string]string{"mutatepods.kubemacpool.io": "allocate"}),
+		namespace("ignored", map[string]string{"mutatevirtualmachines.kubemacpool.io": "ignore"}),
+		namespace("system", map[string]string{"runlevel": "0", "mutatepods.kubemacpool.io": "allocate"}),
+		namespace("team", map[string]string{"kubemacpool": "enabled"}),
+	}
+
+	tests := []struct {
+		name            string
+		selector        *metav1.LabelSelector
+		defaultSelector metav1.LabelSelector
+		want            []string
+	}{
+		{
+			name:            "pod default opts in",
+			defaultSelector: defaultPodNamespaceSelector,
+			want:            []string{"pods"},
+		},
+		{
+			name:            "vm default opts out",
+			defaultSelector: defaultVmNamespaceSelector,
+			want:            []string{"default", "pods", "team"},
+		},
+		{
+			name:            "custom selector",
+			selector:        &metav1.LabelSelector{MatchLabels: map[string]string{"kubemacpool": "enabled"}},
+			defaultSelector: defaultVmNamespaceSelector,
+			want:            []string{"team"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectedNamespaces(namespaces, tt.selector, tt.defaultSelector)
+			if err != nil {
+				t.Fatalf("selectedNamespaces() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectedNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -30,18 +30,23 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"unicode"
 
 	"github.com/dustin/go-humanize"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -55,33 +60,37 @@ const (
 	DefaultNamespace        = "luigi-system"
 	KubemacpoolNamespace    = "dhcp-controller-system"
 	DefaultMetricsPort      = "8080"
-	MultusImage             = "docker.io/platform9/multus:v3.7.2-pmk-2644970"
-	WhereaboutsImage        = "docker.io/platform9/whereabouts:v0.6.3-pmk-3299438"
-	SriovCniImage           = "docker.io/platform9/sriov-cni:v2.6.2-pmk-2877848"
-	SriovDpImage            = "docker.io/platform9/sriov-network-device-plugin:v3.3.2-pmk-2877839"
-	OvsImage                = "quay.io/platform9/openvswitch:v2.17.5-3"
-	OvsCniImage             = "quay.io/kubevirt/ovs-cni-plugin:v0.28.0"
-	OvsMarkerImage          = "quay.io/kubevirt/ovs-cni-marker:v0.28.0"
-	HostPlumberImage        = "quay.io/platform9/hostplumber:v0.5.8"
-	DhcpControllerImage     = "docker.io/platform9/pf9-dhcp-controller:v1.1"
-	KubemacpoolImage        = "quay.io/kubevirt/kubemacpool:v0.41.0"
 	KubemacpoolRangeStart   = "02:55:43:00:00:00"
 	KubemacpoolRangeEnd     = "02:55:43:FF:FF:FF"
-	KubeRbacProxyImage      = "quay.io/brancz/kube-rbac-proxy:v0.18.1"
-	NfdImage                = "docker.io/platform9/node-feature-discovery:v0.11.3-pmk-2877967"
-	TemplateDir             = "/etc/plugin_templates/"
-	CreateDir               = TemplateDir + "create/"
-	DeleteDir               = TemplateDir + "delete/"
 	NetworkPluginsConfigMap = "pf9-networkplugins-config"
 	IpReconcilerSchedule    = "*/5 * * * *"
 	HugepageSize            = "2Mi"
+	VhostSockDir            = "/var/lib/vhost_sockets"
 )
 
+var (
+	TemplateDir = "/etc/plugin_templates/"
+	CreateDir   = TemplateDir + "create/"
+	DeleteDir   = TemplateDir + "delete/"
+	BundleDir   = TemplateDir + "bundles/"
+)
+
+// SetTemplateDir points the operator at a different plugin_templates directory,
+// rendered manifests are written to its create/ and delete/ subdirectories
+func SetTemplateDir(dir string) {
+	TemplateDir = filepath.Clean(dir) + "/"
+	CreateDir = TemplateDir + "create/"
+	DeleteDir = TemplateDir + "delete/"
+	BundleDir = TemplateDir + "bundles/"
+}
+
 // NetworkPluginsReconciler reconciles a NetworkPlugins object
 type NetworkPluginsReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	Log    logr.Logger
+	// ServerVersion is used to check bundle compatibility, the check is skipped if nil
+	ServerVersion discovery.ServerVersionInterface
 }
 
 type PluginsUpdateInfo struct {
@@ -89,6 +98,7 @@ type PluginsUpdateInfo struct {
 	NamespacedName types.NamespacedName
 	currentSpec    *plumberv1.NetworkPluginsSpec
 	prevSpec       *plumberv1.NetworkPluginsSpec
+	bundle         *PluginBundle
 }
 
 type MultusT plumberv1.Multus
@@ -100,7 +110,7 @@ type DhcpControllerT plumberv1.DhcpController
 type NodeFeatureDiscoveryT plumberv1.NodeFeatureDiscovery
 
 type ApplyPlugin interface {
-	WriteConfigToTemplate(string, string) error
+	WriteConfigToTemplate(string, *PluginBundle, string) error
 	ApplyTemplate(string) error
 }
 
@@ -168,6 +178,31 @@ func (r *NetworkPluginsReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		return ctrl.Result{}, nil
 	}
 
+	newStatus := networkPluginsReq.Status.DeepCopy()
+	reqInfo.bundle, newStatus.KubernetesVersion, err = r.selectBundle(&networkPluginsReq)
+	if err != nil {
+		log.Error(err, "Error selecting plugin bundle")
+		return ctrl.Result{}, err
+	}
+	log.Info("Using plugin bundle", "bundleVersion", reqInfo.bundle.Version)
+
+	if plugins := networkPluginsReq.Spec.Plugins; plugins != nil && plugins.DhcpController != nil {
+		condition, rangeErr := kubemacpoolRangesCondition(plugins.DhcpController, networkPluginsReq.Generation)
+		meta.SetStatusCondition(&newStatus.Conditions, condition)
+		if rangeErr != nil {
+			// Nothing is applied until the ranges are fixed, which is a
+			// new event, so don't requeue
+			log.Error(rangeErr, "Invalid KubeMacPool ranges, not applying plugins")
+			if err := r.updateStatus(ctx, &networkPluginsReq, newStatus); err != nil {
+				log.Error(err, "Failed to update NetworkPlugins status")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+	} else {
+		meta.RemoveStatusCondition(&newStatus.Conditions, plumberv1.ConditionKubemacpoolRangesValid)
+	}
+
 	var fileList []string
 	err = r.parseNewPlugins(reqInfo, &fileList)
 	if err != nil {
@@ -199,10 +234,72 @@ func (r *NetworkPluginsReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		return ctrl.Result{}, err
 	}
 
+	newStatus.ActiveBundle = reqInfo.bundle.Version
+	if err := r.updateStatus(ctx, &networkPluginsReq, newStatus); err != nil {
+		log.Error(err, "Failed to update NetworkPlugins status")
+		return ctrl.Result{}, err
+	}
+
+	if plugins := networkPluginsReq.Spec.Plugins; plugins != nil && plugins.DhcpController != nil {
+		// MAC utilization changes without any NetworkPlugins event, poll it
+		return ctrl.Result{RequeueAfter: KubemacpoolStatusInterval}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
-func (hostPlumberConfig *HostPlumberT) WriteConfigToTemplate(outputDir, registry string) error {
+// selectBundle returns the bundle to apply along with the server version it
+// was checked against
+func (r *NetworkPluginsReconciler) selectBundle(networkPlugins *plumberv1.NetworkPlugins) (*PluginBundle, string, error) {
+	bundles, err := LoadPluginBundles(BundleDir)
+	if err != nil {
+		return nil, "", err
+	}
+	bundle, err := SelectBundle(bundles, networkPlugins.Spec.BundleVersion, networkPlugins.Status.ActiveBundle)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if r.ServerVersion == nil {
+		return bundle, "", nil
+	}
+	info, err := r.ServerVersion.ServerVersion()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get Kubernetes server version: %w", err)
+	}
+	if err := bundle.CheckKubernetesVersion(info.GitVersion); err != nil {
+		return nil, "", err
+	}
+	return bundle, info.GitVersion, nil
+}
+
+// updateStatus fills in the KubeMacPool utilization and writes newStatus if
+// it differs from the current status
+func (r *NetworkPluginsReconciler) updateStatus(ctx context.Context, networkPlugins *plumberv1.NetworkPlugins, newStatus *plumberv1.NetworkPluginsStatus) error {
+	newStatus.Kubemacpool = nil
+	if plugins := networkPlugins.Spec.Plugins; plugins != nil && plugins.DhcpController != nil {
+		kmpStatus, err := r.getKubemacpoolStatus(ctx, plugins.DhcpController)
+		if err != nil {
+			r.Log.Error(err, "Failed to compute KubeMacPool range utilization")
+		} else {
+			newStatus.Kubemacpool = kmpStatus
+		}
+		// Keep the old timestamp if nothing changed, so we don't trigger
+		// another reconcile by writing status
+		if old := networkPlugins.Status.Kubemacpool; old != nil && newStatus.Kubemacpool != nil &&
+			reflect.DeepEqual(old.Ranges, newStatus.Kubemacpool.Ranges) &&
+			reflect.DeepEqual(old.Collisions, newStatus.Kubemacpool.Collisions) {
+			newStatus.Kubemacpool.LastUpdated = old.LastUpdated
+		}
+	}
+
+	if reflect.DeepEqual(&networkPlugins.Status, newStatus) {
+		return nil
+	}
+	networkPlugins.Status = *newStatus
+	return r.Status().Update(ctx, networkPlugins)
+}
+
+func (hostPlumberConfig *HostPlumberT) WriteConfigToTemplate(outputDir string, bundle *PluginBundle, registry string) error {
 	config := make(map[string]interface{})
 	if hostPlumberConfig.Namespace != "" {
 		config["Namespace"] = hostPlumberConfig.Namespace
@@ -219,7 +316,7 @@ func (hostPlumberConfig *HostPlumberT) WriteConfigToTemplate(outputDir, registry
 	if hostPlumberConfig.HostPlumberImage != "" {
 		config["HostPlumberImage"] = hostPlumberConfig.HostPlumberImage
 	} else {
-		config["HostPlumberImage"] = ReplaceContainerRegistry(HostPlumberImage, registry)
+		config["HostPlumberImage"] = bundle.Image(HostPlumberImageKey, registry)
 	}
 
 	if hostPlumberConfig.MetricsPort != "" {
@@ -228,7 +325,7 @@ func (hostPlumberConfig *HostPlumberT) WriteConfigToTemplate(outputDir, registry
 		config["MetricsPort"] = DefaultMetricsPort
 	}
 
-	config["KubeRbacProxyImage"] = ReplaceContainerRegistry(KubeRbacProxyImage, registry)
+	config["KubeRbacProxyImage"] = bundle.Image(KubeRbacProxyImageKey, registry)
 
 	t, err := template.ParseFiles(filepath.Join(TemplateDir, "pf9-hostplumber", "hostplumber.yaml"))
 	if err != nil {
@@ -246,7 +343,7 @@ func (hostPlumberConfig *HostPlumberT) ApplyTemplate(outputDir string) error {
 	return nil
 }
 
-func (dhcpControllerConfig *DhcpControllerT) WriteConfigToTemplate(outputDir, registry string) error {
+func (dhcpControllerConfig *DhcpControllerT) WriteConfigToTemplate(outputDir string, bundle *PluginBundle, registry string) error {
 	config := make(map[string]interface{})
 
 	if dhcpControllerConfig.ImagePullPolicy == "Always" {
@@ -258,7 +355,7 @@ func (dhcpControllerConfig *DhcpControllerT) WriteConfigToTemplate(outputDir, re
 	if dhcpControllerConfig.DhcpControllerImage != "" {
 		config["DhcpControllerImage"] = dhcpControllerConfig.DhcpControllerImage
 	} else {
-		config["DhcpControllerImage"] = ReplaceContainerRegistry(DhcpControllerImage, registry)
+		config["DhcpControllerImage"] = bundle.Image(DhcpControllerImageKey, registry)
 	}
 
 	if dhcpControllerConfig.KubemacpoolNamespace != "" {
@@ -267,20 +364,36 @@ func (dhcpControllerConfig *DhcpControllerT) WriteConfigToTemplate(outputDir, re
 		config["KubemacpoolNamespace"] = KubemacpoolNamespace
 	}
 
-	if dhcpControllerConfig.KubemacpoolRangeStart != "" {
-		config["KubemacpoolRangeStart"] = dhcpControllerConfig.KubemacpoolRangeStart
-	} else {
-		config["KubemacpoolRangeStart"] = KubemacpoolRangeStart
+	rangeStart, rangeEnd, err := mergeMacRanges(kubemacpoolRanges((*plumberv1.DhcpController)(dhcpControllerConfig)))
+	if err != nil {
+		return err
+	}
+	config["KubemacpoolRangeStart"] = rangeStart
+	config["KubemacpoolRangeEnd"] = rangeEnd
+
+	podSelector, err := namespaceSelectorJSON(dhcpControllerConfig.PodNamespaceSelector, defaultPodNamespaceSelector)
+	if err != nil {
+		return fmt.Errorf("invalid podNamespaceSelector: %w", err)
+	}
+	config["PodNamespaceSelector"] = podSelector
+
+	vmSelector, err := namespaceSelectorJSON(dhcpControllerConfig.VmNamespaceSelector, defaultVmNamespaceSelector)
+	if err != nil {
+		return fmt.Errorf("invalid vmNamespaceSelector: %w", err)
 	}
+	config["VmNamespaceSelector"] = vmSelector
 
-	if dhcpControllerConfig.KubemacpoolRangeEnd != "" {
-		config["KubemacpoolRangeEnd"] = dhcpControllerConfig.KubemacpoolRangeEnd
+	if dhcpControllerConfig.MacAllocationWaitTime != nil {
+		if *dhcpControllerConfig.MacAllocationWaitTime <= 0 {
+			return fmt.Errorf("macAllocationWaitTime must be a positive number of seconds")
+		}
+		config["KubemacpoolWaitTime"] = *dhcpControllerConfig.MacAllocationWaitTime
 	} else {
-		config["KubemacpoolRangeEnd"] = KubemacpoolRangeEnd
+		config["KubemacpoolWaitTime"] = KubemacpoolWaitTime
 	}
 
-	config["KubeRbacProxyImage"] = ReplaceContainerRegistry(KubeRbacProxyImage, registry)
-	config["KubemacpoolImage"] = ReplaceContainerRegistry(KubemacpoolImage, registry)
+	config["KubeRbacProxyImage"] = bundle.Image(KubeRbacProxyImageKey, registry)
+	config["KubemacpoolImage"] = bundle.Image(KubemacpoolImageKey, registry)
 
 	t, err := template.ParseFiles(filepath.Join(TemplateDir, "dhcpcontroller", "dhcpcontroller.yaml"))
 	if err != nil {
@@ -298,13 +411,13 @@ func (dhcpControllerConfig *DhcpControllerT) ApplyTemplate(outputDir string) err
 	return nil
 }
 
-func (nfdConfig *NodeFeatureDiscoveryT) WriteConfigToTemplate(outputDir, registry string) error {
+func (nfdConfig *NodeFeatureDiscoveryT) WriteConfigToTemplate(outputDir string, bundle *PluginBundle, registry string) error {
 	config := make(map[string]interface{})
 
 	if nfdConfig.NfdImage != "" {
 		config["NfdImage"] = nfdConfig.NfdImage
 	} else {
-		config["NfdImage"] = ReplaceContainerRegistry(NfdImage, registry)
+		config["NfdImage"] = bundle.Image(NfdImageKey, registry)
 	}
 
 	if nfdConfig.ImagePullPolicy == "Always" {
@@ -329,7 +442,7 @@ func (nfdConfig *NodeFeatureDiscoveryT) ApplyTemplate(outputDir string) error {
 	return nil
 }
 
-func (multusConfig *MultusT) WriteConfigToTemplate(outputDir, registry string) error {
+func (multusConfig *MultusT) WriteConfigToTemplate(outputDir string, bundle *PluginBundle, registry string) error {
 	config := make(map[string]interface{})
 	if multusConfig.Namespace != "" {
 		config["Namespace"] = multusConfig.Namespace
@@ -346,7 +459,7 @@ func (multusConfig *MultusT) WriteConfigToTemplate(outputDir, registry string) e
 	if multusConfig.MultusImage != "" {
 		config["MultusImage"] = multusConfig.MultusImage
 	} else {
-		config["MultusImage"] = ReplaceContainerRegistry(MultusImage, registry)
+		config["MultusImage"] = bundle.Image(MultusImageKey, registry)
 	}
 
 	t, err := template.ParseFiles(filepath.Join(TemplateDir, "multus", "multus.yaml"))
@@ -365,7 +478,7 @@ func (multusConfig *MultusT) ApplyTemplate(outputDir string) error {
 	return nil
 }
 
-func (whereaboutsConfig *WhereaboutsT) WriteConfigToTemplate(outputDir, registry string) error {
+func (whereaboutsConfig *WhereaboutsT) WriteConfigToTemplate(outputDir string, bundle *PluginBundle, registry string) error {
 	config := make(map[string]interface{})
 	if whereaboutsConfig.Namespace != "" {
 		config["Namespace"] = whereaboutsConfig.Namespace
@@ -382,7 +495,7 @@ func (whereaboutsConfig *WhereaboutsT) WriteConfigToTemplate(outputDir, registry
 	if whereaboutsConfig.WhereaboutsImage != "" {
 		config["WhereaboutsImage"] = whereaboutsConfig.WhereaboutsImage
 	} else {
-		config["WhereaboutsImage"] = ReplaceContainerRegistry(WhereaboutsImage, registry)
+		config["WhereaboutsImage"] = bundle.Image(WhereaboutsImageKey, registry)
 	}
 
 	if whereaboutsConfig.IpReconcilerSchedule != "" {
@@ -411,7 +524,7 @@ func (whereaboutsConfig *WhereaboutsT) ApplyTemplate(outputDir string) error {
 	return nil
 }
 
-func (sriovConfig *SriovT) WriteConfigToTemplate(outputDir, registry string) error {
+func (sriovConfig *SriovT) WriteConfigToTemplate(outputDir string, bundle *PluginBundle, registry string) error {
 	config := make(map[string]interface{})
 
 	if sriovConfig.Namespace != "" {
@@ -423,13 +536,13 @@ func (sriovConfig *SriovT) WriteConfigToTemplate(outputDir, registry string) err
 	if sriovConfig.SriovCniImage != "" {
 		config["SriovCniImage"] = sriovConfig.SriovCniImage
 	} else {
-		config["SriovCniImage"] = ReplaceContainerRegistry(SriovCniImage, registry)
+		config["SriovCniImage"] = bundle.Image(SriovCniImageKey, registry)
 	}
 
 	if sriovConfig.SriovDpImage != "" {
 		config["SriovDpImage"] = sriovConfig.SriovDpImage
 	} else {
-		config["SriovDpImage"] = ReplaceContainerRegistry(SriovDpImage, registry)
+		config["SriovDpImage"] = bundle.Image(SriovDpImageKey, registry)
 	}
 
 	// Apply the SRIOV CNI
@@ -438,7 +551,7 @@ func (sriovConfig *SriovT) WriteConfigToTemplate(outputDir, registry string) err
 		return err
 	}
 
-	if err := renderTemplateToFile(config, t, outputDir+"sriov-cni.yaml"); err != nil {
+	if err := renderTemplateToFile(config, t, filepath.Join(outputDir, "sriov-cni.yaml")); err != nil {
 		return err
 	}
 
@@ -459,7 +572,7 @@ func (sriovConfig *SriovT) ApplyTemplate(outputDir string) error {
 	return nil
 }
 
-func (ovsConfig *OvsT) WriteConfigToTemplate(outputDir, registry string) error {
+func (ovsConfig *OvsT) WriteConfigToTemplate(outputDir string, bundle *PluginBundle, registry string) error {
 	config := make(map[string]interface{})
 
 	if ovsConfig.Namespace != "" {
@@ -477,29 +590,49 @@ func (ovsConfig *OvsT) WriteConfigToTemplate(outputDir, registry string) error {
 	if ovsConfig.OVSImage != "" {
 		config["OVSImage"] = ovsConfig.OVSImage
 	} else {
-		config["OVSImage"] = ReplaceContainerRegistry(OvsImage, registry)
+		config["OVSImage"] = bundle.Image(OvsImageKey, registry)
 	}
 
 	if ovsConfig.CNIImage != "" {
 		config["CNIImage"] = ovsConfig.CNIImage
 	} else {
-		config["CNIImage"] = ReplaceContainerRegistry(OvsCniImage, registry)
+		config["CNIImage"] = bundle.Image(OvsCniImageKey, registry)
 	}
 
 	if ovsConfig.MarkerImage != "" {
 		config["MarkerImage"] = ovsConfig.MarkerImage
 	} else {
-		config["MarkerImage"] = ReplaceContainerRegistry(OvsMarkerImage, registry)
+		config["MarkerImage"] = bundle.Image(OvsMarkerImageKey, registry)
 	}
 
+	config["DPDK"] = ovsConfig.DPDK
 	if ovsConfig.DPDK != nil {
-		if ovsConfig.DPDK.LcoreMask == "" || ovsConfig.DPDK.SocketMem == "" || ovsConfig.DPDK.PmdCpuMask == "" || ovsConfig.DPDK.HugepageMemory == "" {
-			return fmt.Errorf("LcoreMask, SocketMem, PmdCpuMask, HugepageMemory are required parameters to enable Dpdk")
+		if err := validateDpdkConfig(ovsConfig.DPDK); err != nil {
+			return err
+		}
+		config["HugepageSize"] = hostHugepageSize()
+		config["DpdkSocketMem"] = dpdkSocketMem(ovsConfig.DPDK)
+		if ovsConfig.DPDK.VhostSockDir != "" {
+			config["VhostSockDir"] = ovsConfig.DPDK.VhostSockDir
+		} else {
+			config["VhostSockDir"] = VhostSockDir
 		}
-		config["HugepageSize"] = GetHugepageSize()
-		config["DPDK"] = ovsConfig.DPDK
 	}
 
+	if err := validateOvsKeyValues("otherConfig", ovsConfig.OtherConfig); err != nil {
+		return err
+	}
+	if err := validateOvsKeyValues("externalIds", ovsConfig.ExternalIds); err != nil {
+		return err
+	}
+	for key := range ovsConfig.OtherConfig {
+		if containsString(dpdkOtherConfigKeys, key) {
+			return fmt.Errorf("otherConfig key %s is managed by the dpdk section, set it there instead", key)
+		}
+	}
+	config["OtherConfig"] = joinOvsKeyValues(ovsConfig.OtherConfig)
+	config["ExternalIds"] = joinOvsKeyValues(ovsConfig.ExternalIds)
+
 	// Apply the OVS DaemonSet
 	t, err := template.ParseFiles(filepath.Join(TemplateDir, "ovs", "ovs-daemons.yaml"))
 	if err != nil {
@@ -528,6 +661,125 @@ func (ovsConfig *OvsT) ApplyTemplate(outputDir string) error {
 	return nil
 }
 
+// other_config keys that are rendered from the structured Dpdk fields
+var dpdkOtherConfigKeys = []string{"dpdk-init", "dpdk-lcore-mask", "dpdk-socket-mem", "pmd-cpu-mask", "dpdk-extra", "vhost-sock-dir"}
+
+func validateDpdkConfig(dpdk *plumberv1.Dpdk) error {
+	if dpdk.LcoreMask == "" || dpdk.PmdCpuMask == "" || dpdk.HugepageMemory == "" {
+		return fmt.Errorf("LcoreMask, PmdCpuMask, HugepageMemory are required parameters to enable Dpdk")
+	}
+	if dpdk.SocketMem == "" && len(dpdk.SocketMemory) == 0 {
+		return fmt.Errorf("one of SocketMem or SocketMemory is required to enable Dpdk")
+	}
+	if dpdk.SocketMem != "" && len(dpdk.SocketMemory) > 0 {
+		return fmt.Errorf("SocketMem and SocketMemory are mutually exclusive")
+	}
+
+	if dpdk.SocketMem != "" && !socketMemRegexp.MatchString(dpdk.SocketMem) {
+		return fmt.Errorf("SocketMem %q is not a comma separated list of MB per socket", dpdk.SocketMem)
+	}
+	// Both end up in double quoted YAML and ovs-vsctl strings
+	if !quotable(dpdk.ExtraArgs) {
+		return fmt.Errorf("dpdkExtra must not contain double quotes, backslashes or control characters")
+	}
+	if dpdk.VhostSockDir != "" && (!filepath.IsAbs(dpdk.VhostSockDir) || !quotable(dpdk.VhostSockDir) || strings.ContainsAny(dpdk.VhostSockDir, " \t")) {
+		return fmt.Errorf("vhostSockDir %q must be an absolute path without whitespace or quotes", dpdk.VhostSockDir)
+	}
+
+	seen := make(map[int]bool)
+	for _, sm := range dpdk.SocketMemory {
+		if sm.Socket < 0 || sm.MemoryMB < 0 {
+			return fmt.Errorf("invalid SocketMemory entry socket=%d memoryMB=%d", sm.Socket, sm.MemoryMB)
+		}
+		if seen[sm.Socket] {
+			return fmt.Errorf("NUMA socket %d listed more than once in SocketMemory", sm.Socket)
+		}
+		seen[sm.Socket] = true
+	}
+
+	lcore, err := parseCpuMask(dpdk.LcoreMask)
+	if err != nil {
+		return fmt.Errorf("invalid LcoreMask: %w", err)
+	}
+	pmd, err := parseCpuMask(dpdk.PmdCpuMask)
+	if err != nil {
+		return fmt.Errorf("invalid PmdCpuMask: %w", err)
+	}
+	if new(big.Int).And(lcore, pmd).Sign() != 0 {
+		return fmt.Errorf("PmdCpuMask %s overlaps LcoreMask %s", dpdk.PmdCpuMask, dpdk.LcoreMask)
+	}
+	return nil
+}
+
+var socketMemRegexp = regexp.MustCompile(`^[0-9]+(,[0-9]+)*$`)
+
+// quotable returns whether s can go inside double quotes as is, in YAML and
+// in ovs-vsctl values
+func quotable(s string) bool {
+	for _, r := range s {
+		if r == '"' || r == '\\' || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCpuMask parses a hex CPU mask with an optional 0x prefix
+func parseCpuMask(mask string) (*big.Int, error) {
+	m := strings.TrimPrefix(strings.ToLower(mask), "0x")
+	val, ok := new(big.Int).SetString(m, 16)
+	// SetString takes a sign, which a mask has none of
+	if !ok || val.Sign() == 0 || strings.ContainsAny(m, "+-") {
+		return nil, fmt.Errorf("%q is not a non-zero hex value", mask)
+	}
+	return val, nil
+}
+
+// dpdkSocketMem renders the dpdk-socket-mem value, sockets missing from
+// SocketMemory are given 0 MB
+func dpdkSocketMem(dpdk *plumberv1.Dpdk) string {
+	if dpdk.SocketMem != "" {
+		return dpdk.SocketMem
+	}
+
+	maxSocket := 0
+	perSocket := make(map[int]int)
+	for _, sm := range dpdk.SocketMemory {
+		perSocket[sm.Socket] = sm.MemoryMB
+		if sm.Socket > maxSocket {
+			maxSocket = sm.Socket
+		}
+	}
+
+	var mem []string
+	for socket := 0; socket <= maxSocket; socket++ {
+		mem = append(mem, strconv.Itoa(perSocket[socket]))
+	}
+	return strings.Join(mem, ",")
+}
+
+func validateOvsKeyValues(field string, kv map[string]string) error {
+	for key, val := range kv {
+		if key == "" || strings.ContainsAny(key, "=: \t") || !quotable(key) {
+			return fmt.Errorf("%s: invalid key %q", field, key)
+		}
+		if strings.ContainsAny(val, " \t") || !quotable(val) {
+			return fmt.Errorf("%s: value for key %s must not contain whitespace, quotes or backslashes", field, key)
+		}
+	}
+	return nil
+}
+
+// joinOvsKeyValues renders a map as space separated key=value pairs sorted by key
+func joinOvsKeyValues(kv map[string]string) string {
+	var pairs []string
+	for key, val := range kv {
+		pairs = append(pairs, key+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
 func ReplaceContainerRegistry(originalImage, newRegistry string) string {
 	if newRegistry == "" {
 		return originalImage
@@ -538,10 +790,10 @@ func ReplaceContainerRegistry(originalImage, newRegistry string) string {
 	return privateImg
 }
 
-func (r *NetworkPluginsReconciler) createPlugin(plugin ApplyPlugin, registry string) error {
+func (r *NetworkPluginsReconciler) createPlugin(plugin ApplyPlugin, bundle *PluginBundle, registry string) error {
 	outputDir := CreateDir
 
-	if err := plugin.WriteConfigToTemplate(outputDir, registry); err != nil {
+	if err := plugin.WriteConfigToTemplate(outputDir, bundle, registry); err != nil {
 		fmt.Printf("WriteConfigToTemplate returned error: %s\n", err)
 		return err
 	}
@@ -553,10 +805,10 @@ func (r *NetworkPluginsReconciler) createPlugin(plugin ApplyPlugin, registry str
 	return nil
 }
 
-func (r *NetworkPluginsReconciler) deletePlugin(plugin ApplyPlugin, registry string) error {
+func (r *NetworkPluginsReconciler) deletePlugin(plugin ApplyPlugin, bundle *PluginBundle, registry string) error {
 	outputDir := DeleteDir
 
-	if err := plugin.WriteConfigToTemplate(outputDir, registry); err != nil {
+	if err := plugin.WriteConfigToTemplate(outputDir, bundle, registry); err != nil {
 		return err
 	}
 
@@ -583,7 +835,7 @@ func (r *NetworkPluginsReconciler) parseNewPlugins(req *PluginsUpdateInfo, fileL
 	if plugins := req.currentSpec.Plugins; plugins != nil {
 		if plugins.Multus != nil {
 			multusConfig := (*MultusT)(plugins.Multus)
-			err := r.createPlugin(multusConfig, customRegistry)
+			err := r.createPlugin(multusConfig, req.bundle, customRegistry)
 			if err != nil {
 				fmt.Printf("error: %s\n", err)
 				return err
@@ -593,7 +845,7 @@ func (r *NetworkPluginsReconciler) parseNewPlugins(req *PluginsUpdateInfo, fileL
 
 		if plugins.Sriov != nil {
 			sriovConfig := (*SriovT)(plugins.Sriov)
-			err := r.createPlugin(sriovConfig, customRegistry)
+			err := r.createPlugin(sriovConfig, req.bundle, customRegistry)
 			if err != nil {
 				return err
 			}
@@ -603,7 +855,7 @@ func (r *NetworkPluginsReconciler) parseNewPlugins(req *PluginsUpdateInfo, fileL
 
 		if plugins.Whereabouts != nil {
 			whConfig := (*WhereaboutsT)(plugins.Whereabouts)
-			err := r.createPlugin(whConfig, customRegistry)
+			err := r.createPlugin(whConfig, req.bundle, customRegistry)
 			if err != nil {
 				return err
 			}
@@ -612,7 +864,7 @@ func (r *NetworkPluginsReconciler) parseNewPlugins(req *PluginsUpdateInfo, fileL
 
 		if plugins.OVS != nil {
 			ovsConfig := (*OvsT)(plugins.OVS)
-			err := r.createPlugin(ovsConfig, customRegistry)
+			err := r.createPlugin(ovsConfig, req.bundle, customRegistry)
 			if err != nil {
 				return err
 			}
@@ -622,7 +874,7 @@ func (r *NetworkPluginsReconciler) parseNewPlugins(req *PluginsUpdateInfo, fileL
 
 		if plugins.HostPlumber != nil {
 			hostPlumberConfig := (*HostPlumberT)(plugins.HostPlumber)
-			err := r.createPlugin(hostPlumberConfig, customRegistry)
+			err := r.createPlugin(hostPlumberConfig, req.bundle, customRegistry)
 			if err != nil {
 				return err
 			}
@@ -631,7 +883,7 @@ func (r *NetworkPluginsReconciler) parseNewPlugins(req *PluginsUpdateInfo, fileL
 
 		if plugins.DhcpController != nil {
 			dhcpControllerConfig := (*DhcpControllerT)(plugins.DhcpController)
-			err := r.createPlugin(dhcpControllerConfig, customRegistry)
+			err := r.createPlugin(dhcpControllerConfig, req.bundle, customRegistry)
 			if err != nil {
 				return err
 			}
@@ -640,7 +892,7 @@ func (r *NetworkPluginsReconciler) parseNewPlugins(req *PluginsUpdateInfo, fileL
 
 		if plugins.NodeFeatureDiscovery != nil {
 			nfdConfig := (*NodeFeatureDiscoveryT)(plugins.NodeFeatureDiscovery)
-			err := r.createPlugin(nfdConfig, customRegistry)
+			err := r.createPlugin(nfdConfig, req.bundle, customRegistry)
 			if err != nil {
 				return err
 			}
@@ -671,7 +923,7 @@ func (r *NetworkPluginsReconciler) parseMissingPlugins(req *PluginsUpdateInfo, f
 
 	if (noOldPlugins == true || req.currentSpec.Plugins.Multus == nil) && old.Multus != nil {
 		multusConfig := (*MultusT)(old.Multus)
-		err := r.deletePlugin(multusConfig, customRegistry)
+		err := r.deletePlugin(multusConfig, req.bundle, customRegistry)
 		if err != nil {
 			return err
 		}
@@ -680,7 +932,7 @@ func (r *NetworkPluginsReconciler) parseMissingPlugins(req *PluginsUpdateInfo, f
 
 	if (noOldPlugins == true || req.currentSpec.Plugins.Whereabouts == nil) && old.Whereabouts != nil {
 		whereaboutsConfig := (*WhereaboutsT)(old.Whereabouts)
-		err := r.deletePlugin(whereaboutsConfig, customRegistry)
+		err := r.deletePlugin(whereaboutsConfig, req.bundle, customRegistry)
 		if err != nil {
 			return err
 		}
@@ -689,7 +941,7 @@ func (r *NetworkPluginsReconciler) parseMissingPlugins(req *PluginsUpdateInfo, f
 
 	if (noOldPlugins == true || req.currentSpec.Plugins.Sriov == nil) && old.Sriov != nil {
 		sriovConfig := (*SriovT)(old.Sriov)
-		err := r.deletePlugin(sriovConfig, customRegistry)
+		err := r.deletePlugin(sriovConfig, req.bundle, customRegistry)
 		if err != nil {
 			return err
 		}
@@ -699,7 +951,7 @@ func (r *NetworkPluginsReconciler) parseMissingPlugins(req *PluginsUpdateInfo, f
 
 	if (noOldPlugins == true || req.currentSpec.Plugins.OVS == nil) && old.OVS != nil {
 		ovsConfig := (*OvsT)(old.OVS)
-		err := r.deletePlugin(ovsConfig, customRegistry)
+		err := r.deletePlugin(ovsConfig, req.bundle, customRegistry)
 		if err != nil {
 			return err
 		}
@@ -709,7 +961,7 @@ func (r *NetworkPluginsReconciler) parseMissingPlugins(req *PluginsUpdateInfo, f
 
 	if (noOldPlugins == true || req.currentSpec.Plugins.HostPlumber == nil) && old.HostPlumber != nil {
 		hostPlumberConfig := (*HostPlumberT)(old.HostPlumber)
-		err := r.deletePlugin(hostPlumberConfig, customRegistry)
+		err := r.deletePlugin(hostPlumberConfig, req.bundle, customRegistry)
 		if err != nil {
 			return err
 		}
@@ -718,7 +970,7 @@ func (r *NetworkPluginsReconciler) parseMissingPlugins(req *PluginsUpdateInfo, f
 
 	if (noOldPlugins == true || req.currentSpec.Plugins.DhcpController == nil) && old.DhcpController != nil {
 		dhcpControllerConfig := (*DhcpControllerT)(old.DhcpController)
-		err := r.deletePlugin(dhcpControllerConfig, customRegistry)
+		err := r.deletePlugin(dhcpControllerConfig, req.bundle, customRegistry)
 		if err != nil {
 			return err
 		}
@@ -727,7 +979,7 @@ func (r *NetworkPluginsReconciler) parseMissingPlugins(req *PluginsUpdateInfo, f
 
 	if (noOldPlugins == true || req.currentSpec.Plugins.NodeFeatureDiscovery == nil) && old.NodeFeatureDiscovery != nil {
 		nfdConfig := (*NodeFeatureDiscoveryT)(old.NodeFeatureDiscovery)
-		err := r.deletePlugin(nfdConfig, customRegistry)
+		err := r.deletePlugin(nfdConfig, req.bundle, customRegistry)
 		if err != nil {
 			return err
 		}
@@ -819,6 +1071,8 @@ func (r *NetworkPluginsReconciler) TeardownPlugins(req *PluginsUpdateInfo) error
 	deleteInfo.NamespacedName = req.NamespacedName
 	deleteInfo.prevSpec = req.prevSpec
 	deleteInfo.currentSpec = &plumberv1.NetworkPluginsSpec{}
+	// Only object names matter when deleting, the images are not needed
+	deleteInfo.bundle = &PluginBundle{}
 
 	if err := r.parseMissingPlugins(deleteInfo, &activePlugins); err != nil {
 		r.Log.Error(err, "Could not parse plugins to delete")
@@ -904,7 +1158,9 @@ func renderTemplateToFile(config map[string]interface{}, t *template.Template, f
 	if err != nil {
 		return err
 	}
-	err = t.Execute(f, config)
+	// Fail on a key the template references but the plugin never set, rather
+	// than rendering an empty string that only breaks at apply time
+	err = t.Option("missingkey=error").Execute(f, config)
 	if err != nil {
 		fmt.Printf("template.Execute failed for file: %s err: %s\n", filename, err)
 		f.Close()
@@ -931,6 +1187,9 @@ func (r *NetworkPluginsReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(r)
 }
 
+// hostHugepageSize is swapped out by tests that need a stable value
+var hostHugepageSize = GetHugepageSize
+
 func GetHugepageSize() string {
 	// Get the hugepage size from meminfo and convert it into bibytes from KB
 	var hugepagesize int64
@@ -0,0 +1,312 @@
+package controllers
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	plumberv1 "github.com/platform9/luigi/api/v1"
+)
+
+var _ = Describe("NetworkPlugins controller", Ordered, func() {
+	const (
+		timeout  = 30 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx        = context.Background()
+		reconciler *NetworkPluginsReconciler
+		nsn        = types.NamespacedName{Name: "networkplugins-test", Namespace: "default"}
+	)
+
+	multusDs := types.NamespacedName{Name: "kube-multus-ds-amd64", Namespace: DefaultNamespace}
+	whereaboutsDs := types.NamespacedName{Name: "whereabouts", Namespace: DefaultNamespace}
+	nadCrd := types.NamespacedName{Name: "network-attachment-definitions.k8s.cni.cncf.io"}
+	specConfigMap := types.NamespacedName{Name: NetworkPluginsConfigMap, Namespace: nsn.Namespace}
+
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: nsn})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	exists := func(key types.NamespacedName, obj client.Object) func() error {
+		return func() error {
+			return k8sClient.Get(ctx, key, obj)
+		}
+	}
+
+	isNotFound := func(key types.NamespacedName, obj client.Object) func() bool {
+		return func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, obj))
+		}
+	}
+
+	BeforeAll(func() {
+		reconciler = &NetworkPluginsReconciler{
+			Client:        k8sClient,
+			Scheme:        scheme.Scheme,
+			Log:           logf.Log.WithName("networkplugins-test"),
+			ServerVersion: discovery.NewDiscoveryClientForConfigOrDie(cfg),
+		}
+	})
+
+	It("applies the templates of every plugin in the spec", func() {
+		networkPlugins := &plumberv1.NetworkPlugins{
+			ObjectMeta: metav1.ObjectMeta{Name: nsn.Name, Namespace: nsn.Namespace},
+			Spec: plumberv1.NetworkPluginsSpec{
+				Plugins: &plumberv1.Plugins{
+					Multus:      &plumberv1.Multus{},
+					Whereabouts: &plumberv1.Whereabouts{},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, networkPlugins)).To(Succeed())
+
+		reconcile()
+
+		Expect(k8sClient.Get(ctx, multusDs, &appsv1.DaemonSet{})).To(Succeed())
+		Expect(k8sClient.Get(ctx, whereaboutsDs, &appsv1.DaemonSet{})).To(Succeed())
+		crd := &unstructured.Unstructured{}
+		crd.SetGroupVersionKind(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"})
+		Eventually(exists(nadCrd, crd), timeout, interval).Should(Succeed())
+
+		ds := &appsv1.DaemonSet{}
+		Expect(k8sClient.Get(ctx, multusDs, ds)).To(Succeed())
+		bundles, err := LoadPluginBundles(BundleDir)
+		Expect(err).NotTo(HaveOccurred())
+		latest := bundles[LatestBundleVersion(bundles)]
+		Expect(ds.Spec.Template.Spec.Containers[0].Image).To(Equal(latest.Images[MultusImageKey]))
+
+		Expect(k8sClient.Get(ctx, specConfigMap, &corev1.ConfigMap{})).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, nsn, networkPlugins)).To(Succeed())
+		Expect(networkPlugins.GetFinalizers()).To(ContainElement("teardownPlugins"))
+		Expect(networkPlugins.Status.ActiveBundle).To(Equal(latest.Version))
+		Expect(networkPlugins.Status.KubernetesVersion).NotTo(BeEmpty())
+	})
+
+	It("deletes the objects of a plugin removed from the spec", func() {
+		networkPlugins := &plumberv1.NetworkPlugins{}
+		Expect(k8sClient.Get(ctx, nsn, networkPlugins)).To(Succeed())
+		prevSpec := networkPlugins.Spec.DeepCopy()
+
+		networkPlugins.Spec.Plugins.Whereabouts = nil
+		Expect(k8sClient.Update(ctx, networkPlugins)).To(Succeed())
+
+		By("computing the manifests of the removed plugin")
+		var fileList []string
+		reqInfo := &PluginsUpdateInfo{
+			Log:            reconciler.Log,
+			NamespacedName: nsn,
+			currentSpec:    &networkPlugins.Spec,
+			prevSpec:       prevSpec,
+			bundle:         &PluginBundle{},
+		}
+		Expect(reconciler.parseMissingPlugins(reqInfo, &fileList)).To(Succeed())
+		Expect(fileList).To(ConsistOf("whereabouts.yaml"))
+
+		reconcile()
+
+		Eventually(isNotFound(whereaboutsDs, &appsv1.DaemonSet{}), timeout, interval).Should(BeTrue())
+		Expect(k8sClient.Get(ctx, multusDs, &appsv1.DaemonSet{})).To(Succeed())
+	})
+
+	It("tears down every plugin and removes the finalizer when deleted", func() {
+		networkPlugins := &plumberv1.NetworkPlugins{}
+		Expect(k8sClient.Get(ctx, nsn, networkPlugins)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, networkPlugins)).To(Succeed())
+
+		reconcile()
+
+		Eventually(isNotFound(multusDs, &appsv1.DaemonSet{}), timeout, interval).Should(BeTrue())
+		Eventually(isNotFound(specConfigMap, &corev1.ConfigMap{}), timeout, interval).Should(BeTrue())
+		Eventually(isNotFound(nsn, &plumberv1.NetworkPlugins{}), timeout, interval).Should(BeTrue())
+	})
+})
+
+func TestParseCpuMask(t *testing.T) {
+	tests := []struct {
+		mask    string
+		want    int64
+		wantErr bool
+	}{
+		{mask: "0x2", want: 0x2},
+		{mask: "0XfF", want: 0xff},
+		{mask: "f0", want: 0xf0},
+		{mask: "0x0", wantErr: true},
+		{mask: "", wantErr: true},
+		{mask: "0x", wantErr: true},
+		{mask: "0xg1", wantErr: true},
+		{mask: "-1", wantErr: true},
+		{mask: "0x+1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mask, func(t *testing.T) {
+			got, err := parseCpuMask(tt.mask)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCpuMask(%q) error = %v, wantErr %v", tt.mask, err, tt.wantErr)
+			}
+			if err == nil && got.Cmp(big.NewInt(tt.want)) != 0 {
+				t.Errorf("parseCpuMask(%q) = %s, want %d", tt.mask, got, tt.want)
+			}
+		})
+	}
+
+	// Masks wider than 64 bits, for hosts with more than 64 CPUs
+	wide, err := parseCpuMask("0x10000000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wide.BitLen() != 65 {
+		t.Errorf("got a %d bit mask, want 65", wide.BitLen())
+	}
+}
+
+func TestValidateDpdkConfig(t *testing.T) {
+	valid := func() *plumberv1.Dpdk {
+		return &plumberv1.Dpdk{LcoreMask: "0x1", PmdCpuMask: "0x6", HugepageMemory: "2Gi", SocketMem: "1024"}
+	}
+	tests := []struct {
+		name    string
+		modify  func(*plumberv1.Dpdk)
+		wantErr string
+	}{
+		{name: "valid"},
+		{
+			name: "socket memory",
+			modify: func(d *plumberv1.Dpdk) {
+				d.SocketMem, d.SocketMemory = "", []plumberv1.DpdkSocketMemory{{Socket: 1, MemoryMB: 1024}}
+			},
+		},
+		{name: "missing lcore mask", modify: func(d *plumberv1.Dpdk) { d.LcoreMask = "" }, wantErr: "required"},
+		{name: "missing hugepage memory", modify: func(d *plumberv1.Dpdk) { d.HugepageMemory = "" }, wantErr: "required"},
+		{name: "no socket memory", modify: func(d *plumberv1.Dpdk) { d.SocketMem = "" }, wantErr: "one of SocketMem or SocketMemory"},
+		{
+			name:    "both socket memory fields",
+			modify:  func(d *plumberv1.Dpdk) { d.SocketMemory = []plumberv1.DpdkSocketMemory{{Socket: 0, MemoryMB: 1024}} },
+			wantErr: "mutually exclusive",
+		},
+		{
+			name: "duplicate socket",
+			modify: func(d *plumberv1.Dpdk) {
+				d.SocketMem = ""
+				d.SocketMemory = []plumberv1.DpdkSocketMemory{{Socket: 0, MemoryMB: 1024}, {Socket: 0, MemoryMB: 512}}
+			},
+			wantErr: "more than once",
+		},
+		{
+			name: "negative memory",
+			modify: func(d *plumberv1.Dpdk) {
+				d.SocketMem, d.SocketMemory = "", []plumberv1.DpdkSocketMemory{{Socket: 0, MemoryMB: -1}}
+			},
+			wantErr: "invalid SocketMemory",
+		},
+		{name: "invalid socketMem", modify: func(d *plumberv1.Dpdk) { d.SocketMem = "1024, 2048" }, wantErr: "comma separated"},
+		{name: "quoted dpdkExtra", modify: func(d *plumberv1.Dpdk) { d.ExtraArgs = `--vdev "net_tap0"` }, wantErr: "dpdkExtra"},
+		{name: "dpdkExtra with newline", modify: func(d *plumberv1.Dpdk) { d.ExtraArgs = "-a 0000:01:00.0\n-a x" }, wantErr: "dpdkExtra"},
+		{name: "dpdkExtra with spaces", modify: func(d *plumberv1.Dpdk) { d.ExtraArgs = "-a 0000:01:00.0 --iova-mode=va" }},
+		{name: "relative vhostSockDir", modify: func(d *plumberv1.Dpdk) { d.VhostSockDir = "vhost" }, wantErr: "vhostSockDir"},
+		{name: "invalid lcore mask", modify: func(d *plumberv1.Dpdk) { d.LcoreMask = "zz" }, wantErr: "invalid LcoreMask"},
+		{name: "zero pmd mask", modify: func(d *plumberv1.Dpdk) { d.PmdCpuMask = "0x0" }, wantErr: "invalid PmdCpuMask"},
+		{name: "overlapping masks", modify: func(d *plumberv1.Dpdk) { d.PmdCpuMask = "0x3" }, wantErr: "overlaps"},
+		{
+			name:    "overlap above 64 CPUs",
+			modify:  func(d *plumberv1.Dpdk) { d.LcoreMask, d.PmdCpuMask = "0x10000000000000001", "0x10000000000000006" },
+			wantErr: "overlaps",
+		},
+		{
+			name:   "disjoint above 64 CPUs",
+			modify: func(d *plumberv1.Dpdk) { d.LcoreMask, d.PmdCpuMask = "0x10000000000000000", "0x6" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dpdk := valid()
+			if tt.modify != nil {
+				tt.modify(dpdk)
+			}
+			err := validateDpdkConfig(dpdk)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateOvsKeyValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		kv      map[string]string
+		wantErr bool
+	}{
+		{name: "valid", kv: map[string]string{"ovn-bridge-mappings": "physnet1:br-ex,physnet2:br-vlan"}},
+		{name: "empty key", kv: map[string]string{"": "x"}, wantErr: true},
+		{name: "key with colon", kv: map[string]string{"a:b": "x"}, wantErr: true},
+		{name: "value with space", kv: map[string]string{"a": "x y"}, wantErr: true},
+		{name: "value with quote", kv: map[string]string{"a": `x"y`}, wantErr: true},
+		{name: "value with backslash", kv: map[string]string{"a": `x\y`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateOvsKeyValues("otherConfig", tt.kv); (err != nil) != tt.wantErr {
+				t.Errorf("validateOvsKeyValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDpdkSocketMem(t *testing.T) {
+	tests := []struct {
+		name string
+		dpdk plumberv1.Dpdk
+		want string
+	}{
+		{name: "raw string", dpdk: plumberv1.Dpdk{SocketMem: "1024,2048"}, want: "1024,2048"},
+		{
+			name: "single socket",
+			dpdk: plumberv1.Dpdk{SocketMemory: []plumberv1.DpdkSocketMemory{{Socket: 0, MemoryMB: 1024}}},
+			want: "1024",
+		},
+		{
+			name: "unordered sockets",
+			dpdk: plumberv1.Dpdk{SocketMemory: []plumberv1.DpdkSocketMemory{{Socket: 1, MemoryMB: 2048}, {Socket: 0, MemoryMB: 1024}}},
+			want: "1024,2048",
+		},
+		{
+			name: "missing sockets get 0",
+			dpdk: plumberv1.Dpdk{SocketMemory: []plumberv1.DpdkSocketMemory{{Socket: 2, MemoryMB: 512}}},
+			want: "0,0,512",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dpdkSocketMem(&tt.dpdk); got != tt.want {
+				t.Errorf("dpdkSocketMem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -76,6 +76,17 @@ func (a *NetworkPluginsValidator) Handle(ctx context.Context, req admission.Requ
 
 	}
 
+	if bundleVersion := networkPluginsReq.Spec.BundleVersion; bundleVersion != "" {
+		bundles, err := LoadPluginBundles(BundleDir)
+		if err != nil {
+			log.Error(err, "Error loading plugin bundles")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if _, ok := bundles[bundleVersion]; !ok {
+			return admission.Denied(fmt.Sprintf("unknown bundleVersion %s", bundleVersion))
+		}
+	}
+
 	if err := a.isNetworkPluginsValid(networkPluginsReq, networkPluginsList); err != nil {
 		log.Error(err, "NetworkPlugins already exist, New can not be installed before removing old ones")
 		return admission.Denied(fmt.Sprintf("NetworkPlugins already exists: %v", err.Error()))
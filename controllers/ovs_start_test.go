@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// fakeOvsVsctl keeps the Open_vSwitch columns it is given as one file per
+// key under $FAKE_DB/<column>/, with the ovs-vsctl quoting removed
+const fakeOvsVsctl = `#!/bin/bash
+[[ $1 == --no-wait ]] && shift
+case $1 in
+set)
+	for arg in "${@:4}" ; do
+		column=${arg%%:*} rest=${arg#*:}
+		value=${rest#*=} value=${value#\"} value=${value%\"}
+		mkdir -p "$FAKE_DB/$column"
+		printf "%s" "$value" > "$FAKE_DB/$column/${rest%%=*}"
+	done ;;
+remove)
+	rm -f "$FAKE_DB/$4/$5" ;;
+esac
+`
+
+// ovsServicesEnv renders the OVS DaemonSet and returns the environment of
+// its ovs-services container
+func ovsServicesEnv(t *testing.T, config *OvsT) []string {
+	t.Helper()
+	outputDir := t.TempDir()
+	if err := config.WriteConfigToTemplate(outputDir, testBundle(), ""); err != nil {
+		t.Fatalf("WriteConfigToTemplate failed: %v", err)
+	}
+	manifest, err := os.ReadFile(filepath.Join(outputDir, "ovs-daemons.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := strictDecoder(t)
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(manifest)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			continue
+		}
+		ds, ok := obj.(*appsv1.DaemonSet)
+		if !ok {
+			continue
+		}
+		for _, container := range ds.Spec.Template.Spec.Containers {
+			if container.Name != "ovs-services" {
+				continue
+			}
+			var env []string
+			for _, e := range container.Env {
+				env = append(env, e.Name+"="+e.Value)
+			}
+			return env
+		}
+	}
+	t.Fatalf("no ovs-services container rendered")
+	return nil
+}
+
+// TestOvsStartScript runs the start script of the OVS image with the
+// environment the operator renders, and checks what ends up in the
+// Open_vSwitch table
+func TestOvsStartScript(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	prevTemplateDir := TemplateDir
+	SetTemplateDir("../plugin_templates")
+	defer SetTemplateDir(prevTemplateDir)
+	prevHugepageSize := hostHugepageSize
+	hostHugepageSize = func() string { return HugepageSize }
+	defer func() { hostHugepageSize = prevHugepageSize }()
+
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "ovs-vsctl"), []byte(fakeOvsVsctl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(binDir, "ovs-ctl"), []byte("#!/bin/bash\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	db := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "managed-keys")
+
+	start := func(env []string) map[string]string {
+		t.Helper()
+		cmd := exec.Command(bash, "../hostplumber/pkg/ovs-docker/start-ovs.sh")
+		cmd.Env = append([]string{
+			"PATH=" + binDir + string(os.PathListSeparator) + os.Getenv("PATH"),
+			"FAKE_DB=" + db,
+			"STATE_FILE=" + stateFile,
+		}, env...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("start-ovs.sh failed: %v: %s", err, out)
+		}
+		got := make(map[string]string)
+		files, _ := filepath.Glob(filepath.Join(db, "*", "*"))
+		for _, file := range files {
+			value, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rel, _ := filepath.Rel(db, file)
+			got[strings.Replace(rel, string(filepath.Separator), ":", 1)] = string(value)
+		}
+		return got
+	}
+
+	var custom *OvsT
+	for _, tc := range templateTestCases() {
+		if tc.plugin == "ovs" && tc.variant == "custom" {
+			custom = tc.config.(*OvsT)
+		}
+	}
+	got := start(ovsServicesEnv(t, custom))
+	want := map[string]string{
+		"other_config:dpdk-init":         "true",
+		"other_config:dpdk-hugepage-dir": "/dev/hugepages",
+		"other_config:dpdk-socket-mem":   "1024,1024",
+		"other_config:dpdk-lcore-mask":   "0x1",
+		"other_config:pmd-cpu-mask":      "0x6",
+		"other_config:dpdk-extra":        "--iova-mode=va",
+		"other_config:vhost-sock-dir":    "/var/run/vhost",
+		"other_config:max-idle":          "30000",
+		"external_ids:ovn-encap-type":    "geneve",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after the first start got %v, want %v", got, want)
+	}
+
+	// Keys no longer rendered are removed on the next start
+	got = start(ovsServicesEnv(t, &OvsT{OtherConfig: map[string]string{"max-idle": "10000"}}))
+	if want := map[string]string{"other_config:max-idle": "10000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after the second start got %v, want %v", got, want)
+	}
+}
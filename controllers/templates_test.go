@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	plumberv1 "github.com/platform9/luigi/api/v1"
+)
+
+// Run with -update to regenerate the golden files after changing a template
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata/")
+
+// The template tests render plugin_templates/ without a cluster, so they are
+// plain go tests rather than part of the envtest suite
+
+func testBundle() *PluginBundle {
+	bundle := &PluginBundle{Version: "test", Images: make(map[string]string)}
+	for _, key := range bundleImageKeys {
+		bundle.Images[key] = "docker.io/luigi-test/" + key + ":test"
+	}
+	return bundle
+}
+
+type templateTestCase struct {
+	plugin   string
+	variant  string
+	config   ApplyPlugin
+	registry string
+}
+
+func templateTestCases() []templateTestCase {
+	waitTime := 120
+	return []templateTestCase{
+		{plugin: "multus", variant: "default", config: &MultusT{}},
+		{plugin: "multus", variant: "custom", registry: "registry.example.com", config: &MultusT{
+			Namespace:       "custom-ns",
+			ImagePullPolicy: "Always",
+			MultusImage:     "registry.example.com/multus:custom",
+		}},
+		{plugin: "whereabouts", variant: "default", config: &WhereaboutsT{}},
+		{plugin: "whereabouts", variant: "custom", registry: "registry.example.com", config: &WhereaboutsT{
+			Namespace:                "custom-ns",
+			ImagePullPolicy:          "Always",
+			WhereaboutsImage:         "registry.example.com/whereabouts:custom",
+			IpReconcilerSchedule:     "*/5 * * * *",
+			IpReconcilerNodeSelector: map[string]string{"node-role.kubernetes.io/worker": ""},
+		}},
+		{plugin: "sriov", variant: "default", config: &SriovT{}},
+		{plugin: "sriov", variant: "custom", registry: "registry.example.com", config: &SriovT{
+			Namespace:     "custom-ns",
+			SriovCniImage: "registry.example.com/sriov-cni:custom",
+			SriovDpImage:  "registry.example.com/sriov-dp:custom",
+		}},
+		{plugin: "ovs", variant: "default", config: &OvsT{}},
+		{plugin: "ovs", variant: "custom", registry: "registry.example.com", config: &OvsT{
+			Namespace:       "custom-ns",
+			ImagePullPolicy: "Always",
+			OVSImage:        "registry.example.com/ovs:custom",
+			CNIImage:        "registry.example.com/ovs-cni:custom",
+			MarkerImage:     "registry.example.com/ovs-marker:custom",
+			DPDK: &plumberv1.Dpdk{
+				LcoreMask:      "0x1",
+				SocketMemory:   []plumberv1.DpdkSocketMemory{{Socket: 0, MemoryMB: 1024}, {Socket: 1, MemoryMB: 1024}},
+				PmdCpuMask:     "0x6",
+				HugepageMemory: "2Gi",
+				ExtraArgs:      "--iova-mode=va",
+				VhostSockDir:   "/var/run/vhost",
+			},
+			OtherConfig: map[string]string{"max-idle": "30000"},
+			ExternalIds: map[string]string{"ovn-encap-type": "geneve"},
+		}},
+		{plugin: "hostplumber", variant: "default", config: &HostPlumberT{}},
+		{plugin: "hostplumber", variant: "custom", registry: "registry.example.com", config: &HostPlumberT{
+			Namespace:        "custom-ns",
+			ImagePullPolicy:  "Always",
+			HostPlumberImage: "registry.example.com/hostplumber:custom",
+			MetricsPort:      "9090",
+		}},
+		{plugin: "dhcpcontroller", variant: "default", config: &DhcpControllerT{}},
+		{plugin: "dhcpcontroller", variant: "custom", registry: "registry.example.com", config: &DhcpControllerT{
+			KubemacpoolNamespace: "custom-kubemacpool",
+			ImagePullPolicy:      "Always",
+			DhcpControllerImage:  "registry.example.com/dhcp-controller:custom",
+			KubemacpoolRanges: []plumberv1.MacRange{
+				{Start: "02:00:00:01:00:00", End: "02:00:00:01:ff:ff"},
+				{Start: "02:00:00:00:00:00", End: "02:00:00:00:ff:ff"},
+			},
+			PodNamespaceSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"kubemacpool": "enabled"}},
+			VmNamespaceSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"kubemacpool": "enabled"}},
+			MacAllocationWaitTime: &waitTime,
+		}},
+		{plugin: "nfd", variant: "default", config: &NodeFeatureDiscoveryT{}},
+		{plugin: "nfd", variant: "custom", registry: "registry.example.com", config: &NodeFeatureDiscoveryT{
+			ImagePullPolicy: "Always",
+			NfdImage:        "registry.example.com/nfd:custom",
+		}},
+	}
+}
+
+func TestPluginTemplates(t *testing.T) {
+	prevTemplateDir := TemplateDir
+	SetTemplateDir("../plugin_templates")
+	defer SetTemplateDir(prevTemplateDir)
+
+	prevHugepageSize := hostHugepageSize
+	hostHugepageSize = func() string { return HugepageSize }
+	defer func() { hostHugepageSize = prevHugepageSize }()
+
+	decoder := strictDecoder(t)
+	bundle := testBundle()
+
+	for _, tc := range templateTestCases() {
+		tc := tc
+		t.Run(tc.plugin+"/"+tc.variant, func(t *testing.T) {
+			outputDir := t.TempDir()
+			if err := tc.config.WriteConfigToTemplate(outputDir, bundle, tc.registry); err != nil {
+				t.Fatalf("WriteConfigToTemplate failed: %v", err)
+			}
+
+			rendered, err := filepath.Glob(filepath.Join(outputDir, "*.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rendered) == 0 {
+				t.Fatalf("no manifests rendered")
+			}
+
+			goldenDir := filepath.Join("testdata", "templates", tc.plugin, tc.variant)
+			for _, file := range rendered {
+				got, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				validateManifests(t, decoder, filepath.Base(file), got)
+				compareGolden(t, filepath.Join(goldenDir, filepath.Base(file)), got)
+			}
+		})
+	}
+}
+
+func TestPluginTemplatesMissingKey(t *testing.T) {
+	prevTemplateDir := TemplateDir
+	SetTemplateDir(t.TempDir())
+	defer SetTemplateDir(prevTemplateDir)
+
+	if err := os.MkdirAll(filepath.Join(TemplateDir, "multus"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := []byte("image: {{ .MultusImag }}\n")
+	if err := os.WriteFile(filepath.Join(TemplateDir, "multus", "multus.yaml"), tmpl, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	multus := &MultusT{}
+	if err := multus.WriteConfigToTemplate(t.TempDir(), testBundle(), ""); err == nil {
+		t.Fatalf("expected an error rendering a template with a misspelt key")
+	}
+}
+
+// strictDecoder decodes manifests into the typed API objects, rejecting
+// unknown and duplicate fields as well as values of the wrong type.
+//
+// This is not OpenAPI schema validation: no OpenAPI document of the built-in
+// kinds is available offline, so what only the API server checks, like
+// required fields, enums and formats, is not caught here. The schemas of the
+// CRDs are checked by validateCRD.
+func strictDecoder(t *testing.T) runtime.Decoder {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiextensionsv1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	return serializer.NewCodecFactory(s, serializer.EnableStrict).UniversalDeserializer()
+}
+
+func validateManifests(t *testing.T, decoder runtime.Decoder, name string, manifest []byte) {
+	t.Helper()
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(manifest)))
+	for i := 0; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to split document %d: %v", name, i, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, gvk, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			t.Errorf("%s: document %d (%v) is invalid: %v", name, i, gvk, err)
+			continue
+		}
+		if crd, ok := obj.(*apiextensionsv1.CustomResourceDefinition); ok {
+			if err := validateCRD(crd); err != nil {
+				t.Errorf("%s: CRD %s is invalid: %v", name, crd.Name, err)
+			}
+		}
+	}
+}
+
+// validateCRD checks that the schema of every version of the CRD is a valid
+// structural schema, as the API server requires for apiextensions.k8s.io/v1
+func validateCRD(crd *apiextensionsv1.CustomResourceDefinition) error {
+	var errs []error
+	for _, version := range crd.Spec.Versions {
+		if version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+			errs = append(errs, fmt.Errorf("version %s has no schema", version.Name))
+			continue
+		}
+		internal := &apiextensions.JSONSchemaProps{}
+		if err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(version.Schema.OpenAPIV3Schema, internal, nil); err != nil {
+			errs = append(errs, fmt.Errorf("version %s: %w", version.Name, err))
+			continue
+		}
+		structural, err := schema.NewStructural(internal)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("version %s schema is not structural: %w", version.Name, err))
+			continue
+		}
+		path := field.NewPath("spec", "versions").Key(version.Name).Child("schema", "openAPIV3Schema")
+		if fieldErrs := schema.ValidateStructural(path, structural); len(fieldErrs) > 0 {
+			errs = append(errs, fmt.Errorf("version %s schema is not structural: %w", version.Name, fieldErrs.ToAggregate()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func TestValidateCRD(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name: "v1",
+				Schema: &apiextensionsv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+						Type: "object",
+						Properties: map[string]apiextensionsv1.JSONSchemaProps{
+							"spec": {Type: "object", Properties: map[string]apiextensionsv1.JSONSchemaProps{
+								"count": {Type: "integer"},
+							}},
+						},
+					},
+				},
+			}},
+		},
+	}
+	if err := validateCRD(crd); err != nil {
+		t.Fatalf("valid schema rejected: %v", err)
+	}
+
+	// Every property of a structural schema has a type
+	crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["status"] = apiextensionsv1.JSONSchemaProps{}
+	if err := validateCRD(crd); err == nil {
+		t.Errorf("schema without a type accepted")
+	}
+}
+
+func compareGolden(t *testing.T, goldenFile string, got []byte) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenFile, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run go test with -update to create it: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s does not match the rendered template, run go test with -update if the change is intended", goldenFile)
+	}
+}
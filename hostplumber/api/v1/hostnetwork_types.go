@@ -34,16 +34,60 @@ type HostNetworkStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	OvsStatus       []*OvsStatus       `json:"ovsStatus,omitempty"`
+	OvsSystem       *OvsSystemStatus   `json:"ovsSystem,omitempty"`
 	InterfaceStatus []*InterfaceStatus `json:"interfaceStatus,omitempty"`
 	Routes          *Routes            `json:"routes,omitempty"`
-	Sysctl          []string           `json:"sysctlConfig,omitempty"`
+	// Sysctl holds key=value with the current value of each sysctl set by a template
+	Sysctl []string `json:"sysctlConfig,omitempty"`
+	// Rules are the IPv4 and IPv6 policy routing rules of the host
+	Rules []*Rule `json:"rules,omitempty"`
 }
 
+// OvsStatus is the state of an OVS bridge
 type OvsStatus struct {
+	// NodeInterface lists the interfaces attached to the bridge, comma
+	// separated as in OvsConfig
 	NodeInterface string `json:"nodeInterface,omitempty"`
 	BridgeName    string `json:"bridgeName,omitempty"`
+	// DatapathType is netdev for a DPDK bridge, system for the kernel datapath
+	DatapathType string           `json:"datapathType,omitempty"`
+	Ports        []*OvsPortStatus `json:"ports,omitempty"`
 }
 
+// OvsPortStatus is a port of an OVS bridge. A port with more than one interface is a bond.
+type OvsPortStatus struct {
+	Name     string `json:"name"`
+	BondMode string `json:"bondMode,omitempty"`
+	Lacp     string `json:"lacp,omitempty"`
+	// LacpStatus is Negotiated when LACP is current on every member, Degraded
+	// when on some of them only, and Failed when on none
+	LacpStatus string `json:"lacpStatus,omitempty"`
+	// BondActiveMember is the name of the member interface carrying traffic
+	BondActiveMember string                `json:"bondActiveMember,omitempty"`
+	Interfaces       []*OvsInterfaceStatus `json:"interfaces,omitempty"`
+}
+
+type OvsInterfaceStatus struct {
+	Name string `json:"name"`
+	// Type is system for a kernel netdev, otherwise the OVS interface type,
+	// e.g. internal, dpdk, dpdkvhostuser, dpdkvhostuserclient
+	Type       string `json:"type"`
+	AdminState string `json:"adminState,omitempty"`
+	LinkState  string `json:"linkState,omitempty"`
+	MTU        int    `json:"mtu,omitempty"`
+	// LacpCurrent is unset when LACP does not run on the interface
+	LacpCurrent *bool  `json:"lacpCurrent,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// OvsSystemStatus is the node wide OVS state
+type OvsSystemStatus struct {
+	OvsVersion      string `json:"ovsVersion,omitempty"`
+	DpdkInitialized bool   `json:"dpdkInitialized"`
+	DpdkVersion     string `json:"dpdkVersion,omitempty"`
+}
+
+// InterfaceStatus is a physical NIC, or a bond. PfName is the interface name.
 type InterfaceStatus struct {
 	PfName       string       `json:"pfName,omitempty"`
 	IPv4         *IPv4Info    `json:"ipv4,omitempty"`
@@ -56,6 +100,30 @@ type InterfaceStatus struct {
 	PfDriver     string       `json:"pfDriver,omitempty"`
 	SriovEnabled bool         `json:"sriovEnabled"`
 	SriovStatus  *SriovStatus `json:"sriovStatus,omitempty"`
+	// EswitchMode is legacy or switchdev, empty if the PF has no eswitch
+	EswitchMode string `json:"eswitchMode,omitempty"`
+	HwTcOffload bool   `json:"hwTcOffload,omitempty"`
+	// Master is the bond or bridge the interface is enslaved to
+	Master     string      `json:"master,omitempty"`
+	BondStatus *BondStatus `json:"bondStatus,omitempty"`
+}
+
+type BondStatus struct {
+	Mode           string              `json:"mode,omitempty"`
+	Miimon         int                 `json:"miimon,omitempty"`
+	LacpRate       string              `json:"lacpRate,omitempty"`
+	XmitHashPolicy string              `json:"xmitHashPolicy,omitempty"`
+	ActiveMember   string              `json:"activeMember,omitempty"`
+	Members        []*BondMemberStatus `json:"members,omitempty"`
+}
+
+type BondMemberStatus struct {
+	Name string `json:"name"`
+	// MiiStatus is UP, GOING_DOWN, DOWN or GOING_BACK
+	MiiStatus string `json:"miiStatus,omitempty"`
+	// State is ACTIVE or BACKUP
+	State            string `json:"state,omitempty"`
+	LinkFailureCount int    `json:"linkFailureCount,omitempty"`
 }
 
 type IPv4Info struct {
@@ -63,13 +131,31 @@ type IPv4Info struct {
 }
 
 type IPv6Info struct {
+	// Address lists the static global addresses to configure. In the status
+	// it lists the global addresses, static or from SLAAC and DHCPv6.
 	Address []string `json:"address,omitempty"`
+	// LinkLocal lists the link-local addresses, only in the status
+	LinkLocal []string `json:"linkLocal,omitempty"`
+	// Autoconf enables or disables SLAAC, accepting router advertisements
+	// even with forwarding enabled. Unset leaves it as it is. In the status
+	// it tells whether SLAAC is enabled.
+	Autoconf *bool `json:"autoconf,omitempty"`
 }
 
 type SriovStatus struct {
 	TotalVfs int       `json:"totalVfs,omitempty"`
 	NumVfs   int       `json:"numVfs,omitempty"`
 	Vfs      []*VfInfo `json:"vfs,omitempty"`
+	// VfGroups are the VF ranges with their own driver or resource name
+	VfGroups []*VfGroupStatus `json:"vfGroups,omitempty"`
+}
+
+// VfGroupStatus is a range of VFs of a PF, bound to VfDriver and meant for
+// the device plugin resource ResourceName
+type VfGroupStatus struct {
+	VfRange      string `json:"vfRange"`
+	VfDriver     string `json:"vfDriver"`
+	ResourceName string `json:"resourceName,omitempty"`
 }
 
 type VfInfo struct {
@@ -81,6 +167,13 @@ type VfInfo struct {
 	Qos      int    `json:"qos"`
 	Spoofchk bool   `json:"spoofchk"`
 	Trust    bool   `json:"trust"`
+	// MinTxRate and MaxTxRate are in Mbps, 0 is no limit
+	MinTxRate int `json:"minTxRate,omitempty"`
+	MaxTxRate int `json:"maxTxRate,omitempty"`
+	// LinkState is auto, enable or disable
+	LinkState string `json:"linkState,omitempty"`
+	// Representor is the netdev of the VF on the eswitch, in switchdev mode
+	Representor string `json:"representor,omitempty"`
 }
 
 type Routes struct {
@@ -93,6 +186,19 @@ type Route struct {
 	Gw  string `json:"gw,omitempty"`
 	Dev string `json:"dev,omitempty"`
 	Src string `json:"src,omitempty"`
+	// Table is set for routes outside of the main table
+	Table int `json:"table,omitempty"`
+}
+
+// Rule is a policy routing rule
+type Rule struct {
+	Priority int    `json:"priority"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Iif      string `json:"iif,omitempty"`
+	Oif      string `json:"oif,omitempty"`
+	Fwmark   int    `json:"fwmark,omitempty"`
+	Table    int    `json:"table"`
 }
 
 //+kubebuilder:object:root=true
@@ -32,6 +32,78 @@ type HostNetworkTemplateSpec struct {
 	InterfaceConfig []InterfaceConfig `json:"interfaceConfig,omitempty"`
 	SriovConfig     []SriovConfig     `json:"sriovConfig,omitempty"`
 	OvsConfig       []*OvsConfig      `json:"ovsConfig,omitempty"`
+	// BondConfig lists Linux bonds. They are created before interfaceConfig is
+	// applied, so VLANs, MTUs and IPs can be configured on them there.
+	BondConfig []BondConfig `json:"bondConfig,omitempty"`
+	// VxlanConfig lists VXLAN interfaces. They are created after VLANs and
+	// before bridges, so they can use a VLAN as device and be bridge ports.
+	VxlanConfig []VxlanConfig `json:"vxlanConfig,omitempty"`
+	// BridgeConfig lists Linux bridges. They are created before
+	// interfaceConfig is applied, so MTUs and IPs can be configured on them there.
+	BridgeConfig []BridgeConfig `json:"bridgeConfig,omitempty"`
+	// RouteConfig lists static routes. They are added after every other
+	// section, so they can use the interfaces and addresses configured there.
+	RouteConfig []RouteConfig `json:"routeConfig,omitempty"`
+	// RuleConfig lists policy routing rules
+	RuleConfig []RuleConfig `json:"ruleConfig,omitempty"`
+	// SysctlConfig sets networking sysctls, e.g. net.ipv4.ip_forward: "1".
+	// Only an allowlist of net.* sysctls can be set. A dot in an interface
+	// name is written as a slash: net.ipv4.conf.bond0/1000.rp_filter.
+	SysctlConfig map[string]string `json:"sysctlConfig,omitempty"`
+	// SriovDisruption controls how sriovConfig changes that reset VFs in use
+	// are rolled out. By default the node is drained first, one node at a time.
+	SriovDisruption *SriovDisruptionConfig `json:"sriovDisruption,omitempty"`
+	// ConnectivityCheck, when set, snapshots the host network before applying
+	// and restores it if the node loses connectivity after applying
+	ConnectivityCheck *ConnectivityCheckConfig `json:"connectivityCheck,omitempty"`
+}
+
+// Policies for disruptive sriovConfig changes
+const (
+	DisruptionPolicyDrain    = "Drain"
+	DisruptionPolicyApproval = "Approval"
+	DisruptionPolicyNone     = "None"
+)
+
+// ApproveDisruptionAnnotation is set on a Node to let the Approval policy
+// apply a disruptive change there. It is removed once the change is applied.
+const ApproveDisruptionAnnotation = "plumber.k8s.pf9.io/approve-disruption"
+
+// ConnectivityCheckConfig sets what the node must reach once the template is
+// applied. The API server is always checked.
+type ConnectivityCheckConfig struct {
+	// TimeoutSeconds is how long the node has to reach the API server and the
+	// ping targets before the change is rolled back, 60 if unset
+	// +kubebuilder:validation:Minimum=5
+	TimeoutSeconds *int `json:"timeoutSeconds,omitempty"`
+	// PingTargets are addresses or hostnames that must answer a ping too,
+	// e.g. the default gateway
+	PingTargets []string `json:"pingTargets,omitempty"`
+}
+
+// PlanAnnotation puts a template in plan mode: while it is set, each
+// matching node writes the changes applying the template would make to
+// status.plans instead of applying it. Changing its value plans again.
+const PlanAnnotation = "plumber.k8s.pf9.io/plan"
+
+// SriovDisruptionConfig sets how disruptive sriovConfig changes are applied: a
+// VF count change, or a VF driver or eswitch mode change on existing VFs
+type SriovDisruptionConfig struct {
+	// Policy Drain cordons and drains the node, respecting
+	// PodDisruptionBudgets. Approval waits for the
+	// plumber.k8s.pf9.io/approve-disruption annotation on the node. None
+	// applies the change right away.
+	// +kubebuilder:validation:Enum=Drain;Approval;None
+	Policy string `json:"policy,omitempty"`
+	// MaxUnavailable is how many nodes of the template can go through a
+	// disruptive change at once, 1 if unset
+	// +kubebuilder:validation:Minimum=1
+	MaxUnavailable *int `json:"maxUnavailable,omitempty"`
+	// ResourceTimeoutSeconds is how long to wait for the device plugin to
+	// advertise the resourceNames of vfConfig again before uncordoning the
+	// node anyway, 600 if unset
+	// +kubebuilder:validation:Minimum=0
+	ResourceTimeoutSeconds *int `json:"resourceTimeoutSeconds,omitempty"`
 }
 
 type InterfaceConfig struct {
@@ -45,17 +117,65 @@ type InterfaceConfig struct {
 type VlanConfig struct {
 	VlanId *int    `json:"id"`
 	Name   *string `json:"name,omitempty"`
+	// IPv4 and IPv6 configure the addresses of the VLAN interface, as in
+	// interfaceConfig
+	IPv4 *IPv4Info `json:"ipv4,omitempty"`
+	IPv6 *IPv6Info `json:"ipv6,omitempty"`
 }
 
 type SriovConfig struct {
 	PfName   *string `json:"pfName,omitempty"`
 	PciAddr  *string `json:"pciAddr,omitempty"`
 	VendorId *string `json:"vendorId,omitempty"`
+	// DeviceId narrows down vendorId, without it every PF of the vendor with
+	// an SR-IOV profile is selected
 	DeviceId *string `json:"deviceId,omitempty"`
 	NumVfs   *int    `json:"numVfs,omitempty"`
 	MTU      *int    `json:"mtu,omitempty"`
+	// VfDriver defaults to the VF driver of the PF's SR-IOV profile
 	VfDriver *string `json:"vfDriver,omitempty"`
 	PfDriver *string `json:"pfDriver,omitempty"`
+	// EswitchMode is set through devlink after the VFs are created. switchdev
+	// creates a representor per VF, for OVS hardware offload.
+	// +kubebuilder:validation:Enum=legacy;switchdev
+	EswitchMode string `json:"eswitchMode,omitempty"`
+	// HwTcOffload turns the hw-tc-offload ethtool feature of the PF on or off
+	HwTcOffload *bool `json:"hwTcOffload,omitempty"`
+	// VfConfig sets VFs of the PF through netlink, after they are created
+	VfConfig []VfConfig `json:"vfConfig,omitempty"`
+}
+
+// VfConfig sets a VF or a range of VFs. Unset fields are left as they are.
+type VfConfig struct {
+	// VfRange is a VF ID, or an inclusive range of IDs like 0-7
+	// +kubebuilder:validation:Pattern=`^[0-9]+(-[0-9]+)?$`
+	VfRange string `json:"vfRange"`
+	// VfDriver binds the VFs of the range to another driver than the PF's
+	// vfDriver. A VF can only be in one range with a vfDriver or resourceName.
+	VfDriver string `json:"vfDriver,omitempty"`
+	// ResourceName is the SR-IOV device plugin resource the range is meant
+	// for, reported in the PF status
+	ResourceName string `json:"resourceName,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4095
+	Vlan *int `json:"vlan,omitempty"`
+	// Qos is the 802.1p priority of the VLAN
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=7
+	Qos *int `json:"qos,omitempty"`
+	// Mac is the administrative MAC, only for a single VF
+	Mac      string `json:"mac,omitempty"`
+	Trust    *bool  `json:"trust,omitempty"`
+	Spoofchk *bool  `json:"spoofchk,omitempty"`
+	// MinTxRate is the guaranteed rate in Mbps, 0 for none
+	// +kubebuilder:validation:Minimum=0
+	MinTxRate *int `json:"minTxRate,omitempty"`
+	// MaxTxRate is the rate limit in Mbps, 0 for none
+	// +kubebuilder:validation:Minimum=0
+	MaxTxRate *int `json:"maxTxRate,omitempty"`
+	// LinkState of auto follows the PF link
+	// +kubebuilder:validation:Enum=auto;enable;disable
+	LinkState string `json:"linkState,omitempty"`
 }
 
 type OvsConfig struct {
@@ -63,6 +183,104 @@ type OvsConfig struct {
 	BridgeName    string  `json:"bridgeName,omitempty"`
 	Dpdk          bool    `json:"dpdk,omitempty"`
 	Params        *Params `json:"params,omitempty"`
+	// VfRepresentors are added to the bridge as ports, nodeInterface can be
+	// left empty for a bridge of representors only
+	VfRepresentors []VfRepresentorConfig `json:"vfRepresentors,omitempty"`
+}
+
+// VfRepresentorConfig selects the representors of VFs of a PF in switchdev mode
+type VfRepresentorConfig struct {
+	PfName string `json:"pfName"`
+	// VfRange is a VF ID, or an inclusive range of IDs like 0-7, all VFs if unset
+	// +kubebuilder:validation:Pattern=`^[0-9]+(-[0-9]+)?$`
+	VfRange string `json:"vfRange,omitempty"`
+}
+
+// BondConfig is a Linux bond interface
+type BondConfig struct {
+	Name string `json:"name"`
+	// +kubebuilder:validation:MinItems=1
+	Members []string `json:"members"`
+	// +kubebuilder:validation:Enum="802.3ad";active-backup;balance-xor
+	Mode string `json:"mode"`
+	// Miimon is the MII link monitoring interval in milliseconds, 100 if unset
+	// +kubebuilder:validation:Minimum=0
+	Miimon *int `json:"miimon,omitempty"`
+	// LacpRate only applies to 802.3ad bonds
+	// +kubebuilder:validation:Enum=slow;fast
+	LacpRate string `json:"lacpRate,omitempty"`
+	// XmitHashPolicy applies to 802.3ad and balance-xor bonds
+	// +kubebuilder:validation:Enum=layer2;layer2+3;layer3+4;encap2+3;encap3+4
+	XmitHashPolicy string `json:"xmitHashPolicy,omitempty"`
+}
+
+// BridgeConfig is a Linux bridge interface
+type BridgeConfig struct {
+	Name string `json:"name"`
+	// Ports are enslaved to the bridge, ports enslaved before and no longer
+	// listed are released
+	Ports []string `json:"ports,omitempty"`
+	// Stp enables the spanning tree protocol
+	Stp bool `json:"stp,omitempty"`
+	// VlanFiltering makes the bridge VLAN aware
+	VlanFiltering bool `json:"vlanFiltering,omitempty"`
+}
+
+// VxlanConfig is a VXLAN interface. Remote and Group are mutually exclusive.
+type VxlanConfig struct {
+	Name string `json:"name"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=16777215
+	Vni int `json:"vni"`
+	// LocalIP is the source address of the encapsulated packets
+	LocalIP string `json:"localIP,omitempty"`
+	// Remote is the unicast destination of the encapsulated packets
+	Remote string `json:"remote,omitempty"`
+	// Group is the multicast group to join, it needs Device
+	Group string `json:"group,omitempty"`
+	// DstPort is the UDP destination port, 4789 if unset
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	DstPort *int `json:"dstPort,omitempty"`
+	// Device is the underlying interface of the tunnel
+	Device string `json:"device,omitempty"`
+}
+
+// RouteConfig is a static route. It needs a gw, a dev or both.
+type RouteConfig struct {
+	// Dst is a CIDR, or default
+	Dst string `json:"dst"`
+	Gw  string `json:"gw,omitempty"`
+	Dev string `json:"dev,omitempty"`
+	// Src is the preferred source address
+	Src string `json:"src,omitempty"`
+	// Table is the routing table ID, the main table if unset
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4294967295
+	Table *int64 `json:"table,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	Metric *int `json:"metric,omitempty"`
+}
+
+// RuleConfig is a policy routing rule, which looks up Table for the packets
+// it matches
+type RuleConfig struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=32765
+	Priority int `json:"priority"`
+	// From matches the source CIDR
+	From string `json:"from,omitempty"`
+	// To matches the destination CIDR
+	To string `json:"to,omitempty"`
+	// Iif matches the incoming interface
+	Iif string `json:"iif,omitempty"`
+	// Oif matches the outgoing interface
+	Oif string `json:"oif,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	Fwmark *int64 `json:"fwmark,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4294967295
+	Table int64 `json:"table"`
 }
 
 type Params struct {
@@ -71,14 +289,99 @@ type Params struct {
 	Lacp       string `json:"lacp,omitempty"`
 }
 
+// Condition types of a HostNetworkTemplate and of each node it is applied on
+const (
+	// Applied is True once every node matching NodeSelector applied the current generation
+	ConditionApplied = "Applied"
+
+	ConditionSriovApplied      = "SriovApplied"
+	ConditionBondsApplied      = "BondsApplied"
+	ConditionInterfacesApplied = "InterfacesApplied"
+	ConditionSysctlApplied     = "SysctlApplied"
+	ConditionVlansApplied      = "VlansApplied"
+	ConditionVxlansApplied     = "VxlansApplied"
+	ConditionBridgesApplied    = "BridgesApplied"
+	ConditionOvsApplied        = "OvsApplied"
+	ConditionRoutesApplied     = "RoutesApplied"
+	ConditionRulesApplied      = "RulesApplied"
+	// ConnectivityVerified is False once a change that cut the node off was rolled back
+	ConditionConnectivityVerified = "ConnectivityVerified"
+)
+
+// Phases of a disruptive sriovConfig change on a node. Nodes in any of them
+// count against maxUnavailable.
+const (
+	DisruptionDraining            = "Draining"
+	DisruptionWaitingForApproval  = "WaitingForApproval"
+	DisruptionWaitingForResources = "WaitingForResources"
+)
+
 // HostNetworkTemplateStatus defines the observed state of HostNetworkTemplate
 type HostNetworkTemplateStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// Summary is a one line overview, e.g. "Applied 12/14 nodes"
+	Summary string `json:"summary,omitempty"`
+	// MatchingNodes is the number of nodes matching NodeSelector
+	MatchingNodes int `json:"matchingNodes"`
+	// AppliedNodes is the number of matching nodes that applied the current generation
+	AppliedNodes int `json:"appliedNodes"`
+	// FailedNodes lists the matching nodes whose last apply failed
+	FailedNodes []string `json:"failedNodes,omitempty"`
+	// Nodes holds the apply result reported by the hostplumber agent of each matching node
+	Nodes []NodeApplyStatus `json:"nodes,omitempty"`
+	// Plans holds the changes each matching node would make, in plan mode
+	Plans      []NodePlan         `json:"plans,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// NodePlan is what applying the template would change on one node, computed
+// against the state discovered there without touching the host
+type NodePlan struct {
+	NodeName string `json:"nodeName"`
+	// Generation is the template generation this plan is for
+	Generation int64 `json:"generation"`
+	// Changes lists each change, e.g. "delete VLAN eth1.100" or
+	// "move port eth2 from OVS bridge br-old to br-new"
+	Changes []string `json:"changes,omitempty"`
+	// Error is set when the plan could not be computed completely
+	Error string `json:"error,omitempty"`
+	// PlannedTime is when Changes or Error last changed
+	PlannedTime metav1.Time `json:"plannedTime"`
+}
+
+// NodeApplyStatus is the result of applying the template on one node
+type NodeApplyStatus struct {
+	NodeName string `json:"nodeName"`
+	// ObservedGeneration is the template generation this result is for
+	ObservedGeneration int64  `json:"observedGeneration"`
+	Applied            bool   `json:"applied"`
+	LastError          string `json:"lastError,omitempty"`
+	// LastTransitionTime is when Applied, LastError or ObservedGeneration last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	// Conditions has one entry per section of the spec: SriovApplied,
+	// InterfacesApplied, VlansApplied and OvsApplied
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Disruption is the phase of a disruptive sriovConfig change in progress:
+	// Draining, WaitingForApproval or WaitingForResources
+	Disruption string `json:"disruption,omitempty"`
+	// DisruptionTime is when the node entered the Disruption phase
+	DisruptionTime *metav1.Time `json:"disruptionTime,omitempty"`
+	// RolledBackGeneration is the template generation that was rolled back
+	// after the node lost connectivity. It is not applied again.
+	RolledBackGeneration int64 `json:"rolledBackGeneration,omitempty"`
+	// RollbackTime is when the rollback happened
+	RollbackTime *metav1.Time `json:"rollbackTime,omitempty"`
+	// RollbackReason is the connectivity check that failed
+	RollbackReason string `json:"rollbackReason,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.summary`
+//+kubebuilder:printcolumn:name="Failed Nodes",type=string,JSONPath=`.status.failedNodes`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // HostNetworkTemplate is the Schema for the hostnetworktemplates API
 type HostNetworkTemplate struct {
@@ -21,9 +21,121 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BondConfig) DeepCopyInto(out *BondConfig) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Miimon != nil {
+		in, out := &in.Miimon, &out.Miimon
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BondConfig.
+func (in *BondConfig) DeepCopy() *BondConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(BondConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BondMemberStatus) DeepCopyInto(out *BondMemberStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BondMemberStatus.
+func (in *BondMemberStatus) DeepCopy() *BondMemberStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BondMemberStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BondStatus) DeepCopyInto(out *BondStatus) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]*BondMemberStatus, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(BondMemberStatus)
+				**out = **in
+			}
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BondStatus.
+func (in *BondStatus) DeepCopy() *BondStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BondStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BridgeConfig) DeepCopyInto(out *BridgeConfig) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BridgeConfig.
+func (in *BridgeConfig) DeepCopy() *BridgeConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(BridgeConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectivityCheckConfig) DeepCopyInto(out *ConnectivityCheckConfig) {
+	*out = *in
+	if in.TimeoutSeconds != nil {
+		in, out := &in.TimeoutSeconds, &out.TimeoutSeconds
+		*out = new(int)
+		**out = **in
+	}
+	if in.PingTargets != nil {
+		in, out := &in.PingTargets, &out.PingTargets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectivityCheckConfig.
+func (in *ConnectivityCheckConfig) DeepCopy() *ConnectivityCheckConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectivityCheckConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostNetwork) DeepCopyInto(out *HostNetwork) {
 	*out = *in
@@ -108,10 +220,15 @@ func (in *HostNetworkStatus) DeepCopyInto(out *HostNetworkStatus) {
 			if (*in)[i] != nil {
 				in, out := &(*in)[i], &(*out)[i]
 				*out = new(OvsStatus)
-				**out = **in
+				(*in).DeepCopyInto(*out)
 			}
 		}
 	}
+	if in.OvsSystem != nil {
+		in, out := &in.OvsSystem, &out.OvsSystem
+		*out = new(OvsSystemStatus)
+		**out = **in
+	}
 	if in.InterfaceStatus != nil {
 		in, out := &in.InterfaceStatus, &out.InterfaceStatus
 		*out = make([]*InterfaceStatus, len(*in))
@@ -133,6 +250,17 @@ func (in *HostNetworkStatus) DeepCopyInto(out *HostNetworkStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]*Rule, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(Rule)
+				**out = **in
+			}
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostNetworkStatus.
@@ -151,7 +279,7 @@ func (in *HostNetworkTemplate) DeepCopyInto(out *HostNetworkTemplate) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostNetworkTemplate.
@@ -239,6 +367,58 @@ func (in *HostNetworkTemplateSpec) DeepCopyInto(out *HostNetworkTemplateSpec) {
 			}
 		}
 	}
+	if in.BondConfig != nil {
+		in, out := &in.BondConfig, &out.BondConfig
+		*out = make([]BondConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.VxlanConfig != nil {
+		in, out := &in.VxlanConfig, &out.VxlanConfig
+		*out = make([]VxlanConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.BridgeConfig != nil {
+		in, out := &in.BridgeConfig, &out.BridgeConfig
+		*out = make([]BridgeConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RouteConfig != nil {
+		in, out := &in.RouteConfig, &out.RouteConfig
+		*out = make([]RouteConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RuleConfig != nil {
+		in, out := &in.RuleConfig, &out.RuleConfig
+		*out = make([]RuleConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SysctlConfig != nil {
+		in, out := &in.SysctlConfig, &out.SysctlConfig
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SriovDisruption != nil {
+		in, out := &in.SriovDisruption, &out.SriovDisruption
+		*out = new(SriovDisruptionConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConnectivityCheck != nil {
+		in, out := &in.ConnectivityCheck, &out.ConnectivityCheck
+		*out = new(ConnectivityCheckConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostNetworkTemplateSpec.
@@ -254,6 +434,32 @@ func (in *HostNetworkTemplateSpec) DeepCopy() *HostNetworkTemplateSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostNetworkTemplateStatus) DeepCopyInto(out *HostNetworkTemplateStatus) {
 	*out = *in
+	if in.FailedNodes != nil {
+		in, out := &in.FailedNodes, &out.FailedNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeApplyStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Plans != nil {
+		in, out := &in.Plans, &out.Plans
+		*out = make([]NodePlan, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostNetworkTemplateStatus.
@@ -294,6 +500,16 @@ func (in *IPv6Info) DeepCopyInto(out *IPv6Info) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.LinkLocal != nil {
+		in, out := &in.LinkLocal, &out.LinkLocal
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Autoconf != nil {
+		in, out := &in.Autoconf, &out.Autoconf
+		*out = new(bool)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPv6Info.
@@ -366,6 +582,11 @@ func (in *InterfaceStatus) DeepCopyInto(out *InterfaceStatus) {
 		*out = new(SriovStatus)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.BondStatus != nil {
+		in, out := &in.BondStatus, &out.BondStatus
+		*out = new(BondStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceStatus.
@@ -378,6 +599,58 @@ func (in *InterfaceStatus) DeepCopy() *InterfaceStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeApplyStatus) DeepCopyInto(out *NodeApplyStatus) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DisruptionTime != nil {
+		in, out := &in.DisruptionTime, &out.DisruptionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.RollbackTime != nil {
+		in, out := &in.RollbackTime, &out.RollbackTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeApplyStatus.
+func (in *NodeApplyStatus) DeepCopy() *NodeApplyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeApplyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePlan) DeepCopyInto(out *NodePlan) {
+	*out = *in
+	if in.Changes != nil {
+		in, out := &in.Changes, &out.Changes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.PlannedTime.DeepCopyInto(&out.PlannedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePlan.
+func (in *NodePlan) DeepCopy() *NodePlan {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OvsConfig) DeepCopyInto(out *OvsConfig) {
 	*out = *in
@@ -386,6 +659,11 @@ func (in *OvsConfig) DeepCopyInto(out *OvsConfig) {
 		*out = new(Params)
 		**out = **in
 	}
+	if in.VfRepresentors != nil {
+		in, out := &in.VfRepresentors, &out.VfRepresentors
+		*out = make([]VfRepresentorConfig, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OvsConfig.
@@ -398,9 +676,66 @@ func (in *OvsConfig) DeepCopy() *OvsConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OvsInterfaceStatus) DeepCopyInto(out *OvsInterfaceStatus) {
+	*out = *in
+	if in.LacpCurrent != nil {
+		in, out := &in.LacpCurrent, &out.LacpCurrent
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OvsInterfaceStatus.
+func (in *OvsInterfaceStatus) DeepCopy() *OvsInterfaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OvsInterfaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OvsPortStatus) DeepCopyInto(out *OvsPortStatus) {
+	*out = *in
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]*OvsInterfaceStatus, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(OvsInterfaceStatus)
+				(*in).DeepCopyInto(*out)
+			}
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OvsPortStatus.
+func (in *OvsPortStatus) DeepCopy() *OvsPortStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OvsPortStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OvsStatus) DeepCopyInto(out *OvsStatus) {
 	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]*OvsPortStatus, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(OvsPortStatus)
+				(*in).DeepCopyInto(*out)
+			}
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OvsStatus.
@@ -413,6 +748,21 @@ func (in *OvsStatus) DeepCopy() *OvsStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OvsSystemStatus) DeepCopyInto(out *OvsSystemStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OvsSystemStatus.
+func (in *OvsSystemStatus) DeepCopy() *OvsSystemStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OvsSystemStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Params) DeepCopyInto(out *Params) {
 	*out = *in
@@ -443,6 +793,31 @@ func (in *Route) DeepCopy() *Route {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouteConfig) DeepCopyInto(out *RouteConfig) {
+	*out = *in
+	if in.Table != nil {
+		in, out := &in.Table, &out.Table
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Metric != nil {
+		in, out := &in.Metric, &out.Metric
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouteConfig.
+func (in *RouteConfig) DeepCopy() *RouteConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RouteConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Routes) DeepCopyInto(out *Routes) {
 	*out = *in
@@ -480,6 +855,41 @@ func (in *Routes) DeepCopy() *Routes {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Rule) DeepCopyInto(out *Rule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Rule.
+func (in *Rule) DeepCopy() *Rule {
+	if in == nil {
+		return nil
+	}
+	out := new(Rule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuleConfig) DeepCopyInto(out *RuleConfig) {
+	*out = *in
+	if in.Fwmark != nil {
+		in, out := &in.Fwmark, &out.Fwmark
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleConfig.
+func (in *RuleConfig) DeepCopy() *RuleConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RuleConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SriovConfig) DeepCopyInto(out *SriovConfig) {
 	*out = *in
@@ -523,6 +933,18 @@ func (in *SriovConfig) DeepCopyInto(out *SriovConfig) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.HwTcOffload != nil {
+		in, out := &in.HwTcOffload, &out.HwTcOffload
+		*out = new(bool)
+		**out = **in
+	}
+	if in.VfConfig != nil {
+		in, out := &in.VfConfig, &out.VfConfig
+		*out = make([]VfConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SriovConfig.
@@ -535,6 +957,31 @@ func (in *SriovConfig) DeepCopy() *SriovConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SriovDisruptionConfig) DeepCopyInto(out *SriovDisruptionConfig) {
+	*out = *in
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(int)
+		**out = **in
+	}
+	if in.ResourceTimeoutSeconds != nil {
+		in, out := &in.ResourceTimeoutSeconds, &out.ResourceTimeoutSeconds
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SriovDisruptionConfig.
+func (in *SriovDisruptionConfig) DeepCopy() *SriovDisruptionConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SriovDisruptionConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SriovStatus) DeepCopyInto(out *SriovStatus) {
 	*out = *in
@@ -549,6 +996,17 @@ func (in *SriovStatus) DeepCopyInto(out *SriovStatus) {
 			}
 		}
 	}
+	if in.VfGroups != nil {
+		in, out := &in.VfGroups, &out.VfGroups
+		*out = make([]*VfGroupStatus, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(VfGroupStatus)
+				**out = **in
+			}
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SriovStatus.
@@ -561,6 +1019,66 @@ func (in *SriovStatus) DeepCopy() *SriovStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VfConfig) DeepCopyInto(out *VfConfig) {
+	*out = *in
+	if in.Vlan != nil {
+		in, out := &in.Vlan, &out.Vlan
+		*out = new(int)
+		**out = **in
+	}
+	if in.Qos != nil {
+		in, out := &in.Qos, &out.Qos
+		*out = new(int)
+		**out = **in
+	}
+	if in.Trust != nil {
+		in, out := &in.Trust, &out.Trust
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Spoofchk != nil {
+		in, out := &in.Spoofchk, &out.Spoofchk
+		*out = new(bool)
+		**out = **in
+	}
+	if in.MinTxRate != nil {
+		in, out := &in.MinTxRate, &out.MinTxRate
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxTxRate != nil {
+		in, out := &in.MaxTxRate, &out.MaxTxRate
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VfConfig.
+func (in *VfConfig) DeepCopy() *VfConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(VfConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VfGroupStatus) DeepCopyInto(out *VfGroupStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VfGroupStatus.
+func (in *VfGroupStatus) DeepCopy() *VfGroupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VfGroupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VfInfo) DeepCopyInto(out *VfInfo) {
 	*out = *in
@@ -576,6 +1094,21 @@ func (in *VfInfo) DeepCopy() *VfInfo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VfRepresentorConfig) DeepCopyInto(out *VfRepresentorConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VfRepresentorConfig.
+func (in *VfRepresentorConfig) DeepCopy() *VfRepresentorConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(VfRepresentorConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VlanConfig) DeepCopyInto(out *VlanConfig) {
 	*out = *in
@@ -589,6 +1122,16 @@ func (in *VlanConfig) DeepCopyInto(out *VlanConfig) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.IPv4 != nil {
+		in, out := &in.IPv4, &out.IPv4
+		*out = new(IPv4Info)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IPv6 != nil {
+		in, out := &in.IPv6, &out.IPv6
+		*out = new(IPv6Info)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VlanConfig.
@@ -600,3 +1143,23 @@ func (in *VlanConfig) DeepCopy() *VlanConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VxlanConfig) DeepCopyInto(out *VxlanConfig) {
+	*out = *in
+	if in.DstPort != nil {
+		in, out := &in.DstPort, &out.DstPort
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VxlanConfig.
+func (in *VxlanConfig) DeepCopy() *VxlanConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(VxlanConfig)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -18,27 +18,31 @@ package controllers
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os/exec"
-	"strconv"
+	"sort"
 	"strings"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	plumberv1 "hostplumber/api/v1"
 	hoststate "hostplumber/pkg/hoststate"
 	iputils "hostplumber/pkg/utils/ip"
 	linkutils "hostplumber/pkg/utils/link"
 	ovsutils "hostplumber/pkg/utils/ovs"
+	"hostplumber/pkg/utils/persist"
 	sriovutils "hostplumber/pkg/utils/sriov"
+	sysctlutils "hostplumber/pkg/utils/sysctl"
 )
 
 var log logr.Logger
@@ -50,11 +54,19 @@ type HostNetworkTemplateReconciler struct {
 	Log       logr.Logger
 	NodeName  string
 	Namespace string
+	// APIReader lists the pods of the node when draining it, without caching
+	// every pod of the cluster. Set from the manager if nil.
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=plumber.k8s.pf9.io,resources=hostnetworktemplates,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=plumber.k8s.pf9.io,resources=hostnetworktemplates/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=plumber.k8s.pf9.io,resources=hostnetworktemplates/finalizers,verbs=update
+//+kubebuilder:rbac:groups=plumber.k8s.pf9.io,resources=hostnetworks,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=plumber.k8s.pf9.io,resources=hostnetworks/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=list
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
 //+kubebuilder:rbac:groups=*,resources=*,verbs=*
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -85,103 +97,575 @@ func (r *HostNetworkTemplateReconciler) Reconcile(ctx context.Context, req ctrl.
 		return ctrl.Result{}, err
 	}
 
-	// name of our custom finalizer
-	ovsFinalizerName := "ovsFinalizer"
-
-	ovsConfigList := hostConfigReq.Spec.OvsConfig
+	// Each node reverts what the template configured on its own host before
+	// removing its own finalizer
+	finalizerName := nodeFinalizer(r.NodeName)
+	selector := labels.SelectorFromSet(hostConfigReq.Spec.NodeSelector)
+	nodeMatches := selector.Matches(labels.Set(myNode.Labels))
 
 	// examine DeletionTimestamp to determine if object is under deletion
 	if hostConfigReq.ObjectMeta.DeletionTimestamp.IsZero() {
 
-		if len(ovsConfigList) > 0 {
+		if nodeMatches && needsCleanup(hostConfigReq.Spec) &&
+			(!containsString(hostConfigReq.GetFinalizers(), finalizerName) || containsString(hostConfigReq.GetFinalizers(), legacyFinalizer)) {
 			log.Info(" Reconcile triggered for create/update hostnetworktemplate")
 			// The object is not being deleted, so if it does not have our finalizer,
-			// then lets add the finalizer and update the object.
-			if !containsString(hostConfigReq.GetFinalizers(), ovsFinalizerName) {
-				controllerutil.AddFinalizer(&hostConfigReq, ovsFinalizerName)
-				log.Info("Adding Finalizer for ovscleanup")
+			// then lets add the finalizer and update the object. Every node
+			// that matches adds its own when it starts, so the finalizer all
+			// nodes shared in older versions is dropped.
+			if err := adoptLegacyBridges(&hostConfigReq); err != nil {
+				return ctrl.Result{}, err
+			}
+			controllerutil.AddFinalizer(&hostConfigReq, finalizerName)
+			controllerutil.RemoveFinalizer(&hostConfigReq, legacyFinalizer)
+			log.Info("Adding Finalizer for ovscleanup", "finalizer", finalizerName)
 
-				if err := r.Update(ctx, &hostConfigReq); err != nil {
-					log.Error(err, "Error Adding Finalizer")
-					return ctrl.Result{}, err
-				}
+			if err := r.Update(ctx, &hostConfigReq); err != nil {
+				log.Error(err, "Error Adding Finalizer")
+				return ctrl.Result{}, err
 			}
 		}
 	} else {
 		// The object is being deleted
-		if containsString(hostConfigReq.GetFinalizers(), ovsFinalizerName) {
-			if err := deleteOvsConfig(ovsConfigList); err != nil {
-				// return so that it can be retried
+		if !containsString(hostConfigReq.GetFinalizers(), finalizerName) && !containsString(hostConfigReq.GetFinalizers(), legacyFinalizer) {
+			log.Info("HostNetworkTemplate being deleted, nothing to clean up on this node")
+			finalizers := len(hostConfigReq.GetFinalizers())
+			if err := r.removeGoneNodeFinalizers(ctx, &hostConfigReq); err != nil {
 				return ctrl.Result{}, err
 			}
-
-			// remove finalizer from the list and update it.
-			controllerutil.RemoveFinalizer(&hostConfigReq, ovsFinalizerName)
-			log.Info(" Removing ovscleanup Finalizer in delete ")
-			if err := r.Update(ctx, &hostConfigReq); err != nil {
-				log.Error(err, "removing Finalizer failed")
-				return ctrl.Result{}, err
+			if len(hostConfigReq.GetFinalizers()) != finalizers {
+				if err := r.Update(ctx, &hostConfigReq); err != nil {
+					log.Error(err, "removing Finalizer failed")
+					return ctrl.Result{}, err
+				}
 			}
 			return ctrl.Result{}, nil
 		}
-	}
+		if err := r.abortSriovDisruption(ctx, &hostConfigReq, myNode); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := adoptLegacyBridges(&hostConfigReq); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := deleteOvsConfig(hostConfigReq.Name); err != nil {
+			// return so that it can be retried
+			return ctrl.Result{}, err
+		}
+		// Routes and rules first, they can use any interface below
+		if err := deleteRouteConfig(hostConfigReq.Name); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := deleteSysctlConfig(hostConfigReq.Name); err != nil {
+			return ctrl.Result{}, err
+		}
+		// Bridges first, they can have VXLANs and bonds as ports
+		if err := deleteBridgeConfig(hostConfigReq.Name); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := deleteVxlanConfig(hostConfigReq.Name); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := deleteBondConfig(hostConfigReq.Name); err != nil {
+			return ctrl.Result{}, err
+		}
 
-	selector := labels.SelectorFromSet(hostConfigReq.Spec.NodeSelector)
-	if !selector.Matches(labels.Set(myNode.Labels)) {
-		log.Info("Node labels don't match template selectors, skipping", "nodeSelector", selector)
+		// remove finalizer from the list and update it.
+		controllerutil.RemoveFinalizer(&hostConfigReq, finalizerName)
+		controllerutil.RemoveFinalizer(&hostConfigReq, legacyFinalizer)
+		if err := r.removeGoneNodeFinalizers(ctx, &hostConfigReq); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info(" Removing ovscleanup Finalizer in delete ", "finalizer", finalizerName)
+		if err := r.Update(ctx, &hostConfigReq); err != nil {
+			log.Error(err, "removing Finalizer failed")
+			return ctrl.Result{}, err
+		}
 		return ctrl.Result{}, nil
-	} else {
-		log.Info("Labels match, applying HostNetworkTemplate", "nodeSelector", selector)
 	}
 
-	sriovConfigList := hostConfigReq.Spec.SriovConfig
-	if len(sriovConfigList) > 0 {
-		if err := applySriovConfig(sriovConfigList); err != nil {
-			log.Error(err, "Failed to apply SriovConfig")
+	if !nodeMatches {
+		log.Info("Node labels don't match template selectors, skipping", "nodeSelector", selector)
+		if err := r.abortSriovDisruption(ctx, &hostConfigReq, myNode); err != nil {
 			return ctrl.Result{}, err
 		}
-	} else {
-		log.Info("SriovConfig is empty")
-	}
-
-	// Everything that is traditonally done under "ifconfig <ifname>" handled here
-	// Alternatively newer "ip addr" and "ip link" - see https://www.redhat.com/sysadmin/ifconfig-vs-ip
-	// MTUs, IPs, routes, link up/down, etc...
-	ifConfigList := hostConfigReq.Spec.InterfaceConfig
-	if len(ifConfigList) > 0 {
-		log.Info("ifConfigList not empty")
-		if err := applyInterfaceConfig(ifConfigList, hostConfigReq.Name); err != nil {
-			log.Error(err, "Failed to apply interfaceConfig")
+		// Drop any result this node reported while it still matched, which
+		// also releases its disruption slot
+		if err := r.updateNodeStatus(ctx, req.NamespacedName, nil); err != nil {
+			log.Error(err, "Failed to update HostNetworkTemplate status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	} else if inPlanMode(&hostConfigReq) {
+		log.Info("Labels match, planning HostNetworkTemplate", "nodeSelector", selector)
+		changes, err := planTemplate(&hostConfigReq)
+		plan := plumberv1.NodePlan{
+			NodeName:   r.NodeName,
+			Generation: hostConfigReq.Generation,
+			Changes:    changes,
+		}
+		if err != nil {
+			log.Error(err, "Failed to plan HostNetworkTemplate")
+			plan.Error = err.Error()
+		}
+		if err := r.updateNodePlan(ctx, req.NamespacedName, plan); err != nil {
+			log.Error(err, "Failed to update HostNetworkTemplate status")
 			return ctrl.Result{}, err
 		}
+		return ctrl.Result{}, nil
+	} else if r.rolledBack(&hostConfigReq) {
+		log.Info("Generation was rolled back after connectivity was lost, waiting for a new one", "generation", hostConfigReq.Generation)
+		return ctrl.Result{}, nil
 	} else {
-		log.Info("interfaceConfig is empty")
+		log.Info("Labels match, applying HostNetworkTemplate", "nodeSelector", selector)
 	}
 
-	if len(ovsConfigList) > 0 {
-		if err := applyOvsConfig(ovsConfigList); err != nil {
-			log.Error(err, "Failed to apply OVS config")
-			return ctrl.Result{}, err
+	nodeStatus := &plumberv1.NodeApplyStatus{
+		NodeName:           r.NodeName,
+		ObservedGeneration: hostConfigReq.Generation,
+	}
+	sriovWait, applyErr := r.prepareSriovDisruption(ctx, &hostConfigReq, myNode, nodeStatus)
+	if applyErr == nil {
+		// A template that cuts the node off is rolled back to this snapshot
+		snap, err := r.takeSnapshot(ctx, &hostConfigReq)
+		if err != nil {
+			applyErr = err
+			nodeStatus.LastError = fmt.Sprintf("%s: %s", plumberv1.ConditionConnectivityVerified, err)
 		} else {
-			log.Info("Successfully applied OVS config")
+			applyErr = applyTemplate(&hostConfigReq, nodeStatus, sriovWait)
+			if err := r.confirmConnectivity(ctx, &hostConfigReq, snap, nodeStatus); err != nil {
+				applyErr = err
+			}
+			if err := r.completeSriovDisruption(ctx, &hostConfigReq, nodeStatus); err != nil && applyErr == nil {
+				applyErr = err
+			}
 		}
 	} else {
-		log.Info("No OVS config present")
+		log.Error(applyErr, "Failed to prepare the SR-IOV change")
+		nodeStatus.LastError = fmt.Sprintf("%s: %s", plumberv1.ConditionSriovApplied, applyErr)
+	}
+	if err := r.updateNodeStatus(ctx, req.NamespacedName, nodeStatus); err != nil {
+		log.Error(err, "Failed to update HostNetworkTemplate status")
+		if applyErr == nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if applyErr != nil {
+		return ctrl.Result{}, applyErr
 	}
 
 	hni := hoststate.New(r.NodeName, r.Namespace, r.Client)
 	hni.DiscoverHostState()
 
+	// Status writes of other nodes do not trigger a reconcile, so a change
+	// waiting for a slot, a drain or the device plugin is checked again
+	if sriovWait != "" || nodeStatus.Disruption != "" {
+		return ctrl.Result{RequeueAfter: disruptionRequeue}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
-func applyInterfaceConfig(ifConfigList []plumberv1.InterfaceConfig, templateName string) error {
-	for _, ifConfig := range ifConfigList {
+// legacyFinalizer is the finalizer all nodes shared in older versions, the
+// first node to clean up removed it for all of them
+const legacyFinalizer = "ovsFinalizer"
+
+// nodeFinalizer returns the finalizer of a node, removed once the node
+// reverted what the template configured on its host
+func nodeFinalizer(nodeName string) string {
+	return legacyFinalizer + "-" + nodeName
+}
+
+// removeGoneNodeFinalizers removes the finalizers of nodes deleted from the
+// cluster, which would otherwise keep the template forever
+func (r *HostNetworkTemplateReconciler) removeGoneNodeFinalizers(ctx context.Context, hostConfigReq *plumberv1.HostNetworkTemplate) error {
+	for _, finalizer := range hostConfigReq.GetFinalizers() {
+		nodeName := strings.TrimPrefix(finalizer, nodeFinalizer(""))
+		if nodeName == finalizer || nodeName == r.NodeName {
+			continue
+		}
+		err := r.Get(ctx, types.NamespacedName{Name: nodeName}, &corev1.Node{})
+		if apierrors.IsNotFound(err) {
+			log.Info("Removing Finalizer of a deleted node", "finalizer", finalizer)
+			controllerutil.RemoveFinalizer(hostConfigReq, finalizer)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// needsCleanup returns whether the template configures anything that must be
+// removed from the host when the template is deleted
+func needsCleanup(spec plumberv1.HostNetworkTemplateSpec) bool {
+	return len(spec.OvsConfig) > 0 || len(spec.BondConfig) > 0 ||
+		len(spec.BridgeConfig) > 0 || len(spec.VxlanConfig) > 0 ||
+		len(spec.RouteConfig) > 0 || len(spec.RuleConfig) > 0 ||
+		len(spec.SysctlConfig) > 0 || sriovDisruptionEnabled(spec)
+}
+
+// applyTemplate applies each section of the template in order, recording a
+// condition per section in nodeStatus. It stops at the first section that
+// fails. The sriovConfig section is skipped while sriovWait says what a
+// disruptive change waits for.
+func applyTemplate(hostConfigReq *plumberv1.HostNetworkTemplate, nodeStatus *plumberv1.NodeApplyStatus, sriovWait string) error {
+	spec := hostConfigReq.Spec
+	managedOvs, managedOvsErr := ovsutils.GetManagedBridges(hostConfigReq.Name)
+	managedBonds, managedBondsErr := linkutils.GetManagedBonds(hostConfigReq.Name)
+	managedVlans, managedVlansErr := linkutils.GetManagedVlans(hostConfigReq.Name)
+	managedVxlans, managedVxlansErr := linkutils.GetManagedVxlans(hostConfigReq.Name)
+	managedBridges, managedBridgesErr := linkutils.GetManagedBridges(hostConfigReq.Name)
+	managedRoutes, managedRoutesErr := iputils.HasManagedRoutes(hostConfigReq.Name)
+	managedSysctls, managedSysctlsErr := sysctlutils.GetManaged(hostConfigReq.Name)
+
+	// Everything that is traditonally done under "ifconfig <ifname>" handled by the interfaces section
+	// Alternatively newer "ip addr" and "ip link" - see https://www.redhat.com/sysadmin/ifconfig-vs-ip
+	// MTUs, IPs, routes, link up/down, etc...
+	sections := []struct {
+		condition  string
+		configured bool
+		apply      func() error
+	}{
+		{plumberv1.ConditionSriovApplied, len(spec.SriovConfig) > 0, func() error {
+			return applySriovConfig(spec.SriovConfig)
+		}},
+		// Bonds come before VLANs and interfaces, which can be configured on them.
+		// They are reconciled while the template still manages bonds, to remove them.
+		{plumberv1.ConditionBondsApplied, len(spec.BondConfig) > 0 || len(managedBonds) > 0 || managedBondsErr != nil, func() error {
+			return applyBondConfig(spec.BondConfig, hostConfigReq.Name)
+		}},
+		// VLANs are reconciled even when none are listed, to remove the ones
+		// this template created before
+		{plumberv1.ConditionVlansApplied, len(spec.InterfaceConfig) > 0 || len(managedVlans) > 0 || managedVlansErr != nil, func() error {
+			return applyVlanConfig(spec.InterfaceConfig, hostConfigReq.Name)
+		}},
+		// VXLANs can use a bond or VLAN as device, and bridges can have any of
+		// them as ports. Both come before interfaces, which can set their MTU and IPs.
+		{plumberv1.ConditionVxlansApplied, len(spec.VxlanConfig) > 0 || len(managedVxlans) > 0 || managedVxlansErr != nil, func() error {
+			return applyVxlanConfig(spec.VxlanConfig, hostConfigReq.Name)
+		}},
+		{plumberv1.ConditionBridgesApplied, len(spec.BridgeConfig) > 0 || len(managedBridges) > 0 || managedBridgesErr != nil, func() error {
+			return applyBridgeConfig(spec.BridgeConfig, hostConfigReq.Name)
+		}},
+		{plumberv1.ConditionInterfacesApplied, len(spec.InterfaceConfig) > 0, func() error {
+			return applyInterfaceConfig(spec.InterfaceConfig)
+		}},
+		// Sysctls come after interfaces, per interface sysctls can apply to
+		// any of them. They are reconciled while the template still manages
+		// some, to restore them.
+		{plumberv1.ConditionSysctlApplied, len(spec.SysctlConfig) > 0 || len(managedSysctls) > 0 || managedSysctlsErr != nil, func() error {
+			return applySysctlConfig(spec.SysctlConfig, hostConfigReq.Name)
+		}},
+		// OVS is reconciled while the template still manages bridges, to
+		// remove them once they are no longer listed
+		{plumberv1.ConditionOvsApplied, len(spec.OvsConfig) > 0 || len(managedOvs) > 0 || managedOvsErr != nil, func() error {
+			return applyOvsConfig(spec.OvsConfig, hostConfigReq.Name)
+		}},
+		// Routes and rules come last, they can use any interface and address
+		// configured above. They are reconciled while the template still
+		// manages some, to remove them.
+		{plumberv1.ConditionRoutesApplied, len(spec.RouteConfig) > 0 || managedRoutes || managedRoutesErr != nil, func() error {
+			return applyRouteConfig(spec.RouteConfig, hostConfigReq.Name)
+		}},
+		{plumberv1.ConditionRulesApplied, len(spec.RuleConfig) > 0 || managedRoutes || managedRoutesErr != nil, func() error {
+			return applyRuleConfig(spec.RuleConfig, hostConfigReq.Name)
+		}},
+	}
+
+	var applyErr error
+	failedSection := ""
+	for _, section := range sections {
+		condition := metav1.Condition{
+			Type:               section.condition,
+			ObservedGeneration: hostConfigReq.Generation,
+		}
+		switch {
+		case applyErr != nil:
+			condition.Status = metav1.ConditionUnknown
+			condition.Reason = "NotAttempted"
+			condition.Message = fmt.Sprintf("skipped after %s failed", failedSection)
+		case !section.configured:
+			log.Info("Section not configured, skipping", "section", section.condition)
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "NotConfigured"
+		case section.condition == plumberv1.ConditionSriovApplied && sriovWait != "":
+			log.Info("Section waiting", "section", section.condition, "reason", sriovWait)
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = reasonWaiting
+			condition.Message = sriovWait
+		default:
+			if err := section.apply(); err != nil {
+				log.Error(err, "Failed to apply section", "section", section.condition)
+				applyErr = err
+				failedSection = section.condition
+				condition.Status = metav1.ConditionFalse
+				condition.Reason = "Failed"
+				condition.Message = err.Error()
+			} else {
+				log.Info("Successfully applied section", "section", section.condition)
+				condition.Status = metav1.ConditionTrue
+				condition.Reason = "Applied"
+			}
+		}
+		nodeStatus.Conditions = append(nodeStatus.Conditions, condition)
+	}
+
+	nodeStatus.Applied = applyErr == nil && sriovWait == ""
+	if applyErr != nil {
+		nodeStatus.LastError = fmt.Sprintf("%s: %s", failedSection, applyErr)
+	}
+	return applyErr
+}
 
+// applyVlanConfig creates the VLANs of each listed interface, and deletes the
+// ones the template created on interfaces it no longer lists
+func applyVlanConfig(ifConfigList []plumberv1.InterfaceConfig, templateName string) error {
+	for _, ifConfig := range ifConfigList {
 		if err := createVlanInterfaces(ifConfig, templateName); err != nil {
 			return err
 		}
+	}
+
+	managed, err := linkutils.GetManagedVlans(templateName)
+	if err != nil {
+		log.Error(err, "Failed to read managed vlans", "template", templateName)
+		return err
+	}
+	for _, ifName := range unlistedInterfaces(ifConfigList, managed) {
+		log.Info("Deleting vlans of interface no longer in template", "ifName", ifName, "vlans", managed[ifName])
+		if err := linkutils.ReplaceManagedVlans(templateName, ifName, nil); err != nil {
+			log.Error(err, "Failed to update managed vlans config", "ifName", ifName)
+			return err
+		}
+	}
+	return nil
+}
+
+// unlistedInterfaces returns the interfaces with managed VLANs that are not
+// in ifConfigList, sorted
+func unlistedInterfaces(ifConfigList []plumberv1.InterfaceConfig, managed map[string][]string) []string {
+	listed := make(map[string]bool)
+	for _, ifConfig := range ifConfigList {
+		listed[*ifConfig.Name] = true
+	}
+	var unlisted []string
+	for ifName := range managed {
+		if !listed[ifName] {
+			unlisted = append(unlisted, ifName)
+		}
+	}
+	sort.Strings(unlisted)
+	return unlisted
+}
+
+// applyBondConfig creates or updates the bonds of the template, and deletes the
+// ones it created before and no longer lists
+func applyBondConfig(bondConfigList []plumberv1.BondConfig, templateName string) error {
+	var bonds []string
+	for _, bondConfig := range bondConfigList {
+		bond := linkutils.Bond{
+			Name:           bondConfig.Name,
+			Members:        bondConfig.Members,
+			Mode:           bondConfig.Mode,
+			Miimon:         -1,
+			LacpRate:       bondConfig.LacpRate,
+			XmitHashPolicy: bondConfig.XmitHashPolicy,
+		}
+		if bondConfig.Miimon != nil {
+			bond.Miimon = *bondConfig.Miimon
+		}
+		log.Info("Configuring bond", "bond", bond.Name, "members", bond.Members, "mode", bond.Mode)
+		if err := linkutils.CreateOrUpdateBond(bond); err != nil {
+			log.Error(err, "Failed to configure bond", "bond", bond.Name)
+			return err
+		}
+		bonds = append(bonds, bond.Name)
+	}
+	if err := linkutils.ReplaceManagedBonds(templateName, bonds); err != nil {
+		log.Error(err, "Failed to update managed bonds config", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+func deleteBondConfig(templateName string) error {
+	log.Info("Deleting bonds of template", "template", templateName)
+	if err := linkutils.ReplaceManagedBonds(templateName, nil); err != nil {
+		log.Error(err, "Error deleting bonds", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+// applyVxlanConfig creates or updates the VXLANs of the template, and deletes
+// the ones it created before and no longer lists
+func applyVxlanConfig(vxlanConfigList []plumberv1.VxlanConfig, templateName string) error {
+	var vxlans []string
+	for _, vxlanConfig := range vxlanConfigList {
+		vxlan := linkutils.Vxlan{
+			Name:    vxlanConfig.Name,
+			Vni:     vxlanConfig.Vni,
+			LocalIP: vxlanConfig.LocalIP,
+			Remote:  vxlanConfig.Remote,
+			Group:   vxlanConfig.Group,
+			Device:  vxlanConfig.Device,
+		}
+		if vxlanConfig.DstPort != nil {
+			vxlan.DstPort = *vxlanConfig.DstPort
+		}
+		log.Info("Configuring vxlan", "vxlan", vxlan.Name, "vni", vxlan.Vni, "device", vxlan.Device)
+		if err := linkutils.CreateOrUpdateVxlan(vxlan); err != nil {
+			log.Error(err, "Failed to configure vxlan", "vxlan", vxlan.Name)
+			return err
+		}
+		vxlans = append(vxlans, vxlan.Name)
+	}
+	if err := linkutils.ReplaceManagedVxlans(templateName, vxlans); err != nil {
+		log.Error(err, "Failed to update managed vxlans config", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+func deleteVxlanConfig(templateName string) error {
+	log.Info("Deleting vxlans of template", "template", templateName)
+	if err := linkutils.ReplaceManagedVxlans(templateName, nil); err != nil {
+		log.Error(err, "Error deleting vxlans", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+// applyBridgeConfig creates or updates the bridges of the template, and
+// deletes the ones it created before and no longer lists
+func applyBridgeConfig(bridgeConfigList []plumberv1.BridgeConfig, templateName string) error {
+	var bridges []string
+	for _, bridgeConfig := range bridgeConfigList {
+		bridge := linkutils.Bridge{
+			Name:          bridgeConfig.Name,
+			Ports:         bridgeConfig.Ports,
+			Stp:           bridgeConfig.Stp,
+			VlanFiltering: bridgeConfig.VlanFiltering,
+		}
+		log.Info("Configuring bridge", "bridge", bridge.Name, "ports", bridge.Ports)
+		if err := linkutils.CreateOrUpdateBridge(bridge); err != nil {
+			log.Error(err, "Failed to configure bridge", "bridge", bridge.Name)
+			return err
+		}
+		bridges = append(bridges, bridge.Name)
+	}
+	if err := linkutils.ReplaceManagedBridges(templateName, bridges); err != nil {
+		log.Error(err, "Failed to update managed bridges config", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+func deleteBridgeConfig(templateName string) error {
+	log.Info("Deleting bridges of template", "template", templateName)
+	if err := linkutils.ReplaceManagedBridges(templateName, nil); err != nil {
+		log.Error(err, "Error deleting bridges", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+// applyRouteConfig adds or replaces the routes of the template, and deletes the
+// ones it added before and no longer lists
+func applyRouteConfig(routeConfigList []plumberv1.RouteConfig, templateName string) error {
+	routes := routesOf(routeConfigList)
+	log.Info("Configuring routes", "template", templateName, "routes", len(routes))
+	if err := iputils.ApplyRoutes(templateName, routes); err != nil {
+		log.Error(err, "Failed to configure routes", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+func routesOf(routeConfigList []plumberv1.RouteConfig) []iputils.Route {
+	var routes []iputils.Route
+	for _, routeConfig := range routeConfigList {
+		route := iputils.Route{
+			Dst: routeConfig.Dst,
+			Gw:  routeConfig.Gw,
+			Dev: routeConfig.Dev,
+			Src: routeConfig.Src,
+		}
+		if routeConfig.Table != nil {
+			route.Table = int(*routeConfig.Table)
+		}
+		if routeConfig.Metric != nil {
+			route.Metric = *routeConfig.Metric
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// applyRuleConfig adds the rules of the template, and deletes the ones it
+// added before and no longer lists
+func applyRuleConfig(ruleConfigList []plumberv1.RuleConfig, templateName string) error {
+	rules := rulesOf(ruleConfigList)
+	log.Info("Configuring routing rules", "template", templateName, "rules", len(rules))
+	if err := iputils.ApplyRules(templateName, rules); err != nil {
+		log.Error(err, "Failed to configure routing rules", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+func rulesOf(ruleConfigList []plumberv1.RuleConfig) []iputils.Rule {
+	var rules []iputils.Rule
+	for _, ruleConfig := range ruleConfigList {
+		rule := iputils.Rule{
+			Priority: ruleConfig.Priority,
+			From:     ruleConfig.From,
+			To:       ruleConfig.To,
+			Iif:      ruleConfig.Iif,
+			Oif:      ruleConfig.Oif,
+			Table:    int(ruleConfig.Table),
+		}
+		if ruleConfig.Fwmark != nil {
+			rule.Fwmark = int(*ruleConfig.Fwmark)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func deleteRouteConfig(templateName string) error {
+	log.Info("Deleting routes and rules of template", "template", templateName)
+	if err := iputils.ApplyRules(templateName, nil); err != nil {
+		log.Error(err, "Error deleting routing rules", "template", templateName)
+		return err
+	}
+	if err := iputils.ApplyRoutes(templateName, nil); err != nil {
+		log.Error(err, "Error deleting routes", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+// applySysctlConfig sets the sysctls of the template, and restores the ones it
+// set before and no longer lists
+func applySysctlConfig(sysctlConfig map[string]string, templateName string) error {
+	log.Info("Configuring sysctls", "template", templateName, "sysctls", sysctlConfig)
+	if err := sysctlutils.Apply(templateName, sysctlConfig); err != nil {
+		log.Error(err, "Failed to configure sysctls", "template", templateName)
+		return err
+	}
+	return nil
+}
+
+func deleteSysctlConfig(templateName string) error {
+	log.Info("Restoring sysctls of template", "template", templateName)
+	if err := sysctlutils.Apply(templateName, nil); err != nil {
+		log.Error(err, "Error restoring sysctls", "template", templateName)
+		return err
+	}
+	return nil
+}
 
+func applyInterfaceConfig(ifConfigList []plumberv1.InterfaceConfig) error {
+	for _, ifConfig := range ifConfigList {
 		if err := configureMtu(ifConfig); err != nil {
 			return err
 		}
@@ -199,18 +683,16 @@ func createVlanInterfaces(ifConfig plumberv1.InterfaceConfig, templateName strin
 	ifName := *ifConfig.Name
 	vlanConfig := ifConfig.Vlan
 	if len(vlanConfig) > 0 {
-		var vlanIfName string
 		for _, vlanIf := range vlanConfig {
 			vid := *vlanIf.VlanId
-			if vlanIf.Name != nil {
-				vlanIfName = *vlanIf.Name
-			} else {
-				vlanIfName = fmt.Sprintf("%s.%d", ifName, vid)
-			}
+			vlanIfName := vlanIfName(ifName, vlanIf)
 			if err := linkutils.CreateVlanIf(vlanIfName, ifName, vid); err != nil {
 				log.Error(err, "Failed to create vlan interface", "vlan", vlanIfName, "ifName", ifName)
 				return err
 			}
+			if err := configureAddrs(vlanIfName, vlanIf.IPv4, vlanIf.IPv6); err != nil {
+				return err
+			}
 			newVlanIfs = append(newVlanIfs, vlanIfName)
 		}
 	}
@@ -223,6 +705,14 @@ func createVlanInterfaces(ifConfig plumberv1.InterfaceConfig, templateName strin
 	return nil
 }
 
+// vlanIfName returns the name of a VLAN interface, <parent>.<vid> by default
+func vlanIfName(ifName string, vlanIf plumberv1.VlanConfig) string {
+	if vlanIf.Name != nil {
+		return *vlanIf.Name
+	}
+	return fmt.Sprintf("%s.%d", ifName, *vlanIf.VlanId)
+}
+
 func configureMtu(ifConfig plumberv1.InterfaceConfig) error {
 	ifName := *ifConfig.Name
 
@@ -237,504 +727,691 @@ func configureMtu(ifConfig plumberv1.InterfaceConfig) error {
 }
 
 func configureIPs(ifConfig plumberv1.InterfaceConfig) error {
-	ifName := *ifConfig.Name
+	return configureAddrs(*ifConfig.Name, ifConfig.IPv4, ifConfig.IPv6)
+}
 
-	if ifConfig.IPv4 != nil {
-		v4Config := ifConfig.IPv4
+// configureAddrs makes the listed addresses the static addresses of the
+// interface. IPv6 link-local addresses and the ones from SLAAC or DHCPv6 are
+// kept, unless autoconf disables SLAAC. An empty list leaves the addresses.
+func configureAddrs(ifName string, v4Config *plumberv1.IPv4Info, v6Config *plumberv1.IPv6Info) error {
+	if v4Config != nil {
 		if len(v4Config.Address) == 0 {
 			log.Info("No IPv4 addresses specified... skipping...")
 		} else {
-			// If an address(s) is specified, first unconfigure any old ones
-			// ipv4.address should reflect desired IP state
-			ipv4Addrs, err := iputils.GetIpv4Cidr(ifName)
-			if err != nil || len(*ipv4Addrs) == 0 {
-				log.Info("Error getting IPv4 for interface", "err", err, "ifName", ifName, "ipv4Addrs", *ipv4Addrs)
-			} else {
-				for _, addr := range *ipv4Addrs {
-					log.Info("Removing old IP", "ifName", ifName, "IPv4", addr)
-					if err := iputils.DelIpv4Cidr(ifName, addr); err != nil {
-						log.Error(err, "Failed to Del IP", "ifName", ifName, "IPv4", addr)
-						return err
-					}
-				}
+			current, err := iputils.GetIpv4Cidr(ifName)
+			if err != nil {
+				log.Error(err, "Error getting IPv4 for interface", "ifName", ifName)
+				return err
 			}
-
-			for _, addr := range v4Config.Address {
-				log.Info("Attempting to configure IP", "ifName", ifName, "IPv4", addr)
-				if err := iputils.SetIpv4Cidr(ifName, addr); err != nil {
-					log.Error(err, "Failed to Add IP", "ifName", ifName, "IPv4", addr)
-					return err
-				}
+			err = replaceAddrs(ifName, *current, v4Config.Address, iputils.DelIpv4Cidr, iputils.SetIpv4Cidr)
+			if err != nil {
+				return err
+			}
+			if err := persistAddrs(ifName, v4Config.Address, nil, nil); err != nil {
+				return err
 			}
 		}
 	}
 
-	if ifConfig.IPv6 != nil {
-		v6Config := ifConfig.IPv6
+	if v6Config != nil {
+		if v6Config.Autoconf != nil {
+			log.Info("Setting IPv6 autoconf", "ifName", ifName, "autoconf", *v6Config.Autoconf)
+			if err := iputils.SetIpv6Autoconf(ifName, *v6Config.Autoconf); err != nil {
+				log.Error(err, "Failed to set IPv6 autoconf", "ifName", ifName)
+				return err
+			}
+			if err := persistAddrs(ifName, nil, nil, v6Config.Autoconf); err != nil {
+				return err
+			}
+		}
 		if len(v6Config.Address) == 0 {
 			log.Info("No IPv6 addresses specified... skipping...")
 		} else {
-			// If an address(s) is specified, first unconfigure any old ones
-			// ipv4.address should reflect desired IP state
-			ipv6Addrs, err := iputils.GetIpv6Cidr(ifName)
-			if err != nil || len(*ipv6Addrs) == 0 {
-				log.Info("Error getting IPv4 for interface", "err", err, "ifName", ifName, "ipv6Addrs", *ipv6Addrs)
-			} else {
-				for _, addr := range *ipv6Addrs {
-					log.Info("Removing old IP", "ifName", ifName, "IPv6", addr)
-					if err := iputils.DelIpv6Cidr(ifName, addr); err != nil {
-						log.Error(err, "Failed to Del IP", "ifName", ifName, "IPv6", addr)
-						return err
-					}
-				}
+			current, err := iputils.GetIpv6Addrs(ifName)
+			if err != nil {
+				log.Error(err, "Error getting IPv6 for interface", "ifName", ifName)
+				return err
 			}
-
-			for _, addr := range v6Config.Address {
-				log.Info("Attempting to configure IP", "ifName", ifName, "IPv6", addr)
-				if err := iputils.SetIpv6Cidr(ifName, addr); err != nil {
-					log.Error(err, "Failed to set IP for ifName", "ifName", ifName, "IPv6", addr)
-					return err
-				}
+			err = replaceAddrs(ifName, current.Static, v6Config.Address, iputils.DelIpv6Cidr, iputils.SetIpv6Cidr)
+			if err != nil {
+				return err
+			}
+			if err := persistAddrs(ifName, nil, v6Config.Address, nil); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-func deleteOvsConfig(ovsConfigList []*plumberv1.OvsConfig) error {
-	/*delete ovs bridge present in ovsConfigList*/
-
-	for _, ovsConfig := range ovsConfigList {
-		br := (*ovsConfig).BridgeName
-		log.Info("Deleting ovs bridge ", "bridge", br)
-		err := ovsutils.DeleteOvsBr(br)
-		if err != nil {
-			log.Error(err, "Error deleting ovs bridge", "br", br)
+// replaceAddrs removes the current addresses that are not desired and adds
+// the desired ones, which replaces their lifetime and flags
+func replaceAddrs(ifName string, current, desired []string, del, add func(string, string) error) error {
+	want := make(map[string]bool)
+	for _, addr := range desired {
+		want[normalizeCidr(addr)] = true
+	}
+	for _, addr := range current {
+		if !want[normalizeCidr(addr)] {
+			log.Info("Removing old IP", "ifName", ifName, "ip", addr)
+			if err := del(ifName, addr); err != nil {
+				log.Error(err, "Failed to Del IP", "ifName", ifName, "ip", addr)
+				return err
+			}
+		}
+	}
+	for _, addr := range desired {
+		log.Info("Attempting to configure IP", "ifName", ifName, "ip", addr)
+		if err := add(ifName, addr); err != nil {
+			log.Error(err, "Failed to Add IP", "ifName", ifName, "ip", addr)
 			return err
 		}
 	}
 	return nil
 }
 
-func applyOvsConfig(ovsConfigList []*plumberv1.OvsConfig) error {
-	for _, ovsConfig := range ovsConfigList {
-		nodeInterface := (*ovsConfig).NodeInterface
-		bridgeName := (*ovsConfig).BridgeName
-		dpdk := (*ovsConfig).Dpdk
-		var BondMode, Lacp string
-		var MtuRequest int
-		if (*ovsConfig).Params != nil {
-			MtuRequest = (*ovsConfig).Params.MtuRequest
-			BondMode = (*ovsConfig).Params.BondMode
-			Lacp = (*ovsConfig).Params.Lacp
-		}
-
-		var nic1, nic2 string
-		interfaces := strings.Split(nodeInterface, ",")
-		length := len(interfaces)
-		if length > 2 {
-			err := errors.New("More than 2 interfaces specified for OVS bridge")
-			log.Error(err, "bridgeName", "need 1 for bridge", "or 2 for bond")
-			return nil
-		}
-
-		if length == 1 && dpdk == false {
-			if err := createOvsBridge(nodeInterface, bridgeName); err != nil {
-				log.Error(err, "Failed to create", "OVS bridge", bridgeName)
-				return err
-			} else {
-				log.Info("Successfully created", "OVS bridge", bridgeName)
-			}
+// persistAddrs persists the static addresses and SLAAC of an interface, nil
+// keeps what is persisted for them
+func persistAddrs(ifName string, ipv4, ipv6 []string, autoconf *bool) error {
+	err := persist.Update(ifName, func(iface *persist.Interface) {
+		if ipv4 != nil {
+			iface.IPv4 = ipv4
 		}
-		if length == 1 && dpdk == true {
-			if err := createDpdkBridge(nodeInterface, bridgeName, MtuRequest); err != nil {
-				log.Error(err, "Failed to create", "OVS-DPDK bridge", bridgeName)
-				return err
-			} else {
-				log.Info("Successfully created", "OVS-DPDK bridge", bridgeName)
-			}
-		}
-
-		if length == 2 {
-			nic1 = interfaces[0]
-			nic2 = interfaces[1]
+		if ipv6 != nil {
+			iface.IPv6 = ipv6
 		}
-		if length == 2 && dpdk == false {
-			if err := createOvsBond(nic1, nic2, bridgeName, MtuRequest, BondMode, Lacp); err != nil {
-				log.Error(err, "Failed to create", "OVS bond for", bridgeName)
-				return err
-			} else {
-				log.Info("Successfully created", "OVS bond for", bridgeName)
-			}
+		if autoconf != nil {
+			iface.IPv6Autoconf = autoconf
 		}
+	})
+	if err != nil {
+		log.Error(err, "Failed to persist IPs", "ifName", ifName)
+	}
+	return err
+}
 
-		if length == 2 && dpdk == true {
-			if err := createOvsDpdkBond(nic1, nic2, bridgeName, MtuRequest, BondMode, Lacp); err != nil {
-				log.Error(err, "Failed to create", "OVS-DPDK bond for", bridgeName)
-				return err
-			} else {
-				log.Info("Successfully created", "OVS-DPDK bond for", bridgeName)
-			}
-		}
+// deleteOvsConfig deletes the bridges the template created and the ports it
+// added to bridges that already existed
+func deleteOvsConfig(templateName string) error {
+	log.Info("Deleting ovs config of template", "template", templateName)
+	if err := ovsutils.DeleteManagedBridges(templateName); err != nil {
+		log.Error(err, "Error deleting ovs config", "template", templateName)
+		return err
 	}
 	return nil
 }
 
-func createOvsBridge(nodeInterface string, bridgeName string) error {
-	log.Info("Physical interface name: ", "physnet", nodeInterface)
-	log.Info("Bridge interface name: ", "ovsbr", bridgeName)
-	cmd := exec.Command("ovs-vsctl", "br-exists", bridgeName)
-	output, err := cmd.CombinedOutput()
-	add_port_to_br := false
-	if err != nil {
-		// if err.Error() == "exit status 2"
-		exitError, ok := err.(*exec.ExitError)
-		log.Info("Bridge missing", "ok", ok, "out", output, "err", exitError)
-		if ok {
-			exec.Command("ovs-vsctl", "add-br", bridgeName).Run()
-			add_port_to_br = true
-		}
-	} else {
-		cmd := exec.Command("ovs-vsctl", "list-ports", bridgeName)
-		output, err := cmd.Output()
-		if err != nil {
-			return err
-		}
-		exists := contains(output, nodeInterface)
-		if exists {
-			log.Info("Bridge already has a port for this node interface")
-		} else {
-			add_port_to_br = true
-		}
-	}
-	// Check if port already belongs to another bridge, remove it first if so
-	if add_port_to_br {
-		cmd := exec.Command("ovs-vsctl", "port-to-br", nodeInterface)
-		output, err := cmd.Output()
-		if err == nil {
-			br := strings.TrimSuffix(string(output), "\n")
-			log.Info("Interface already attached to another bridge", "ovsbr", br)
-			cmd := exec.Command("ovs-vsctl", "del-port", br, nodeInterface)
-			if err := cmd.Run(); err != nil {
-				log.Error(err, "Failed to remove interface from current bridge")
-				return err
-			}
-		}
-		cmd = exec.Command("ovs-vsctl", "add-port", bridgeName, nodeInterface)
-		if err := cmd.Run(); err != nil {
-			log.Error(err, "Failed to add interface to specified bridge")
-			return err
+// adoptLegacyBridges records the bridges of a template as created by
+// hostplumber when the template still has the legacy finalizer and no bridges
+// were saved for it. Older versions created every bridge in ovsConfig without
+// saving which ones, and deleted them all with the template.
+func adoptLegacyBridges(tmpl *plumberv1.HostNetworkTemplate) error {
+	if !containsString(tmpl.GetFinalizers(), legacyFinalizer) {
+		return nil
+	}
+	saved, err := ovsutils.HasManagedBridges(tmpl.Name)
+	if err != nil || saved {
+		return err
+	}
+
+	var managed []ovsutils.ManagedBridge
+	for _, ovsConfig := range tmpl.Spec.OvsConfig {
+		br := ovsutils.ManagedBridge{Name: ovsConfig.BridgeName, Created: true}
+		if ovsConfig.NodeInterface != "" {
+			br.Ports = []string{ovsPortName(ovsConfig)}
 		}
-		log.Info("Added node interface to ovs bridge", "ovsbr", bridgeName)
+		managed = append(managed, br)
+	}
+	log.Info("Adopting ovs bridges created by an older version", "template", tmpl.Name)
+	return ovsutils.UpdateManagedBridges(tmpl.Name, managed)
+}
 
-		// Move interface IPs (if any) to the corresponding OVS bridge
-		ipv4Addrs, err := iputils.GetIpv4Cidr(nodeInterface)
-		move_ips := false
-		if err != nil {
-			log.Error(err, "Error getting IPv4 address for interface", "ifName", nodeInterface)
-		} else if len(*ipv4Addrs) == 0 {
-			log.Info("No IPv4 address for interface", "ifName", nodeInterface)
-		} else {
-			log.Info("IPv4 address(es) for interface", "ifName", nodeInterface, "ip", *ipv4Addrs)
-			for _, addr := range *ipv4Addrs {
-				log.Info("Removing interface IP", "ifName", nodeInterface, "ip", addr)
-				if err := iputils.DelIpv4Cidr(nodeInterface, addr); err != nil {
-					log.Error(err, "Failed to flush IP", "ifName", nodeInterface, "ip", addr)
-					return err
-				}
+// applyOvsConfig converges OVS to exactly the bridges and ports in
+// ovsConfigList. Ports are changed in place when their members, bond mode or
+// MTU differ; what the template managed before and no longer lists is removed.
+func applyOvsConfig(ovsConfigList []*plumberv1.OvsConfig, templateName string) error {
+	previous, err := ovsutils.GetManagedBridges(templateName)
+	if err != nil {
+		return err
+	}
+	createdBefore := make(map[string]bool)
+	for _, br := range previous {
+		createdBefore[br.Name] = br.Created
+	}
+
+	var managed []ovsutils.ManagedBridge
+	addManaged := func(bridgeName string, created bool, portNames ...string) {
+		for i := range managed {
+			if managed[i].Name == bridgeName {
+				managed[i].Created = managed[i].Created || created
+				managed[i].Ports = append(managed[i].Ports, portNames...)
+				return
 			}
-			move_ips = true
 		}
-		if move_ips {
-			for _, addr := range *ipv4Addrs {
-				log.Info("Attempting to assign IP to bridge", "ovsbr", bridgeName, "ip", addr)
-				if err := iputils.SetIpv4Cidr(bridgeName, addr); err != nil {
-					log.Info("Failed to assign IP to bridge", "ovsbr", bridgeName, "ip", addr)
-					return err
-				}
+		managed = append(managed, ovsutils.ManagedBridge{
+			Name:    bridgeName,
+			Created: created || createdBefore[bridgeName],
+			Ports:   portNames,
+		})
+	}
+
+	for _, ovsConfig := range ovsConfigList {
+		if err := applyOvsBridge(ovsConfig, addManaged); err != nil {
+			// Keep track of what was created so far without removing anything
+			if saveErr := ovsutils.UpdateManagedBridges(templateName, managed); saveErr != nil {
+				log.Error(saveErr, "Failed to save managed ovs bridges", "template", templateName)
 			}
+			return err
 		}
 	}
-	return nil
+	return ovsutils.ReplaceManagedBridges(templateName, managed)
 }
 
-func createDpdkBridge(nodeInterface string, bridgeName string, mtuRequest int) error {
-	log.Info("Physical interface name: ", "physnet", nodeInterface)
-	log.Info("Bridge interface name: ", "ovsbr", bridgeName)
-	portName := "dpdk-" + bridgeName
-	cmd := exec.Command("ovs-vsctl", "br-exists", bridgeName)
-	output, err := cmd.CombinedOutput()
-	add_port_to_br := false
-	if err != nil {
-		// if err.Error() == "exit status 2"
-		exitError, ok := err.(*exec.ExitError)
-		log.Info("Bridge missing", "ok", ok, "out", output, "err", exitError)
-		if ok {
-			brCmd := "ovs-vsctl add-br " + bridgeName + " -- set bridge " + bridgeName + " datapath_type=netdev"
-			cmd = exec.Command("bash", "-c", brCmd)
-			_, err = cmd.CombinedOutput()
-			if err != nil {
-				return err
-			}
-			log.Info("Created : ", "ovsbr", bridgeName)
-			add_port_to_br = true
+func applyOvsBridge(ovsConfig *plumberv1.OvsConfig, addManaged func(string, bool, ...string)) error {
+	nodeInterface := (*ovsConfig).NodeInterface
+	bridgeName := (*ovsConfig).BridgeName
+	if nodeInterface == "" {
+		if len(ovsConfig.VfRepresentors) == 0 {
+			return fmt.Errorf("OVS bridge %s needs a nodeInterface or vfRepresentors", bridgeName)
 		}
-	} else {
-		cmd := exec.Command("ovs-vsctl", "list-ports", bridgeName)
-		output, err := cmd.Output()
+		created, err := ensureOvsBridge(bridgeName, "")
 		if err != nil {
 			return err
 		}
-		exists := strings.Contains(strings.TrimSuffix(string(output), "\n"), portName)
-		if exists {
-			log.Info("Bridge", bridgeName, "already has a port for this node interface")
-		} else {
-			add_port_to_br = true
-		}
+		addManaged(bridgeName, created)
+		return addVfRepresentors(bridgeName, ovsConfig.VfRepresentors, addManaged)
 	}
-	// Check if port already belongs to another bridge, remove it first if so
-	if add_port_to_br {
-		//get the PCI address for the NIC’s and bind vfio-pci driver:
-		pciAddr, err := findPciAddr(nodeInterface)
-		if err != nil {
+	dpdk := (*ovsConfig).Dpdk
+	var BondMode, Lacp string
+	var MtuRequest int
+	if (*ovsConfig).Params != nil {
+		MtuRequest = (*ovsConfig).Params.MtuRequest
+		BondMode = (*ovsConfig).Params.BondMode
+		Lacp = (*ovsConfig).Params.Lacp
+	}
+
+	interfaces := strings.Split(nodeInterface, ",")
+	if len(interfaces) > 2 {
+		return fmt.Errorf("more than 2 interfaces specified for OVS bridge %s, need 1 for a bridge or 2 for a bond", bridgeName)
+	}
+
+	var created bool
+	var err error
+	portName := ovsPortName(ovsConfig)
+	switch {
+	case len(interfaces) == 1 && !dpdk:
+		if created, err = createOvsBridge(nodeInterface, bridgeName); err != nil {
+			log.Error(err, "Failed to create", "OVS bridge", bridgeName)
 			return err
 		}
-		log.Info("Pci Address of", nodeInterface, pciAddr)
-		bindCmd := "dpdk-devbind.py --bind=vfio-pci " + pciAddr
-		cmd = exec.Command("bash", "-c", bindCmd)
-		_, err = cmd.CombinedOutput()
-		if err != nil {
-			log.Error(err, "Error binding", "interface", nodeInterface)
+		log.Info("Successfully created", "OVS bridge", bridgeName)
+	case len(interfaces) == 1 && dpdk:
+		if created, err = createDpdkBridge(nodeInterface, bridgeName, MtuRequest); err != nil {
+			log.Error(err, "Failed to create", "OVS-DPDK bridge", bridgeName)
 			return err
 		}
-		addportCmd := "ovs-vsctl add-port " + bridgeName + " " + portName + " -- set Interface " + portName + " type=dpdk options:dpdk-devargs=" + pciAddr
-		cmd = exec.Command("bash", "-c", addportCmd)
-		_, err = cmd.CombinedOutput()
+		log.Info("Successfully created", "OVS-DPDK bridge", bridgeName)
+	case !dpdk:
+		if created, err = createOvsBond(interfaces[0], interfaces[1], bridgeName, MtuRequest, BondMode, Lacp); err != nil {
+			log.Error(err, "Failed to create", "OVS bond for", bridgeName)
+			return err
+		}
+		log.Info("Successfully created", "OVS bond for", bridgeName)
+	default:
+		if created, err = createOvsDpdkBond(interfaces[0], interfaces[1], bridgeName, MtuRequest, BondMode, Lacp); err != nil {
+			log.Error(err, "Failed to create", "OVS-DPDK bond for", bridgeName)
+			return err
+		}
+		log.Info("Successfully created", "OVS-DPDK bond for", bridgeName)
+	}
+	addManaged(bridgeName, created, portName)
+	return addVfRepresentors(bridgeName, ovsConfig.VfRepresentors, addManaged)
+}
+
+// ovsPortName returns the name of the port of the nodeInterface: the
+// interface itself, or dpdk-, bond- or dpdkbond- and the bridge name
+func ovsPortName(ovsConfig *plumberv1.OvsConfig) string {
+	bond := strings.Contains(ovsConfig.NodeInterface, ",")
+	switch {
+	case !bond && !ovsConfig.Dpdk:
+		return ovsConfig.NodeInterface
+	case !bond:
+		return "dpdk-" + ovsConfig.BridgeName
+	case !ovsConfig.Dpdk:
+		return "bond-" + ovsConfig.BridgeName
+	default:
+		return "dpdkbond-" + ovsConfig.BridgeName
+	}
+}
+
+// vfRepresentor is the representor netdev of a VF
+type vfRepresentor struct {
+	vf   int
+	name string
+}
+
+// vfRepresentors returns the representors of the VFs of repConfig, in VF order
+func vfRepresentors(repConfig plumberv1.VfRepresentorConfig) ([]vfRepresentor, error) {
+	reps, err := sriovutils.GetVfRepresentors(repConfig.PfName)
+	if err != nil {
+		return nil, err
+	}
+	numVfs, err := sriovutils.GetCurrentNumVfsForPf(repConfig.PfName)
+	if err != nil {
+		return nil, err
+	}
+	if numVfs == 0 {
+		return nil, fmt.Errorf("PF %s has no VFs", repConfig.PfName)
+	}
+	vfRange := repConfig.VfRange
+	if vfRange == "" {
+		vfRange = fmt.Sprintf("0-%d", numVfs-1)
+	}
+	ids, err := sriovutils.ParseVfRange(vfRange, numVfs)
+	if err != nil {
+		return nil, fmt.Errorf("representors of PF %s: %w", repConfig.PfName, err)
+	}
+	var selected []vfRepresentor
+	for _, id := range ids {
+		rep, ok := reps[id]
+		if !ok {
+			return nil, fmt.Errorf("VF %d of PF %s has no representor", id, repConfig.PfName)
+		}
+		selected = append(selected, vfRepresentor{vf: id, name: rep})
+	}
+	return selected, nil
+}
+
+// addVfRepresentors adds the representors of the VFs to the bridge. The PF
+// must be in switchdev mode, see the eswitchMode of sriovConfig.
+func addVfRepresentors(bridgeName string, repConfigList []plumberv1.VfRepresentorConfig, addManaged func(string, bool, ...string)) error {
+	for _, repConfig := range repConfigList {
+		reps, err := vfRepresentors(repConfig)
 		if err != nil {
-			log.Error(err, "Failed to add interface to", "bridge", bridgeName)
 			return err
 		}
-		var setIfaceCmd string
-		if mtuRequest != 0 {
-			setIfaceCmd = "ovs-vsctl set Interface " + portName + " mtu_request=" + strconv.Itoa(mtuRequest)
-			cmd = exec.Command("bash", "-c", setIfaceCmd)
-			_, err = cmd.CombinedOutput()
+		for _, rep := range reps {
+			port := ovsutils.PortConfig{
+				Name:       rep.name,
+				Interfaces: []ovsutils.Interface{{Name: rep.name}},
+			}
+			matches, err := ovsutils.PortMatches(bridgeName, port)
 			if err != nil {
-				log.Error(err, "Could not set ", "mtu_request=", mtuRequest)
 				return err
 			}
+			if !matches {
+				if err := ovsutils.AddPort(bridgeName, port); err != nil {
+					log.Error(err, "Failed to add VF representor to bridge", "ovsbr", bridgeName, "representor", rep.name)
+					return err
+				}
+				log.Info("Added VF representor to ovs bridge", "ovsbr", bridgeName, "representor", rep.name, "pfName", repConfig.PfName, "vf", rep.vf)
+			}
+			addManaged(bridgeName, false, rep.name)
 		}
 	}
 	return nil
 }
 
-func createOvsBond(nic1 string, nic2 string, bridgeName string, mtuRequest int, bondMode string, lacp string) error {
-	log.Info("Physical interface1 name: ", "physnet", nic1)
-	log.Info("Physical interface2 name: ", "physnet", nic2)
+// createOvsBridge returns whether it created the bridge
+func createOvsBridge(nodeInterface string, bridgeName string) (bool, error) {
+	log.Info("Physical interface name: ", "physnet", nodeInterface)
 	log.Info("Bridge interface name: ", "ovsbr", bridgeName)
-	bondName := "bond-" + bridgeName
-	cmd := exec.Command("ovs-vsctl", "br-exists", bridgeName)
-	output, err := cmd.CombinedOutput()
-	add_bond_to_br := false
+	created, err := ensureOvsBridge(bridgeName, "")
 	if err != nil {
-		// if err.Error() == "exit status 2"
-		exitError, ok := err.(*exec.ExitError)
-		log.Info("Bridge missing", "ok", ok, "out", output, "err", exitError)
-		if ok {
-			cmd = exec.Command("ovs-vsctl", "add-br", bridgeName)
-			_, err = cmd.CombinedOutput()
-			if err != nil {
-				return err
+		return created, err
+	}
+	port := ovsutils.PortConfig{
+		Name:       nodeInterface,
+		Interfaces: []ovsutils.Interface{{Name: nodeInterface}},
+	}
+	matches, err := ovsutils.PortMatches(bridgeName, port)
+	if err != nil {
+		return created, err
+	}
+	if matches {
+		log.Info("Bridge already has the port", "ovsbr", bridgeName, "port", nodeInterface)
+		return created, nil
+	}
+
+	// Detaches the interface from any other bridge or bond in the same transaction
+	if err := ovsutils.AddPort(bridgeName, port); err != nil {
+		log.Error(err, "Failed to add interface to specified bridge")
+		return created, err
+	}
+	log.Info("Added node interface to ovs bridge", "ovsbr", bridgeName)
+
+	if err := moveAddrsToBridge(nodeInterface, nodeInterface, bridgeName); err != nil {
+		return created, err
+	}
+	return created, nil
+}
+
+// moveAddrsToBridge moves the addresses and routes of an interface added to
+// an OVS bridge to the bridge, which the host now reaches the network through.
+// SLAAC configures the bridge instead when it configured the interface.
+func moveAddrsToBridge(nodeInterface string, portName string, bridgeName string) error {
+	migrated, err := iputils.MigrateToBridge(nodeInterface, portName, bridgeName)
+	if err != nil {
+		log.Error(err, "Failed to move addresses and routes to bridge", "ifName", nodeInterface, "ovsbr", bridgeName)
+		return err
+	}
+	if len(migrated.IPv4) == 0 && len(migrated.IPv6) == 0 && !migrated.Autoconf && len(migrated.Routes) == 0 {
+		log.Info("No IP address or route for interface", "ifName", nodeInterface)
+		return nil
+	}
+	log.Info("Moved addresses and routes to bridge", "ifName", nodeInterface, "ovsbr", bridgeName,
+		"ipv4", migrated.IPv4, "ipv6", migrated.IPv6, "autoconf", migrated.Autoconf, "routes", len(migrated.Routes))
+
+	// The bridge has the addresses and routes at boot, not the interface
+	err = persist.Update(bridgeName, func(iface *persist.Interface) {
+		iface.Kind = persist.KindOvsBridge
+		iface.IPv4 = appendMissing(iface.IPv4, migrated.IPv4...)
+		iface.IPv6 = appendMissing(iface.IPv6, migrated.IPv6...)
+		if iface.IPv6Autoconf == nil || migrated.Autoconf {
+			iface.IPv6Autoconf = &migrated.Autoconf
+		}
+		if len(migrated.Routes) > 0 {
+			iface.Routes = appendMissingRoutes(iface.Routes, persistRoutes(migrated.Routes)...)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	noAutoconf := false
+	if err := persistAddrs(nodeInterface, []string{}, []string{}, &noAutoconf); err != nil {
+		return err
+	}
+	err = persist.Update(nodeInterface, func(iface *persist.Interface) {
+		iface.Routes = []persist.Route{}
+	})
+	if err != nil {
+		log.Error(err, "Failed to persist routes", "ifName", nodeInterface)
+	}
+	return err
+}
+
+// persistRoutes returns the routes as they are persisted
+func persistRoutes(routes []iputils.Route) []persist.Route {
+	var result []persist.Route
+	for _, r := range routes {
+		result = append(result, persist.Route{Dst: r.Dst, Gw: r.Gw, Src: r.Src, Table: r.Table, Metric: r.Metric})
+	}
+	return result
+}
+
+// appendMissingRoutes appends the routes not in slice yet, never returning nil
+func appendMissingRoutes(slice []persist.Route, routes ...persist.Route) []persist.Route {
+	result := append([]persist.Route{}, slice...)
+	for _, r := range routes {
+		found := false
+		for _, existing := range result {
+			if existing == r {
+				found = true
+				break
 			}
-			log.Info("Created : ", "ovsbr", bridgeName)
-			add_bond_to_br = true
 		}
-	} else {
-		cmd = exec.Command("ovs-vsctl", "list-ports", bridgeName)
-		output, err = cmd.Output()
-		if err != nil {
-			return err
+		if !found {
+			result = append(result, r)
 		}
-		exists := strings.Contains(strings.TrimSuffix(string(output), "\n"), bondName)
-		cmd = exec.Command("ovs-vsctl", "list-ifaces", bridgeName)
-		output, err = cmd.Output()
-		if err != nil {
-			return err
+	}
+	return result
+}
+
+// appendMissing appends the strings not in slice yet, never returning nil
+func appendMissing(slice []string, s ...string) []string {
+	result := append([]string{}, slice...)
+	for _, item := range s {
+		if !containsString(result, item) {
+			result = append(result, item)
 		}
-		if exists && strings.Contains(strings.TrimSuffix(string(output), "\n"), nic1) && strings.Contains(strings.TrimSuffix(string(output), "\n"), nic2) {
-			log.Info("Bridge", bridgeName, "already has a bond added to it")
-		} else {
-			add_bond_to_br = true
+	}
+	return result
+}
+
+// ensureOvsBridge creates the bridge if it is missing, or moves it to the
+// requested datapath, and returns whether it created the bridge
+func ensureOvsBridge(bridgeName string, datapathType string) (bool, error) {
+	exists, err := ovsutils.BridgeExists(bridgeName)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		log.Info("Bridge missing", "ovsbr", bridgeName)
+		if err := ovsutils.AddBridge(bridgeName, datapathType); err != nil {
+			return false, err
 		}
+		log.Info("Created : ", "ovsbr", bridgeName)
+		return true, nil
+	}
 
+	current, err := ovsutils.GetBridgeDatapathType(bridgeName)
+	if err != nil {
+		return false, err
 	}
-	if add_bond_to_br {
-		var setPortCmd, setIfaceCmd string
-		bondCmd := "ovs-vsctl add-bond " + bridgeName + " " + bondName + " " + nic1 + " " + nic2
-		cmd = exec.Command("bash", "-c", bondCmd)
-		_, err = cmd.CombinedOutput()
-		if err != nil {
-			log.Error(err, "Error adding ", "OVS bond to bridge", bridgeName)
-			return err
+	if current != datapathType {
+		log.Info("Changing bridge datapath", "ovsbr", bridgeName, "from", current, "to", datapathType)
+		if err := ovsutils.SetBridgeDatapathType(bridgeName, datapathType); err != nil {
+			return false, err
 		}
-		if mtuRequest != 0 {
-			setIfaceCmd = "ovs-vsctl set Interface " + bridgeName + " mtu_request=" + strconv.Itoa(mtuRequest)
-			cmd = exec.Command("bash", "-c", setIfaceCmd)
-			_, err = cmd.CombinedOutput()
-			if err != nil {
-				log.Error(err, "Could not set ", "mtu_request=", mtuRequest)
-				return err
-			}
+	}
+	return false, nil
+}
+
+// dpdkDevargs returns the PCI address of a NIC used by a DPDK interface. A NIC
+// still on its kernel driver is bound to vfio-pci first. A NIC already bound
+// has no netdev left, so its address is taken from the interface configured
+// on the existing port instead.
+func dpdkDevargs(nic string, existing *ovsutils.PortConfig, ifaceName string) (string, error) {
+	pciAddr, err := findPciAddr(nic)
+	if err == nil {
+		log.Info("Pci Address of", nic, pciAddr)
+		if err := bindVfioPci(pciAddr); err != nil {
+			log.Error(err, "Error binding", "interface", nic)
+			return "", err
 		}
-		if bondMode != "" {
-			setPortCmd = "ovs-vsctl set port bond-" + bridgeName + " bond_mode=" + bondMode
-			cmd = exec.Command("bash", "-c", setPortCmd)
-			_, err = cmd.CombinedOutput()
-			if err != nil {
-				log.Error(err, "Could not set ", "bond_mode=", bondMode)
-				return err
+		return pciAddr, nil
+	}
+	if existing != nil {
+		for _, iface := range existing.Interfaces {
+			if iface.Name == ifaceName && iface.Options["dpdk-devargs"] != "" {
+				return iface.Options["dpdk-devargs"], nil
 			}
 		}
-		if lacp != "" {
-			setPortCmd = "ovs-vsctl set port bond-" + bridgeName + " lacp=" + lacp
-			cmd = exec.Command("bash", "-c", setPortCmd)
-			_, err = cmd.CombinedOutput()
-			if err != nil {
-				log.Error(err, "Could not set lacp")
-				return err
-			}
+	}
+	log.Error(err, "Could not find PCI address of ", "Interface", nic)
+	return "", err
+}
+
+// createDpdkBridge returns whether it created the bridge
+func createDpdkBridge(nodeInterface string, bridgeName string, mtuRequest int) (bool, error) {
+	log.Info("Physical interface name: ", "physnet", nodeInterface)
+	log.Info("Bridge interface name: ", "ovsbr", bridgeName)
+	portName := "dpdk-" + bridgeName
+	created, err := ensureOvsBridge(bridgeName, "netdev")
+	if err != nil {
+		return created, err
+	}
+	existing, _, err := ovsutils.GetPort(portName)
+	if err != nil {
+		return created, err
+	}
+	pciAddr, err := dpdkDevargs(nodeInterface, existing, portName)
+	if err != nil {
+		return created, err
+	}
+	port := ovsutils.PortConfig{
+		Name: portName,
+		Interfaces: []ovsutils.Interface{{
+			Name:       portName,
+			Type:       "dpdk",
+			Options:    map[string]string{"dpdk-devargs": pciAddr},
+			MtuRequest: mtuRequest,
+		}},
+	}
+	matches, err := ovsutils.PortMatches(bridgeName, port)
+	if err != nil {
+		return created, err
+	}
+	if !matches {
+		if err := ovsutils.AddPort(bridgeName, port); err != nil {
+			log.Error(err, "Failed to add interface to", "bridge", bridgeName)
+			return created, err
 		}
 	}
-	return nil
+	if err := ovsutils.SetInterfaceMtuRequest(portName, mtuRequest); err != nil {
+		log.Error(err, "Could not set ", "mtu_request=", mtuRequest)
+		return created, err
+	}
+	return created, nil
 }
 
-func createOvsDpdkBond(nic1 string, nic2 string, bridgeName string, mtuRequest int, bondMode string, lacp string) error {
+// createOvsBond returns whether it created the bridge
+func createOvsBond(nic1 string, nic2 string, bridgeName string, mtuRequest int, bondMode string, lacp string) (bool, error) {
 	log.Info("Physical interface1 name: ", "physnet", nic1)
 	log.Info("Physical interface2 name: ", "physnet", nic2)
 	log.Info("Bridge interface name: ", "ovsbr", bridgeName)
-	bondName := "dpdkbond-" + bridgeName
-	cmd := exec.Command("ovs-vsctl", "br-exists", bridgeName)
-	output, err := cmd.CombinedOutput()
-	add_bond_to_br := false
+	bondName := "bond-" + bridgeName
+	created, err := ensureOvsBridge(bridgeName, "")
 	if err != nil {
-		// if err.Error() == "exit status 2"
-		exitError, ok := err.(*exec.ExitError)
-		log.Info("Bridge missing", "ok", ok, "out", output, "err", exitError)
-		if ok {
-			brCmd := "ovs-vsctl add-br " + bridgeName + " -- set bridge " + bridgeName + " datapath_type=netdev"
-			cmd = exec.Command("bash", "-c", brCmd)
-			_, err = cmd.CombinedOutput()
-			if err != nil {
-				return err
-			}
-			log.Info("Created : ", "ovsbr", bridgeName)
-			add_bond_to_br = true
-		}
+		return created, err
+	}
+	bond := ovsutils.PortConfig{
+		Name:       bondName,
+		Interfaces: []ovsutils.Interface{{Name: nic1}, {Name: nic2}},
+		BondMode:   bondMode,
+		Lacp:       lacp,
+	}
+	matches, err := ovsutils.PortMatches(bridgeName, bond)
+	if err != nil {
+		return created, err
+	}
+	if matches {
+		log.Info("Bridge already has the bond", "ovsbr", bridgeName)
 	} else {
-		cmd := exec.Command("ovs-vsctl", "list-ports", bridgeName)
-		output, err := cmd.Output()
-		if err != nil {
-			return err
+		if err := ovsutils.AddPort(bridgeName, bond); err != nil {
+			log.Error(err, "Error adding ", "OVS bond to bridge", bridgeName)
+			return created, err
 		}
-		exists := strings.Contains(strings.TrimSuffix(string(output), "\n"), bondName)
-		if exists {
-			log.Info("Bridge", bridgeName, "already has a bond added to it")
-		} else {
-			add_bond_to_br = true
+		for _, nic := range []string{nic1, nic2} {
+			if err := moveAddrsToBridge(nic, bondName, bridgeName); err != nil {
+				return created, err
+			}
 		}
 	}
+	if err := ovsutils.SetInterfaceMtuRequest(bridgeName, mtuRequest); err != nil {
+		log.Error(err, "Could not set ", "mtu_request=", mtuRequest)
+		return created, err
+	}
+	return created, nil
+}
 
-	if add_bond_to_br {
-		pciNic1, err := findPciAddr(nic1)
-		if err != nil {
-			log.Error(err, "Could not find PCI address of ", "Interface", nic1)
-			return err
-		}
-		pciNic2, err := findPciAddr(nic2)
-		if err != nil {
-			log.Error(err, "Could not find PCI address of ", "Interface", nic2)
-			return err
-		}
+// createOvsDpdkBond returns whether it created the bridge
+func createOvsDpdkBond(nic1 string, nic2 string, bridgeName string, mtuRequest int, bondMode string, lacp string) (bool, error) {
+	log.Info("Physical interface1 name: ", "physnet", nic1)
+	log.Info("Physical interface2 name: ", "physnet", nic2)
+	log.Info("Bridge interface name: ", "ovsbr", bridgeName)
+	bondName := "dpdkbond-" + bridgeName
+	created, err := ensureOvsBridge(bridgeName, "netdev")
+	if err != nil {
+		return created, err
+	}
+	existing, _, err := ovsutils.GetPort(bondName)
+	if err != nil {
+		return created, err
+	}
 
-		log.Info("Pci Address of", nic1, pciNic1)
-		bindCmd := "dpdk-devbind.py --bind=vfio-pci " + pciNic1
-		cmd = exec.Command("bash", "-c", bindCmd)
-		_, err = cmd.CombinedOutput()
-		if err != nil {
-			log.Error(err, "Error binding", "interface", nic1)
-			return err
-		}
-		log.Info("Pci Address of", nic2, pciNic2)
-		bindCmd = "dpdk-devbind.py --bind=vfio-pci " + pciNic2
-		cmd = exec.Command("bash", "-c", bindCmd)
-		_, err = cmd.CombinedOutput()
+	bond := ovsutils.PortConfig{
+		Name:     bondName,
+		BondMode: bondMode,
+		Lacp:     lacp,
+	}
+	for i, nic := range []string{nic1, nic2} {
+		ifaceName := fmt.Sprintf("%s-dpdk%d", bridgeName, i)
+		pciAddr, err := dpdkDevargs(nic, existing, ifaceName)
 		if err != nil {
-			log.Error(err, "Error binding", "interface", nic2)
-			return err
+			return created, err
 		}
+		bond.Interfaces = append(bond.Interfaces, ovsutils.Interface{
+			Name:    ifaceName,
+			Type:    "dpdk",
+			Options: map[string]string{"dpdk-devargs": pciAddr},
+		})
+	}
 
-		var bondCmd, setIfaceCmd, setPortCmd string
-		bondCmd = "ovs-vsctl add-bond " + bridgeName + " " + bondName + " " + bridgeName + "-dpdk0 " + bridgeName + "-dpdk1" + " -- set Interface " + bridgeName + "-dpdk0 type=dpdk options:dpdk-devargs=" + pciNic1 + " -- set Interface " + bridgeName + "-dpdk1 type=dpdk options:dpdk-devargs=" + pciNic2
-		cmd = exec.Command("bash", "-c", bondCmd)
-		_, err = cmd.CombinedOutput()
-		if err != nil {
+	matches, err := ovsutils.PortMatches(bridgeName, bond)
+	if err != nil {
+		return created, err
+	}
+	if !matches {
+		if err := ovsutils.AddPort(bridgeName, bond); err != nil {
 			log.Error(err, "Error adding ", "DPDK bond to bridge", bridgeName)
-			return err
+			return created, err
 		}
-		if mtuRequest != 0 {
-			setIfaceCmd = "ovs-vsctl set Interface " + bridgeName + "  mtu_request=" + strconv.Itoa(mtuRequest)
-			cmd = exec.Command("bash", "-c", setIfaceCmd)
-			_, err := cmd.CombinedOutput()
-			if err != nil {
-				log.Error(err, "Could not set ", "mtu_request=", mtuRequest)
-				return err
-			}
-		}
-		if bondMode != "" {
-			setPortCmd = "ovs-vsctl set port dpdkbond-" + bridgeName + " bond_mode=" + bondMode
-			cmd = exec.Command("bash", "-c", setPortCmd)
-			_, err := cmd.CombinedOutput()
-			if err != nil {
-				log.Error(err, "Could not set ", "bond_mode=", bondMode)
-				return err
-			}
+	}
+	if err := ovsutils.SetInterfaceMtuRequest(bridgeName, mtuRequest); err != nil {
+		log.Error(err, "Could not set ", "mtu_request=", mtuRequest)
+		return created, err
+	}
+	return created, nil
+}
+
+func bindVfioPci(pciAddr string) error {
+	out, err := exec.Command("dpdk-devbind.py", "--bind=vfio-pci", pciAddr).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dpdk-devbind.py failed for %s: %w: %s", pciAddr, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// sriovPfList returns the PFs a sriovConfig entry selects
+func sriovPfList(sriovConfig plumberv1.SriovConfig) ([]string, error) {
+	var pfList []string
+	if sriovConfig.PfName != nil {
+		log.Info("Configuring via PF:", "PfName", *sriovConfig.PfName)
+		pfList = append(pfList, *sriovConfig.PfName)
+	} else if sriovConfig.PciAddr != nil {
+		log.Info("Configuring via PCI address:", "PciAddr", *sriovConfig.PciAddr)
+		pfName, err := sriovutils.GetPfNameForPciAddr(*sriovConfig.PciAddr)
+		if err != nil {
+			return nil, err
 		}
-		if lacp != "" {
-			setPortCmd = "ovs-vsctl set port dpdkbond-" + bridgeName + " lacp=" + lacp
-			cmd = exec.Command("bash", "-c", setPortCmd)
-			_, err := cmd.CombinedOutput()
-			if err != nil {
-				log.Error(err, "Could not set lacp")
-				return err
-			}
+		log.Info("Got pfName matching PciAddr", "pfName", pfName, "PciAddr", *sriovConfig.PciAddr)
+		pfList = append(pfList, pfName)
+	} else if sriovConfig.VendorId != nil {
+		// Without a device ID, every PF of the vendor with an SR-IOV profile matches
+		deviceId := ""
+		if sriovConfig.DeviceId != nil {
+			deviceId = *sriovConfig.DeviceId
 		}
+		log.Info("Configuring via device/vendor ID", "VendorId", *sriovConfig.VendorId, "DeviceId", deviceId)
+		return sriovutils.GetPfListForVendorAndDevice(*sriovConfig.VendorId, deviceId)
 	}
-	return nil
+	return pfList, nil
+}
+
+// defaultVfDriver returns the vfDriver of the sriovConfig entry, or the VF
+// driver of the PF's SR-IOV profile. VFs of a NIC without a profile stay on
+// the driver the kernel bound, and "" is returned.
+func defaultVfDriver(pfName string, sriovConfig plumberv1.SriovConfig) (string, error) {
+	if sriovConfig.VfDriver != nil {
+		return *sriovConfig.VfDriver, nil
+	}
+	profile, err := sriovutils.GetProfileForPf(pfName)
+	if err != nil {
+		return "", err
+	}
+	if profile == nil {
+		log.Info("No SR-IOV profile for PF, keeping the kernel VF driver", "pfName", pfName)
+		return "", nil
+	}
+	return profile.VfDriver, nil
 }
 
 func applySriovConfig(sriovConfigList []plumberv1.SriovConfig) error {
 	for _, sriovConfig := range sriovConfigList {
-		var pfName string
-		var pfList []string
-		var err error
-		if sriovConfig.PfName != nil {
-			log.Info("Configuring via PF:", "PfName", *sriovConfig.PfName)
-			pfName = *sriovConfig.PfName
-			pfList = append(pfList, pfName)
-		} else if sriovConfig.PciAddr != nil {
-			log.Info("Configuring via PCI address:", "PciAddr", *sriovConfig.PciAddr)
-			pfName, err = sriovutils.GetPfNameForPciAddr(*sriovConfig.PciAddr)
-			if err != nil {
-				return err
-			}
-			log.Info("Got pfName matching PciAddr", "pfName", pfName, "PciAddr", *sriovConfig.PciAddr)
-			pfList = append(pfList, pfName)
-		} else if sriovConfig.VendorId != nil && sriovConfig.DeviceId != nil {
-			log.Info("Configuring via device/vendor ID", "VendorId", *sriovConfig.VendorId, "DeviceId", *sriovConfig.DeviceId)
-			pfList, err = sriovutils.GetPfListForVendorAndDevice(*sriovConfig.VendorId, *sriovConfig.DeviceId)
-			if err != nil {
-				return err
-			}
+		pfList, err := sriovPfList(sriovConfig)
+		if err != nil {
+			return err
 		}
 		log.Info("Configuring interfaces", "pfList", pfList)
 		for _, pfName := range pfList {
@@ -747,18 +1424,18 @@ func applySriovConfig(sriovConfigList []plumberv1.SriovConfig) error {
 				return err
 			}
 
-			if sriovConfig.VfDriver != nil {
-				if err := sriovutils.EnableDriverForVfs(pfName, *sriovConfig.VfDriver); err != nil {
-					log.Info("Failed to set vfDriver", "vfDriver", *sriovConfig.VfDriver, "err", err)
-					return err
-				}
-			} else {
-				// If driver field is omitted, set the default kernel driver
-				// TODO: How to determine default driver for different NICs?
-				if err := sriovutils.EnableDriverForVfs(pfName, "i40evf"); err != nil {
-					log.Info("Failed to set vfDriver i40evf", err)
-					return err
-				}
+			if err := applyEswitchConfig(pfName, sriovConfig); err != nil {
+				log.Error(err, "Failed to configure the eswitch", "pfName", pfName)
+				return err
+			}
+
+			vfDriver, err := defaultVfDriver(pfName, sriovConfig)
+			if err != nil {
+				return err
+			}
+			if err := enableVfDrivers(pfName, *sriovConfig.NumVfs, vfDriver, sriovConfig.VfConfig); err != nil {
+				log.Info("Failed to set vfDriver", "vfDriver", vfDriver, "err", err)
+				return err
 			}
 
 			if sriovConfig.MTU != nil && *sriovConfig.MTU >= 576 {
@@ -768,6 +1445,107 @@ func applySriovConfig(sriovConfigList []plumberv1.SriovConfig) error {
 					return err
 				}
 			}
+
+			if err := configureVfs(pfName, *sriovConfig.NumVfs, sriovConfig.VfConfig); err != nil {
+				log.Error(err, "Failed to configure VFs", "pfName", pfName)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyEswitchConfig sets the eswitch mode and hw-tc-offload of the PF, once
+// its VFs exist. NICs whose SR-IOV profile has no switchdev support are
+// rejected.
+func applyEswitchConfig(pfName string, sriovConfig plumberv1.SriovConfig) error {
+	if sriovConfig.EswitchMode != "" {
+		if sriovConfig.EswitchMode == sriovutils.EswitchModeSwitchdev {
+			profile, err := sriovutils.GetProfileForPf(pfName)
+			if err != nil {
+				return err
+			}
+			if profile != nil && !profile.Switchdev {
+				return fmt.Errorf("PF %s of profile %s does not support switchdev", pfName, profile.Name)
+			}
+		}
+		log.Info("sriovConfig eswitchMode", "pfName", pfName, "eswitchMode", sriovConfig.EswitchMode)
+		if err := sriovutils.SetEswitchMode(pfName, sriovConfig.EswitchMode); err != nil {
+			return err
+		}
+	}
+	if sriovConfig.HwTcOffload != nil {
+		if err := sriovutils.SetHwTcOffload(pfName, *sriovConfig.HwTcOffload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableVfDrivers binds the VFs of a PF with numVfs VFs to the driver of
+// their range in vfConfigList, or to vfDriver, and saves the ranges with a
+// driver or resource name so they are reported in the PF status
+func enableVfDrivers(pfName string, numVfs int, vfDriver string, vfConfigList []plumberv1.VfConfig) error {
+	drivers, groups, err := vfDrivers(numVfs, vfDriver, vfConfigList)
+	if err != nil {
+		return err
+	}
+	if err := sriovutils.EnableDriversForVfs(pfName, drivers); err != nil {
+		return err
+	}
+	return sriovutils.SaveVfGroups(pfName, groups)
+}
+
+// vfDrivers returns the driver of each VF of a PF with numVfs VFs, and the
+// ranges of vfConfigList with a driver or resource name
+func vfDrivers(numVfs int, vfDriver string, vfConfigList []plumberv1.VfConfig) ([]string, []sriovutils.VfGroup, error) {
+	var groups []sriovutils.VfGroup
+	for _, vfConfig := range vfConfigList {
+		if vfConfig.VfDriver == "" && vfConfig.ResourceName == "" {
+			continue
+		}
+		ids, err := sriovutils.ParseVfRange(vfConfig.VfRange, numVfs)
+		if err != nil {
+			return nil, nil, err
+		}
+		group := sriovutils.VfGroup{
+			VfRange:      vfConfig.VfRange,
+			Ids:          ids,
+			VfDriver:     vfConfig.VfDriver,
+			ResourceName: vfConfig.ResourceName,
+		}
+		if group.VfDriver == "" {
+			group.VfDriver = vfDriver
+		}
+		groups = append(groups, group)
+	}
+	drivers, err := sriovutils.VfDrivers(numVfs, vfDriver, groups)
+	if err != nil {
+		return nil, nil, err
+	}
+	return drivers, groups, nil
+}
+
+// configureVfs applies the per VF settings of a PF with numVfs VFs
+func configureVfs(pfName string, numVfs int, vfConfigList []plumberv1.VfConfig) error {
+	for _, vfConfig := range vfConfigList {
+		ids, err := sriovutils.ParseVfRange(vfConfig.VfRange, numVfs)
+		if err != nil {
+			return err
+		}
+		log.Info("Configuring VFs", "pfName", pfName, "vfRange", vfConfig.VfRange)
+		settings := sriovutils.VfSettings{
+			Vlan:      vfConfig.Vlan,
+			Qos:       vfConfig.Qos,
+			Mac:       vfConfig.Mac,
+			Trust:     vfConfig.Trust,
+			Spoofchk:  vfConfig.Spoofchk,
+			MinTxRate: vfConfig.MinTxRate,
+			MaxTxRate: vfConfig.MaxTxRate,
+			LinkState: vfConfig.LinkState,
+		}
+		if err := sriovutils.ConfigureVfs(pfName, ids, settings); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -775,22 +1553,18 @@ func applySriovConfig(sriovConfigList []plumberv1.SriovConfig) error {
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *HostNetworkTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&plumberv1.HostNetworkTemplate{}).
+		// Status writes by the agents on other nodes must not re-apply the template,
+		// plan annotation changes have to plan it again
+		WithEventFilter(predicate.Or(predicate.GenerationChangedPredicate{}, deletionStartedPredicate(), planChangedPredicate())).
 		Complete(r)
 }
 
 // Helper functions to check and remove string from a slice of strings.
-func contains(s []byte, str string) bool {
-	for _, v := range s {
-		log.Info("contains ", "item", string(v))
-		if string(v) == str {
-			return true
-		}
-	}
-	return false
-}
-
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -811,12 +1585,10 @@ func removeString(slice []string, s string) (result []string) {
 }
 
 func findPciAddr(nodeInterface string) (string, error) {
-	cmd := "ethtool -i " + nodeInterface + "| grep bus-info | awk '{print $2}'"
-	out, err := exec.Command("bash", "-c", cmd).Output()
+	pci, err := sriovutils.GetPciAddrForIf(nodeInterface)
 	if err != nil {
 		log.Error(err, "Error finding PCI address of", "interface", nodeInterface)
 		return "", err
 	}
-	pci := strings.TrimSuffix(string(out), "\n")
 	return pci, nil
 }
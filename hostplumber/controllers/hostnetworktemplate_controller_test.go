@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	plumberv1 "hostplumber/api/v1"
+	linkutils "hostplumber/pkg/utils/link"
+	ovsutils "hostplumber/pkg/utils/ovs"
+)
+
+func TestAdoptLegacyBridges(t *testing.T) {
+	prevDir := ovsutils.StateDir
+	ovsutils.StateDir = t.TempDir()
+	t.Cleanup(func() { ovsutils.StateDir = prevDir })
+
+	tmpl := &plumberv1.HostNetworkTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "tmpl"},
+		Spec: plumberv1.HostNetworkTemplateSpec{OvsConfig: []*plumberv1.OvsConfig{
+			{BridgeName: "br0", NodeInterface: "eth1"},
+			{BridgeName: "br1", NodeInterface: "eth2,eth3"},
+		}},
+	}
+
+	// Templates without the legacy finalizer saved their bridges already
+	if err := adoptLegacyBridges(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := ovsutils.HasManagedBridges("tmpl"); saved {
+		t.Fatalf("bridges adopted without the legacy finalizer")
+	}
+
+	tmpl.Finalizers = []string{legacyFinalizer}
+	if err := adoptLegacyBridges(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ovsutils.GetManagedBridges("tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ovsutils.ManagedBridge{
+		{Name: "br0", Created: true, Ports: []string{"eth1"}},
+		{Name: "br1", Created: true, Ports: []string{"bond-br1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Saved bridges are kept as they are
+	tmpl.Spec.OvsConfig = tmpl.Spec.OvsConfig[:1]
+	if err := adoptLegacyBridges(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ovsutils.GetManagedBridges("tmpl"); len(got) != 2 {
+		t.Errorf("saved bridges changed: %+v", got)
+	}
+}
+
+func TestPlanVlanConfigUnlisted(t *testing.T) {
+	prevDir := linkutils.StateDir
+	linkutils.StateDir = t.TempDir()
+	t.Cleanup(func() { linkutils.StateDir = prevDir })
+
+	if err := linkutils.UpdateManagedVlans("tmpl", "eth9", []string{"eth9.100"}); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := planVlanConfig(nil, "tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"delete VLAN eth9.100 of eth9"}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("got %v, want %v", changes, want)
+	}
+}
@@ -0,0 +1,421 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	plumberv1 "hostplumber/api/v1"
+	sriovutils "hostplumber/pkg/utils/sriov"
+)
+
+// cordonedByAnnotation is set with the template name on a node hostplumber
+// cordoned, so it only uncordons nodes it cordoned itself
+const cordonedByAnnotation = "plumber.k8s.pf9.io/cordoned-by"
+
+// reasonWaiting is the reason of the SriovApplied condition while a
+// disruptive change waits for a slot, a drain or an approval
+const reasonWaiting = "Waiting"
+
+const (
+	defaultResourceTimeout = 600 * time.Second
+	// disruptionRequeue is how often a node going through a disruptive change
+	// checks its progress
+	disruptionRequeue = 15 * time.Second
+)
+
+// disruptionSettings returns the policy, maxUnavailable and resource timeout
+// of the template, with their defaults
+func disruptionSettings(spec plumberv1.HostNetworkTemplateSpec) (string, int, time.Duration) {
+	policy, maxUnavailable, timeout := plumberv1.DisruptionPolicyDrain, 1, defaultResourceTimeout
+	if config := spec.SriovDisruption; config != nil {
+		if config.Policy != "" {
+			policy = config.Policy
+		}
+		if config.MaxUnavailable != nil {
+			maxUnavailable = *config.MaxUnavailable
+		}
+		if config.ResourceTimeoutSeconds != nil {
+			timeout = time.Duration(*config.ResourceTimeoutSeconds) * time.Second
+		}
+	}
+	return policy, maxUnavailable, timeout
+}
+
+// sriovDisruptions returns why applying sriovConfigList would reset VFs
+// that exist, which pods may be using: a VF count change, or an eswitch mode
+// or VF driver change. Creating VFs on a PF without any is not disruptive.
+func sriovDisruptions(sriovConfigList []plumberv1.SriovConfig) ([]string, error) {
+	var reasons []string
+	for _, sriovConfig := range sriovConfigList {
+		pfList, err := sriovPfList(sriovConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, pfName := range pfList {
+			if !sriovutils.VerifyPfExists(pfName) {
+				continue
+			}
+			current, err := sriovutils.GetCurrentNumVfsForPf(pfName)
+			if err != nil {
+				return nil, err
+			}
+			if current == 0 {
+				continue
+			}
+			changes, err := pfSriovChanges(pfName, current, sriovConfig)
+			if err != nil {
+				return nil, err
+			}
+			reasons = append(reasons, changes...)
+		}
+	}
+	return reasons, nil
+}
+
+// pfSriovChanges returns what applying sriovConfig changes on a PF with
+// numVfs VFs: the VF count, or else the eswitch mode or the driver of VFs
+func pfSriovChanges(pfName string, numVfs int, sriovConfig plumberv1.SriovConfig) ([]string, error) {
+	if numVfs != *sriovConfig.NumVfs {
+		return []string{fmt.Sprintf("%s: %d to %d VFs", pfName, numVfs, *sriovConfig.NumVfs)}, nil
+	}
+	if numVfs == 0 {
+		return nil, nil
+	}
+	if sriovConfig.EswitchMode != "" {
+		mode, err := sriovutils.GetEswitchMode(pfName)
+		if err != nil {
+			return nil, err
+		}
+		if mode != "" && mode != sriovConfig.EswitchMode {
+			return []string{fmt.Sprintf("%s: eswitch mode %s to %s", pfName, mode, sriovConfig.EswitchMode)}, nil
+		}
+	}
+	vfDriver, err := defaultVfDriver(pfName, sriovConfig)
+	if err != nil {
+		return nil, err
+	}
+	drivers, _, err := vfDrivers(numVfs, vfDriver, sriovConfig.VfConfig)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := sriovutils.VfDriverChanges(pfName, drivers)
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) > 0 {
+		return []string{fmt.Sprintf("%s: driver of VFs %v", pfName, changed)}, nil
+	}
+	return nil, nil
+}
+
+// prepareSriovDisruption decides whether the sriovConfig section can be
+// applied on this node now. A disruptive change first takes one of the
+// maxUnavailable slots of the template, then waits for the node to be drained
+// or the change to be approved. It returns what the node waits for, empty
+// when the section can be applied, and sets the Disruption phase of nodeStatus.
+func (r *HostNetworkTemplateReconciler) prepareSriovDisruption(ctx context.Context, hostConfigReq *plumberv1.HostNetworkTemplate,
+	node *corev1.Node, nodeStatus *plumberv1.NodeApplyStatus) (string, error) {
+	for _, previous := range hostConfigReq.Status.Nodes {
+		if previous.NodeName == r.NodeName {
+			nodeStatus.Disruption = previous.Disruption
+			nodeStatus.DisruptionTime = previous.DisruptionTime
+		}
+	}
+
+	policy, maxUnavailable, _ := disruptionSettings(hostConfigReq.Spec)
+	if len(hostConfigReq.Spec.SriovConfig) == 0 || policy == plumberv1.DisruptionPolicyNone {
+		return "", nil
+	}
+	reasons, err := sriovDisruptions(hostConfigReq.Spec.SriovConfig)
+	if err != nil {
+		return "", err
+	}
+	if len(reasons) == 0 {
+		return "", nil
+	}
+	log.Info("Disruptive SR-IOV change", "reasons", reasons, "policy", policy)
+
+	phase := plumberv1.DisruptionDraining
+	if policy == plumberv1.DisruptionPolicyApproval {
+		phase = plumberv1.DisruptionWaitingForApproval
+	}
+	if nodeStatus.Disruption == "" {
+		claimed, err := r.claimDisruptionSlot(ctx, types.NamespacedName{Namespace: hostConfigReq.Namespace, Name: hostConfigReq.Name}, phase, maxUnavailable)
+		if err != nil {
+			return "", err
+		}
+		if !claimed {
+			return fmt.Sprintf("waiting for fewer than %d nodes to go through a disruptive change (%s)", maxUnavailable, strings.Join(reasons, ", ")), nil
+		}
+		now := metav1.Now()
+		nodeStatus.DisruptionTime = &now
+	} else if nodeStatus.Disruption != phase {
+		now := metav1.Now()
+		nodeStatus.DisruptionTime = &now
+	}
+	nodeStatus.Disruption = phase
+
+	if policy == plumberv1.DisruptionPolicyApproval {
+		if _, approved := node.Annotations[plumberv1.ApproveDisruptionAnnotation]; !approved {
+			return fmt.Sprintf("waiting for the %s annotation on the node (%s)", plumberv1.ApproveDisruptionAnnotation, strings.Join(reasons, ", ")), nil
+		}
+		log.Info("Disruptive SR-IOV change approved", "node", node.Name)
+		return "", nil
+	}
+
+	remaining, err := r.drainNode(ctx, node, hostConfigReq.Name)
+	if err != nil {
+		return "", err
+	}
+	if remaining > 0 {
+		return fmt.Sprintf("draining the node, %d pods left (%s)", remaining, strings.Join(reasons, ", ")), nil
+	}
+	log.Info("Node drained for disruptive SR-IOV change", "node", node.Name)
+	return "", nil
+}
+
+// claimDisruptionSlot sets the Disruption phase of this node in the template
+// status if fewer than maxUnavailable other nodes have one. The status is
+// updated with its resourceVersion, so two nodes cannot take the last slot.
+func (r *HostNetworkTemplateReconciler) claimDisruptionSlot(ctx context.Context, key types.NamespacedName, phase string, maxUnavailable int) (bool, error) {
+	claimed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		claimed = false
+		hostConfigReq := &plumberv1.HostNetworkTemplate{}
+		if err := r.Get(ctx, key, hostConfigReq); err != nil {
+			return err
+		}
+		disrupted := 0
+		own := -1
+		for i, nodeStatus := range hostConfigReq.Status.Nodes {
+			if nodeStatus.NodeName == r.NodeName {
+				own = i
+			} else if nodeStatus.Disruption != "" {
+				disrupted++
+			}
+		}
+		if own >= 0 && hostConfigReq.Status.Nodes[own].Disruption != "" {
+			claimed = true
+			return nil
+		}
+		if disrupted >= maxUnavailable {
+			log.Info("No disruption slot left", "nodes", disrupted, "maxUnavailable", maxUnavailable)
+			return nil
+		}
+
+		now := metav1.Now()
+		if own < 0 {
+			hostConfigReq.Status.Nodes = append(hostConfigReq.Status.Nodes, plumberv1.NodeApplyStatus{
+				NodeName:           r.NodeName,
+				ObservedGeneration: hostConfigReq.Generation,
+				LastTransitionTime: now,
+			})
+			own = len(hostConfigReq.Status.Nodes) - 1
+		}
+		hostConfigReq.Status.Nodes[own].Disruption = phase
+		hostConfigReq.Status.Nodes[own].DisruptionTime = &now
+		claimed = true
+		return r.Status().Update(ctx, hostConfigReq)
+	})
+	return claimed, err
+}
+
+// drainNode cordons the node and evicts its pods through the eviction API,
+// so PodDisruptionBudgets are respected. It returns how many pods are left.
+func (r *HostNetworkTemplateReconciler) drainNode(ctx context.Context, node *corev1.Node, templateName string) (int, error) {
+	if !node.Spec.Unschedulable {
+		log.Info("Cordoning node", "node", node.Name)
+		patch := client.MergeFrom(node.DeepCopy())
+		node.Spec.Unschedulable = true
+		metav1.SetMetaDataAnnotation(&node.ObjectMeta, cordonedByAnnotation, templateName)
+		if err := r.Patch(ctx, node, patch); err != nil {
+			return 0, fmt.Errorf("failed to cordon node %s: %w", node.Name, err)
+		}
+	}
+
+	podList := &corev1.PodList{}
+	if err := r.APIReader.List(ctx, podList, client.MatchingFields{"spec.nodeName": node.Name}); err != nil {
+		return 0, err
+	}
+	remaining := 0
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !evictable(pod) {
+			continue
+		}
+		remaining++
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		eviction := &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		}
+		if err := r.SubResource("eviction").Create(ctx, pod, eviction); err != nil {
+			switch {
+			case apierrors.IsNotFound(err):
+				remaining--
+			case apierrors.IsTooManyRequests(err):
+				log.Info("Eviction blocked by a PodDisruptionBudget", "pod", pod.Namespace+"/"+pod.Name)
+			default:
+				return remaining, fmt.Errorf("failed to evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+			continue
+		}
+		log.Info("Evicted pod", "pod", pod.Namespace+"/"+pod.Name)
+	}
+	return remaining, nil
+}
+
+// evictable returns whether draining evicts the pod. DaemonSet and mirror
+// pods stay on the node, finished pods are ignored.
+func evictable(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, mirror := pod.Annotations[corev1.MirrorPodAnnotationKey]; mirror {
+		return false
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
+// completeSriovDisruption moves a node whose disruptive change was applied to
+// WaitingForResources. Once the node advertises the resourceNames of vfConfig
+// again, or the resource timeout expires, it uncordons the node if hostplumber
+// cordoned it, removes the approval and releases the slot.
+func (r *HostNetworkTemplateReconciler) completeSriovDisruption(ctx context.Context, hostConfigReq *plumberv1.HostNetworkTemplate,
+	nodeStatus *plumberv1.NodeApplyStatus) error {
+	if nodeStatus.Disruption == "" {
+		return nil
+	}
+	if !meta.IsStatusConditionTrue(nodeStatus.Conditions, plumberv1.ConditionSriovApplied) {
+		return nil
+	}
+	now := metav1.Now()
+	if nodeStatus.Disruption != plumberv1.DisruptionWaitingForResources {
+		nodeStatus.Disruption = plumberv1.DisruptionWaitingForResources
+		nodeStatus.DisruptionTime = &now
+	}
+
+	node := &corev1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: r.NodeName}, node); err != nil {
+		return err
+	}
+	_, _, timeout := disruptionSettings(hostConfigReq.Spec)
+	names := vfResourceNames(hostConfigReq.Spec.SriovConfig)
+	if !resourcesAdvertised(node, names) {
+		if nodeStatus.DisruptionTime != nil && now.Sub(nodeStatus.DisruptionTime.Time) < timeout {
+			log.Info("Waiting for the device plugin to advertise SR-IOV resources", "resources", names)
+			return nil
+		}
+		log.Info("SR-IOV resources not advertised in time, completing the change anyway", "resources", names, "timeout", timeout)
+	}
+
+	if err := r.releaseNode(ctx, node, hostConfigReq.Name); err != nil {
+		return err
+	}
+	nodeStatus.Disruption = ""
+	nodeStatus.DisruptionTime = nil
+	return nil
+}
+
+// releaseNode uncordons the node if hostplumber cordoned it for the template,
+// and removes the approval of the disruptive change
+func (r *HostNetworkTemplateReconciler) releaseNode(ctx context.Context, node *corev1.Node, templateName string) error {
+	patch := client.MergeFrom(node.DeepCopy())
+	changed := false
+	if node.Annotations[cordonedByAnnotation] == templateName {
+		log.Info("Uncordoning node", "node", node.Name)
+		node.Spec.Unschedulable = false
+		delete(node.Annotations, cordonedByAnnotation)
+		changed = true
+	}
+	if _, approved := node.Annotations[plumberv1.ApproveDisruptionAnnotation]; approved {
+		delete(node.Annotations, plumberv1.ApproveDisruptionAnnotation)
+		changed = true
+	}
+	if changed {
+		if err := r.Patch(ctx, node, patch); err != nil {
+			return fmt.Errorf("failed to uncordon node %s: %w", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// abortSriovDisruption releases the node from a disruptive change of the
+// template that will not complete, as the template is deleted or no longer
+// selects the node. The slot is released with the status of the node.
+func (r *HostNetworkTemplateReconciler) abortSriovDisruption(ctx context.Context, hostConfigReq *plumberv1.HostNetworkTemplate, node *corev1.Node) error {
+	disrupted := node.Annotations[cordonedByAnnotation] == hostConfigReq.Name
+	for _, nodeStatus := range hostConfigReq.Status.Nodes {
+		if nodeStatus.NodeName == r.NodeName && nodeStatus.Disruption != "" {
+			disrupted = true
+		}
+	}
+	if !disrupted {
+		return nil
+	}
+	log.Info("Aborting disruptive SR-IOV change", "node", node.Name)
+	return r.releaseNode(ctx, node, hostConfigReq.Name)
+}
+
+// sriovDisruptionEnabled returns whether a disruptive change of the template
+// can cordon a node, which must be uncordoned when the template is deleted
+func sriovDisruptionEnabled(spec plumberv1.HostNetworkTemplateSpec) bool {
+	policy, _, _ := disruptionSettings(spec)
+	return len(spec.SriovConfig) > 0 && policy != plumberv1.DisruptionPolicyNone
+}
+
+// vfResourceNames returns the resourceNames of the vfConfig of every PF
+func vfResourceNames(sriovConfigList []plumberv1.SriovConfig) []string {
+	var names []string
+	for _, sriovConfig := range sriovConfigList {
+		for _, vfConfig := range sriovConfig.VfConfig {
+			if vfConfig.ResourceName != "" && !containsString(names, vfConfig.ResourceName) {
+				names = append(names, vfConfig.ResourceName)
+			}
+		}
+	}
+	return names
+}
+
+// resourcesAdvertised returns whether the node has some of each resource
+// allocatable. A name without a prefix matches any <prefix>/<name>.
+func resourcesAdvertised(node *corev1.Node, names []string) bool {
+	for _, name := range names {
+		found := false
+		for resource, quantity := range node.Status.Allocatable {
+			if (string(resource) == name || strings.HasSuffix(string(resource), "/"+name)) && !quantity.IsZero() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// waitingForDisruption returns whether the node is going through, or waiting
+// for, a disruptive sriovConfig change rather than failing
+func waitingForDisruption(nodeStatus plumberv1.NodeApplyStatus) bool {
+	if nodeStatus.Disruption != "" {
+		return true
+	}
+	condition := meta.FindStatusCondition(nodeStatus.Conditions, plumberv1.ConditionSriovApplied)
+	return condition != nil && condition.Reason == reasonWaiting && nodeStatus.LastError == ""
+}
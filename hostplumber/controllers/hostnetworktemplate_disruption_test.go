@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	plumberv1 "hostplumber/api/v1"
+)
+
+func TestEvictable(t *testing.T) {
+	isController := true
+	for _, tc := range []struct {
+		name string
+		pod  corev1.Pod
+		want bool
+	}{
+		{"deployment pod", corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Controller: &isController}},
+		}}, true},
+		{"daemonset pod", corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Controller: &isController}},
+		}}, false},
+		{"mirror pod", corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "abc"},
+		}}, false},
+		{"completed pod", corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodSucceeded}}, false},
+		{"bare pod", corev1.Pod{}, true},
+	} {
+		if got := evictable(&tc.pod); got != tc.want {
+			t.Errorf("%s: evictable %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestResourcesAdvertised(t *testing.T) {
+	node := &corev1.Node{Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+		"intel.com/intel_sriov_netdevice": resource.MustParse("4"),
+		"intel.com/intel_sriov_dpdk":      resource.MustParse("0"),
+	}}}
+	if !resourcesAdvertised(node, nil) {
+		t.Errorf("no resources to wait for")
+	}
+	if !resourcesAdvertised(node, []string{"intel_sriov_netdevice"}) {
+		t.Errorf("resource name without prefix not found")
+	}
+	if !resourcesAdvertised(node, []string{"intel.com/intel_sriov_netdevice"}) {
+		t.Errorf("resource name with prefix not found")
+	}
+	if resourcesAdvertised(node, []string{"intel_sriov_netdevice", "intel_sriov_dpdk"}) {
+		t.Errorf("resource without any allocatable found")
+	}
+}
+
+func TestSummarizeWaitingNodes(t *testing.T) {
+	status := &plumberv1.HostNetworkTemplateStatus{Nodes: []plumberv1.NodeApplyStatus{
+		{NodeName: "node1", ObservedGeneration: 2, Applied: true},
+		{NodeName: "node2", ObservedGeneration: 2, Disruption: plumberv1.DisruptionDraining},
+		{NodeName: "node3", ObservedGeneration: 2, Conditions: []metav1.Condition{{
+			Type: plumberv1.ConditionSriovApplied, Status: metav1.ConditionFalse, Reason: reasonWaiting,
+		}}},
+		{NodeName: "node4", ObservedGeneration: 2, LastError: "SriovApplied: failed"},
+	}}
+	var nodes []corev1.Node
+	for _, name := range []string{"node1", "node2", "node3", "node4"} {
+		nodes = append(nodes, corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	summarizeTemplateStatus(status, 2, nodes)
+	if status.AppliedNodes != 1 {
+		t.Errorf("applied nodes %d, want 1", status.AppliedNodes)
+	}
+	if len(status.FailedNodes) != 1 || status.FailedNodes[0] != "node4" {
+		t.Errorf("failed nodes %v, want [node4]", status.FailedNodes)
+	}
+}
+
+func TestDisruptionSettings(t *testing.T) {
+	policy, maxUnavailable, timeout := disruptionSettings(plumberv1.HostNetworkTemplateSpec{})
+	if policy != plumberv1.DisruptionPolicyDrain || maxUnavailable != 1 || timeout != defaultResourceTimeout {
+		t.Errorf("defaults %s %d %s", policy, maxUnavailable, timeout)
+	}
+	two, zero := 2, 0
+	policy, maxUnavailable, timeout = disruptionSettings(plumberv1.HostNetworkTemplateSpec{
+		SriovDisruption: &plumberv1.SriovDisruptionConfig{
+			Policy:                 plumberv1.DisruptionPolicyApproval,
+			MaxUnavailable:         &two,
+			ResourceTimeoutSeconds: &zero,
+		},
+	})
+	if policy != plumberv1.DisruptionPolicyApproval || maxUnavailable != 2 || timeout != 0 {
+		t.Errorf("settings %s %d %s", policy, maxUnavailable, timeout)
+	}
+}
+
+func TestSriovDisruptionEnabled(t *testing.T) {
+	numVfs := 4
+	spec := plumberv1.HostNetworkTemplateSpec{SriovConfig: []plumberv1.SriovConfig{{NumVfs: &numVfs}}}
+	if !sriovDisruptionEnabled(spec) || !needsCleanup(spec) {
+		t.Errorf("a sriovConfig drains by default and needs a finalizer")
+	}
+	spec.SriovDisruption = &plumberv1.SriovDisruptionConfig{Policy: plumberv1.DisruptionPolicyNone}
+	if sriovDisruptionEnabled(spec) || needsCleanup(spec) {
+		t.Errorf("the None policy never cordons")
+	}
+}
+
+func TestAbortSriovDisruption(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: map[string]string{
+			cordonedByAnnotation:                  "tmpl",
+			plumberv1.ApproveDisruptionAnnotation: "",
+		}},
+		Spec: corev1.NodeSpec{Unschedulable: true},
+	}
+	r := &HostNetworkTemplateReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build(),
+		NodeName: "node1",
+	}
+	log = r.Log
+
+	// Another template's drain is left alone
+	other := &plumberv1.HostNetworkTemplate{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	if err := r.abortSriovDisruption(context.TODO(), other, node); err != nil {
+		t.Fatal(err)
+	}
+	if !node.Spec.Unschedulable {
+		t.Fatalf("node uncordoned for a template that did not cordon it")
+	}
+
+	tmpl := &plumberv1.HostNetworkTemplate{ObjectMeta: metav1.ObjectMeta{Name: "tmpl"}}
+	if err := r.abortSriovDisruption(context.TODO(), tmpl, node); err != nil {
+		t.Fatal(err)
+	}
+	got := &corev1.Node{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: "node1"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Unschedulable || len(got.Annotations) != 0 {
+		t.Errorf("node still cordoned: unschedulable %t, annotations %v", got.Spec.Unschedulable, got.Annotations)
+	}
+}
@@ -0,0 +1,446 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	plumberv1 "hostplumber/api/v1"
+	iputils "hostplumber/pkg/utils/ip"
+	linkutils "hostplumber/pkg/utils/link"
+	ovsutils "hostplumber/pkg/utils/ovs"
+	sriovutils "hostplumber/pkg/utils/sriov"
+	sysctlutils "hostplumber/pkg/utils/sysctl"
+)
+
+// inPlanMode returns whether the template is in plan mode
+func inPlanMode(hostConfigReq *plumberv1.HostNetworkTemplate) bool {
+	_, ok := hostConfigReq.Annotations[plumberv1.PlanAnnotation]
+	return ok
+}
+
+// planTemplate returns what applying the template would change on this node:
+// VF counts, eswitch modes and VF drivers, bonds, VLAN interfaces, VXLANs,
+// bridges, IPs and MTUs, sysctls, OVS bridges and ports, and routes and
+// rules. Nothing on the host is changed. The changes found before an error
+// are returned with it.
+func planTemplate(hostConfigReq *plumberv1.HostNetworkTemplate) ([]string, error) {
+	spec := hostConfigReq.Spec
+	name := hostConfigReq.Name
+	var changes []string
+	planners := []func() ([]string, error){
+		func() ([]string, error) { return planSriovConfig(spec.SriovConfig) },
+		func() ([]string, error) { return planBondConfig(spec.BondConfig, name) },
+		func() ([]string, error) { return planVlanConfig(spec.InterfaceConfig, name) },
+		func() ([]string, error) { return planVxlanConfig(spec.VxlanConfig, name) },
+		func() ([]string, error) { return planBridgeConfig(spec.BridgeConfig, name) },
+		func() ([]string, error) { return planInterfaceConfig(spec.InterfaceConfig) },
+		func() ([]string, error) { return sysctlutils.Plan(name, spec.SysctlConfig) },
+		func() ([]string, error) { return planOvsConfig(spec.OvsConfig, name) },
+		func() ([]string, error) { return iputils.PlanRoutes(name, routesOf(spec.RouteConfig)) },
+		func() ([]string, error) { return iputils.PlanRules(name, rulesOf(spec.RuleConfig)) },
+	}
+	for _, plan := range planners {
+		sectionChanges, err := plan()
+		changes = append(changes, sectionChanges...)
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+func planSriovConfig(sriovConfigList []plumberv1.SriovConfig) ([]string, error) {
+	var changes []string
+	for _, sriovConfig := range sriovConfigList {
+		pfList, err := sriovPfList(sriovConfig)
+		if err != nil {
+			return changes, err
+		}
+		for _, pfName := range pfList {
+			if !sriovutils.VerifyPfExists(pfName) {
+				changes = append(changes, fmt.Sprintf("%s: skipped, the PF does not exist", pfName))
+				continue
+			}
+			current, err := sriovutils.GetCurrentNumVfsForPf(pfName)
+			if err != nil {
+				return changes, err
+			}
+			pfChanges, err := pfSriovChanges(pfName, current, sriovConfig)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, pfChanges...)
+		}
+	}
+	return changes, nil
+}
+
+func planBondConfig(bondConfigList []plumberv1.BondConfig, templateName string) ([]string, error) {
+	var bonds []string
+	for _, bondConfig := range bondConfigList {
+		bonds = append(bonds, bondConfig.Name)
+	}
+	create, remove, err := linkutils.PlanManagedBonds(templateName, bonds)
+	return linkChanges("bond", create, remove), err
+}
+
+func planVxlanConfig(vxlanConfigList []plumberv1.VxlanConfig, templateName string) ([]string, error) {
+	var vxlans []string
+	for _, vxlanConfig := range vxlanConfigList {
+		vxlans = append(vxlans, vxlanConfig.Name)
+	}
+	create, remove, err := linkutils.PlanManagedVxlans(templateName, vxlans)
+	return linkChanges("VXLAN", create, remove), err
+}
+
+func planBridgeConfig(bridgeConfigList []plumberv1.BridgeConfig, templateName string) ([]string, error) {
+	var bridges []string
+	for _, bridgeConfig := range bridgeConfigList {
+		bridges = append(bridges, bridgeConfig.Name)
+	}
+	create, remove, err := linkutils.PlanManagedBridges(templateName, bridges)
+	return linkChanges("bridge", create, remove), err
+}
+
+// linkChanges returns the changes for the interfaces of a kind created and
+// deleted
+func linkChanges(kind string, create, remove []string) []string {
+	var changes []string
+	for _, name := range create {
+		changes = append(changes, fmt.Sprintf("create %s %s", kind, name))
+	}
+	for _, name := range remove {
+		changes = append(changes, fmt.Sprintf("delete %s %s", kind, name))
+	}
+	return changes
+}
+
+func planVlanConfig(ifConfigList []plumberv1.InterfaceConfig, templateName string) ([]string, error) {
+	var changes []string
+	for _, ifConfig := range ifConfigList {
+		ifName := *ifConfig.Name
+		var vlanIfs []string
+		for _, vlanIf := range ifConfig.Vlan {
+			vlanIfs = append(vlanIfs, vlanIfName(ifName, vlanIf))
+		}
+		create, remove, err := linkutils.PlanManagedVlans(templateName, ifName, vlanIfs)
+		if err != nil {
+			return changes, err
+		}
+		for _, vlan := range create {
+			changes = append(changes, fmt.Sprintf("create VLAN %s on %s", vlan, ifName))
+		}
+		for _, vlan := range remove {
+			changes = append(changes, fmt.Sprintf("delete VLAN %s of %s", vlan, ifName))
+		}
+		for _, vlanIf := range ifConfig.Vlan {
+			addrChanges, err := planAddrs(vlanIfName(ifName, vlanIf), vlanIf.IPv4, vlanIf.IPv6)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, addrChanges...)
+		}
+	}
+
+	managed, err := linkutils.GetManagedVlans(templateName)
+	if err != nil {
+		return changes, err
+	}
+	for _, ifName := range unlistedInterfaces(ifConfigList, managed) {
+		for _, vlan := range managed[ifName] {
+			changes = append(changes, fmt.Sprintf("delete VLAN %s of %s", vlan, ifName))
+		}
+	}
+	return changes, nil
+}
+
+func planInterfaceConfig(ifConfigList []plumberv1.InterfaceConfig) ([]string, error) {
+	var changes []string
+	for _, ifConfig := range ifConfigList {
+		ifName := *ifConfig.Name
+		if ifConfig.MTU != nil && *ifConfig.MTU >= 576 {
+			if iface, err := net.InterfaceByName(ifName); err != nil {
+				changes = append(changes, fmt.Sprintf("set MTU %d on %s, which does not exist yet", *ifConfig.MTU, ifName))
+			} else if iface.MTU != *ifConfig.MTU {
+				changes = append(changes, fmt.Sprintf("set MTU of %s from %d to %d", ifName, iface.MTU, *ifConfig.MTU))
+			}
+		}
+
+		addrChanges, err := planAddrs(ifName, ifConfig.IPv4, ifConfig.IPv6)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, addrChanges...)
+	}
+	return changes, nil
+}
+
+// planAddrs returns the changes configureAddrs would make to the addresses
+// of an interface, all of them adds when it does not exist yet
+func planAddrs(ifName string, v4Config *plumberv1.IPv4Info, v6Config *plumberv1.IPv6Info) ([]string, error) {
+	var changes []string
+	if _, err := net.InterfaceByName(ifName); err != nil {
+		if v4Config != nil {
+			changes = append(changes, ipChanges(ifName, nil, v4Config.Address)...)
+		}
+		if v6Config != nil {
+			if v6Config.Autoconf != nil {
+				changes = append(changes, fmt.Sprintf("set IPv6 autoconf of %s to %t", ifName, *v6Config.Autoconf))
+			}
+			changes = append(changes, ipChanges(ifName, nil, v6Config.Address)...)
+		}
+		return changes, nil
+	}
+
+	if v4Config != nil && len(v4Config.Address) > 0 {
+		current, err := iputils.GetIpv4Cidr(ifName)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, ipChanges(ifName, *current, v4Config.Address)...)
+	}
+	if v6Config != nil {
+		if v6Config.Autoconf != nil {
+			autoconf, err := iputils.GetIpv6Autoconf(ifName)
+			if err != nil {
+				return changes, err
+			}
+			if autoconf != *v6Config.Autoconf {
+				changes = append(changes, fmt.Sprintf("set IPv6 autoconf of %s from %t to %t", ifName, autoconf, *v6Config.Autoconf))
+			}
+		}
+		if len(v6Config.Address) > 0 {
+			// Link-local and SLAAC addresses stay
+			current, err := iputils.GetIpv6Addrs(ifName)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, ipChanges(ifName, current.Static, v6Config.Address)...)
+		}
+	}
+	return changes, nil
+}
+
+// ipChanges returns the addresses configureAddrs would remove from and add to
+// an interface. It replaces them all, but the ones in both lists stay.
+func ipChanges(ifName string, current, desired []string) []string {
+	want := make(map[string]bool)
+	for _, addr := range desired {
+		want[normalizeCidr(addr)] = true
+	}
+	have := make(map[string]bool)
+	var changes []string
+	for _, addr := range current {
+		have[normalizeCidr(addr)] = true
+		if !want[normalizeCidr(addr)] {
+			changes = append(changes, fmt.Sprintf("remove IP %s from %s", addr, ifName))
+		}
+	}
+	for _, addr := range desired {
+		if !have[normalizeCidr(addr)] {
+			changes = append(changes, fmt.Sprintf("add IP %s to %s", addr, ifName))
+		}
+	}
+	return changes
+}
+
+// normalizeCidr returns an address as netlink lists it, e.g. 2001:db8::1/64
+// for 2001:0db8::0001/64
+func normalizeCidr(addr string) string {
+	ip, ipNet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return addr
+	}
+	return (&net.IPNet{IP: ip, Mask: ipNet.Mask}).String()
+}
+
+func planOvsConfig(ovsConfigList []*plumberv1.OvsConfig, templateName string) ([]string, error) {
+	var changes []string
+	var managed []ovsutils.ManagedBridge
+	addManaged := func(bridgeName string, portNames ...string) {
+		for i := range managed {
+			if managed[i].Name == bridgeName {
+				managed[i].Ports = append(managed[i].Ports, portNames...)
+				return
+			}
+		}
+		managed = append(managed, ovsutils.ManagedBridge{Name: bridgeName, Ports: portNames})
+	}
+
+	for _, ovsConfig := range ovsConfigList {
+		bridgeName := ovsConfig.BridgeName
+		exists, err := ovsutils.BridgeExists(bridgeName)
+		if err != nil {
+			return changes, err
+		}
+		if !exists {
+			changes = append(changes, fmt.Sprintf("create OVS bridge %s", bridgeName))
+		}
+		addManaged(bridgeName)
+
+		if ovsConfig.NodeInterface != "" {
+			portName := ovsPortName(ovsConfig)
+			portChanges, err := planOvsPort(bridgeName, portName)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, portChanges...)
+			if !ovsConfig.Dpdk {
+				for _, ifName := range strings.Split(ovsConfig.NodeInterface, ",") {
+					oldPort, oldBridge, err := ovsutils.InterfacePort(ifName)
+					if err != nil {
+						return changes, err
+					}
+					if oldPort != "" && oldPort != portName {
+						changes = append(changes, fmt.Sprintf("move interface %s from port %s of OVS bridge %s to port %s of OVS bridge %s",
+							ifName, oldPort, oldBridge, portName, bridgeName))
+					}
+					if oldPort != portName {
+						migrationChanges, err := planMigration(ifName, portName, bridgeName)
+						if err != nil {
+							return changes, err
+						}
+						changes = append(changes, migrationChanges...)
+					}
+				}
+			}
+			addManaged(bridgeName, portName)
+		}
+
+		for _, repConfig := range ovsConfig.VfRepresentors {
+			reps, err := vfRepresentors(repConfig)
+			if err != nil {
+				return changes, err
+			}
+			for _, rep := range reps {
+				portChanges, err := planOvsPort(bridgeName, rep.name)
+				if err != nil {
+					return changes, err
+				}
+				changes = append(changes, portChanges...)
+				addManaged(bridgeName, rep.name)
+			}
+		}
+	}
+
+	stale, err := ovsutils.PlanManagedBridges(templateName, managed)
+	if err != nil {
+		return changes, err
+	}
+	return append(changes, stale...), nil
+}
+
+// planMigration returns the addresses and routes moveAddrsToBridge would move
+// from an interface to the bridge it is added to
+func planMigration(ifName, portName, bridgeName string) ([]string, error) {
+	if _, err := net.InterfaceByName(ifName); err != nil {
+		return nil, nil
+	}
+	m, err := iputils.MigrationOf(ifName, portName)
+	if err != nil {
+		return nil, err
+	}
+	var changes []string
+	for _, addr := range append(append([]string{}, m.IPv4...), m.IPv6...) {
+		changes = append(changes, fmt.Sprintf("move IP %s from %s to OVS bridge %s", addr, ifName, bridgeName))
+	}
+	if m.Autoconf {
+		changes = append(changes, fmt.Sprintf("move IPv6 autoconf from %s to OVS bridge %s", ifName, bridgeName))
+	}
+	for _, route := range m.Routes {
+		changes = append(changes, fmt.Sprintf("move route %s to OVS bridge %s", route, bridgeName))
+	}
+	return changes, nil
+}
+
+// planOvsPort returns whether a port is added to the bridge or moved there
+// from another one
+func planOvsPort(bridgeName, portName string) ([]string, error) {
+	port, oldBridge, err := ovsutils.GetPort(portName)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case port == nil:
+		return []string{fmt.Sprintf("add port %s to OVS bridge %s", portName, bridgeName)}, nil
+	case oldBridge != bridgeName:
+		return []string{fmt.Sprintf("move port %s from OVS bridge %s to %s", portName, oldBridge, bridgeName)}, nil
+	}
+	return nil, nil
+}
+
+// updateNodePlan records the plan of this node in the template status. The
+// planned time is kept while the plan does not change.
+func (r *HostNetworkTemplateReconciler) updateNodePlan(ctx context.Context, key types.NamespacedName, plan plumberv1.NodePlan) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		hostConfigReq := &plumberv1.HostNetworkTemplate{}
+		if err := r.Get(ctx, key, hostConfigReq); err != nil {
+			return err
+		}
+
+		nodeList := &corev1.NodeList{}
+		if err := r.List(ctx, nodeList, client.MatchingLabels(hostConfigReq.Spec.NodeSelector)); err != nil {
+			return err
+		}
+
+		newStatus := hostConfigReq.Status.DeepCopy()
+		setNodePlan(newStatus, r.NodeName, &plan)
+		summarizeTemplateStatus(newStatus, hostConfigReq.Generation, nodeList.Items)
+
+		if equality.Semantic.DeepEqual(&hostConfigReq.Status, newStatus) {
+			return nil
+		}
+		hostConfigReq.Status = *newStatus
+		return r.Status().Update(ctx, hostConfigReq)
+	})
+}
+
+// setNodePlan replaces the plan of nodeName, a nil plan removes it
+func setNodePlan(status *plumberv1.HostNetworkTemplateStatus, nodeName string, plan *plumberv1.NodePlan) {
+	var plans []plumberv1.NodePlan
+	var old *plumberv1.NodePlan
+	for i := range status.Plans {
+		if status.Plans[i].NodeName == nodeName {
+			old = &status.Plans[i]
+			continue
+		}
+		plans = append(plans, status.Plans[i])
+	}
+
+	if plan != nil {
+		newPlan := *plan
+		newPlan.PlannedTime = metav1.Now()
+		if old != nil && old.Generation == newPlan.Generation && old.Error == newPlan.Error &&
+			equality.Semantic.DeepEqual(old.Changes, newPlan.Changes) {
+			newPlan.PlannedTime = old.PlannedTime
+		}
+		plans = append(plans, newPlan)
+	}
+
+	sort.Slice(plans, func(i, j int) bool { return plans[i].NodeName < plans[j].NodeName })
+	status.Plans = plans
+}
+
+// planChangedPredicate passes updates setting, changing or removing the plan
+// annotation, which do not change the generation
+func planChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldPlan, oldOk := e.ObjectOld.GetAnnotations()[plumberv1.PlanAnnotation]
+			newPlan, newOk := e.ObjectNew.GetAnnotations()[plumberv1.PlanAnnotation]
+			return oldOk != newOk || oldPlan != newPlan
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	plumberv1 "hostplumber/api/v1"
+)
+
+func TestIpChanges(t *testing.T) {
+	current := []string{"10.0.0.5/24", "2001:db8::1/64", "10.0.1.5/24"}
+	desired := []string{"10.0.0.5/24", "2001:0db8::0001/64", "10.0.2.5/24"}
+	want := []string{
+		"remove IP 10.0.1.5/24 from eth1",
+		"add IP 10.0.2.5/24 to eth1",
+	}
+	if got := ipChanges("eth1", current, desired); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := ipChanges("eth1", desired, desired); len(got) != 0 {
+		t.Errorf("no change expected, got %q", got)
+	}
+}
+
+func TestSetNodePlan(t *testing.T) {
+	planned := metav1.NewTime(metav1.Now().Add(-3600e9))
+	status := &plumberv1.HostNetworkTemplateStatus{Plans: []plumberv1.NodePlan{
+		{NodeName: "node2", Generation: 1, Changes: []string{"create VLAN eth1.100 on eth1"}, PlannedTime: planned},
+	}}
+
+	setNodePlan(status, "node1", &plumberv1.NodePlan{NodeName: "node1", Generation: 1})
+	setNodePlan(status, "node2", &plumberv1.NodePlan{NodeName: "node2", Generation: 1, Changes: []string{"create VLAN eth1.100 on eth1"}})
+	if len(status.Plans) != 2 || status.Plans[0].NodeName != "node1" {
+		t.Fatalf("plans not sorted by node: %+v", status.Plans)
+	}
+	if !status.Plans[1].PlannedTime.Equal(&planned) {
+		t.Errorf("planned time of an unchanged plan updated")
+	}
+
+	setNodePlan(status, "node2", &plumberv1.NodePlan{NodeName: "node2", Generation: 2})
+	if status.Plans[1].PlannedTime.Equal(&planned) {
+		t.Errorf("planned time of a changed plan kept")
+	}
+
+	setNodePlan(status, "node1", nil)
+	if len(status.Plans) != 1 || status.Plans[0].NodeName != "node2" {
+		t.Errorf("plan of node1 not removed: %+v", status.Plans)
+	}
+
+	nodes := []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}}
+	summarizeTemplateStatus(status, 2, nodes)
+	if len(status.Plans) != 0 {
+		t.Errorf("plan of a node no longer matching kept: %+v", status.Plans)
+	}
+}
+
+func TestPlanAddrsMissingInterface(t *testing.T) {
+	autoconf := false
+	got, err := planAddrs("hp-missing0.100",
+		&plumberv1.IPv4Info{Address: []string{"10.0.0.5/24"}},
+		&plumberv1.IPv6Info{Address: []string{"2001:db8::5/64"}, Autoconf: &autoconf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"add IP 10.0.0.5/24 to hp-missing0.100",
+		"set IPv6 autoconf of hp-missing0.100 to false",
+		"add IP 2001:db8::5/64 to hp-missing0.100",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
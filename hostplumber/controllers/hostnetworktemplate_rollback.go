@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	plumberv1 "hostplumber/api/v1"
+	"hostplumber/pkg/utils/snapshot"
+	sysctlutils "hostplumber/pkg/utils/sysctl"
+)
+
+const (
+	defaultConnectivityTimeout = 60 * time.Second
+	// connectivityProbeTimeout bounds each API server request and ping
+	connectivityProbeTimeout = 5 * time.Second
+	connectivityInterval     = 2 * time.Second
+)
+
+// reasonRolledBack is the reason of the ConnectivityVerified condition once
+// the change was rolled back
+const reasonRolledBack = "RolledBack"
+
+// rolledBack returns whether this node rolled back the current generation of
+// the template, which is then not applied again
+func (r *HostNetworkTemplateReconciler) rolledBack(hostConfigReq *plumberv1.HostNetworkTemplate) bool {
+	for _, nodeStatus := range hostConfigReq.Status.Nodes {
+		if nodeStatus.NodeName == r.NodeName {
+			return nodeStatus.RolledBackGeneration == hostConfigReq.Generation
+		}
+	}
+	return false
+}
+
+// takeSnapshot snapshots the host network before the template is applied,
+// when the template has a connectivity check. It returns nil when there is
+// nothing to check, or the node has no connectivity to lose to begin with.
+// The sysctls taken are the ones the template sets and the ones it set before.
+func (r *HostNetworkTemplateReconciler) takeSnapshot(ctx context.Context, hostConfigReq *plumberv1.HostNetworkTemplate) (*snapshot.Snapshot, error) {
+	check := hostConfigReq.Spec.ConnectivityCheck
+	if check == nil {
+		return nil, nil
+	}
+	if err := r.checkConnectivity(ctx, check); err != nil {
+		log.Info("No connectivity before applying, not checking it after", "err", err.Error())
+		return nil, nil
+	}
+	managed, err := sysctlutils.GetManaged(hostConfigReq.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the sysctls of the template: %w", err)
+	}
+	var sysctls []string
+	for key := range hostConfigReq.Spec.SysctlConfig {
+		sysctls = append(sysctls, key)
+	}
+	for key := range managed {
+		if _, ok := hostConfigReq.Spec.SysctlConfig[key]; !ok {
+			sysctls = append(sysctls, key)
+		}
+	}
+	snap, err := snapshot.Take(sysctls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot the host network: %w", err)
+	}
+	return snap, nil
+}
+
+// confirmConnectivity waits for the node to reach the API server and the ping
+// targets, and restores the snapshot if it does not within the timeout. The
+// outcome is recorded in the ConnectivityVerified condition of nodeStatus,
+// which is True without a snapshot to restore.
+func (r *HostNetworkTemplateReconciler) confirmConnectivity(ctx context.Context, hostConfigReq *plumberv1.HostNetworkTemplate,
+	snap *snapshot.Snapshot, nodeStatus *plumberv1.NodeApplyStatus) error {
+	check := hostConfigReq.Spec.ConnectivityCheck
+	condition := metav1.Condition{
+		Type:               plumberv1.ConditionConnectivityVerified,
+		ObservedGeneration: hostConfigReq.Generation,
+	}
+	if snap == nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "NotConfigured"
+		if check != nil {
+			condition.Reason = "NotChecked"
+			condition.Message = "no connectivity before applying"
+		}
+		nodeStatus.Conditions = append(nodeStatus.Conditions, condition)
+		return nil
+	}
+	timeout := defaultConnectivityTimeout
+	if check.TimeoutSeconds != nil {
+		timeout = time.Duration(*check.TimeoutSeconds) * time.Second
+	}
+
+	checkErr := r.waitForConnectivity(ctx, check, timeout)
+	if checkErr == nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Verified"
+		nodeStatus.Conditions = append(nodeStatus.Conditions, condition)
+		return nil
+	}
+
+	log.Error(checkErr, "Connectivity lost after applying, restoring the host network", "timeout", timeout)
+	reason := checkErr.Error()
+	if err := snap.Restore(); err != nil {
+		log.Error(err, "Failed to restore the host network")
+		reason = fmt.Sprintf("%s, restoring the host network failed: %v", reason, err)
+	} else if err := r.waitForConnectivity(ctx, check, timeout); err != nil {
+		reason = fmt.Sprintf("%s, still no connectivity after the rollback: %v", reason, err)
+	} else {
+		log.Info("Connectivity back after the rollback")
+	}
+
+	now := metav1.Now()
+	nodeStatus.Applied = false
+	nodeStatus.RolledBackGeneration = hostConfigReq.Generation
+	nodeStatus.RollbackTime = &now
+	nodeStatus.RollbackReason = reason
+	condition.Status = metav1.ConditionFalse
+	condition.Reason = reasonRolledBack
+	condition.Message = reason
+	nodeStatus.Conditions = append(nodeStatus.Conditions, condition)
+	err := fmt.Errorf("rolled back: %s", reason)
+	nodeStatus.LastError = fmt.Sprintf("%s: %s", plumberv1.ConditionConnectivityVerified, err)
+	return err
+}
+
+// waitForConnectivity checks connectivity until it succeeds or the timeout
+// expires, returning the last failure
+func (r *HostNetworkTemplateReconciler) waitForConnectivity(ctx context.Context, check *plumberv1.ConnectivityCheckConfig, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := r.checkConnectivity(ctx, check)
+		if err == nil || time.Now().Add(connectivityInterval).After(deadline) {
+			return err
+		}
+		time.Sleep(connectivityInterval)
+	}
+}
+
+// checkConnectivity reads the Node from the API server, bypassing the cache,
+// and pings each target
+func (r *HostNetworkTemplateReconciler) checkConnectivity(ctx context.Context, check *plumberv1.ConnectivityCheckConfig) error {
+	probeCtx, cancel := context.WithTimeout(ctx, connectivityProbeTimeout)
+	defer cancel()
+	if err := r.APIReader.Get(probeCtx, types.NamespacedName{Name: r.NodeName}, &corev1.Node{}); err != nil {
+		return fmt.Errorf("API server unreachable: %w", err)
+	}
+	for _, target := range check.PingTargets {
+		if err := ping(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ping(target string) error {
+	seconds := fmt.Sprint(int(connectivityProbeTimeout.Seconds()))
+	out, err := exec.Command("ping", "-c", "1", "-W", seconds, target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ping %s failed: %s: %w", target, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	plumberv1 "hostplumber/api/v1"
+)
+
+func TestRolledBack(t *testing.T) {
+	r := &HostNetworkTemplateReconciler{NodeName: "node1"}
+	tmpl := &plumberv1.HostNetworkTemplate{ObjectMeta: metav1.ObjectMeta{Generation: 3}}
+	tmpl.Status.Nodes = []plumberv1.NodeApplyStatus{
+		{NodeName: "node1", ObservedGeneration: 3, RolledBackGeneration: 3},
+		{NodeName: "node2", ObservedGeneration: 3},
+	}
+	if !r.rolledBack(tmpl) {
+		t.Errorf("generation 3 was rolled back on node1")
+	}
+	tmpl.Generation = 4
+	if r.rolledBack(tmpl) {
+		t.Errorf("generation 4 was not rolled back on node1")
+	}
+	r.NodeName = "node2"
+	tmpl.Generation = 3
+	if r.rolledBack(tmpl) {
+		t.Errorf("generation 3 was not rolled back on node2")
+	}
+}
+
+func TestConfirmConnectivityWithoutSnapshot(t *testing.T) {
+	r := &HostNetworkTemplateReconciler{NodeName: "node1"}
+	tmpl := &plumberv1.HostNetworkTemplate{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+	tmpl.Spec.ConnectivityCheck = &plumberv1.ConnectivityCheckConfig{}
+	nodeStatus := &plumberv1.NodeApplyStatus{NodeName: "node1", Applied: true}
+	if err := r.confirmConnectivity(context.TODO(), tmpl, nil, nodeStatus); err != nil {
+		t.Fatal(err)
+	}
+	if !nodeStatus.Applied || len(nodeStatus.Conditions) != 1 {
+		t.Fatalf("unexpected status %+v", nodeStatus)
+	}
+	if condition := nodeStatus.Conditions[0]; condition.Status != metav1.ConditionTrue || condition.Reason != "NotChecked" {
+		t.Errorf("unexpected condition %+v", condition)
+	}
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	plumberv1 "hostplumber/api/v1"
+)
+
+// updateNodeStatus records the apply result of this node in the template
+// status, drops its plan and refreshes the summary. A nil nodeStatus removes
+// this node's entry.
+// Every node's agent writes to the same object, so conflicts are retried.
+func (r *HostNetworkTemplateReconciler) updateNodeStatus(ctx context.Context, key types.NamespacedName, nodeStatus *plumberv1.NodeApplyStatus) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		hostConfigReq := &plumberv1.HostNetworkTemplate{}
+		if err := r.Get(ctx, key, hostConfigReq); err != nil {
+			return err
+		}
+
+		nodeList := &corev1.NodeList{}
+		if err := r.List(ctx, nodeList, client.MatchingLabels(hostConfigReq.Spec.NodeSelector)); err != nil {
+			return err
+		}
+
+		newStatus := hostConfigReq.Status.DeepCopy()
+		setNodeApplyStatus(newStatus, r.NodeName, nodeStatus)
+		setNodePlan(newStatus, r.NodeName, nil)
+		summarizeTemplateStatus(newStatus, hostConfigReq.Generation, nodeList.Items)
+
+		if equality.Semantic.DeepEqual(&hostConfigReq.Status, newStatus) {
+			return nil
+		}
+		hostConfigReq.Status = *newStatus
+		return r.Status().Update(ctx, hostConfigReq)
+	})
+}
+
+// setNodeApplyStatus replaces the entry of nodeName, keeping the transition
+// times of whatever did not change
+func setNodeApplyStatus(status *plumberv1.HostNetworkTemplateStatus, nodeName string, nodeStatus *plumberv1.NodeApplyStatus) {
+	var nodes []plumberv1.NodeApplyStatus
+	var old *plumberv1.NodeApplyStatus
+	for i := range status.Nodes {
+		if status.Nodes[i].NodeName == nodeName {
+			old = &status.Nodes[i]
+			continue
+		}
+		nodes = append(nodes, status.Nodes[i])
+	}
+
+	if nodeStatus != nil {
+		newNode := *nodeStatus
+		newNode.LastTransitionTime = metav1.Now()
+		newNode.Conditions = nil
+		if old != nil {
+			newNode.Conditions = old.Conditions
+			if old.Applied == newNode.Applied && old.LastError == newNode.LastError &&
+				old.ObservedGeneration == newNode.ObservedGeneration {
+				newNode.LastTransitionTime = old.LastTransitionTime
+			}
+		}
+		for _, condition := range nodeStatus.Conditions {
+			meta.SetStatusCondition(&newNode.Conditions, condition)
+		}
+		nodes = append(nodes, newNode)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeName < nodes[j].NodeName })
+	status.Nodes = nodes
+}
+
+// summarizeTemplateStatus drops entries and plans of nodes that no longer
+// match the template and computes the counts, failed nodes, summary and Applied condition
+func summarizeTemplateStatus(status *plumberv1.HostNetworkTemplateStatus, generation int64, matchingNodes []corev1.Node) {
+	matching := make(map[string]bool)
+	for _, node := range matchingNodes {
+		matching[node.Name] = true
+	}
+
+	var nodes []plumberv1.NodeApplyStatus
+	var failed []string
+	applied := 0
+	for _, nodeStatus := range status.Nodes {
+		if !matching[nodeStatus.NodeName] {
+			continue
+		}
+		nodes = append(nodes, nodeStatus)
+		if waitingForDisruption(nodeStatus) {
+			// Neither failed nor applied yet
+			continue
+		}
+		if !nodeStatus.Applied {
+			failed = append(failed, nodeStatus.NodeName)
+		} else if nodeStatus.ObservedGeneration == generation {
+			applied++
+		}
+	}
+
+	status.Nodes = nodes
+	var plans []plumberv1.NodePlan
+	for _, plan := range status.Plans {
+		if matching[plan.NodeName] {
+			plans = append(plans, plan)
+		}
+	}
+	status.Plans = plans
+	status.FailedNodes = failed
+	status.MatchingNodes = len(matching)
+	status.AppliedNodes = applied
+	status.Summary = fmt.Sprintf("Applied %d/%d nodes", applied, len(matching))
+
+	condition := metav1.Condition{
+		Type:               plumberv1.ConditionApplied,
+		ObservedGeneration: generation,
+	}
+	switch {
+	case len(failed) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NodesFailed"
+		condition.Message = "failed on nodes: " + strings.Join(failed, ", ")
+	case applied < len(matching):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Progressing"
+		condition.Message = fmt.Sprintf("waiting for %d nodes", len(matching)-applied)
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "AllNodesApplied"
+		condition.Message = status.Summary
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// deletionStartedPredicate passes the update that sets the deletion
+// timestamp, so the finalizer runs even if the generation is unchanged
+func deletionStartedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectOld.GetDeletionTimestamp().IsZero() && !e.ObjectNew.GetDeletionTimestamp().IsZero()
+		},
+	}
+}
@@ -36,6 +36,7 @@ import (
 	plumberv1 "hostplumber/api/v1"
 	"hostplumber/controllers"
 	hoststate "hostplumber/pkg/hoststate"
+	"hostplumber/pkg/utils/persist"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -55,11 +56,20 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var discoveryInterval time.Duration
+	var discoveryDebounce time.Duration
+	var persistenceBackend string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&discoveryInterval, "discovery-interval", hoststate.DefaultResyncPeriod,
+		"Interval of the periodic full host state rediscovery, 0 disables it. Can be set with DISCOVERY_INTERVAL.")
+	flag.DurationVar(&discoveryDebounce, "discovery-debounce", hoststate.DefaultDebounce,
+		"How long netlink link, address and route events must stop before the host state is rediscovered. Can be set with DISCOVERY_DEBOUNCE.")
+	flag.StringVar(&persistenceBackend, "persistence-backend", "auto",
+		"How host network changes are persisted across reboots: auto, ifcfg, keyfile, networkd, netplan or none. Can be set with PERSISTENCE_BACKEND.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -72,6 +82,31 @@ func main() {
 		metricsAddr = os.Getenv("METRICS_BIND_ADDRESS")
 	}
 
+	var err error
+	if interval := os.Getenv("DISCOVERY_INTERVAL"); interval != "" {
+		if discoveryInterval, err = time.ParseDuration(interval); err != nil {
+			fmt.Printf("Invalid DISCOVERY_INTERVAL %q: %s", interval, err)
+			os.Exit(1)
+		}
+	}
+	if debounce := os.Getenv("DISCOVERY_DEBOUNCE"); debounce != "" {
+		if discoveryDebounce, err = time.ParseDuration(debounce); err != nil {
+			fmt.Printf("Invalid DISCOVERY_DEBOUNCE %q: %s", debounce, err)
+			os.Exit(1)
+		}
+	}
+	if discoveryDebounce <= 0 {
+		fmt.Printf("discovery-debounce must be positive")
+		os.Exit(1)
+	}
+	if backend := os.Getenv("PERSISTENCE_BACKEND"); backend != "" {
+		persistenceBackend = backend
+	}
+	if err := persist.SetBackend(persistenceBackend); err != nil {
+		fmt.Printf("Invalid persistence backend: %s", err)
+		os.Exit(1)
+	}
+
 	nodeName := os.Getenv("K8S_NODE_NAME")
 	if nodeName == "" {
 		fmt.Printf("K8S_NODE_NAME env variable not set")
@@ -107,21 +142,12 @@ func main() {
 		os.Exit(1)
 	}
 
-	ticker := time.NewTicker(10 * time.Second)
-	done := make(chan bool)
-
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			case t := <-ticker.C:
-				setupLog.Info("Re-discovering HostNetwork...", "time", t)
-				hni := hoststate.New(nodeName, namespace, mgr.GetClient())
-				hni.DiscoverHostState()
-			}
-		}
-	}()
+	// Rediscovers the host state on netlink events and periodically, once the cache is synced
+	watcher := hoststate.NewWatcher(nodeName, namespace, mgr.GetClient(), discoveryDebounce, discoveryInterval)
+	if err := mgr.Add(watcher); err != nil {
+		setupLog.Error(err, "unable to set up host state discovery")
+		os.Exit(1)
+	}
 
 	//+kubebuilder:scaffold:builder
 
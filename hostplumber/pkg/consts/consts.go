@@ -1,9 +1,19 @@
 package consts
 
 const (
-	HostPlumberCfg     = "/etc/hostplumber/"
+	HostPlumberCfg = "/etc/hostplumber/"
+	// HostStateDir keeps the state hostplumber needs to undo its changes on
+	// the host, so it outlives the pod
+	HostStateDir       = "/host/var/lib/hostplumber/"
 	SysClassNet        = "/host/sys/class/net/"
 	SysPciDrivers      = "/host/sys/bus/pci/drivers/"
 	SysPciDevices      = "/host/sys/bus/pci/devices/"
 	RhelNetworkScripts = "/host/etc/sysconfig/network-scripts/"
+	NMConnections      = "/host/etc/NetworkManager/system-connections/"
+	NetworkdDir        = "/host/etc/systemd/network/"
+	NetplanDir         = "/host/etc/netplan/"
+	HostRun            = "/host/run/"
+	ProcSys            = "/host/proc/sys/"
+	SysctlD            = "/host/etc/sysctl.d/"
+	SriovProfiles      = "/etc/hostplumber-sriov-profiles/"
 )
@@ -7,15 +7,22 @@ import (
 	"hostplumber/pkg/consts"
 	iputils "hostplumber/pkg/utils/ip"
 	linkutils "hostplumber/pkg/utils/link"
+	ovsutils "hostplumber/pkg/utils/ovs"
 	sriovutils "hostplumber/pkg/utils/sriov"
+	sysctlutils "hostplumber/pkg/utils/sysctl"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/vishvananda/netlink"
 	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 type HostNetworkInfo struct {
@@ -47,42 +54,90 @@ func (hni *HostNetworkInfo) DiscoverHostState() {
 		// TODO: Don't return? Discover as much info as we can
 		//return
 	}
+	if err := hni.discoverRules(); err != nil {
+		hni.log.Error("Failed to discover routing rules ", zap.Error(err))
+	}
+	if err := hni.discoverSysctls(); err != nil {
+		hni.log.Error("Failed to discover sysctls ", zap.Error(err))
+	}
 	hni.discoverInterfaceStatus()
+	if err := hni.discoverBonds(); err != nil {
+		hni.log.Error("Failed to discover bonds ", zap.Error(err))
+	}
 
-	// TODO: Discover OVS related info here
-	// hni.discoverOvsInfo()
+	if err := hni.discoverOvsInfo(); err != nil {
+		// OVS may not be deployed on this node
+		hni.log.Infow("Skipping OVS discovery", "err", err)
+	}
 
-	oldHostState := &plumberv1.HostNetwork{}
-	newHostState := &plumberv1.HostNetwork{}
-	newHostState.Name = hni.nodeName
-	newHostState.Namespace = hni.namespace
-	newHostState.Status = *hni.currentStatus
+	if err := hni.publishHostState(ctx); err != nil {
+		hni.log.Error("Failed to publish HostNetwork ", zap.Error(err))
+	}
+}
 
+// publishHostState writes the discovered state to the HostNetwork of the node
+// through the status subresource, creating it owned by the Node if missing. The
+// status is only written when it changed, so watchers are not woken up for nothing.
+func (hni *HostNetworkInfo) publishHostState(ctx context.Context) error {
+	hostState := &plumberv1.HostNetwork{}
 	nsn := types.NamespacedName{Name: hni.nodeName, Namespace: hni.namespace}
-	err := hni.client.Get(ctx, nsn, oldHostState)
-	if err != nil && errors.IsNotFound(err) {
-		hni.log.Error("HostStateSpec not found... creating ", zap.Error(err))
-		if err := hni.client.Create(ctx, newHostState); err != nil {
-			hni.log.Infof("Failed to created new HostState for Node %s", hni.nodeName)
-			return
+	err := hni.client.Get(ctx, nsn, hostState)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to fetch HostNetwork: %w", err)
+	}
+	if errors.IsNotFound(err) {
+		hni.log.Infof("HostNetwork not found for Node %s, creating", hni.nodeName)
+		hostState = &plumberv1.HostNetwork{}
+		hostState.Name = hni.nodeName
+		hostState.Namespace = hni.namespace
+		if err := hni.setNodeOwner(ctx, hostState); err != nil {
+			return err
 		}
-	} else if err != nil {
-		hni.log.Error("Failed to fetch HostNetwork ", zap.Error(err))
-		if err := hni.client.Create(ctx, newHostState); err != nil {
-			hni.log.Error("Failed to create new HostNetwork ", zap.Error(err))
-			return
+		// The status of a created object is dropped, it is patched below
+		if err := hni.client.Create(ctx, hostState); err != nil {
+			return fmt.Errorf("failed to create HostNetwork: %w", err)
 		}
-		return
-	} else {
-		if err := hni.client.Delete(ctx, oldHostState); err != nil {
-			hni.log.Error("Error deleting old HostState ", zap.Error(err))
-			return
+	} else if !hni.ownedByNode(hostState) {
+		// HostNetworks created by earlier versions have no owner
+		patch := client.MergeFrom(hostState.DeepCopy())
+		if err := hni.setNodeOwner(ctx, hostState); err != nil {
+			return err
+		}
+		if err := hni.client.Patch(ctx, hostState, patch); err != nil {
+			return fmt.Errorf("failed to set HostNetwork owner: %w", err)
 		}
-		if err := hni.client.Create(ctx, newHostState); err != nil {
-			hni.log.Error("Failed to create new HostNetwork ", zap.Error(err))
-			return
+	}
+
+	if equality.Semantic.DeepEqual(hostState.Status, *hni.currentStatus) {
+		hni.log.Debugf("HostNetwork status unchanged for Node %s", hni.nodeName)
+		return nil
+	}
+	patch := client.MergeFrom(hostState.DeepCopy())
+	hostState.Status = *hni.currentStatus
+	if err := hni.client.Status().Patch(ctx, hostState, patch); err != nil {
+		return fmt.Errorf("failed to patch HostNetwork status: %w", err)
+	}
+	hni.log.Infof("Updated HostNetwork status for Node %s", hni.nodeName)
+	return nil
+}
+
+// setNodeOwner makes the Node own the HostNetwork, so it is garbage collected
+// along with the Node
+func (hni *HostNetworkInfo) setNodeOwner(ctx context.Context, hostState *plumberv1.HostNetwork) error {
+	node := &corev1.Node{}
+	if err := hni.client.Get(ctx, types.NamespacedName{Name: hni.nodeName}, node); err != nil {
+		return fmt.Errorf("failed to get Node %s: %w", hni.nodeName, err)
+	}
+	return controllerutil.SetOwnerReference(node, hostState, hni.client.Scheme())
+}
+
+func (hni *HostNetworkInfo) ownedByNode(hostState *plumberv1.HostNetwork) bool {
+	for _, ref := range hostState.OwnerReferences {
+		if ref.Kind == "Node" && ref.Name == hni.nodeName {
+			return true
 		}
 	}
+	return false
 }
 
 func (hni *HostNetworkInfo) discoverRoutingTable() error {
@@ -97,7 +152,7 @@ func (hni *HostNetworkInfo) discoverRoutingTable() error {
 		linkAttrs := link.Attrs()
 		linkName := linkAttrs.Name
 
-		v4routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+		v4routes, err := listRoutes(link, netlink.FAMILY_V4)
 		if err != nil {
 			hni.log.Warnw("No IPv4 routes for link", "interface", linkName, "err", err)
 		}
@@ -113,11 +168,14 @@ func (hni *HostNetworkInfo) discoverRoutingTable() error {
 			if v4route.Src != nil {
 				route.Src = (v4route.Src).String()
 			}
+			if v4route.Table != unix.RT_TABLE_MAIN {
+				route.Table = v4route.Table
+			}
 			route.Dev = linkName
 			currV4Routes = append(currV4Routes, route)
 		}
 
-		v6routes, err := netlink.RouteList(link, netlink.FAMILY_V6)
+		v6routes, err := listRoutes(link, netlink.FAMILY_V6)
 		if err != nil {
 			hni.log.Warnw("No IPv6 routes for link", "interface", linkName, "err", err)
 		}
@@ -133,6 +191,9 @@ func (hni *HostNetworkInfo) discoverRoutingTable() error {
 			if v6route.Src != nil {
 				route.Src = (v6route.Src).String()
 			}
+			if v6route.Table != unix.RT_TABLE_MAIN {
+				route.Table = v6route.Table
+			}
 			route.Dev = linkName
 			currV6Routes = append(currV6Routes, route)
 		}
@@ -149,6 +210,56 @@ func (hni *HostNetworkInfo) discoverRoutingTable() error {
 	return nil
 }
 
+// listRoutes returns the routes of the link in every table but the local one,
+// which only holds the routes to the host's own addresses
+func listRoutes(link netlink.Link, family int) ([]netlink.Route, error) {
+	routes, err := netlink.RouteListFiltered(family, &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Table:     unix.RT_TABLE_UNSPEC,
+	}, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []netlink.Route
+	for _, route := range routes {
+		if route.Table != unix.RT_TABLE_LOCAL {
+			filtered = append(filtered, route)
+		}
+	}
+	return filtered, nil
+}
+
+// discoverSysctls reports the current value of the sysctls set by templates
+func (hni *HostNetworkInfo) discoverSysctls() error {
+	sysctls, err := sysctlutils.Effective()
+	if err != nil {
+		return err
+	}
+	hni.currentStatus.Sysctl = sysctls
+	return nil
+}
+
+func (hni *HostNetworkInfo) discoverRules() error {
+	rules, err := iputils.ListRules()
+	if err != nil {
+		return err
+	}
+	var currRules []*plumberv1.Rule
+	for _, rule := range rules {
+		currRules = append(currRules, &plumberv1.Rule{
+			Priority: rule.Priority,
+			From:     rule.From,
+			To:       rule.To,
+			Iif:      rule.Iif,
+			Oif:      rule.Oif,
+			Fwmark:   rule.Fwmark,
+			Table:    rule.Table,
+		})
+	}
+	hni.currentStatus.Rules = currRules
+	return nil
+}
+
 func (hni *HostNetworkInfo) addNetPciDevice(devicePath, ifName string) error {
 	var ifStatus *plumberv1.InterfaceStatus = new(plumberv1.InterfaceStatus)
 
@@ -209,12 +320,16 @@ func (hni *HostNetworkInfo) addNetPciDevice(devicePath, ifName string) error {
 		ifStatus.IPv4.Address = *ipv4Addrs
 	}
 
-	ipv6Addrs, err := iputils.GetIpv6Cidr(ifName)
-	if err != nil || len(*ipv6Addrs) == 0 {
-		hni.log.Infow("Error getting IPv6 for interface", "err", err, "ifName", ifName, "ipv6Addrs", *ipv6Addrs)
+	if ipv6Info, err := getIPv6Info(ifName); err != nil {
+		hni.log.Infow("Error getting IPv6 for interface", "err", err, "ifName", ifName)
 	} else {
-		ifStatus.IPv6 = new(plumberv1.IPv6Info)
-		ifStatus.IPv6.Address = *ipv6Addrs
+		ifStatus.IPv6 = ipv6Info
+	}
+
+	if link, err := netlink.LinkByName(ifName); err == nil && link.Attrs().MasterIndex != 0 {
+		if master, err := netlink.LinkByIndex(link.Attrs().MasterIndex); err == nil {
+			ifStatus.Master = master.Attrs().Name
+		}
 	}
 
 	var totalVfs int
@@ -228,6 +343,28 @@ func (hni *HostNetworkInfo) addNetPciDevice(devicePath, ifName string) error {
 			hni.log.Infof("Failed to retrieve VF details: %s", err)
 			// Ignore error, don't populate VF info
 		}
+		if mode, err := sriovutils.GetEswitchMode(ifName); err != nil {
+			hni.log.Infow("Failed to get eswitch mode", "ifName", ifName, "err", err)
+		} else if mode != "" {
+			ifStatus.EswitchMode = mode
+			if ifStatus.HwTcOffload, err = sriovutils.GetHwTcOffload(ifName); err != nil {
+				hni.log.Infow("Failed to get hw-tc-offload", "ifName", ifName, "err", err)
+			}
+			if mode == sriovutils.EswitchModeSwitchdev {
+				hni.addVfRepresentors(ifStatus.SriovStatus, ifName)
+			}
+		}
+		groups, err := sriovutils.GetVfGroups(ifName)
+		if err != nil {
+			hni.log.Infof("Failed to read VF groups of %s: %s", ifName, err)
+		}
+		for _, group := range groups {
+			ifStatus.SriovStatus.VfGroups = append(ifStatus.SriovStatus.VfGroups, &plumberv1.VfGroupStatus{
+				VfRange:      group.VfRange,
+				VfDriver:     group.VfDriver,
+				ResourceName: group.ResourceName,
+			})
+		}
 	} else {
 		hni.log.Infof("SRIOV disabled for %s", ifName)
 		ifStatus.SriovEnabled = false
@@ -259,6 +396,10 @@ func (hni *HostNetworkInfo) discoverInterfaceStatus() error {
 			// VF info populated later, skip
 			return nil
 		}
+		if sriovutils.IsVfRepresentor(ifName) {
+			// Reported with the VFs of its PF
+			return nil
+		}
 
 		hni.log.Infow("Adding physical interface", "ifName", ifName, "ifPath", ifPath)
 		if err := hni.addNetPciDevice(devicePath, ifName); err != nil {
@@ -274,6 +415,82 @@ func (hni *HostNetworkInfo) discoverInterfaceStatus() error {
 	return nil
 }
 
+// discoverBonds adds an InterfaceStatus for each Linux bond, with the state of its members
+func (hni *HostNetworkInfo) discoverBonds() error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+	names := make(map[int]string)
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+
+	for _, link := range links {
+		bond, ok := link.(*netlink.Bond)
+		if !ok {
+			continue
+		}
+		attrs := bond.Attrs()
+		ifStatus := &plumberv1.InterfaceStatus{
+			PfName:  attrs.Name,
+			MacAddr: attrs.HardwareAddr.String(),
+			MTU:     attrs.MTU,
+			BondStatus: &plumberv1.BondStatus{
+				Mode:   bond.Mode.String(),
+				Miimon: bond.Miimon,
+			},
+		}
+		if bond.Mode == netlink.BOND_MODE_802_3AD {
+			ifStatus.BondStatus.LacpRate = bond.LacpRate.String()
+		}
+		if bond.Mode == netlink.BOND_MODE_802_3AD || bond.Mode == netlink.BOND_MODE_BALANCE_XOR {
+			ifStatus.BondStatus.XmitHashPolicy = bond.XmitHashPolicy.String()
+		}
+		if bond.ActiveSlave > 0 {
+			ifStatus.BondStatus.ActiveMember = names[bond.ActiveSlave]
+		}
+		for _, member := range links {
+			if member.Attrs().MasterIndex != attrs.Index {
+				continue
+			}
+			memberStatus := &plumberv1.BondMemberStatus{Name: member.Attrs().Name}
+			if slave, ok := member.Attrs().Slave.(*netlink.BondSlave); ok {
+				memberStatus.MiiStatus = slave.MiiStatus.String()
+				memberStatus.State = slave.State.String()
+				memberStatus.LinkFailureCount = int(slave.LinkFailureCount)
+			}
+			ifStatus.BondStatus.Members = append(ifStatus.BondStatus.Members, memberStatus)
+		}
+
+		if ipv4Addrs, err := iputils.GetIpv4Cidr(attrs.Name); err == nil && len(*ipv4Addrs) > 0 {
+			ifStatus.IPv4 = &plumberv1.IPv4Info{Address: *ipv4Addrs}
+		}
+		if ipv6Info, err := getIPv6Info(attrs.Name); err == nil {
+			ifStatus.IPv6 = ipv6Info
+		}
+		hni.currentStatus.InterfaceStatus = append(hni.currentStatus.InterfaceStatus, ifStatus)
+	}
+	return nil
+}
+
+// getIPv6Info returns the global and link-local IPv6 addresses of an
+// interface and whether SLAAC is on, nil when it has no IPv6 address
+func getIPv6Info(ifName string) (*plumberv1.IPv6Info, error) {
+	addrs, err := iputils.GetIpv6Addrs(ifName)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs.Global()) == 0 && len(addrs.LinkLocal) == 0 {
+		return nil, nil
+	}
+	info := &plumberv1.IPv6Info{Address: addrs.Global(), LinkLocal: addrs.LinkLocal}
+	if autoconf, err := iputils.GetIpv6Autoconf(ifName); err == nil {
+		info.Autoconf = &autoconf
+	}
+	return info, nil
+}
+
 func (hni *HostNetworkInfo) populateVfInfo(info *plumberv1.SriovStatus, devicePath, pfName string) error {
 	linkInfo, err := netlink.LinkByName(pfName)
 	if err != nil {
@@ -291,6 +508,9 @@ func (hni *HostNetworkInfo) populateVfInfo(info *plumberv1.SriovStatus, devicePa
 		vf.Spoofchk = vfLink.Spoofchk
 		// netlink not returning trust mode, filed bug: https://github.com/vishvananda/netlink/issues/580
 		vf.Trust = false
+		vf.MinTxRate = int(vfLink.MinTxRate)
+		vf.MaxTxRate = int(vfLink.MaxTxRate)
+		vf.LinkState = sriovutils.LinkStateString(vfLink.LinkState)
 		vf.PciAddr = sriovutils.GetVfPciAddrById(devicePath, vf.ID)
 		vf.VfDriver = sriovutils.GetVfDriverByPci(vf.PciAddr)
 		vfList = append(vfList, vf)
@@ -300,3 +520,83 @@ func (hni *HostNetworkInfo) populateVfInfo(info *plumberv1.SriovStatus, devicePa
 
 	return nil
 }
+
+// addVfRepresentors sets the representor of each VF of a PF in switchdev mode
+func (hni *HostNetworkInfo) addVfRepresentors(info *plumberv1.SriovStatus, pfName string) {
+	reps, err := sriovutils.GetVfRepresentors(pfName)
+	if err != nil {
+		hni.log.Infow("Failed to get VF representors", "pfName", pfName, "err", err)
+		return
+	}
+	for _, vf := range info.Vfs {
+		vf.Representor = reps[vf.ID]
+	}
+}
+
+func (hni *HostNetworkInfo) discoverOvsInfo() error {
+	state, err := ovsutils.GetState()
+	if err != nil {
+		return err
+	}
+
+	hni.currentStatus.OvsSystem = &plumberv1.OvsSystemStatus{
+		OvsVersion:      state.OvsVersion,
+		DpdkInitialized: state.DpdkInitialized,
+		DpdkVersion:     state.DpdkVersion,
+	}
+
+	for _, bridge := range state.Bridges {
+		brStatus := &plumberv1.OvsStatus{
+			BridgeName:   bridge.Name,
+			DatapathType: bridge.DatapathType,
+		}
+		var nodeIfs []string
+		for _, port := range bridge.Ports {
+			portStatus := &plumberv1.OvsPortStatus{
+				Name:             port.Name,
+				BondMode:         port.BondMode,
+				Lacp:             port.Lacp,
+				BondActiveMember: port.BondActiveMember,
+			}
+			lacpMembers, lacpCurrent := 0, 0
+			for _, iface := range port.Interfaces {
+				portStatus.Interfaces = append(portStatus.Interfaces, &plumberv1.OvsInterfaceStatus{
+					Name:        iface.Name,
+					Type:        iface.Type,
+					AdminState:  iface.AdminState,
+					LinkState:   iface.LinkState,
+					MTU:         iface.Mtu,
+					LacpCurrent: iface.LacpCurrent,
+					Error:       iface.Error,
+				})
+				if iface.LacpCurrent != nil {
+					lacpMembers++
+					if *iface.LacpCurrent {
+						lacpCurrent++
+					}
+				}
+				if iface.Type != "internal" {
+					nodeIfs = append(nodeIfs, iface.Name)
+				}
+			}
+			portStatus.LacpStatus = lacpStatus(lacpMembers, lacpCurrent)
+			brStatus.Ports = append(brStatus.Ports, portStatus)
+		}
+		brStatus.NodeInterface = strings.Join(nodeIfs, ",")
+		hni.currentStatus.OvsStatus = append(hni.currentStatus.OvsStatus, brStatus)
+	}
+	return nil
+}
+
+func lacpStatus(members, current int) string {
+	switch {
+	case members == 0:
+		return ""
+	case current == members:
+		return "Negotiated"
+	case current > 0:
+		return "Degraded"
+	default:
+		return "Failed"
+	}
+}
@@ -0,0 +1,117 @@
+package hoststate
+
+import (
+	"context"
+	"testing"
+
+	plumberv1 "hostplumber/api/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newHostNetworkInfo(t *testing.T, objs ...client.Object) (*HostNetworkInfo, client.Client) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := plumberv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	node := &corev1.Node{}
+	node.Name = "node1"
+	node.UID = "node1-uid"
+	objs = append(objs, node)
+	k8sclient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return New("node1", "luigi-system", k8sclient), k8sclient
+}
+
+func withRoute(hni *HostNetworkInfo, dst string) {
+	hni.currentStatus = &plumberv1.HostNetworkStatus{
+		Routes: &plumberv1.Routes{V4Routes: []*plumberv1.Route{{Dst: dst, Dev: "eth0"}}},
+	}
+}
+
+func getHostNetwork(t *testing.T, k8sclient client.Client) *plumberv1.HostNetwork {
+	t.Helper()
+	hostState := &plumberv1.HostNetwork{}
+	nsn := types.NamespacedName{Name: "node1", Namespace: "luigi-system"}
+	if err := k8sclient.Get(context.Background(), nsn, hostState); err != nil {
+		t.Fatal(err)
+	}
+	return hostState
+}
+
+func TestPublishHostStateCreatesOwnedHostNetwork(t *testing.T) {
+	hni, k8sclient := newHostNetworkInfo(t)
+	withRoute(hni, "10.0.0.0/24")
+
+	if err := hni.publishHostState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	hostState := getHostNetwork(t, k8sclient)
+	if len(hostState.OwnerReferences) != 1 {
+		t.Fatalf("expected a Node owner, got %+v", hostState.OwnerReferences)
+	}
+	owner := hostState.OwnerReferences[0]
+	if owner.Kind != "Node" || owner.Name != "node1" || owner.UID != "node1-uid" {
+		t.Errorf("unexpected owner %+v", owner)
+	}
+	if routes := hostState.Status.Routes; routes == nil || routes.V4Routes[0].Dst != "10.0.0.0/24" {
+		t.Errorf("status not written: %+v", hostState.Status)
+	}
+}
+
+func TestPublishHostStateOnlyWritesChanges(t *testing.T) {
+	hni, k8sclient := newHostNetworkInfo(t)
+	withRoute(hni, "10.0.0.0/24")
+	if err := hni.publishHostState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	created := getHostNetwork(t, k8sclient)
+
+	if err := hni.publishHostState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	unchanged := getHostNetwork(t, k8sclient)
+	if unchanged.ResourceVersion != created.ResourceVersion {
+		t.Errorf("unchanged status was written, resourceVersion %s -> %s", created.ResourceVersion, unchanged.ResourceVersion)
+	}
+
+	withRoute(hni, "10.0.1.0/24")
+	if err := hni.publishHostState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	updated := getHostNetwork(t, k8sclient)
+	if updated.UID != created.UID {
+		t.Errorf("HostNetwork was recreated")
+	}
+	if updated.Status.Routes.V4Routes[0].Dst != "10.0.1.0/24" {
+		t.Errorf("status not updated: %+v", updated.Status.Routes.V4Routes[0])
+	}
+}
+
+func TestPublishHostStateAdoptsUnownedHostNetwork(t *testing.T) {
+	existing := &plumberv1.HostNetwork{}
+	existing.Name = "node1"
+	existing.Namespace = "luigi-system"
+	existing.UID = "hostnetwork-uid"
+	hni, k8sclient := newHostNetworkInfo(t, existing)
+	withRoute(hni, "10.0.0.0/24")
+
+	if err := hni.publishHostState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	hostState := getHostNetwork(t, k8sclient)
+	if hostState.UID != "hostnetwork-uid" {
+		t.Errorf("HostNetwork was recreated")
+	}
+	if len(hostState.OwnerReferences) != 1 || hostState.OwnerReferences[0].Kind != "Node" {
+		t.Errorf("owner not set: %+v", hostState.OwnerReferences)
+	}
+}
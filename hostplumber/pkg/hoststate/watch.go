@@ -0,0 +1,175 @@
+package hoststate
+
+import (
+	"context"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	DefaultDebounce     = 2 * time.Second
+	DefaultResyncPeriod = time.Minute
+
+	// Wait before subscribing again when netlink closed a subscription
+	resubscribeDelay = 5 * time.Second
+)
+
+// Watcher rediscovers the host state when netlink reports a link, address or
+// route change, and periodically to catch changes netlink does not report,
+// such as VF settings. It is a manager.Runnable.
+type Watcher struct {
+	log *zap.SugaredLogger
+	// Debounce is how long events must stop before rediscovering, so a burst
+	// of events (e.g. creating VFs) only triggers one rediscovery. A steady
+	// stream of events still triggers one every maxDelay.
+	debounce time.Duration
+	maxDelay time.Duration
+	// resyncPeriod is the interval of full rediscovery, 0 disables it
+	resyncPeriod time.Duration
+	discover     func()
+}
+
+func NewWatcher(nodeName, namespace string, k8sclient client.Client, debounce, resyncPeriod time.Duration) *Watcher {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	return &Watcher{
+		log:          logger.Sugar(),
+		debounce:     debounce,
+		maxDelay:     10 * debounce,
+		resyncPeriod: resyncPeriod,
+		discover: func() {
+			New(nodeName, namespace, k8sclient).DiscoverHostState()
+		},
+	}
+}
+
+// NeedLeaderElection is false, every node discovers its own state
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start discovers the host state once, then on netlink events and
+// periodically until ctx is done
+func (w *Watcher) Start(ctx context.Context) error {
+	events := make(chan struct{}, 1)
+	go w.subscribe(ctx, events)
+	w.run(ctx, events)
+	return nil
+}
+
+func (w *Watcher) run(ctx context.Context, events <-chan struct{}) {
+	w.discover()
+
+	var resync <-chan time.Time
+	if w.resyncPeriod > 0 {
+		ticker := time.NewTicker(w.resyncPeriod)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+
+	// Timers are not drained, Stop and Reset discard a pending expiry since go 1.23
+	debounce := time.NewTimer(w.debounce)
+	debounce.Stop()
+	// Set while events are pending a rediscovery
+	var firstEvent time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			debounce.Stop()
+			return
+		case <-events:
+			now := time.Now()
+			if firstEvent.IsZero() {
+				firstEvent = now
+			}
+			wait := w.debounce
+			if deadline := firstEvent.Add(w.maxDelay); now.Add(wait).After(deadline) {
+				wait = deadline.Sub(now)
+			}
+			debounce.Reset(wait)
+		case <-debounce.C:
+			firstEvent = time.Time{}
+			w.log.Infow("Re-discovering HostNetwork after netlink events")
+			w.discover()
+		case t := <-resync:
+			w.log.Infow("Re-discovering HostNetwork", "time", t)
+			w.discover()
+		}
+	}
+}
+
+// subscribe forwards netlink link, address and route events to events,
+// subscribing again if netlink drops a subscription
+func (w *Watcher) subscribe(ctx context.Context, events chan<- struct{}) {
+	notify := func() {
+		select {
+		case events <- struct{}{}:
+		default:
+			// An event is already pending
+		}
+	}
+	onError := func(err error) {
+		w.log.Warnw("netlink subscription failed", "err", err)
+	}
+
+	for {
+		done := make(chan struct{})
+		links := make(chan netlink.LinkUpdate)
+		addrs := make(chan netlink.AddrUpdate)
+		routes := make(chan netlink.RouteUpdate)
+		// Subscriptions that started, and must be drained until they close
+		// their channel once done is closed
+		var started []func()
+
+		err := netlink.LinkSubscribeWithOptions(links, done, netlink.LinkSubscribeOptions{ErrorCallback: onError})
+		if err == nil {
+			started = append(started, func() { drain(links) })
+			err = netlink.AddrSubscribeWithOptions(addrs, done, netlink.AddrSubscribeOptions{ErrorCallback: onError})
+		}
+		if err == nil {
+			started = append(started, func() { drain(addrs) })
+			err = netlink.RouteSubscribeWithOptions(routes, done, netlink.RouteSubscribeOptions{ErrorCallback: onError})
+		}
+		if err == nil {
+			started = append(started, func() { drain(routes) })
+			w.log.Infow("Subscribed to netlink link, address and route events")
+		} else {
+			w.log.Errorw("Failed to subscribe to netlink events", "err", err)
+		}
+
+		// Each subscription closes its channel when it fails
+		for open := err == nil; open; {
+			select {
+			case <-ctx.Done():
+				open = false
+			case _, open = <-links:
+			case _, open = <-addrs:
+			case _, open = <-routes:
+			}
+			if open {
+				notify()
+			}
+		}
+		close(done)
+		for _, drainFn := range started {
+			go drainFn()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resubscribeDelay):
+		}
+		// Changes may have been missed while unsubscribed
+		notify()
+	}
+}
+
+func drain[T any](ch <-chan T) {
+	for range ch {
+	}
+}
@@ -0,0 +1,88 @@
+package hoststate
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestWatcher(debounce, maxDelay, resyncPeriod time.Duration) (*Watcher, *int32) {
+	var count int32
+	return &Watcher{
+		log:          zap.NewNop().Sugar(),
+		debounce:     debounce,
+		maxDelay:     maxDelay,
+		resyncPeriod: resyncPeriod,
+		discover:     func() { atomic.AddInt32(&count, 1) },
+	}, &count
+}
+
+func runWatcher(t *testing.T, w *Watcher) chan<- struct{} {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		w.run(ctx, events)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return events
+}
+
+func waitForCount(t *testing.T, count *int32, want int32, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for atomic.LoadInt32(count) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d discoveries within %s, got %d", want, within, atomic.LoadInt32(count))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatcherDebouncesEvents(t *testing.T) {
+	w, count := newTestWatcher(50*time.Millisecond, time.Hour, 0)
+	events := runWatcher(t, w)
+
+	// The initial discovery
+	waitForCount(t, count, 1, time.Second)
+
+	for i := 0; i < 10; i++ {
+		events <- struct{}{}
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitForCount(t, count, 2, time.Second)
+	time.Sleep(150 * time.Millisecond)
+	if got := atomic.LoadInt32(count); got != 2 {
+		t.Errorf("a burst of events triggered %d discoveries, expected 1", got-1)
+	}
+}
+
+func TestWatcherMaxDelay(t *testing.T) {
+	w, count := newTestWatcher(50*time.Millisecond, 100*time.Millisecond, 0)
+	events := runWatcher(t, w)
+	waitForCount(t, count, 1, time.Second)
+
+	// Events keep coming faster than the debounce period
+	stop := time.Now().Add(400 * time.Millisecond)
+	for time.Now().Before(stop) {
+		events <- struct{}{}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(count); got < 3 {
+		t.Errorf("a steady stream of events triggered %d discoveries, expected at least 2", got-1)
+	}
+}
+
+func TestWatcherResync(t *testing.T) {
+	w, count := newTestWatcher(time.Second, time.Hour, 30*time.Millisecond)
+	runWatcher(t, w)
+	waitForCount(t, count, 3, time.Second)
+}
@@ -1,22 +1,67 @@
 #!/bin/bash
 
-CONFIG_FILE=/etc/openvswitch/ovs.conf
+# The operator renders the OVS configuration into the environment:
+# EnableDpdk, LcoreMask, SocketMem, PmdCpuMask, DpdkExtra and VhostSockDir for
+# DPDK, and OvsOtherConfig and OvsExternalIds as space separated key=value pairs.
+
+# Keys set from OvsOtherConfig and OvsExternalIds, removed once no longer set
+STATE_FILE=${STATE_FILE:-/etc/openvswitch/luigi-managed-keys}
+# other_config keys set from the DPDK variables
+DPDK_KEYS="dpdk-init dpdk-hugepage-dir dpdk-socket-mem dpdk-lcore-mask pmd-cpu-mask dpdk-extra vhost-sock-dir"
+
 export PATH=$PATH:/usr/local/share/openvswitch/scripts
 ovs-ctl start
 
 # Start ovsdb
 ovs-ctl restart --no-ovs-vswitchd --system-id=random
 
-if $EnableDpdk == true ; then
-	printf "Setting DPDK configuration options..."
-	# Read the config and setup OVS
-	while IFS= read -r config_line
-	do
-		if [[ $config_line ]] && [[ $config_line != \#* ]] ; then
-			ovs-vsctl --no-wait set Open_vSwitch . other_config:$config_line
-		fi	
-	done < "$CONFIG_FILE"
+set_ovs() {
+	ovs-vsctl --no-wait set Open_vSwitch . "$@"
+}
+
+remove_ovs() {
+	ovs-vsctl --no-wait remove Open_vSwitch . "$1" "$2"
+}
+
+if [[ $EnableDpdk == true ]] ; then
+	echo "Setting DPDK configuration options..."
+	set_ovs other_config:dpdk-init=true other_config:dpdk-hugepage-dir=/dev/hugepages \
+		"other_config:dpdk-socket-mem=\"$SocketMem\"" \
+		"other_config:dpdk-lcore-mask=$LcoreMask" "other_config:pmd-cpu-mask=$PmdCpuMask"
+	if [[ $DpdkExtra ]] ; then
+		set_ovs "other_config:dpdk-extra=\"$DpdkExtra\""
+	else
+		remove_ovs other_config dpdk-extra
+	fi
+	if [[ $VhostSockDir ]] ; then
+		set_ovs "other_config:vhost-sock-dir=\"$VhostSockDir\""
+	else
+		remove_ovs other_config vhost-sock-dir
+	fi
+else
+	for key in $DPDK_KEYS ; do
+		remove_ovs other_config "$key"
+	done
+fi
+
+# Values hold no whitespace or quotes, the operator rejects them
+managed=""
+for pair in $OvsOtherConfig ; do
+	set_ovs "other_config:${pair%%=*}=\"${pair#*=}\""
+	managed+="other_config ${pair%%=*}"$'\n'
+done
+for pair in $OvsExternalIds ; do
+	set_ovs "external_ids:${pair%%=*}=\"${pair#*=}\""
+	managed+="external_ids ${pair%%=*}"$'\n'
+done
+if [[ -f $STATE_FILE ]] ; then
+	while read -r column key ; do
+		if [[ $column ]] && ! grep -qxF "$column $key" <<< "$managed" ; then
+			remove_ovs "$column" "$key"
+		fi
+	done < "$STATE_FILE"
 fi
+printf "%s" "$managed" > "$STATE_FILE"
 
 # Start vswitchd
 ovs-ctl restart --no-ovsdb-server --system-id=random
@@ -0,0 +1,190 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	// DefaultTimeout bounds a call, from sending the request to reading its response
+	DefaultTimeout = 30 * time.Second
+)
+
+type request struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     interface{}   `json:"id"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+	ID     interface{}     `json:"id"`
+}
+
+// message is either a request or a response, told apart by Method
+type message struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  interface{}       `json:"error"`
+	ID     interface{}       `json:"id"`
+}
+
+// Client is a JSON-RPC connection to ovsdb-server. Calls are serialized, a
+// Client may be shared between goroutines. A call that fails on the
+// connection drops it, and the next call dials the server again.
+type Client struct {
+	// Timeout bounds each call, DefaultTimeout unless changed
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	socket string
+	conn   net.Conn
+	enc    *json.Encoder
+	dec    *json.Decoder
+	nextID uint64
+	closed bool
+}
+
+// Dial connects to ovsdb-server on a unix socket
+func Dial(socket string) (*Client, error) {
+	c := &Client{Timeout: DefaultTimeout, socket: socket}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("unix", c.socket, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to ovsdb-server at %s: %w", c.socket, err)
+	}
+	c.conn, c.enc, c.dec = conn, json.NewEncoder(conn), json.NewDecoder(conn)
+	return nil
+}
+
+// disconnect drops a connection left in an unknown state, such as with a
+// request sent and its response unread
+func (c *Client) disconnect() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.enc, c.dec = nil, nil, nil
+	return err
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return c.disconnect()
+}
+
+// call sends a request and waits for its response, answering any echo
+// request the server sends in the meantime
+func (c *Client) call(method string, params []interface{}, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return fmt.Errorf("ovsdb %s: client is closed", method)
+	}
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		c.disconnect()
+		return fmt.Errorf("ovsdb %s: %w", method, err)
+	}
+
+	c.nextID++
+	id := c.nextID
+	if err := c.enc.Encode(request{Method: method, Params: params, ID: id}); err != nil {
+		c.disconnect()
+		return fmt.Errorf("ovsdb %s: %w", method, err)
+	}
+
+	for {
+		var msg message
+		if err := c.dec.Decode(&msg); err != nil {
+			c.disconnect()
+			return fmt.Errorf("ovsdb %s: %w", method, err)
+		}
+		if msg.Method == "echo" {
+			if err := c.enc.Encode(response{Result: mustMarshal(msg.Params), ID: msg.ID}); err != nil {
+				c.disconnect()
+				return fmt.Errorf("ovsdb echo reply: %w", err)
+			}
+			continue
+		}
+		if msg.Method != "" {
+			// Notifications such as update are not used by this client
+			continue
+		}
+		if respID, ok := msg.ID.(float64); !ok || uint64(respID) != id {
+			continue
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("ovsdb %s failed: %v", method, msg.Error)
+		}
+		if result == nil {
+			return nil
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			return fmt.Errorf("ovsdb %s: invalid result: %w", method, err)
+		}
+		return nil
+	}
+}
+
+// Echo checks the server is alive
+func (c *Client) Echo() error {
+	return c.call("echo", []interface{}{"ping"}, nil)
+}
+
+// Transact runs the operations atomically on the Open_vSwitch database. If an
+// operation or the commit fails, nothing is changed and an *Error is returned.
+func (c *Client) Transact(ops ...Operation) ([]OperationResult, error) {
+	params := []interface{}{DatabaseName}
+	for _, op := range ops {
+		params = append(params, op)
+	}
+
+	var results []OperationResult
+	if err := c.call("transact", params, &results); err != nil {
+		return nil, err
+	}
+	for i, res := range results {
+		if res.Error == "" {
+			continue
+		}
+		e := &Error{Op: i, Err: res.Error, Details: res.Details}
+		if i < len(ops) {
+			e.OpName = ops[i].Op
+		} else {
+			e.Op = -1
+		}
+		return nil, e
+	}
+	if len(results) < len(ops) {
+		return nil, fmt.Errorf("ovsdb transact returned %d results for %d operations", len(results), len(ops))
+	}
+	return results, nil
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	out, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
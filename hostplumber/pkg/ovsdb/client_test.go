@@ -0,0 +1,239 @@
+package ovsdb_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hostplumber/pkg/ovsdb"
+	"hostplumber/pkg/ovsdb/ovsdbtest"
+)
+
+func newServer(t *testing.T) (*ovsdbtest.Server, *ovsdb.Client) {
+	t.Helper()
+	server, err := ovsdbtest.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	client, err := ovsdb.Dial(server.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestTransactInsertAndSelect(t *testing.T) {
+	server, client := newServer(t)
+
+	if err := client.Echo(); err != nil {
+		t.Fatalf("echo failed: %v", err)
+	}
+
+	results, err := client.Transact(
+		ovsdb.Insert("Interface", map[string]interface{}{"name": "eth1", "type": ""}, "iface"),
+		ovsdb.Insert("Port", map[string]interface{}{
+			"name":         "eth1",
+			"interfaces":   ovsdb.Set{ovsdb.NamedUUID("iface")},
+			"other_config": ovsdb.Map{"priority-tags": "true"},
+		}, "port"),
+		ovsdb.Insert("Bridge", map[string]interface{}{
+			"name":  "br0",
+			"ports": ovsdb.Set{ovsdb.NamedUUID("port")},
+		}, "bridge"),
+		ovsdb.Mutate("Open_vSwitch", []ovsdb.Mutation{
+			ovsdb.MutateInsert("bridges", ovsdb.Set{ovsdb.NamedUUID("bridge")}),
+		}),
+	)
+	if err != nil {
+		t.Fatalf("transact failed: %v", err)
+	}
+	if len(results) != 4 || results[2].UUID == "" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	results, err = client.Transact(ovsdb.Select("Port", []ovsdb.Condition{ovsdb.Equal("name", "eth1")}))
+	if err != nil {
+		t.Fatalf("select failed: %v", err)
+	}
+	if len(results[0].Rows) != 1 {
+		t.Fatalf("expected one port, got %+v", results[0].Rows)
+	}
+	port := results[0].Rows[0]
+	if got := port.StringMap("other_config")["priority-tags"]; got != "true" {
+		t.Errorf("other_config not stored, got %q", got)
+	}
+	if ifaces := port.UUIDs("interfaces"); len(ifaces) != 1 {
+		t.Errorf("expected one interface reference, got %v", ifaces)
+	}
+
+	bridge, ok := server.RowByName("Bridge", "br0")
+	if !ok {
+		t.Fatalf("bridge not created")
+	}
+	if ports := bridge.UUIDs("ports"); len(ports) != 1 || ports[0] != port.UUID() {
+		t.Errorf("bridge does not reference the port: %v", ports)
+	}
+}
+
+func TestTransactFailedOperationRollsBack(t *testing.T) {
+	server, client := newServer(t)
+
+	_, err := client.Transact(
+		ovsdb.Insert("Bridge", map[string]interface{}{"name": "br0"}, "bridge"),
+		ovsdb.Mutate("Open_vSwitch", []ovsdb.Mutation{
+			ovsdb.MutateInsert("bridges", ovsdb.Set{ovsdb.NamedUUID("bridge")}),
+		}),
+		ovsdb.Mutate("NoSuchTable", nil),
+	)
+	var ovsdbErr *ovsdb.Error
+	if !errors.As(err, &ovsdbErr) {
+		t.Fatalf("expected an *ovsdb.Error, got %v", err)
+	}
+	if ovsdbErr.Op != 2 || ovsdbErr.OpName != "mutate" || ovsdbErr.Err != "unknown table" {
+		t.Errorf("unexpected error %+v", ovsdbErr)
+	}
+	if _, ok := server.RowByName("Bridge", "br0"); ok {
+		t.Errorf("bridge of the failed transaction was committed")
+	}
+}
+
+func TestTransactCommitError(t *testing.T) {
+	_, client := newServer(t)
+
+	// A bridge nobody references is garbage collected, a dangling reference
+	// fails the commit
+	_, err := client.Transact(ovsdb.Insert("Port", map[string]interface{}{
+		"name":       "eth1",
+		"interfaces": ovsdb.Set{ovsdb.UUID("00000000-0000-0000-0000-999999999999")},
+	}, "port"), ovsdb.Insert("Bridge", map[string]interface{}{
+		"name":  "br0",
+		"ports": ovsdb.Set{ovsdb.NamedUUID("port")},
+	}, "bridge"), ovsdb.Mutate("Open_vSwitch", []ovsdb.Mutation{
+		ovsdb.MutateInsert("bridges", ovsdb.Set{ovsdb.NamedUUID("bridge")}),
+	}))
+	var ovsdbErr *ovsdb.Error
+	if !errors.As(err, &ovsdbErr) {
+		t.Fatalf("expected an *ovsdb.Error, got %v", err)
+	}
+	if ovsdbErr.Op != -1 || ovsdbErr.Err != "referential integrity violation" {
+		t.Errorf("unexpected error %+v", ovsdbErr)
+	}
+}
+
+func TestClientAnswersEcho(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "db.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	echoReply := make(chan map[string]interface{}, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dec := json.NewDecoder(conn)
+		enc := json.NewEncoder(conn)
+
+		var req map[string]interface{}
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		// Probe the client before answering its request
+		enc.Encode(map[string]interface{}{"method": "echo", "params": []string{"probe"}, "id": "echo"})
+		var reply map[string]interface{}
+		if err := dec.Decode(&reply); err != nil {
+			return
+		}
+		echoReply <- reply
+		enc.Encode(map[string]interface{}{"result": []interface{}{map[string]int{"count": 1}}, "error": nil, "id": req["id"]})
+	}()
+
+	client, err := ovsdb.Dial(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	results, err := client.Transact(ovsdb.Delete("Bridge"))
+	if err != nil {
+		t.Fatalf("transact failed: %v", err)
+	}
+	if results[0].Count != 1 {
+		t.Errorf("unexpected results %+v", results)
+	}
+	reply := <-echoReply
+	if reply["id"] != "echo" {
+		t.Errorf("echo reply has id %v", reply["id"])
+	}
+	if params, _ := reply["result"].([]interface{}); len(params) != 1 || params[0] != "probe" {
+		t.Errorf("echo reply has result %v", reply["result"])
+	}
+}
+
+func TestClientTimeoutRedials(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "db.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// The first connection never answers
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			var req map[string]interface{}
+			json.NewDecoder(conn).Decode(&req)
+		}()
+
+		conn2, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn2.Close()
+		dec := json.NewDecoder(conn2)
+		enc := json.NewEncoder(conn2)
+		var req map[string]interface{}
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		enc.Encode(map[string]interface{}{"result": req["params"], "error": nil, "id": req["id"]})
+		<-done
+	}()
+
+	client, err := ovsdb.Dial(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Timeout = 100 * time.Millisecond
+
+	if err := client.Echo(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if err := client.Echo(); err != nil {
+		t.Fatalf("echo after reconnect failed: %v", err)
+	}
+
+	client.Close()
+	if err := client.Echo(); err == nil {
+		t.Error("expected an error from a closed client")
+	}
+}
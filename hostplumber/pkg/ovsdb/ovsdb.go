@@ -0,0 +1,322 @@
+// Package ovsdb is a minimal client of the OVSDB management protocol (RFC 7047)
+// used to configure Open vSwitch without shelling out to ovs-vsctl.
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const (
+	DefaultSocket = "/var/run/openvswitch/db.sock"
+	DatabaseName  = "Open_vSwitch"
+)
+
+// UUID is a reference to a row, encoded as ["uuid", "<uuid>"]
+type UUID string
+
+func (u UUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"uuid", string(u)})
+}
+
+func (u *UUID) UnmarshalJSON(data []byte) error {
+	var pair []string
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 || pair[0] != "uuid" {
+		return fmt.Errorf("invalid uuid %s", data)
+	}
+	*u = UUID(pair[1])
+	return nil
+}
+
+// NamedUUID refers to a row inserted earlier in the same transaction
+type NamedUUID string
+
+func (u NamedUUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"named-uuid", string(u)})
+}
+
+// Set is an OVSDB set, encoded as ["set", [...]]
+type Set []interface{}
+
+func (s Set) MarshalJSON() ([]byte, error) {
+	elems := []interface{}(s)
+	if elems == nil {
+		elems = []interface{}{}
+	}
+	return json.Marshal([]interface{}{"set", elems})
+}
+
+// Map is an OVSDB string to string map, encoded as ["map", [[k, v], ...]]
+type Map map[string]string
+
+func (m Map) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([][]string, 0, len(m))
+	for _, k := range keys {
+		pairs = append(pairs, []string{k, m[k]})
+	}
+	return json.Marshal([]interface{}{"map", pairs})
+}
+
+// Condition is a where clause, encoded as [column, function, value]
+type Condition struct {
+	Column   string
+	Function string
+	Value    interface{}
+}
+
+func (c Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{c.Column, c.Function, c.Value})
+}
+
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	var triple []json.RawMessage
+	if err := json.Unmarshal(data, &triple); err != nil {
+		return err
+	}
+	if len(triple) != 3 {
+		return fmt.Errorf("invalid condition %s", data)
+	}
+	if err := json.Unmarshal(triple[0], &c.Column); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(triple[1], &c.Function); err != nil {
+		return err
+	}
+	return json.Unmarshal(triple[2], &c.Value)
+}
+
+func Equal(column string, value interface{}) Condition {
+	return Condition{Column: column, Function: "==", Value: value}
+}
+
+func Includes(column string, value interface{}) Condition {
+	return Condition{Column: column, Function: "includes", Value: value}
+}
+
+// Mutation is a mutate clause, encoded as [column, mutator, value]
+type Mutation struct {
+	Column  string
+	Mutator string
+	Value   interface{}
+}
+
+func (m Mutation) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{m.Column, m.Mutator, m.Value})
+}
+
+func (m *Mutation) UnmarshalJSON(data []byte) error {
+	var c Condition
+	if err := c.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*m = Mutation{Column: c.Column, Mutator: c.Function, Value: c.Value}
+	return nil
+}
+
+// MutateInsert adds the elements of a set or map column
+func MutateInsert(column string, value interface{}) Mutation {
+	return Mutation{Column: column, Mutator: "insert", Value: value}
+}
+
+// MutateDelete removes the elements of a set or map column
+func MutateDelete(column string, value interface{}) Mutation {
+	return Mutation{Column: column, Mutator: "delete", Value: value}
+}
+
+// MutateAdd adds value to an integer column
+func MutateAdd(column string, value int) Mutation {
+	return Mutation{Column: column, Mutator: "+=", Value: value}
+}
+
+// Operation is one operation of a transact request
+type Operation struct {
+	Op        string                 `json:"op"`
+	Table     string                 `json:"table"`
+	Row       map[string]interface{} `json:"row,omitempty"`
+	Where     []Condition            `json:"where"`
+	Columns   []string               `json:"columns,omitempty"`
+	Mutations []Mutation             `json:"mutations,omitempty"`
+	UUIDName  string                 `json:"uuid-name,omitempty"`
+}
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+	type operation Operation
+	if o.Op == "insert" {
+		// insert is the only operation without a where clause
+		return json.Marshal(struct {
+			operation
+			Where []Condition `json:"where,omitempty"`
+		}{operation: operation(o)})
+	}
+	if o.Where == nil {
+		o.Where = []Condition{}
+	}
+	return json.Marshal(operation(o))
+}
+
+func Insert(table string, row map[string]interface{}, uuidName string) Operation {
+	return Operation{Op: "insert", Table: table, Row: row, UUIDName: uuidName}
+}
+
+func Select(table string, where []Condition, columns ...string) Operation {
+	return Operation{Op: "select", Table: table, Where: where, Columns: columns}
+}
+
+func Update(table string, row map[string]interface{}, where ...Condition) Operation {
+	return Operation{Op: "update", Table: table, Row: row, Where: where}
+}
+
+func Mutate(table string, mutations []Mutation, where ...Condition) Operation {
+	return Operation{Op: "mutate", Table: table, Mutations: mutations, Where: where}
+}
+
+func Delete(table string, where ...Condition) Operation {
+	return Operation{Op: "delete", Table: table, Where: where}
+}
+
+// OperationResult is the result of one operation of a transaction
+type OperationResult struct {
+	Count   int    `json:"count,omitempty"`
+	UUID    UUID   `json:"uuid,omitempty"`
+	Rows    []Row  `json:"rows,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+// Error is an error reported by ovsdb-server for a transaction
+type Error struct {
+	// Op is the index of the operation that failed, or -1 if the commit failed
+	Op      int
+	OpName  string
+	Err     string
+	Details string
+}
+
+func (e *Error) Error() string {
+	where := "commit"
+	if e.Op >= 0 {
+		where = fmt.Sprintf("operation %d (%s)", e.Op, e.OpName)
+	}
+	if e.Details != "" {
+		return fmt.Sprintf("ovsdb %s failed: %s: %s", where, e.Err, e.Details)
+	}
+	return fmt.Sprintf("ovsdb %s failed: %s", where, e.Err)
+}
+
+// Row is a row returned by select. Values keep their JSON encoding, use the
+// accessors to decode them.
+type Row map[string]interface{}
+
+func (r Row) UUID() string {
+	return parseUUID(r["_uuid"])
+}
+
+func (r Row) String(column string) string {
+	switch v := r[column].(type) {
+	case string:
+		return v
+	case []interface{}:
+		// An optional string is a set of zero or one element
+		if elems := setElems(v); len(elems) == 1 {
+			if s, ok := elems[0].(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// Int returns the value of an integer column and whether it is set
+func (r Row) Int(column string) (int, bool) {
+	v := r[column]
+	if set, ok := v.([]interface{}); ok {
+		elems := setElems(set)
+		if len(elems) != 1 {
+			return 0, false
+		}
+		v = elems[0]
+	}
+	if f, ok := v.(float64); ok {
+		return int(f), true
+	}
+	return 0, false
+}
+
+// Bool returns the value of a boolean column and whether it is set
+func (r Row) Bool(column string) (bool, bool) {
+	v := r[column]
+	if set, ok := v.([]interface{}); ok {
+		elems := setElems(set)
+		if len(elems) != 1 {
+			return false, false
+		}
+		v = elems[0]
+	}
+	b, ok := v.(bool)
+	return b, ok
+}
+
+// UUIDs returns the references held by a uuid or set of uuid column
+func (r Row) UUIDs(column string) []string {
+	var uuids []string
+	v, ok := r[column].([]interface{})
+	if !ok {
+		return nil
+	}
+	if len(v) == 2 && v[0] == "uuid" {
+		return []string{parseUUID(v)}
+	}
+	for _, elem := range setElems(v) {
+		if u := parseUUID(elem); u != "" {
+			uuids = append(uuids, u)
+		}
+	}
+	return uuids
+}
+
+func (r Row) StringMap(column string) map[string]string {
+	m := make(map[string]string)
+	v, ok := r[column].([]interface{})
+	if !ok || len(v) != 2 || v[0] != "map" {
+		return m
+	}
+	pairs, _ := v[1].([]interface{})
+	for _, p := range pairs {
+		pair, ok := p.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		k, _ := pair[0].(string)
+		val, _ := pair[1].(string)
+		m[k] = val
+	}
+	return m
+}
+
+func parseUUID(v interface{}) string {
+	pair, ok := v.([]interface{})
+	if !ok || len(pair) != 2 || pair[0] != "uuid" {
+		return ""
+	}
+	s, _ := pair[1].(string)
+	return s
+}
+
+// setElems returns the elements of a ["set", [...]], or the value itself if
+// it is a single atom
+func setElems(v []interface{}) []interface{} {
+	if len(v) == 2 && v[0] == "set" {
+		elems, _ := v[1].([]interface{})
+		return elems
+	}
+	return []interface{}{v}
+}
@@ -0,0 +1,588 @@
+// Package ovsdbtest is an in-memory OVSDB server for unit tests. It implements
+// enough of the transact method for the Open_vSwitch tables hostplumber uses:
+// insert, select, update, mutate and delete with ==, != and includes
+// conditions, garbage collection of unreferenced rows, referential integrity
+// and unique names. It also stands in for ovs-vswitchd by copying next_cfg to
+// cur_cfg after each commit, unless paused.
+package ovsdbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+
+	"hostplumber/pkg/ovsdb"
+)
+
+type row map[string]interface{}
+
+// Server serves one in-memory Open_vSwitch database on a unix socket
+type Server struct {
+	Socket string
+
+	mu       sync.Mutex
+	listener net.Listener
+	tables   map[string]map[string]row
+	uuidSeq  int
+	paused   bool
+}
+
+var (
+	// Only rows of root tables survive without being referenced
+	rootTables = map[string]bool{"Open_vSwitch": true}
+	// Tables whose name column is unique
+	nameIndexed = map[string]bool{"Bridge": true, "Port": true, "Interface": true}
+)
+
+// NewServer starts a server with an empty Open_vSwitch row on a socket in dir
+func NewServer(dir string) (*Server, error) {
+	s := &Server{
+		Socket: filepath.Join(dir, "db.sock"),
+		tables: map[string]map[string]row{
+			"Open_vSwitch": {},
+			"Bridge":       {},
+			"Port":         {},
+			"Interface":    {},
+		},
+	}
+	s.tables["Open_vSwitch"][s.newUUID()] = row{"bridges": setOf(), "next_cfg": 0.0, "cur_cfg": 0.0}
+
+	os.Remove(s.Socket)
+	l, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return nil, err
+	}
+	s.listener = l
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// PauseVswitchd stops cur_cfg from following next_cfg, as if ovs-vswitchd
+// was busy or not running
+func (s *Server) PauseVswitchd() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+// ResumeVswitchd applies the pending configuration and the following ones
+func (s *Server) ResumeVswitchd() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+	s.applyConfig()
+}
+
+// applyConfig sets cur_cfg to next_cfg, like ovs-vswitchd once it has
+// reconfigured
+func (s *Server) applyConfig() {
+	for _, r := range s.tables["Open_vSwitch"] {
+		r["cur_cfg"] = r["next_cfg"]
+	}
+}
+
+// Rows returns a copy of the rows of a table, keyed by uuid
+func (s *Server) Rows(table string) map[string]ovsdb.Row {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := make(map[string]ovsdb.Row)
+	for uuid, r := range s.tables[table] {
+		rows[uuid] = s.exportRow(uuid, r)
+	}
+	return rows
+}
+
+// RowByName returns the row of table with the given name column
+func (s *Server) RowByName(table, name string) (ovsdb.Row, bool) {
+	for _, r := range s.Rows(table) {
+		if r.String("name") == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) newUUID() string {
+	s.uuidSeq++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", s.uuidSeq)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+type rpcMessage struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     interface{}       `json:"id"`
+}
+
+type rpcResponse struct {
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+	ID     interface{} `json:"id"`
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var msg rpcMessage
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		resp := rpcResponse{ID: msg.ID}
+		switch msg.Method {
+		case "echo":
+			resp.Result = msg.Params
+		case "list_dbs":
+			resp.Result = []string{ovsdb.DatabaseName}
+		case "transact":
+			result, err := s.transact(msg.Params)
+			if err != nil {
+				resp.Error = err.Error()
+			} else {
+				resp.Result = result
+			}
+		default:
+			resp.Error = "unknown method"
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+type opError struct {
+	err     string
+	details string
+}
+
+func (s *Server) transact(params []json.RawMessage) ([]interface{}, error) {
+	if len(params) == 0 {
+		return nil, fmt.Errorf("missing database name")
+	}
+	var db string
+	if err := json.Unmarshal(params[0], &db); err != nil || db != ovsdb.DatabaseName {
+		return nil, fmt.Errorf("unknown database %s", params[0])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Work on a copy so a failed transaction leaves the database untouched
+	tx := &transaction{server: s, tables: s.cloneTables(), named: make(map[string]string)}
+	var results []interface{}
+	for _, raw := range params[1:] {
+		var op ovsdb.Operation
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return nil, err
+		}
+		var rawOp struct {
+			Row map[string]json.RawMessage `json:"row"`
+		}
+		json.Unmarshal(raw, &rawOp)
+		res, oerr := tx.apply(op, decodeRow(rawOp.Row))
+		if oerr != nil {
+			results = append(results, map[string]string{"error": oerr.err, "details": oerr.details})
+			return results, nil
+		}
+		results = append(results, res)
+	}
+
+	if oerr := tx.commit(); oerr != nil {
+		results = append(results, map[string]string{"error": oerr.err, "details": oerr.details})
+		return results, nil
+	}
+	s.tables = tx.tables
+	if !s.paused {
+		s.applyConfig()
+	}
+	return results, nil
+}
+
+type transaction struct {
+	server *Server
+	tables map[string]map[string]row
+	named  map[string]string
+}
+
+func (tx *transaction) apply(op ovsdb.Operation, opRow row) (interface{}, *opError) {
+	table, ok := tx.tables[op.Table]
+	if !ok {
+		return nil, &opError{"unknown table", op.Table}
+	}
+
+	switch op.Op {
+	case "insert":
+		uuid := tx.server.newUUID()
+		if op.UUIDName != "" {
+			tx.named[op.UUIDName] = uuid
+		}
+		newRow := row{}
+		for col, val := range opRow {
+			newRow[col] = val
+		}
+		table[uuid] = newRow
+		return map[string]interface{}{"uuid": []string{"uuid", uuid}}, nil
+
+	case "select":
+		var rows []interface{}
+		for _, uuid := range sortedKeys(table) {
+			if tx.matches(uuid, table[uuid], op.Where) {
+				rows = append(rows, tx.server.exportRow(uuid, table[uuid]))
+			}
+		}
+		if rows == nil {
+			rows = []interface{}{}
+		}
+		return map[string]interface{}{"rows": rows}, nil
+
+	case "update", "mutate", "delete":
+		count := 0
+		for _, uuid := range sortedKeys(table) {
+			if !tx.matches(uuid, table[uuid], op.Where) {
+				continue
+			}
+			count++
+			switch op.Op {
+			case "update":
+				for col, val := range opRow {
+					table[uuid][col] = val
+				}
+			case "mutate":
+				for _, m := range op.Mutations {
+					if err := tx.mutate(table[uuid], m); err != nil {
+						return nil, err
+					}
+				}
+			case "delete":
+				delete(table, uuid)
+			}
+		}
+		return map[string]interface{}{"count": count}, nil
+	}
+	return nil, &opError{"unknown operation", op.Op}
+}
+
+func (tx *transaction) mutate(r row, m ovsdb.Mutation) *opError {
+	current := elems(r[m.Column])
+	values := elems(tx.resolve(decodeValue(m.Value)))
+	switch m.Mutator {
+	case "insert":
+		for _, v := range values {
+			if !containsValue(current, v) {
+				current = append(current, v)
+			}
+		}
+	case "delete":
+		var kept []interface{}
+		for _, v := range current {
+			if !containsValue(values, v) {
+				kept = append(kept, v)
+			}
+		}
+		current = kept
+	case "+=":
+		sum, _ := r[m.Column].(float64)
+		for _, v := range values {
+			n, ok := v.(float64)
+			if !ok {
+				return &opError{"domain error", fmt.Sprintf("cannot add %v to %s", v, m.Column)}
+			}
+			sum += n
+		}
+		r[m.Column] = sum
+		return nil
+	default:
+		return &opError{"not supported", "mutator " + m.Mutator}
+	}
+	r[m.Column] = setOf(current...)
+	return nil
+}
+
+func (tx *transaction) matches(uuid string, r row, where []ovsdb.Condition) bool {
+	for _, c := range where {
+		var actual interface{}
+		if c.Column == "_uuid" {
+			actual = ref(uuid)
+		} else {
+			actual = r[c.Column]
+		}
+		want := tx.resolve(decodeValue(c.Value))
+		switch c.Function {
+		case "==":
+			if !sameValue(actual, want) {
+				return false
+			}
+		case "!=":
+			if sameValue(actual, want) {
+				return false
+			}
+		case "includes":
+			for _, v := range elems(want) {
+				if !containsValue(elems(actual), v) {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// resolve replaces named-uuid references with the uuids assigned in this transaction
+func (tx *transaction) resolve(v interface{}) interface{} {
+	switch val := v.(type) {
+	case namedRef:
+		return ref(tx.named[string(val)])
+	case set:
+		out := make(set, len(val))
+		for i, e := range val {
+			out[i] = tx.resolve(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for k, e := range val {
+			out[k] = tx.resolve(e)
+		}
+		return out
+	}
+	return v
+}
+
+func (tx *transaction) commit() *opError {
+	for _, table := range tx.tables {
+		for _, r := range table {
+			for col, val := range r {
+				r[col] = tx.resolve(val)
+			}
+		}
+	}
+
+	// Garbage collect rows of non-root tables nobody references
+	for {
+		referenced := tx.references()
+		removed := false
+		for name, table := range tx.tables {
+			if rootTables[name] {
+				continue
+			}
+			for uuid := range table {
+				if !referenced[uuid] {
+					delete(table, uuid)
+					removed = true
+				}
+			}
+		}
+		if !removed {
+			break
+		}
+	}
+
+	exists := make(map[string]bool)
+	for _, table := range tx.tables {
+		for uuid := range table {
+			exists[uuid] = true
+		}
+	}
+	for uuid := range tx.references() {
+		if !exists[uuid] {
+			return &opError{"referential integrity violation", "reference to missing row " + uuid}
+		}
+	}
+
+	for name := range nameIndexed {
+		seen := make(map[string]bool)
+		for _, r := range tx.tables[name] {
+			n, _ := r["name"].(string)
+			if seen[n] {
+				return &opError{"constraint violation", fmt.Sprintf("duplicate %s name %q", name, n)}
+			}
+			seen[n] = true
+		}
+	}
+	return nil
+}
+
+func (tx *transaction) references() map[string]bool {
+	refs := make(map[string]bool)
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch val := v.(type) {
+		case ref:
+			refs[string(val)] = true
+		case set:
+			for _, e := range val {
+				walk(e)
+			}
+		case map[string]interface{}:
+			for _, e := range val {
+				walk(e)
+			}
+		}
+	}
+	for _, table := range tx.tables {
+		for _, r := range table {
+			for _, val := range r {
+				walk(val)
+			}
+		}
+	}
+	return refs
+}
+
+func (s *Server) cloneTables() map[string]map[string]row {
+	clone := make(map[string]map[string]row)
+	for name, table := range s.tables {
+		clone[name] = make(map[string]row)
+		for uuid, r := range table {
+			newRow := row{}
+			for col, val := range r {
+				newRow[col] = val
+			}
+			clone[name][uuid] = newRow
+		}
+	}
+	return clone
+}
+
+// Values are stored decoded: ref and namedRef for uuids, a []interface{} tagged
+// set for sets, map[string]interface{} for maps, atoms as decoded by encoding/json
+
+type ref string
+type namedRef string
+
+type set []interface{}
+
+func setOf(values ...interface{}) set {
+	return set(values)
+}
+
+func decodeRow(raw map[string]json.RawMessage) row {
+	r := row{}
+	for col, val := range raw {
+		var v interface{}
+		json.Unmarshal(val, &v)
+		r[col] = decodeValue(v)
+	}
+	return r
+}
+
+func decodeValue(v interface{}) interface{} {
+	pair, ok := v.([]interface{})
+	if !ok || len(pair) != 2 {
+		return v
+	}
+	switch pair[0] {
+	case "uuid":
+		return ref(pair[1].(string))
+	case "named-uuid":
+		return namedRef(pair[1].(string))
+	case "set":
+		var out set
+		for _, e := range pair[1].([]interface{}) {
+			out = append(out, decodeValue(e))
+		}
+		return out
+	case "map":
+		out := make(map[string]interface{})
+		for _, p := range pair[1].([]interface{}) {
+			kv := p.([]interface{})
+			out[kv[0].(string)] = decodeValue(kv[1])
+		}
+		return out
+	}
+	return v
+}
+
+func encodeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case ref:
+		return []interface{}{"uuid", string(val)}
+	case set:
+		if len(val) == 1 {
+			return encodeValue(val[0])
+		}
+		out := []interface{}{}
+		for _, e := range val {
+			out = append(out, encodeValue(e))
+		}
+		return []interface{}{"set", out}
+	case map[string]interface{}:
+		pairs := []interface{}{}
+		for _, k := range sortedKeys(val) {
+			pairs = append(pairs, []interface{}{k, encodeValue(val[k])})
+		}
+		return []interface{}{"map", pairs}
+	}
+	return v
+}
+
+func (s *Server) exportRow(uuid string, r row) ovsdb.Row {
+	out := ovsdb.Row{"_uuid": []interface{}{"uuid", uuid}}
+	for col, val := range r {
+		out[col] = encodeValue(val)
+	}
+	return out
+}
+
+// elems returns the elements of a set, or the value itself as a one element set
+func elems(v interface{}) []interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case set:
+		return []interface{}(val)
+	}
+	return []interface{}{v}
+}
+
+func sameValue(a, b interface{}) bool {
+	ea, eb := elems(a), elems(b)
+	if len(ea) != len(eb) {
+		return false
+	}
+	for _, v := range ea {
+		if !containsValue(eb, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, e := range values {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
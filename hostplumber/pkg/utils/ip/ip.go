@@ -1,7 +1,13 @@
 package ip
 
 import (
+	"fmt"
+	"strings"
+
+	sysctlutils "hostplumber/pkg/utils/sysctl"
+
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 func GetIpv4Cidr(ifName string) (*[]string, error) {
@@ -31,7 +37,7 @@ func SetIpv4Cidr(ifName string, ipCidr string) error {
 		return err
 	}
 	if err := netlink.AddrReplace(ifLink, addr); err != nil {
-		return nil
+		return fmt.Errorf("failed to add %s to %s: %w", ipCidr, ifName, err)
 	}
 	return nil
 }
@@ -67,19 +73,21 @@ func GetIpv6Cidr(ifName string) (*[]string, error) {
 	return ipList, nil
 }
 
-// TODO: This is identical to the v4 function for now
-// Keep incase v4 vs v6 differences arise, or remove?
+// SetIpv6Cidr adds the address, enabling IPv6 on the interface if needed
 func SetIpv6Cidr(ifName string, ipCidr string) error {
 	ifLink, err := netlink.LinkByName(ifName)
 	if err != nil {
 		return err
 	}
+	if err := EnableIpv6(ifName); err != nil {
+		return err
+	}
 	addr, err := netlink.ParseAddr(ipCidr)
 	if err != nil {
 		return err
 	}
 	if err := netlink.AddrReplace(ifLink, addr); err != nil {
-		return nil
+		return fmt.Errorf("failed to add %s to %s: %w", ipCidr, ifName, err)
 	}
 	return nil
 }
@@ -98,3 +106,128 @@ func DelIpv6Cidr(ifName string, ipCidr string) error {
 	}
 	return nil
 }
+
+// Ipv6Addrs are the IPv6 addresses of an interface
+type Ipv6Addrs struct {
+	// Static are the global addresses configured by hand or by hostplumber
+	Static []string
+	// Dynamic are the global addresses from SLAAC or DHCPv6, which expire
+	Dynamic   []string
+	LinkLocal []string
+}
+
+// Global returns the static and dynamic global addresses
+func (a *Ipv6Addrs) Global() []string {
+	return append(append([]string{}, a.Static...), a.Dynamic...)
+}
+
+// GetIpv6Addrs returns the IPv6 addresses of an interface by kind
+func GetIpv6Addrs(ifName string) (*Ipv6Addrs, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+	if err != nil {
+		return nil, err
+	}
+	return ipv6AddrsOf(addrs), nil
+}
+
+func ipv6AddrsOf(addrs []netlink.Addr) *Ipv6Addrs {
+	a := &Ipv6Addrs{}
+	for _, addr := range addrs {
+		switch {
+		case addr.IP.IsLinkLocalUnicast():
+			a.LinkLocal = append(a.LinkLocal, addr.IPNet.String())
+		case addr.Flags&unix.IFA_F_PERMANENT != 0:
+			a.Static = append(a.Static, addr.IPNet.String())
+		default:
+			a.Dynamic = append(a.Dynamic, addr.IPNet.String())
+		}
+	}
+	return a
+}
+
+// MoveIpv6Cidr moves a static address to another interface. The address
+// was in use on the same link, so it skips duplicate address detection and
+// is usable right away.
+func MoveIpv6Cidr(from, to string, ipCidr string) error {
+	toLink, err := netlink.LinkByName(to)
+	if err != nil {
+		return err
+	}
+	addr, err := netlink.ParseAddr(ipCidr)
+	if err != nil {
+		return err
+	}
+	if err := DelIpv6Cidr(from, ipCidr); err != nil {
+		return err
+	}
+	if err := EnableIpv6(to); err != nil {
+		return err
+	}
+	addr.Flags = unix.IFA_F_NODAD
+	if err := netlink.AddrReplace(toLink, addr); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %w", ipCidr, to, err)
+	}
+	return nil
+}
+
+// ipv6Sysctl returns the per interface IPv6 sysctl, interface names with
+// dots like VLANs are written with slashes
+func ipv6Sysctl(ifName, name string) string {
+	return "net.ipv6.conf." + strings.ReplaceAll(ifName, ".", "/") + "." + name
+}
+
+// EnableIpv6 enables IPv6 on an interface, which new interfaces like VLANs
+// do not have when the host disables it by default
+func EnableIpv6(ifName string) error {
+	return sysctlutils.Set(ipv6Sysctl(ifName, "disable_ipv6"), "0")
+}
+
+// GetIpv6Autoconf returns whether the interface configures addresses from
+// router advertisements
+func GetIpv6Autoconf(ifName string) (bool, error) {
+	acceptRa, err := sysctlutils.Get(ipv6Sysctl(ifName, "accept_ra"))
+	if err != nil {
+		return false, err
+	}
+	autoconf, err := sysctlutils.Get(ipv6Sysctl(ifName, "autoconf"))
+	if err != nil {
+		return false, err
+	}
+	return acceptRa != "0" && autoconf != "0", nil
+}
+
+// SetIpv6Autoconf enables or disables SLAAC. accept_ra 2 accepts router
+// advertisements even when the host forwards, as Kubernetes nodes do.
+// Disabling it removes the addresses SLAAC configured.
+func SetIpv6Autoconf(ifName string, enabled bool) error {
+	acceptRa, autoconf := "0", "0"
+	if enabled {
+		acceptRa, autoconf = "2", "1"
+		if err := EnableIpv6(ifName); err != nil {
+			return err
+		}
+	}
+	if err := sysctlutils.Set(ipv6Sysctl(ifName, "accept_ra"), acceptRa); err != nil {
+		return err
+	}
+	if err := sysctlutils.Set(ipv6Sysctl(ifName, "autoconf"), autoconf); err != nil {
+		return err
+	}
+	if enabled {
+		return nil
+	}
+	addrs, err := GetIpv6Addrs(ifName)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs.Dynamic {
+		if err := DelIpv6Cidr(ifName, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package ip
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestIpv6AddrsOf(t *testing.T) {
+	parse := func(cidr string, flags int) netlink.Addr {
+		addr, err := netlink.ParseAddr(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr.Flags = flags
+		return *addr
+	}
+	addrs := ipv6AddrsOf([]netlink.Addr{
+		parse("2001:db8::5/64", unix.IFA_F_PERMANENT),
+		parse("2001:db8::a00:27ff:fe4e:66a1/64", unix.IFA_F_MANAGETEMPADDR),
+		parse("fe80::a00:27ff:fe4e:66a1/64", unix.IFA_F_PERMANENT),
+	})
+	want := &Ipv6Addrs{
+		Static:    []string{"2001:db8::5/64"},
+		Dynamic:   []string{"2001:db8::a00:27ff:fe4e:66a1/64"},
+		LinkLocal: []string{"fe80::a00:27ff:fe4e:66a1/64"},
+	}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Errorf("got %+v, want %+v", addrs, want)
+	}
+	if global := addrs.Global(); !reflect.DeepEqual(global, []string{"2001:db8::5/64", "2001:db8::a00:27ff:fe4e:66a1/64"}) {
+		t.Errorf("got global %q", global)
+	}
+}
+
+func TestIpv6Sysctl(t *testing.T) {
+	if got := ipv6Sysctl("eth0.100", "accept_ra"); got != "net.ipv6.conf.eth0/100.accept_ra" {
+		t.Errorf("got %q", got)
+	}
+}
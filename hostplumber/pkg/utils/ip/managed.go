@@ -0,0 +1,187 @@
+package ip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"hostplumber/pkg/consts"
+)
+
+// StateDir holds the per template routes and rules on the host, so which ones
+// hostplumber added is known after the pod restarts
+var StateDir = consts.HostStateDir
+
+// managed is a route or rule a template configures. Created is set when
+// hostplumber added it, rather than finding it on the host. Only created
+// routes and rules are deleted.
+type managed[T any] struct {
+	Spec    T    `json:"spec"`
+	Created bool `json:"created"`
+}
+
+func readManaged[T any](templateName, kind string) ([]managed[T], error) {
+	data, err := ioutil.ReadFile(filepath.Join(StateDir, templateName, kind))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read saved %s of template %s: %w", kind, templateName, err)
+	}
+	var entries []managed[T]
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid saved %s of template %s: %w", kind, templateName, err)
+	}
+	return entries, nil
+}
+
+func saveManaged[T any](templateName, kind string, entries []managed[T]) error {
+	dir := filepath.Join(StateDir, templateName)
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []managed[T]{}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, kind), data, 0644)
+}
+
+// applyManaged adds every wanted entry, saves them, then deletes the created
+// entries the template no longer wants. Entries are identified by key, an
+// entry keeps the Created flag from when it was first added. If adding fails,
+// the previous entries are kept in the saved state so a later apply can
+// remove them.
+func applyManaged[T any](templateName, kind string, want []T, key func(T) string,
+	add func(T) (bool, error), del func(T) error) error {
+	old, err := readManaged[T](templateName, kind)
+	if err != nil {
+		return err
+	}
+	oldByKey := make(map[string]managed[T])
+	for _, entry := range old {
+		oldByKey[key(entry.Spec)] = entry
+	}
+
+	var applied []managed[T]
+	wanted := make(map[string]bool)
+	for _, spec := range want {
+		created, err := add(spec)
+		if err != nil {
+			// Keep tracking what was there before as well
+			for _, entry := range old {
+				if !wanted[key(entry.Spec)] {
+					applied = append(applied, entry)
+				}
+			}
+			if saveErr := saveManaged(templateName, kind, applied); saveErr != nil {
+				fmt.Printf("Failed to save %s of template %s: %v\n", kind, templateName, saveErr)
+			}
+			return err
+		}
+		k := key(spec)
+		wanted[k] = true
+		applied = append(applied, managed[T]{Spec: spec, Created: created || oldByKey[k].Created})
+	}
+	if err := saveManaged(templateName, kind, applied); err != nil {
+		return err
+	}
+
+	for _, entry := range old {
+		if wanted[key(entry.Spec)] || !entry.Created {
+			continue
+		}
+		fmt.Printf("Deleting %s %v no longer in template %s\n", kind, entry.Spec, templateName)
+		if err := del(entry.Spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planManaged returns what applyManaged would change, without changing
+// anything: the wanted entries exists does not find, and the created entries
+// the template no longer wants
+func planManaged[T any](templateName, kind string, want []T, key func(T) string,
+	exists func(T) (bool, error)) ([]T, []T, error) {
+	old, err := readManaged[T](templateName, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	var add, del []T
+	wanted := make(map[string]bool)
+	for _, spec := range want {
+		wanted[key(spec)] = true
+		found, err := exists(spec)
+		if err != nil {
+			return add, del, err
+		}
+		if !found {
+			add = append(add, spec)
+		}
+	}
+	for _, entry := range old {
+		if !wanted[key(entry.Spec)] && entry.Created {
+			del = append(del, entry.Spec)
+		}
+	}
+	return add, del, nil
+}
+
+// HasManagedRoutes returns whether the template has routes or rules saved
+func HasManagedRoutes(templateName string) (bool, error) {
+	routes, err := readManaged[Route](templateName, "routes")
+	if err != nil {
+		return true, err
+	}
+	rules, err := readManaged[Rule](templateName, "rules")
+	if err != nil {
+		return true, err
+	}
+	return len(routes) > 0 || len(rules) > 0, nil
+}
+
+// ApplyRoutes adds or replaces the routes of a template, and deletes the ones
+// it added before and no longer lists
+func ApplyRoutes(templateName string, routes []Route) error {
+	return applyManaged(templateName, "routes", routes, Route.key, ReplaceRoute, DelRoute)
+}
+
+// ApplyRules adds the rules of a template, and deletes the ones it added
+// before and no longer lists
+func ApplyRules(templateName string, rules []Rule) error {
+	return applyManaged(templateName, "rules", rules, Rule.String, AddRule, DelRule)
+}
+
+// PlanRoutes returns the routes ApplyRoutes would add and delete, without
+// changing anything
+func PlanRoutes(templateName string, routes []Route) ([]string, error) {
+	add, del, err := planManaged(templateName, "routes", routes, Route.key, routeExists)
+	var changes []string
+	for _, r := range add {
+		changes = append(changes, fmt.Sprintf("add route %s", r))
+	}
+	for _, r := range del {
+		changes = append(changes, fmt.Sprintf("delete route %s", r))
+	}
+	return changes, err
+}
+
+// PlanRules returns the rules ApplyRules would add and delete, without
+// changing anything
+func PlanRules(templateName string, rules []Rule) ([]string, error) {
+	add, del, err := planManaged(templateName, "rules", rules, Rule.String, ruleExists)
+	var changes []string
+	for _, r := range add {
+		changes = append(changes, fmt.Sprintf("add rule %s", r))
+	}
+	for _, r := range del {
+		changes = append(changes, fmt.Sprintf("delete rule %s", r))
+	}
+	return changes, err
+}
@@ -0,0 +1,135 @@
+package ip
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fakeHost records the entries added and deleted by applyManaged
+type fakeHost struct {
+	entries map[string]bool
+	failOn  string
+}
+
+func (h *fakeHost) add(s string) (bool, error) {
+	if s == h.failOn {
+		return false, fmt.Errorf("failed to add %s", s)
+	}
+	created := !h.entries[s]
+	h.entries[s] = true
+	return created, nil
+}
+
+func (h *fakeHost) del(s string) error {
+	delete(h.entries, s)
+	return nil
+}
+
+func (h *fakeHost) apply(t *testing.T, want ...string) error {
+	t.Helper()
+	identity := func(s string) string { return s }
+	return applyManaged("tmpl", "routes", want, identity, h.add, h.del)
+}
+
+func (h *fakeHost) list() []string {
+	var entries []string
+	for entry := range h.entries {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+func TestApplyManaged(t *testing.T) {
+	StateDir = t.TempDir()
+	host := &fakeHost{entries: map[string]bool{"existing": true}}
+
+	if err := host.apply(t, "a", "b", "existing"); err != nil {
+		t.Fatal(err)
+	}
+	// b is dropped, a is applied again and must still be deleted later
+	if err := host.apply(t, "a", "existing"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := host.list(), []string{"a", "existing"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("host has %v, want %v", got, want)
+	}
+
+	// Only the entries hostplumber added are deleted
+	if err := host.apply(t); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := host.list(), []string{"existing"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("host has %v, want %v", got, want)
+	}
+}
+
+func TestApplyManagedFailure(t *testing.T) {
+	StateDir = t.TempDir()
+	host := &fakeHost{entries: map[string]bool{}}
+	if err := host.apply(t, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	host.failOn = "c"
+	if err := host.apply(t, "c"); err == nil {
+		t.Fatal("expected an error")
+	}
+	host.failOn = ""
+	// a and b are still tracked, and removed once applying succeeds
+	if err := host.apply(t); err != nil {
+		t.Fatal(err)
+	}
+	if got := host.list(); len(got) != 0 {
+		t.Errorf("host still has %v", got)
+	}
+}
+
+func TestPlanManaged(t *testing.T) {
+	StateDir = t.TempDir()
+	host := &fakeHost{entries: map[string]bool{"existing": true}}
+	if err := host.apply(t, "a", "b", "existing"); err != nil {
+		t.Fatal(err)
+	}
+	delete(host.entries, "a")
+
+	identity := func(s string) string { return s }
+	exists := func(s string) (bool, error) { return host.entries[s], nil }
+	add, del, err := planManaged("tmpl", "routes", []string{"a", "c"}, identity, exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a is missing again, existing was not added by hostplumber
+	if want := []string{"a", "c"}; !reflect.DeepEqual(add, want) {
+		t.Errorf("add %v, want %v", add, want)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(del, want) {
+		t.Errorf("delete %v, want %v", del, want)
+	}
+	if got := host.list(); !reflect.DeepEqual(got, []string{"b", "existing"}) {
+		t.Errorf("planning changed the host: %v", got)
+	}
+}
+
+func TestRouteAndRuleStrings(t *testing.T) {
+	route := Route{Dst: "10.1.0.0/16", Gw: "10.0.0.1", Dev: "eth1", Table: 100, Metric: 10}
+	if got := route.String(); got != "10.1.0.0/16 via 10.0.0.1 dev eth1 table 100 metric 10" {
+		t.Errorf("route = %q", got)
+	}
+	if route.key() != (Route{Dst: "10.1.0.0/16", Gw: "10.0.0.2", Table: 100, Metric: 10}).key() {
+		t.Errorf("routes with the same destination, table and metric have different keys")
+	}
+	if (Route{Dst: "default", Gw: "fd00::1"}).family() != (Route{Dst: "::/0"}).family() {
+		t.Errorf("IPv6 default route is not IPv6")
+	}
+
+	rule := Rule{Priority: 100, From: "10.0.0.0/24", Fwmark: 16, Table: 100}
+	if got := rule.String(); got != "100: from 10.0.0.0/24 fwmark 0x10 lookup 100" {
+		t.Errorf("rule = %q", got)
+	}
+	if _, err := (Rule{Priority: 100, From: "10.0.0.0/24"}).toNetlink(); err == nil {
+		t.Errorf("rule without a table accepted")
+	}
+}
@@ -0,0 +1,312 @@
+package ip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Migration is what moved from an interface to the OVS bridge it was added
+// to, so it moves back when the bridge or the port is deleted
+type Migration struct {
+	Interface string `json:"interface"`
+	// Port is the OVS port of the interface, the interface itself or a bond
+	Port string   `json:"port"`
+	IPv4 []string `json:"ipv4,omitempty"`
+	// IPv6 are the static addresses, Autoconf is set when SLAAC moved
+	IPv6     []string `json:"ipv6,omitempty"`
+	Autoconf bool     `json:"autoconf,omitempty"`
+	// Routes are the routes of the interface, with it as their dev
+	Routes []Route `json:"routes,omitempty"`
+}
+
+func (m Migration) empty() bool {
+	return len(m.IPv4) == 0 && len(m.IPv6) == 0 && !m.Autoconf && len(m.Routes) == 0
+}
+
+func migrationFile(bridge string) string {
+	return filepath.Join(StateDir, "migrated", bridge)
+}
+
+// GetMigrations returns what moved to a bridge from the interfaces added to it
+func GetMigrations(bridge string) ([]Migration, error) {
+	data, err := ioutil.ReadFile(migrationFile(bridge))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var migrations []Migration
+	if err := json.Unmarshal(data, &migrations); err != nil {
+		return nil, fmt.Errorf("invalid saved migrations of bridge %s: %w", bridge, err)
+	}
+	return migrations, nil
+}
+
+func saveMigrations(bridge string, migrations []Migration) error {
+	if len(migrations) == 0 {
+		if err := os.Remove(migrationFile(bridge)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(migrationFile(bridge)), 0766); err != nil {
+		return err
+	}
+	data, err := json.Marshal(migrations)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(migrationFile(bridge), data, 0644)
+}
+
+// MigrateToBridge moves the addresses and routes of an interface added to an
+// OVS bridge to the bridge: IPv4 and static IPv6 addresses, SLAAC, and the
+// routes through the interface with their metric, source and table. Routes the
+// kernel adds for the addresses, and the ones from router advertisements, come
+// back on the bridge by themselves. If any step fails, everything moves back
+// to the interface. What moved is saved so RestoreMigrated can undo it.
+func MigrateToBridge(ifName, port, bridge string) (*Migration, error) {
+	m, err := MigrationOf(ifName, port)
+	if err != nil {
+		return nil, err
+	}
+	if m.empty() {
+		return m, nil
+	}
+
+	migrations, err := GetMigrations(bridge)
+	if err != nil {
+		return nil, err
+	}
+	migrations = append(withoutMigration(migrations, ifName), *m)
+	if err := saveMigrations(bridge, migrations); err != nil {
+		return nil, err
+	}
+	if err := migrate(*m, bridge); err != nil {
+		fmt.Printf("Failed to move addresses and routes of %s to %s, moving them back: %v\n", ifName, bridge, err)
+		if restoreErr := restore(*m, bridge); restoreErr != nil {
+			fmt.Printf("Failed to move addresses and routes back to %s: %v\n", ifName, restoreErr)
+		}
+		if saveErr := saveMigrations(bridge, withoutMigration(migrations, ifName)); saveErr != nil {
+			fmt.Printf("Failed to save migrations of bridge %s: %v\n", bridge, saveErr)
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// MigrationOf returns what MigrateToBridge moves from an interface
+func MigrationOf(ifName, port string) (*Migration, error) {
+	m := &Migration{Interface: ifName, Port: port}
+	ipv4Addrs, err := GetIpv4Cidr(ifName)
+	if err != nil {
+		return nil, err
+	}
+	m.IPv4 = *ipv4Addrs
+	ipv6Addrs, err := GetIpv6Addrs(ifName)
+	if err != nil {
+		return nil, err
+	}
+	m.IPv6 = ipv6Addrs.Static
+	if autoconf, err := GetIpv6Autoconf(ifName); err == nil {
+		// Fails when IPv6 is disabled on the host
+		m.Autoconf = autoconf
+	}
+	// Listed before the addresses move, the kernel deletes the IPv4 routes
+	// through the interface with its last address
+	if m.Routes, err = movableRoutes(ifName); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func migrate(m Migration, bridge string) error {
+	for _, addr := range m.IPv4 {
+		fmt.Printf("Moving %s from %s to %s\n", addr, m.Interface, bridge)
+		if err := DelIpv4Cidr(m.Interface, addr); err != nil {
+			return err
+		}
+		if err := SetIpv4Cidr(bridge, addr); err != nil {
+			return err
+		}
+	}
+	for _, addr := range m.IPv6 {
+		fmt.Printf("Moving %s from %s to %s\n", addr, m.Interface, bridge)
+		if err := MoveIpv6Cidr(m.Interface, bridge, addr); err != nil {
+			return err
+		}
+	}
+	if m.Autoconf {
+		if err := SetIpv6Autoconf(m.Interface, false); err != nil {
+			return err
+		}
+		if err := SetIpv6Autoconf(bridge, true); err != nil {
+			return err
+		}
+	}
+	return moveRoutes(m.Routes, m.Interface, bridge)
+}
+
+// moveRoutes deletes the routes from one device and adds them on another
+func moveRoutes(routes []Route, from, to string) error {
+	for _, r := range routes {
+		r.Dev = from
+		if err := DelRoute(r); err != nil {
+			return err
+		}
+	}
+	for _, r := range routes {
+		r.Dev = to
+		fmt.Printf("Moving route %s from %s\n", r, from)
+		if _, err := ReplaceRoute(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore moves what migrated back to the interface. The bridge may be gone
+// already, with its addresses and routes. It goes on after an error, so as
+// much as possible moves back.
+func restore(m Migration, bridge string) error {
+	var errs []error
+	_, err := netlink.LinkByName(bridge)
+	bridgeExists := err == nil
+	if m.Autoconf {
+		if bridgeExists {
+			errs = append(errs, SetIpv6Autoconf(bridge, false))
+		}
+		errs = append(errs, SetIpv6Autoconf(m.Interface, true))
+	}
+	for _, addr := range m.IPv4 {
+		if bridgeExists {
+			errs = append(errs, DelIpv4Cidr(bridge, addr))
+		}
+		errs = append(errs, SetIpv4Cidr(m.Interface, addr))
+	}
+	for _, addr := range m.IPv6 {
+		if bridgeExists {
+			errs = append(errs, MoveIpv6Cidr(bridge, m.Interface, addr))
+		} else {
+			errs = append(errs, SetIpv6Cidr(m.Interface, addr))
+		}
+	}
+	for _, r := range m.Routes {
+		if bridgeExists {
+			bridgeRoute := r
+			bridgeRoute.Dev = bridge
+			errs = append(errs, DelRoute(bridgeRoute))
+		}
+		fmt.Printf("Restoring route %s\n", r)
+		_, err := ReplaceRoute(r)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// RestoreMigrated moves the addresses and routes that migrated to a bridge
+// back to their interfaces, as when the bridge is deleted. With ports, only
+// the interfaces of these ports move back.
+func RestoreMigrated(bridge string, ports ...string) error {
+	migrations, err := GetMigrations(bridge)
+	if err != nil {
+		return err
+	}
+	var kept []Migration
+	var errs []error
+	for _, m := range migrations {
+		if len(ports) > 0 && !contains(ports, m.Port) {
+			kept = append(kept, m)
+			continue
+		}
+		fmt.Printf("Moving addresses and routes of %s back from %s\n", m.Interface, bridge)
+		if err := restore(m, bridge); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s: %w", m.Interface, err))
+		}
+	}
+	if err := saveMigrations(bridge, kept); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func withoutMigration(migrations []Migration, ifName string) []Migration {
+	var kept []Migration
+	for _, m := range migrations {
+		if m.Interface != ifName {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// movableRoutes returns the routes through an interface in every table but
+// the local one, except the ones the kernel and router advertisements add
+func movableRoutes(ifName string) ([]Route, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		filter := &netlink.Route{LinkIndex: link.Attrs().Index, Table: unix.RT_TABLE_UNSPEC}
+		list, err := netlink.RouteListFiltered(family, filter, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range list {
+			if r, ok := routeOf(route, family, ifName); ok {
+				routes = append(routes, r)
+			}
+		}
+	}
+	return routes, nil
+}
+
+// routeOf returns a route as a Route, and whether it is one to move
+func routeOf(route netlink.Route, family int, ifName string) (Route, bool) {
+	if route.Table == unix.RT_TABLE_LOCAL || route.Type != unix.RTN_UNICAST || len(route.MultiPath) > 0 {
+		return Route{}, false
+	}
+	if route.Protocol == unix.RTPROT_KERNEL || route.Protocol == unix.RTPROT_RA {
+		return Route{}, false
+	}
+	r := Route{Dev: ifName, Metric: route.Priority}
+	switch {
+	case route.Dst != nil:
+		r.Dst = route.Dst.String()
+	case family == netlink.FAMILY_V6:
+		// Keeps the family of a default route without a gateway
+		r.Dst = (&net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}).String()
+	default:
+		r.Dst = "default"
+	}
+	if route.Gw != nil {
+		r.Gw = route.Gw.String()
+	}
+	if route.Src != nil {
+		r.Src = route.Src.String()
+	}
+	if route.Table != unix.RT_TABLE_MAIN {
+		r.Table = route.Table
+	}
+	return r, true
+}
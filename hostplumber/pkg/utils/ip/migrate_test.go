@@ -0,0 +1,97 @@
+package ip
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestRouteOf(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.20.0.0/16")
+	unicast := func(route netlink.Route) netlink.Route {
+		route.Type = unix.RTN_UNICAST
+		if route.Table == 0 {
+			route.Table = unix.RT_TABLE_MAIN
+		}
+		return route
+	}
+	tests := []struct {
+		name   string
+		route  netlink.Route
+		family int
+		want   *Route
+	}{{
+		name:   "default route",
+		route:  unicast(netlink.Route{Gw: net.ParseIP("10.0.0.1"), Priority: 100, Src: net.ParseIP("10.0.0.5"), Protocol: unix.RTPROT_DHCP}),
+		family: netlink.FAMILY_V4,
+		want:   &Route{Dst: "default", Gw: "10.0.0.1", Dev: "eth1", Src: "10.0.0.5", Metric: 100},
+	}, {
+		name:   "static route in a table",
+		route:  unicast(netlink.Route{Dst: dst, Gw: net.ParseIP("10.0.0.254"), Table: 100, Protocol: unix.RTPROT_BOOT}),
+		family: netlink.FAMILY_V4,
+		want:   &Route{Dst: "10.20.0.0/16", Gw: "10.0.0.254", Dev: "eth1", Table: 100},
+	}, {
+		name:   "IPv6 default route",
+		route:  unicast(netlink.Route{Gw: net.ParseIP("fe80::1"), Priority: 1024, Protocol: unix.RTPROT_BOOT}),
+		family: netlink.FAMILY_V6,
+		want:   &Route{Dst: "::/0", Gw: "fe80::1", Dev: "eth1", Metric: 1024},
+	}, {
+		name:   "route of an address",
+		route:  unicast(netlink.Route{Dst: dst, Protocol: unix.RTPROT_KERNEL}),
+		family: netlink.FAMILY_V4,
+	}, {
+		name:   "router advertisement",
+		route:  unicast(netlink.Route{Gw: net.ParseIP("fe80::1"), Protocol: unix.RTPROT_RA}),
+		family: netlink.FAMILY_V6,
+	}, {
+		name:   "local route",
+		route:  netlink.Route{Dst: dst, Table: unix.RT_TABLE_LOCAL, Type: unix.RTN_LOCAL},
+		family: netlink.FAMILY_V4,
+	}}
+	for _, tt := range tests {
+		got, ok := routeOf(tt.route, tt.family, "eth1")
+		if tt.want == nil {
+			if ok {
+				t.Errorf("%s: moved as %s", tt.name, got)
+			}
+			continue
+		}
+		if !ok || !reflect.DeepEqual(got, *tt.want) {
+			t.Errorf("%s: got %+v (%t), want %+v", tt.name, got, ok, *tt.want)
+		}
+	}
+}
+
+func TestRestoreMigratedPorts(t *testing.T) {
+	StateDir = t.TempDir()
+	migrations := []Migration{
+		{Interface: "eth1", Port: "bond-br-ex"},
+		{Interface: "eth2", Port: "bond-br-ex"},
+		{Interface: "eth3", Port: "eth3"},
+	}
+	if err := saveMigrations("hp-missing-br", migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreMigrated("hp-missing-br", "bond-br-ex"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetMigrations("hp-missing-br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, migrations[2:]) {
+		t.Errorf("left %+v, want %+v", got, migrations[2:])
+	}
+
+	// Restoring everything removes the saved migrations
+	if err := RestoreMigrated("hp-missing-br"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetMigrations("hp-missing-br"); err != nil || got != nil {
+		t.Errorf("left %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,173 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Route is a static route. Dst is a CIDR or "default", Table 0 is the main table.
+type Route struct {
+	Dst    string `json:"dst"`
+	Gw     string `json:"gw,omitempty"`
+	Dev    string `json:"dev,omitempty"`
+	Src    string `json:"src,omitempty"`
+	Table  int    `json:"table,omitempty"`
+	Metric int    `json:"metric,omitempty"`
+}
+
+// String is the route as ip route shows it
+func (r Route) String() string {
+	parts := []string{r.Dst}
+	if r.Gw != "" {
+		parts = append(parts, "via", r.Gw)
+	}
+	if r.Dev != "" {
+		parts = append(parts, "dev", r.Dev)
+	}
+	if r.Src != "" {
+		parts = append(parts, "src", r.Src)
+	}
+	if r.Table != 0 {
+		parts = append(parts, "table", strconv.Itoa(r.Table))
+	}
+	if r.Metric != 0 {
+		parts = append(parts, "metric", strconv.Itoa(r.Metric))
+	}
+	return strings.Join(parts, " ")
+}
+
+// key identifies the route in the kernel, which keeps one route per
+// destination, table and metric
+func (r Route) key() string {
+	return fmt.Sprintf("%s table %d metric %d", r.Dst, r.table(), r.Metric)
+}
+
+func (r Route) table() int {
+	if r.Table == 0 {
+		return unix.RT_TABLE_MAIN
+	}
+	return r.Table
+}
+
+// family is IPv6 if any address of the route is, IPv4 otherwise
+func (r Route) family() int {
+	for _, addr := range []string{r.Dst, r.Gw, r.Src} {
+		if strings.Contains(addr, ":") {
+			return netlink.FAMILY_V6
+		}
+	}
+	return netlink.FAMILY_V4
+}
+
+// dst is nil for an IPv4 default route. netlink takes the family from the
+// addresses of a route, so an IPv6 default route is ::/0.
+func (r Route) dst() (*net.IPNet, error) {
+	if r.Dst == "default" {
+		if r.family() == netlink.FAMILY_V6 {
+			return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, nil
+		}
+		return nil, nil
+	}
+	_, dst, err := net.ParseCIDR(r.Dst)
+	return dst, err
+}
+
+// toNetlink validates the route and resolves its device
+func (r Route) toNetlink() (*netlink.Route, error) {
+	route := &netlink.Route{
+		Table:    r.table(),
+		Priority: r.Metric,
+	}
+	dst, err := r.dst()
+	if err != nil {
+		return nil, fmt.Errorf("route %s: invalid dst: %w", r, err)
+	}
+	route.Dst = dst
+	if r.Gw == "" && r.Dev == "" {
+		return nil, fmt.Errorf("route %s needs a gw or a dev", r)
+	}
+	if r.Gw != "" {
+		if route.Gw = net.ParseIP(r.Gw); route.Gw == nil {
+			return nil, fmt.Errorf("route %s: invalid gw %q", r, r.Gw)
+		}
+	}
+	if r.Src != "" {
+		if route.Src = net.ParseIP(r.Src); route.Src == nil {
+			return nil, fmt.Errorf("route %s: invalid src %q", r, r.Src)
+		}
+	}
+	if r.Dev != "" {
+		link, err := netlink.LinkByName(r.Dev)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", r, err)
+		}
+		route.LinkIndex = link.Attrs().Index
+	}
+	if route.Gw == nil {
+		route.Scope = netlink.SCOPE_LINK
+	}
+	return route, nil
+}
+
+// routeExists returns whether the kernel has a route with the same
+// destination, table and metric
+func routeExists(r Route) (bool, error) {
+	filter := &netlink.Route{Table: r.table()}
+	if r.Dst != "default" {
+		if _, filter.Dst, _ = net.ParseCIDR(r.Dst); filter.Dst == nil {
+			return false, fmt.Errorf("route %s: invalid dst", r)
+		}
+	}
+	routes, err := netlink.RouteListFiltered(r.family(), filter, netlink.RT_FILTER_DST|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return false, err
+	}
+	for _, route := range routes {
+		if route.Priority == r.Metric {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReplaceRoute adds the route, or replaces the one with the same destination,
+// table and metric. It returns whether there was no such route before.
+func ReplaceRoute(r Route) (bool, error) {
+	route, err := r.toNetlink()
+	if err != nil {
+		return false, err
+	}
+	exists, err := routeExists(r)
+	if err != nil {
+		return false, err
+	}
+	if err := netlink.RouteReplace(route); err != nil {
+		return false, fmt.Errorf("failed to add route %s: %w", r, err)
+	}
+	return !exists, nil
+}
+
+// DelRoute deletes the route. A missing route, or one whose device is gone, is
+// not an error.
+func DelRoute(r Route) error {
+	dst, err := r.dst()
+	if err != nil {
+		return fmt.Errorf("route %s: invalid dst: %w", r, err)
+	}
+	route := &netlink.Route{
+		Dst:      dst,
+		Table:    r.table(),
+		Priority: r.Metric,
+	}
+	if err := netlink.RouteDel(route); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to delete route %s: %w", r, err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Rule is a policy routing rule. From and To are CIDRs, Fwmark 0 matches any mark.
+type Rule struct {
+	Priority int    `json:"priority"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Iif      string `json:"iif,omitempty"`
+	Oif      string `json:"oif,omitempty"`
+	Fwmark   int    `json:"fwmark,omitempty"`
+	Table    int    `json:"table"`
+}
+
+// String is the rule as ip rule shows it
+func (r Rule) String() string {
+	parts := []string{strconv.Itoa(r.Priority) + ":"}
+	from := r.From
+	if from == "" {
+		from = "all"
+	}
+	parts = append(parts, "from", from)
+	if r.To != "" {
+		parts = append(parts, "to", r.To)
+	}
+	if r.Iif != "" {
+		parts = append(parts, "iif", r.Iif)
+	}
+	if r.Oif != "" {
+		parts = append(parts, "oif", r.Oif)
+	}
+	if r.Fwmark != 0 {
+		parts = append(parts, "fwmark", fmt.Sprintf("%#x", r.Fwmark))
+	}
+	parts = append(parts, "lookup", strconv.Itoa(r.Table))
+	return strings.Join(parts, " ")
+}
+
+func (r Rule) family() int {
+	if strings.Contains(r.From, ":") || strings.Contains(r.To, ":") {
+		return netlink.FAMILY_V6
+	}
+	return netlink.FAMILY_V4
+}
+
+func (r Rule) toNetlink() (*netlink.Rule, error) {
+	if r.Table <= 0 {
+		return nil, fmt.Errorf("rule %s needs a table", r)
+	}
+	rule := netlink.NewRule()
+	rule.Family = r.family()
+	rule.Priority = r.Priority
+	rule.Table = r.Table
+	rule.IifName = r.Iif
+	rule.OifName = r.Oif
+	if r.Fwmark != 0 {
+		rule.Mark = r.Fwmark
+	}
+	var err error
+	if r.From != "" {
+		if _, rule.Src, err = net.ParseCIDR(r.From); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid from: %w", r, err)
+		}
+	}
+	if r.To != "" {
+		if _, rule.Dst, err = net.ParseCIDR(r.To); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid to: %w", r, err)
+		}
+	}
+	return rule, nil
+}
+
+// RuleFromNetlink converts a rule listed by netlink
+func RuleFromNetlink(rule netlink.Rule) Rule {
+	r := Rule{
+		Priority: rule.Priority,
+		Iif:      rule.IifName,
+		Oif:      rule.OifName,
+		Table:    rule.Table,
+	}
+	if rule.Src != nil {
+		r.From = rule.Src.String()
+	}
+	if rule.Dst != nil {
+		r.To = rule.Dst.String()
+	}
+	if rule.Mark > 0 {
+		r.Fwmark = rule.Mark
+	}
+	return r
+}
+
+// ruleExists returns whether the kernel has the same rule
+func ruleExists(r Rule) (bool, error) {
+	rules, err := netlink.RuleList(r.family())
+	if err != nil {
+		return false, err
+	}
+	for _, rule := range rules {
+		if RuleFromNetlink(rule) == r {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddRule adds the rule unless the kernel already has it. It returns whether
+// it added it.
+func AddRule(r Rule) (bool, error) {
+	rule, err := r.toNetlink()
+	if err != nil {
+		return false, err
+	}
+	exists, err := ruleExists(r)
+	if err != nil || exists {
+		return false, err
+	}
+	if err := netlink.RuleAdd(rule); err != nil {
+		return false, fmt.Errorf("failed to add rule %s: %w", r, err)
+	}
+	return true, nil
+}
+
+// DelRule deletes the rule. A missing rule is not an error.
+func DelRule(r Rule) error {
+	rule, err := r.toNetlink()
+	if err != nil {
+		return err
+	}
+	if err := netlink.RuleDel(rule); err != nil && !errors.Is(err, syscall.ENOENT) && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to delete rule %s: %w", r, err)
+	}
+	return nil
+}
+
+// ListRules returns the IPv4 and IPv6 rules of the host
+func ListRules() ([]Rule, error) {
+	var rules []Rule
+	for _, family := range []int{unix.AF_INET, unix.AF_INET6} {
+		list, err := netlink.RuleList(family)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range list {
+			rules = append(rules, RuleFromNetlink(rule))
+		}
+	}
+	return rules, nil
+}
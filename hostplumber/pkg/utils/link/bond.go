@@ -0,0 +1,260 @@
+package link
+
+import (
+	"fmt"
+	"hostplumber/pkg/consts"
+	"hostplumber/pkg/utils/persist"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+)
+
+const defaultMiimon = 100
+
+// Bond is a Linux bond to create with its members
+type Bond struct {
+	Name    string
+	Members []string
+	// Mode is 802.3ad, active-backup or balance-xor
+	Mode string
+	// Miimon is the MII monitoring interval in ms, a negative value means 100
+	Miimon int
+	// LacpRate is slow or fast, only for 802.3ad. Empty keeps the kernel default.
+	LacpRate string
+	// XmitHashPolicy is empty to keep the kernel default
+	XmitHashPolicy string
+}
+
+func (b Bond) miimon() int {
+	if b.Miimon < 0 {
+		return defaultMiimon
+	}
+	return b.Miimon
+}
+
+func (b Bond) validate() error {
+	if b.Name == "" || len(b.Members) == 0 {
+		return fmt.Errorf("bond needs a name and at least one member")
+	}
+	switch b.Mode {
+	case "802.3ad", "active-backup", "balance-xor":
+	default:
+		return fmt.Errorf("bond %s: unsupported mode %q", b.Name, b.Mode)
+	}
+	if b.LacpRate != "" {
+		if b.Mode != "802.3ad" {
+			return fmt.Errorf("bond %s: lacpRate only applies to 802.3ad bonds", b.Name)
+		}
+		if _, ok := netlink.StringToBondLacpRateMap[b.LacpRate]; !ok {
+			return fmt.Errorf("bond %s: invalid lacpRate %q", b.Name, b.LacpRate)
+		}
+	}
+	if b.XmitHashPolicy != "" {
+		if _, ok := netlink.StringToBondXmitHashPolicyMap[b.XmitHashPolicy]; !ok {
+			return fmt.Errorf("bond %s: invalid xmitHashPolicy %q", b.Name, b.XmitHashPolicy)
+		}
+	}
+	return nil
+}
+
+// CreateOrUpdateBond creates the bond, or converges an existing one: members
+// are enslaved and released, miimon and xmit_hash_policy are changed in place.
+// The kernel only changes the mode and lacp_rate of a bond without members, so
+// the bond is recreated when those differ.
+func CreateOrUpdateBond(b Bond) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(b.Name)
+	if err == nil {
+		current, isBond := link.(*netlink.Bond)
+		if !isBond {
+			return fmt.Errorf("interface %s already exists and is not a bond", b.Name)
+		}
+		if current.Mode.String() != b.Mode ||
+			(b.LacpRate != "" && current.LacpRate.String() != b.LacpRate) {
+			fmt.Printf("Recreating bond %s to change mode or lacp_rate\n", b.Name)
+			if err := deleteBondLink(b.Name); err != nil {
+				return err
+			}
+			link = nil
+		} else if err := updateBondOptions(b, current); err != nil {
+			return err
+		}
+	} else if _, notFound := err.(netlink.LinkNotFoundError); !notFound {
+		return err
+	} else {
+		link = nil
+	}
+
+	if link == nil {
+		bond := netlink.NewLinkBond(netlink.LinkAttrs{Name: b.Name})
+		bond.Mode = netlink.StringToBondMode(b.Mode)
+		bond.Miimon = b.miimon()
+		if b.LacpRate != "" {
+			bond.LacpRate = netlink.StringToBondLacpRate(b.LacpRate)
+		}
+		if b.XmitHashPolicy != "" {
+			bond.XmitHashPolicy = netlink.StringToBondXmitHashPolicy(b.XmitHashPolicy)
+		}
+		if err := netlink.LinkAdd(bond); err != nil {
+			return fmt.Errorf("failed to create bond %s: %w", b.Name, err)
+		}
+		if link, err = netlink.LinkByName(b.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := setBondMembers(b, link); err != nil {
+		return err
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set bond %s up: %w", b.Name, err)
+	}
+	return persist.Update(b.Name, func(iface *persist.Interface) {
+		iface.Kind = persist.KindBond
+		iface.BondMode = b.Mode
+		iface.Miimon = b.miimon()
+		iface.LacpRate = b.LacpRate
+		iface.XmitHashPolicy = b.XmitHashPolicy
+	})
+}
+
+func updateBondOptions(b Bond, current *netlink.Bond) error {
+	if current.Miimon != b.miimon() {
+		if err := writeBondingOption(b.Name, "miimon", strconv.Itoa(b.miimon())); err != nil {
+			return err
+		}
+	}
+	if b.XmitHashPolicy != "" && current.XmitHashPolicy.String() != b.XmitHashPolicy {
+		if err := writeBondingOption(b.Name, "xmit_hash_policy", b.XmitHashPolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBondingOption(bondName, option, value string) error {
+	optFile := filepath.Join(consts.SysClassNet, bondName, "bonding", option)
+	if err := ioutil.WriteFile(optFile, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to set %s=%s on bond %s: %w", option, value, bondName, err)
+	}
+	return nil
+}
+
+func setBondMembers(b Bond, bond netlink.Link) error {
+	current, err := masterPorts(bond)
+	if err != nil {
+		return err
+	}
+	enslaved := make(map[string]bool)
+	for _, member := range current {
+		name := member.Attrs().Name
+		if containsString(b.Members, name) {
+			enslaved[name] = true
+			continue
+		}
+		fmt.Printf("Releasing %s from bond %s\n", name, b.Name)
+		if err := releaseBondMember(member); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range b.Members {
+		if !enslaved[name] {
+			member, err := netlink.LinkByName(name)
+			if err != nil {
+				return fmt.Errorf("bond %s member %s: %w", b.Name, name, err)
+			}
+			if member.Attrs().MasterIndex != 0 {
+				return fmt.Errorf("bond %s member %s is already enslaved to another interface", b.Name, name)
+			}
+			// A link must be down to be enslaved
+			if err := netlink.LinkSetDown(member); err != nil {
+				return err
+			}
+			if err := netlink.LinkSetMasterByIndex(member, bond.Attrs().Index); err != nil {
+				return fmt.Errorf("failed to add %s to bond %s: %w", name, b.Name, err)
+			}
+			if err := netlink.LinkSetUp(member); err != nil {
+				return err
+			}
+		}
+		if err := persistMaster(name, b.Name, persist.KindBond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistMaster persists the bond or bridge an interface is enslaved to, or
+// that it is released with an empty master
+func persistMaster(name, master, masterKind string) error {
+	return persist.Update(name, func(iface *persist.Interface) {
+		iface.Master = master
+		iface.MasterKind = masterKind
+	})
+}
+
+func releaseBondMember(member netlink.Link) error {
+	name := member.Attrs().Name
+	if err := netlink.LinkSetNoMaster(member); err != nil {
+		return fmt.Errorf("failed to release %s from its bond: %w", name, err)
+	}
+	if err := netlink.LinkSetUp(member); err != nil {
+		return err
+	}
+	return persistMaster(name, "", "")
+}
+
+// DeleteBond releases the members and deletes the bond and what is persisted
+// of it. A missing bond is not an error.
+func DeleteBond(name string) error {
+	if err := deleteBondLink(name); err != nil {
+		return err
+	}
+	return persist.Delete(name)
+}
+
+func deleteBondLink(name string) error {
+	bond, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+			return nil
+		}
+		return err
+	}
+	members, err := masterPorts(bond)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := releaseBondMember(member); err != nil {
+			return err
+		}
+	}
+	if err := netlink.LinkDel(bond); err != nil {
+		return fmt.Errorf("failed to delete bond %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetManagedBonds returns the bonds saved for a template
+func GetManagedBonds(templateName string) ([]string, error) {
+	return getManagedLinks(templateName, "bonds")
+}
+
+// ReplaceManagedBonds saves the bonds a template now configures, and deletes
+// the ones it configured before and no longer does
+func ReplaceManagedBonds(templateName string, bonds []string) error {
+	return replaceManagedLinks(templateName, "bonds", bonds, DeleteBond)
+}
+
+// PlanManagedBonds returns the bonds CreateOrUpdateBond would create and
+// ReplaceManagedBonds would delete, without changing anything
+func PlanManagedBonds(templateName string, bonds []string) ([]string, []string, error) {
+	return planManagedLinks(templateName, "bonds", bonds)
+}
@@ -0,0 +1,24 @@
+package link
+
+import (
+	"testing"
+)
+
+func TestBondValidate(t *testing.T) {
+	valid := Bond{Name: "bond0", Members: []string{"eth1", "eth2"}, Mode: "802.3ad", Miimon: -1, LacpRate: "fast", XmitHashPolicy: "layer3+4"}
+	if err := valid.validate(); err != nil {
+		t.Errorf("valid bond rejected: %v", err)
+	}
+
+	for name, bond := range map[string]Bond{
+		"no members":        {Name: "bond0", Mode: "802.3ad"},
+		"unsupported mode":  {Name: "bond0", Members: []string{"eth1"}, Mode: "balance-rr"},
+		"lacp rate":         {Name: "bond0", Members: []string{"eth1"}, Mode: "active-backup", LacpRate: "fast"},
+		"invalid lacp rate": {Name: "bond0", Members: []string{"eth1"}, Mode: "802.3ad", LacpRate: "medium"},
+		"invalid hash":      {Name: "bond0", Members: []string{"eth1"}, Mode: "balance-xor", XmitHashPolicy: "layer4"},
+	} {
+		if err := bond.validate(); err == nil {
+			t.Errorf("%s: invalid bond accepted", name)
+		}
+	}
+}
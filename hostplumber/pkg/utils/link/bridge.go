@@ -0,0 +1,194 @@
+package link
+
+import (
+	"fmt"
+	"hostplumber/pkg/consts"
+	"hostplumber/pkg/utils/persist"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Bridge is a Linux bridge to create with its ports
+type Bridge struct {
+	Name          string
+	Ports         []string
+	Stp           bool
+	VlanFiltering bool
+}
+
+// CreateOrUpdateBridge creates the bridge, or converges an existing one: STP
+// and VLAN filtering are changed in place, listed ports are enslaved and the
+// ones no longer listed are released.
+func CreateOrUpdateBridge(b Bridge) error {
+	if b.Name == "" {
+		return fmt.Errorf("bridge needs a name")
+	}
+
+	link, err := netlink.LinkByName(b.Name)
+	if err != nil {
+		if _, notFound := err.(netlink.LinkNotFoundError); !notFound {
+			return err
+		}
+		vlanFiltering := b.VlanFiltering
+		bridge := &netlink.Bridge{
+			LinkAttrs:     netlink.LinkAttrs{Name: b.Name},
+			VlanFiltering: &vlanFiltering,
+		}
+		if err := netlink.LinkAdd(bridge); err != nil {
+			return fmt.Errorf("failed to create bridge %s: %w", b.Name, err)
+		}
+		if link, err = netlink.LinkByName(b.Name); err != nil {
+			return err
+		}
+	} else if _, isBridge := link.(*netlink.Bridge); !isBridge {
+		return fmt.Errorf("interface %s already exists and is not a bridge", b.Name)
+	}
+
+	if err := writeBridgeOption(b.Name, "stp_state", b.Stp); err != nil {
+		return err
+	}
+	if err := writeBridgeOption(b.Name, "vlan_filtering", b.VlanFiltering); err != nil {
+		return err
+	}
+	if err := setBridgePorts(b, link); err != nil {
+		return err
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set bridge %s up: %w", b.Name, err)
+	}
+	return persist.Update(b.Name, func(iface *persist.Interface) {
+		iface.Kind = persist.KindBridge
+		iface.Stp = b.Stp
+		iface.VlanFiltering = b.VlanFiltering
+	})
+}
+
+// writeBridgeOption sets a boolean bridge option through sysfs, which unlike
+// netlink can change it on an existing bridge
+func writeBridgeOption(bridgeName, option string, enabled bool) error {
+	optFile := filepath.Join(consts.SysClassNet, bridgeName, "bridge", option)
+	want := "0"
+	if enabled {
+		want = "1"
+	}
+	if current, err := ioutil.ReadFile(optFile); err == nil && strings.TrimSpace(string(current)) == want {
+		return nil
+	}
+	if err := ioutil.WriteFile(optFile, []byte(want), 0644); err != nil {
+		return fmt.Errorf("failed to set %s=%s on bridge %s: %w", option, want, bridgeName, err)
+	}
+	return nil
+}
+
+// masterPorts returns the links enslaved to a bond or bridge
+func masterPorts(master netlink.Link) ([]netlink.Link, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var ports []netlink.Link
+	for _, link := range links {
+		if link.Attrs().MasterIndex == master.Attrs().Index {
+			ports = append(ports, link)
+		}
+	}
+	return ports, nil
+}
+
+func setBridgePorts(b Bridge, bridge netlink.Link) error {
+	current, err := masterPorts(bridge)
+	if err != nil {
+		return err
+	}
+	enslaved := make(map[string]bool)
+	for _, port := range current {
+		name := port.Attrs().Name
+		if containsString(b.Ports, name) {
+			enslaved[name] = true
+			continue
+		}
+		fmt.Printf("Releasing %s from bridge %s\n", name, b.Name)
+		if err := releaseBridgePort(port); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range b.Ports {
+		if !enslaved[name] {
+			port, err := netlink.LinkByName(name)
+			if err != nil {
+				return fmt.Errorf("bridge %s port %s: %w", b.Name, name, err)
+			}
+			if port.Attrs().MasterIndex != 0 {
+				return fmt.Errorf("bridge %s port %s is already enslaved to another interface", b.Name, name)
+			}
+			if err := netlink.LinkSetMasterByIndex(port, bridge.Attrs().Index); err != nil {
+				return fmt.Errorf("failed to add %s to bridge %s: %w", name, b.Name, err)
+			}
+			if err := netlink.LinkSetUp(port); err != nil {
+				return err
+			}
+		}
+		// Also persisted for enslaved ports, the backend may have changed
+		if err := persistMaster(name, b.Name, persist.KindBridge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseBridgePort releases the port and persists it without the bridge.
+// The rest of what is persisted for it may belong to another section, so it
+// is kept.
+func releaseBridgePort(port netlink.Link) error {
+	name := port.Attrs().Name
+	if err := netlink.LinkSetNoMaster(port); err != nil {
+		return fmt.Errorf("failed to release %s from its bridge: %w", name, err)
+	}
+	return persistMaster(name, "", "")
+}
+
+// DeleteBridge releases the ports and deletes the bridge and what is persisted
+// of it. A missing bridge is not an error.
+func DeleteBridge(name string) error {
+	bridge, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+			return persist.Delete(name)
+		}
+		return err
+	}
+	ports, err := masterPorts(bridge)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		if err := releaseBridgePort(port); err != nil {
+			return err
+		}
+	}
+	if err := netlink.LinkDel(bridge); err != nil {
+		return fmt.Errorf("failed to delete bridge %s: %w", name, err)
+	}
+	return persist.Delete(name)
+}
+
+// GetManagedBridges returns the bridges saved for a template
+func GetManagedBridges(templateName string) ([]string, error) {
+	return getManagedLinks(templateName, "bridges")
+}
+
+// ReplaceManagedBridges saves the bridges a template now configures, and
+// deletes the ones it configured before and no longer does
+func ReplaceManagedBridges(templateName string, bridges []string) error {
+	return replaceManagedLinks(templateName, "bridges", bridges, DeleteBridge)
+}
+
+// PlanManagedBridges returns the bridges CreateOrUpdateBridge would create and
+// ReplaceManagedBridges would delete, without changing anything
+func PlanManagedBridges(templateName string, bridges []string) ([]string, []string, error) {
+	return planManagedLinks(templateName, "bridges", bridges)
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"hostplumber/pkg/consts"
+	"hostplumber/pkg/utils/persist"
 	"io/ioutil"
 	"net"
 	"os"
@@ -21,39 +22,20 @@ func checkIfExists(name string) bool {
 	return true
 }
 
-func createVlanIfFile(name string) error {
-	ifCfg := []string{
-		fmt.Sprintf("DEVICE=%s", name),
-		"BOOTPROTO=none",
-		"ONBOOT=yes",
-		"VLAN=yes",
-	}
-
-	ifCfgFile := filepath.Join(consts.RhelNetworkScripts, "ifcfg-"+name)
-	fd, err := os.OpenFile(ifCfgFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-	defer fd.Close()
-	if err != nil {
-		fmt.Printf("Failed to create ifcfg file for %s\n", name)
-		return err
-	}
-
-	writer := bufio.NewWriter(fd)
-	for _, line := range ifCfg {
-		_, err := writer.WriteString(line + "\n")
-		if err != nil {
-			fmt.Printf("Failed to write line %s\n", line)
-			return err
-		}
-	}
-	writer.Flush()
-	return nil
+// persistVlan persists the VLAN so it is created again at boot
+func persistVlan(name string, parent string, vlanId int) error {
+	return persist.Update(name, func(iface *persist.Interface) {
+		iface.Kind = persist.KindVlan
+		iface.Parent = parent
+		iface.VlanID = vlanId
+	})
 }
 
 func CreateVlanIf(name string, parent string, vlanId int) error {
 	exists := checkIfExists(name)
 	if exists {
-		fmt.Printf("already exists\n")
-		return nil
+		// Persisted again, the backend may have changed
+		return persistVlan(name, parent, vlanId)
 	}
 
 	vid := strconv.Itoa(vlanId)
@@ -66,27 +48,12 @@ func CreateVlanIf(name string, parent string, vlanId int) error {
 		return err
 	}
 
-	return createVlanIfFile(name)
-}
-
-func deleteVlanIfFile(name string) error {
-	ifCfgFile := filepath.Join(consts.RhelNetworkScripts, "ifcfg-"+name)
-	_, err := os.Stat(ifCfgFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Printf("File does not exist, nothing to do...\n")
-			return nil
-		} else {
-			return err
-		}
-	}
-
-	return os.Remove(ifCfgFile)
+	return persistVlan(name, parent, vlanId)
 }
 
 func deleteVlanIf(name string) error {
-	if err := deleteVlanIfFile(name); err != nil {
-		fmt.Printf("Failed to remove vlan interface cfg file for %s\n", name)
+	if err := persist.Delete(name); err != nil {
+		fmt.Printf("Failed to remove persisted vlan interface %s\n", name)
 		return err
 	}
 
@@ -99,7 +66,7 @@ func deleteVlanIf(name string) error {
 }
 
 func getManagedVlansForIf(templateName, ifName string) ([]string, error) {
-	ifVlansFile := filepath.Join(consts.HostPlumberCfg, templateName, ifName, "vlans")
+	ifVlansFile := filepath.Join(StateDir, templateName, ifName, "vlans")
 	fd, err := os.Open(ifVlansFile)
 	defer fd.Close()
 	if err != nil {
@@ -120,6 +87,32 @@ func getManagedVlansForIf(templateName, ifName string) ([]string, error) {
 	return vlans, nil
 }
 
+// GetManagedVlans returns the VLAN interfaces saved for a template, by parent
+// interface. Parents left without VLANs are not returned.
+func GetManagedVlans(templateName string) (map[string][]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(StateDir, templateName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	managed := make(map[string][]string)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		vlans, err := getManagedVlansForIf(templateName, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if len(vlans) > 0 {
+			managed[entry.Name()] = vlans
+		}
+	}
+	return managed, nil
+}
+
 func UpdateManagedVlans(templateName, ifName string, newVlans []string) error {
 	var finalVlans []string
 	currentVlans := make(map[string]bool)
@@ -173,12 +166,33 @@ func ReplaceManagedVlans(templateName, ifName string, newVlans []string) error {
 	return nil
 }
 
+// PlanManagedVlans returns the VLAN interfaces of ifName that CreateVlanIf
+// and ReplaceManagedVlans would create and delete, without changing anything
+func PlanManagedVlans(templateName, ifName string, newVlans []string) ([]string, []string, error) {
+	old, err := getManagedVlansForIf(templateName, ifName)
+	if err != nil {
+		return nil, nil, err
+	}
+	var create, remove []string
+	for _, vlan := range newVlans {
+		if _, err := net.InterfaceByName(vlan); err != nil {
+			create = append(create, vlan)
+		}
+	}
+	for _, vlan := range old {
+		if !containsString(newVlans, vlan) {
+			remove = append(remove, vlan)
+		}
+	}
+	return create, remove, nil
+}
+
 func saveManagedVlans(templateName, ifName string, vlanList []string) error {
-	if _, err := os.Stat(filepath.Join(consts.HostPlumberCfg, templateName, ifName)); os.IsNotExist(err) {
-		os.MkdirAll(filepath.Join(consts.HostPlumberCfg, templateName, ifName), 0766)
+	if _, err := os.Stat(filepath.Join(StateDir, templateName, ifName)); os.IsNotExist(err) {
+		os.MkdirAll(filepath.Join(StateDir, templateName, ifName), 0766)
 	}
 
-	ifVlansFile := filepath.Join(consts.HostPlumberCfg, templateName, ifName, "vlans")
+	ifVlansFile := filepath.Join(StateDir, templateName, ifName, "vlans")
 	fd, err := os.OpenFile(ifVlansFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	defer fd.Close()
 	if err != nil {
@@ -234,12 +248,22 @@ func SetMtuForPf(pfName string, mtu int) error {
 	if err := ioutil.WriteFile(mtuFile, mtuStr, 0644); err != nil {
 		return err
 	}
+	err := persist.Update(pfName, func(iface *persist.Interface) {
+		iface.MTU = mtu
+	})
+	if err != nil {
+		return err
+	}
 	return SetMtuForAllVfs(pfName, mtu)
 }
 
 func SetMtuForAllVfs(pfName string, mtu int) error {
-	pfDevice, _ := filepath.EvalSymlinks(filepath.Join(consts.SysClassNet, pfName, "device"))
-	err := filepath.Walk(pfDevice, func(path string, info os.FileInfo, err error) error {
+	pfDevice, err := filepath.EvalSymlinks(filepath.Join(consts.SysClassNet, pfName, "device"))
+	if err != nil {
+		// Not a PCI device, e.g. a bond or VLAN interface
+		return nil
+	}
+	err = filepath.Walk(pfDevice, func(path string, info os.FileInfo, err error) error {
 		name := info.Name()
 		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
 			isVF, _ := filepath.Match("virtfn*", name)
@@ -0,0 +1,127 @@
+package link
+
+import (
+	"bufio"
+	"fmt"
+	"hostplumber/pkg/consts"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StateDir holds the per template VLANs, bonds, bridges and VXLANs on the
+// host, so which ones hostplumber created is known after the pod restarts
+var StateDir = consts.HostStateDir
+
+// getManagedLinks returns the interfaces of a kind (bonds, bridges, ...) saved
+// for a template
+func getManagedLinks(templateName, kind string) ([]string, error) {
+	return readLines(filepath.Join(StateDir, templateName, kind))
+}
+
+// replaceManagedLinks deletes the interfaces of a kind a template configured
+// before and no longer does, then saves the ones it now configures. The ones
+// that failed to be deleted stay saved, so the next apply retries them.
+func replaceManagedLinks(templateName, kind string, names []string, deleteLink func(string) error) error {
+	old, err := getManagedLinks(templateName, kind)
+	if err != nil {
+		fmt.Printf("Error getting existing %s for template\n", kind)
+		return err
+	}
+
+	// Need to physically cleanup old interfaces since we are replacing
+	for i, name := range old {
+		if containsString(names, name) {
+			continue
+		}
+		fmt.Printf("Deleting %s %s no longer in template %s\n", kind, name, templateName)
+		if err := deleteLink(name); err != nil {
+			keep := append([]string{}, names...)
+			for _, stale := range old[i:] {
+				if !containsString(keep, stale) {
+					keep = append(keep, stale)
+				}
+			}
+			if saveErr := writeLines(filepath.Join(StateDir, templateName), kind, keep); saveErr != nil {
+				fmt.Printf("Failed to save %s of template %s: %v\n", kind, templateName, saveErr)
+			}
+			return err
+		}
+	}
+	return writeLines(filepath.Join(StateDir, templateName), kind, names)
+}
+
+// planManagedLinks returns the interfaces of a kind that would be created,
+// the ones missing on the host, and the ones replaceManagedLinks would delete
+func planManagedLinks(templateName, kind string, names []string) ([]string, []string, error) {
+	old, err := getManagedLinks(templateName, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	var create, remove []string
+	for _, name := range names {
+		if _, err := net.InterfaceByName(name); err != nil {
+			create = append(create, name)
+		}
+	}
+	for _, name := range old {
+		if !containsString(names, name) {
+			remove = append(remove, name)
+		}
+	}
+	return create, remove, nil
+}
+
+func readLines(file string) ([]string, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		fmt.Printf("Error opening up saved file %s\n", file)
+		return nil, err
+	}
+	defer fd.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(fd)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func writeLines(dir, name string, lines []string) error {
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, name)
+	fd, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("Failed to open file %s\n", file)
+		return err
+	}
+	defer fd.Close()
+
+	writer := bufio.NewWriter(fd)
+	for _, line := range lines {
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			fmt.Printf("Failed to write line %s to file %s\n", line, file)
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package link
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReplaceManagedLinks(t *testing.T) {
+	prevDir := StateDir
+	StateDir = t.TempDir()
+	t.Cleanup(func() { StateDir = prevDir })
+
+	if err := replaceManagedLinks("tmpl", "bonds", []string{"bond0", "bond1", "bond2"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting bond1 fails, so it and bond2 after it stay saved
+	var deleted []string
+	failing := func(name string) error {
+		if name == "bond1" {
+			return errors.New("busy")
+		}
+		deleted = append(deleted, name)
+		return nil
+	}
+	if err := replaceManagedLinks("tmpl", "bonds", []string{"bond3"}, failing); err == nil {
+		t.Fatalf("expected the error deleting bond1")
+	}
+	saved, err := getManagedLinks("tmpl", "bonds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bond3", "bond1", "bond2"}; !reflect.DeepEqual(saved, want) {
+		t.Errorf("saved %v, want %v", saved, want)
+	}
+
+	deleted = nil
+	if err := replaceManagedLinks("tmpl", "bonds", []string{"bond3"}, func(name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bond1", "bond2"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+	if saved, _ := getManagedLinks("tmpl", "bonds"); !reflect.DeepEqual(saved, []string{"bond3"}) {
+		t.Errorf("saved %v, want [bond3]", saved)
+	}
+}
+
+func TestPlanManagedLinks(t *testing.T) {
+	prevDir := StateDir
+	StateDir = t.TempDir()
+	t.Cleanup(func() { StateDir = prevDir })
+
+	if err := replaceManagedLinks("tmpl", "bridges", []string{"hp-br0", "hp-br1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// lo exists, hp-br2 does not
+	create, remove, err := planManagedLinks("tmpl", "bridges", []string{"lo", "hp-br0", "hp-br2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"hp-br0", "hp-br2"}; !reflect.DeepEqual(create, want) {
+		t.Errorf("create %v, want %v", create, want)
+	}
+	if want := []string{"hp-br1"}; !reflect.DeepEqual(remove, want) {
+		t.Errorf("remove %v, want %v", remove, want)
+	}
+}
+
+func TestGetManagedVlans(t *testing.T) {
+	prevDir := StateDir
+	StateDir = t.TempDir()
+	t.Cleanup(func() { StateDir = prevDir })
+
+	if managed, err := GetManagedVlans("tmpl"); err != nil || managed != nil {
+		t.Fatalf("expected no VLANs without state, got %v, %v", managed, err)
+	}
+	if err := replaceManagedLinks("tmpl", "bonds", []string{"bond0"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveManagedVlans("tmpl", "eth1", []string{"eth1.100", "eth1.200"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveManagedVlans("tmpl", "eth2", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	managed, err := GetManagedVlans("tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]string{"eth1": {"eth1.100", "eth1.200"}}; !reflect.DeepEqual(managed, want) {
+		t.Errorf("got %v, want %v", managed, want)
+	}
+}
@@ -0,0 +1,185 @@
+package link
+
+import (
+	"fmt"
+	"net"
+
+	"hostplumber/pkg/utils/persist"
+
+	"github.com/vishvananda/netlink"
+)
+
+const DefaultVxlanPort = 4789
+
+// Vxlan is a VXLAN interface. The ifcfg backend cannot persist VXLANs,
+// hostplumber creates them again when it starts after a reboot.
+type Vxlan struct {
+	Name    string
+	Vni     int
+	LocalIP string
+	// Remote is a unicast destination, Group a multicast group to join
+	Remote string
+	Group  string
+	// DstPort is the UDP destination port, 0 means 4789
+	DstPort int
+	// Device is the underlying interface, empty to let routing pick it
+	Device string
+}
+
+func (v Vxlan) dstPort() int {
+	if v.DstPort == 0 {
+		return DefaultVxlanPort
+	}
+	return v.DstPort
+}
+
+func (v Vxlan) validate() error {
+	if v.Name == "" {
+		return fmt.Errorf("vxlan needs a name")
+	}
+	if v.Vni < 0 || v.Vni > 1<<24-1 {
+		return fmt.Errorf("vxlan %s: vni %d out of range", v.Name, v.Vni)
+	}
+	if v.LocalIP != "" && net.ParseIP(v.LocalIP) == nil {
+		return fmt.Errorf("vxlan %s: invalid localIP %q", v.Name, v.LocalIP)
+	}
+	if v.Remote != "" && v.Group != "" {
+		return fmt.Errorf("vxlan %s: remote and group are mutually exclusive", v.Name)
+	}
+	if v.Remote != "" {
+		if ip := net.ParseIP(v.Remote); ip == nil || ip.IsMulticast() {
+			return fmt.Errorf("vxlan %s: invalid unicast remote %q", v.Name, v.Remote)
+		}
+	}
+	if v.Group != "" {
+		if ip := net.ParseIP(v.Group); ip == nil || !ip.IsMulticast() {
+			return fmt.Errorf("vxlan %s: invalid multicast group %q", v.Name, v.Group)
+		}
+		if v.Device == "" {
+			return fmt.Errorf("vxlan %s: a multicast group needs a device", v.Name)
+		}
+	}
+	return nil
+}
+
+// netlinkVxlan returns the link to create, vtepIndex is the index of Device
+func (v Vxlan) netlinkVxlan(vtepIndex int) *netlink.Vxlan {
+	vxlan := &netlink.Vxlan{
+		LinkAttrs:    netlink.LinkAttrs{Name: v.Name},
+		VxlanId:      v.Vni,
+		VtepDevIndex: vtepIndex,
+		SrcAddr:      net.ParseIP(v.LocalIP),
+		Port:         v.dstPort(),
+		// The default of iproute2
+		Learning: true,
+	}
+	// The kernel takes a unicast remote or a multicast group in the same attribute
+	if v.Remote != "" {
+		vxlan.Group = net.ParseIP(v.Remote)
+	} else if v.Group != "" {
+		vxlan.Group = net.ParseIP(v.Group)
+	}
+	return vxlan
+}
+
+// vxlanMatches compares the attributes hostplumber sets
+func vxlanMatches(current, want *netlink.Vxlan) bool {
+	return current.VxlanId == want.VxlanId &&
+		current.VtepDevIndex == want.VtepDevIndex &&
+		current.SrcAddr.Equal(want.SrcAddr) &&
+		current.Group.Equal(want.Group) &&
+		current.Port == want.Port
+}
+
+// CreateOrUpdateVxlan creates the VXLAN interface. The kernel does not change
+// the attributes of an existing one, so it is recreated when they differ.
+func CreateOrUpdateVxlan(v Vxlan) error {
+	if err := v.validate(); err != nil {
+		return err
+	}
+
+	vtepIndex := 0
+	if v.Device != "" {
+		device, err := netlink.LinkByName(v.Device)
+		if err != nil {
+			return fmt.Errorf("vxlan %s device %s: %w", v.Name, v.Device, err)
+		}
+		vtepIndex = device.Attrs().Index
+	}
+	want := v.netlinkVxlan(vtepIndex)
+
+	link, err := netlink.LinkByName(v.Name)
+	if err == nil {
+		current, isVxlan := link.(*netlink.Vxlan)
+		if !isVxlan {
+			return fmt.Errorf("interface %s already exists and is not a vxlan", v.Name)
+		}
+		if vxlanMatches(current, want) {
+			if err := netlink.LinkSetUp(link); err != nil {
+				return err
+			}
+			return persistVxlan(v)
+		}
+		fmt.Printf("Recreating vxlan %s to change its attributes\n", v.Name)
+		if err := netlink.LinkDel(link); err != nil {
+			return fmt.Errorf("failed to delete vxlan %s: %w", v.Name, err)
+		}
+	} else if _, notFound := err.(netlink.LinkNotFoundError); !notFound {
+		return err
+	}
+
+	if err := netlink.LinkAdd(want); err != nil {
+		return fmt.Errorf("failed to create vxlan %s: %w", v.Name, err)
+	}
+	if err := netlink.LinkSetUp(want); err != nil {
+		return fmt.Errorf("failed to set vxlan %s up: %w", v.Name, err)
+	}
+	return persistVxlan(v)
+}
+
+func persistVxlan(v Vxlan) error {
+	return persist.Update(v.Name, func(iface *persist.Interface) {
+		iface.Kind = persist.KindVxlan
+		iface.Vni = v.Vni
+		iface.LocalIP = v.LocalIP
+		iface.Remote = v.Remote
+		iface.Group = v.Group
+		iface.DstPort = v.DstPort
+		iface.Device = v.Device
+	})
+}
+
+// DeleteVxlan deletes the VXLAN interface. A missing one is not an error.
+func DeleteVxlan(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+			return persist.Delete(name)
+		}
+		return err
+	}
+	if _, isVxlan := link.(*netlink.Vxlan); !isVxlan {
+		return fmt.Errorf("interface %s is not a vxlan, not deleting it", name)
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete vxlan %s: %w", name, err)
+	}
+	return persist.Delete(name)
+}
+
+// GetManagedVxlans returns the VXLANs saved for a template
+func GetManagedVxlans(templateName string) ([]string, error) {
+	return getManagedLinks(templateName, "vxlans")
+}
+
+// ReplaceManagedVxlans saves the VXLANs a template now configures, and
+// deletes the ones it configured before and no longer does
+func ReplaceManagedVxlans(templateName string, vxlans []string) error {
+	return replaceManagedLinks(templateName, "vxlans", vxlans, DeleteVxlan)
+}
+
+// PlanManagedVxlans returns the VXLANs CreateOrUpdateVxlan would create and
+// ReplaceManagedVxlans would delete, without changing anything
+func PlanManagedVxlans(templateName string, vxlans []string) ([]string, []string, error) {
+	return planManagedLinks(templateName, "vxlans", vxlans)
+}
@@ -0,0 +1,51 @@
+package link
+
+import (
+	"net"
+	"testing"
+)
+
+func TestVxlanValidate(t *testing.T) {
+	for name, vxlan := range map[string]Vxlan{
+		"unicast":   {Name: "vxlan10", Vni: 10, LocalIP: "10.0.0.1", Remote: "10.0.0.2"},
+		"multicast": {Name: "vxlan10", Vni: 10, Group: "239.1.1.1", Device: "eth1"},
+		"ipv6":      {Name: "vxlan10", Vni: 1<<24 - 1, LocalIP: "fd00::1", Remote: "fd00::2"},
+	} {
+		if err := vxlan.validate(); err != nil {
+			t.Errorf("%s: valid vxlan rejected: %v", name, err)
+		}
+	}
+
+	for name, vxlan := range map[string]Vxlan{
+		"vni":              {Name: "vxlan10", Vni: 1 << 24},
+		"local ip":         {Name: "vxlan10", Vni: 10, LocalIP: "10.0.0"},
+		"remote and group": {Name: "vxlan10", Vni: 10, Remote: "10.0.0.2", Group: "239.1.1.1", Device: "eth1"},
+		"multicast remote": {Name: "vxlan10", Vni: 10, Remote: "239.1.1.1"},
+		"unicast group":    {Name: "vxlan10", Vni: 10, Group: "10.0.0.2", Device: "eth1"},
+		"group no device":  {Name: "vxlan10", Vni: 10, Group: "239.1.1.1"},
+	} {
+		if err := vxlan.validate(); err == nil {
+			t.Errorf("%s: invalid vxlan accepted", name)
+		}
+	}
+}
+
+func TestVxlanMatches(t *testing.T) {
+	vxlan := Vxlan{Name: "vxlan10", Vni: 10, LocalIP: "10.0.0.1", Remote: "10.0.0.2"}
+	want := vxlan.netlinkVxlan(3)
+	if want.Port != DefaultVxlanPort || !want.Group.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("unexpected link %+v", want)
+	}
+
+	current := vxlan.netlinkVxlan(3)
+	if !vxlanMatches(current, want) {
+		t.Errorf("identical vxlans do not match")
+	}
+	current.Port = 8472
+	if vxlanMatches(current, want) {
+		t.Errorf("vxlans with different ports match")
+	}
+	if vxlanMatches(vxlan.netlinkVxlan(4), want) {
+		t.Errorf("vxlans with different devices match")
+	}
+}
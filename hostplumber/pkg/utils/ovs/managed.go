@@ -0,0 +1,377 @@
+package ovs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"hostplumber/pkg/consts"
+	iputils "hostplumber/pkg/utils/ip"
+	"hostplumber/pkg/utils/persist"
+)
+
+// ManagedBridge is a bridge a template configures and the ports hostplumber
+// added to it
+type ManagedBridge struct {
+	Name string
+	// Created is set when hostplumber created the bridge, rather than adding
+	// ports to a bridge that already existed. Only created bridges are deleted.
+	Created bool
+	Ports   []string
+}
+
+// StateDir holds the per template bridges and ports on the host, so which
+// bridges hostplumber created is known after the pod restarts
+var StateDir = consts.HostStateDir
+
+func managedOvsFile(templateName string) string {
+	return filepath.Join(StateDir, templateName, "ovs")
+}
+
+// GetManagedBridges returns the bridges and ports saved for a template. The file
+// has one line per bridge: "<bridge> created|existing [<port> ...]"
+func GetManagedBridges(templateName string) ([]ManagedBridge, error) {
+	fd, err := os.Open(managedOvsFile(templateName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Printf("File does not exist, no pre-managed ovs bridges\n")
+			return nil, nil
+		}
+		fmt.Printf("Error opening up saved ovs file\n")
+		return nil, err
+	}
+	defer fd.Close()
+
+	var bridges []ManagedBridge
+	scanner := bufio.NewScanner(fd)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		bridges = append(bridges, ManagedBridge{
+			Name:    fields[0],
+			Created: fields[1] == "created",
+			Ports:   fields[2:],
+		})
+	}
+	return bridges, scanner.Err()
+}
+
+// HasManagedBridges reports whether bridges were saved for a template, even
+// none. Older versions did not save them.
+func HasManagedBridges(templateName string) (bool, error) {
+	if _, err := os.Stat(managedOvsFile(templateName)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func saveManagedBridges(templateName string, bridges []ManagedBridge) error {
+	if _, err := os.Stat(filepath.Join(StateDir, templateName)); os.IsNotExist(err) {
+		os.MkdirAll(filepath.Join(StateDir, templateName), 0766)
+	}
+
+	ovsFile := managedOvsFile(templateName)
+	fd, err := os.OpenFile(ovsFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("Failed to open file %s\n", ovsFile)
+		return err
+	}
+	defer fd.Close()
+
+	writer := bufio.NewWriter(fd)
+	for _, br := range bridges {
+		state := "existing"
+		if br.Created {
+			state = "created"
+		}
+		line := strings.Join(append([]string{br.Name, state}, br.Ports...), " ")
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			fmt.Printf("Failed to write line %s to file %s\n", line, ovsFile)
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// UpdateManagedBridges adds bridges and ports to the ones saved for a template,
+// without removing anything
+func UpdateManagedBridges(templateName string, bridges []ManagedBridge) error {
+	current, err := GetManagedBridges(templateName)
+	if err != nil {
+		fmt.Printf("Error getting existing ovs bridges for template\n")
+		return err
+	}
+
+	for _, br := range bridges {
+		merged := false
+		for i := range current {
+			if current[i].Name != br.Name {
+				continue
+			}
+			current[i].Created = current[i].Created || br.Created
+			for _, port := range br.Ports {
+				if !containsString(current[i].Ports, port) {
+					current[i].Ports = append(current[i].Ports, port)
+				}
+			}
+			merged = true
+		}
+		if !merged {
+			current = append(current, br)
+		}
+	}
+	return saveManagedBridges(templateName, current)
+}
+
+// stalePort is a port ReplaceManagedBridges deletes from a bridge
+type stalePort struct {
+	bridge, port string
+}
+
+// staleManaged returns what a template managed before and no longer does:
+// bridges it created, and ports it added to bridges it did not create. A port
+// now on another bridge of the template is kept.
+func staleManaged(old, bridges []ManagedBridge) ([]string, []stalePort, error) {
+	desired := make(map[string]ManagedBridge)
+	for _, br := range bridges {
+		desired[br.Name] = br
+	}
+
+	var staleBridges []string
+	var stalePorts []stalePort
+	for _, oldBr := range old {
+		newBr, keep := desired[oldBr.Name]
+		if !keep && oldBr.Created {
+			staleBridges = append(staleBridges, oldBr.Name)
+			continue
+		}
+		for _, port := range oldBr.Ports {
+			if keep && containsString(newBr.Ports, port) {
+				continue
+			}
+			if movedTo, err := PortToBridge(port); err != nil {
+				return nil, nil, err
+			} else if movedTo != oldBr.Name {
+				// Gone already, or now on another bridge of the template
+				continue
+			}
+			stalePorts = append(stalePorts, stalePort{bridge: oldBr.Name, port: port})
+		}
+	}
+	return staleBridges, stalePorts, nil
+}
+
+// ReplaceManagedBridges deletes what a template managed before and no longer
+// does: bridges it created, and ports it added to bridges it did not create.
+// The addresses and routes that moved to the bridge move back to the
+// interfaces of the ports. It then saves the bridges the template now
+// configures, with the stale ones that failed to be deleted, so the next
+// apply retries them.
+func ReplaceManagedBridges(templateName string, bridges []ManagedBridge) error {
+	old, err := GetManagedBridges(templateName)
+	if err != nil {
+		fmt.Printf("Error getting existing ovs bridges for template\n")
+		return err
+	}
+
+	// Need to physically cleanup old bridges and ports since we are replacing
+	staleBridges, stalePorts, err := staleManaged(old, bridges)
+	if err != nil {
+		return err
+	}
+	for i, br := range staleBridges {
+		fmt.Printf("Deleting ovs bridge %s no longer in template %s\n", br, templateName)
+		if err := deleteStaleBridge(br); err != nil {
+			return keepStale(templateName, withStale(bridges, old, staleBridges[i:], stalePorts), err)
+		}
+	}
+	for i, stale := range stalePorts {
+		fmt.Printf("Deleting ovs port %s no longer in template %s\n", stale.port, templateName)
+		if err := deleteStalePort(stale); err != nil {
+			return keepStale(templateName, withStale(bridges, old, nil, stalePorts[i:]), err)
+		}
+	}
+	return saveManagedBridges(templateName, bridges)
+}
+
+func deleteStaleBridge(br string) error {
+	if err := DeleteOvsBr(br); err != nil {
+		return err
+	}
+	if err := unpersistBridge(br); err != nil {
+		return err
+	}
+	// The bridge took the addresses and routes of its ports with it
+	return iputils.RestoreMigrated(br)
+}
+
+func deleteStalePort(stale stalePort) error {
+	if err := DelPort(stale.bridge, stale.port); err != nil {
+		return err
+	}
+	if err := unpersistMigrated(stale.bridge, stale.port); err != nil {
+		return err
+	}
+	return iputils.RestoreMigrated(stale.bridge, stale.port)
+}
+
+// keepStale saves bridges after deleting a stale bridge or port failed with
+// err, and returns err
+func keepStale(templateName string, bridges []ManagedBridge, err error) error {
+	if saveErr := saveManagedBridges(templateName, bridges); saveErr != nil {
+		fmt.Printf("Failed to save ovs bridges of template %s: %v\n", templateName, saveErr)
+	}
+	return err
+}
+
+// withStale returns bridges with the stale bridges and ports of old not
+// deleted yet added back
+func withStale(bridges, old []ManagedBridge, staleBridges []string, stalePorts []stalePort) []ManagedBridge {
+	result := append([]ManagedBridge{}, bridges...)
+	for _, oldBr := range old {
+		if containsString(staleBridges, oldBr.Name) {
+			result = append(result, oldBr)
+		}
+	}
+	for _, stale := range stalePorts {
+		found := false
+		for i := range result {
+			if result[i].Name == stale.bridge {
+				result[i].Ports = append(append([]string{}, result[i].Ports...), stale.port)
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, ManagedBridge{Name: stale.bridge, Ports: []string{stale.port}})
+		}
+	}
+	return result
+}
+
+// unpersistBridge removes what is persisted of a deleted bridge. Its ports
+// that had their addresses and routes moved to the bridge get back the ones
+// their distribution configured at boot.
+func unpersistBridge(br string) error {
+	if err := persist.Delete(br); err != nil {
+		return err
+	}
+	return unpersistMigrated(br)
+}
+
+// unpersistMigrated gives the interfaces whose addresses and routes moved to
+// a bridge, of all its ports or only the ones listed, back the configuration
+// their distribution has at boot, and removes what moved from the bridge
+func unpersistMigrated(br string, ports ...string) error {
+	migrations, err := iputils.GetMigrations(br)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if len(ports) > 0 && !containsString(ports, m.Port) {
+			continue
+		}
+		err := persist.Update(m.Interface, func(iface *persist.Interface) {
+			if iface.IPv4 != nil && len(iface.IPv4) == 0 {
+				iface.IPv4 = nil
+			}
+			if iface.IPv6 != nil && len(iface.IPv6) == 0 {
+				iface.IPv6 = nil
+			}
+			if iface.IPv6Autoconf != nil && !*iface.IPv6Autoconf {
+				iface.IPv6Autoconf = nil
+			}
+			if iface.Routes != nil && len(iface.Routes) == 0 {
+				iface.Routes = nil
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if len(ports) == 0 {
+			continue
+		}
+		err = persist.Update(br, func(iface *persist.Interface) {
+			iface.IPv4 = withoutStrings(iface.IPv4, m.IPv4)
+			iface.IPv6 = withoutStrings(iface.IPv6, m.IPv6)
+			var routes []persist.Route
+			for _, r := range iface.Routes {
+				if !migratedRoute(m, r) {
+					routes = append(routes, r)
+				}
+			}
+			if iface.Routes != nil && routes == nil {
+				routes = []persist.Route{}
+			}
+			iface.Routes = routes
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutStrings returns slice without the strings of remove, keeping nil
+// and empty apart
+func withoutStrings(slice, remove []string) []string {
+	if slice == nil {
+		return nil
+	}
+	result := []string{}
+	for _, s := range slice {
+		if !containsString(remove, s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func migratedRoute(m iputils.Migration, r persist.Route) bool {
+	for _, moved := range m.Routes {
+		if moved.Dst == r.Dst && moved.Gw == r.Gw && moved.Src == r.Src && moved.Table == r.Table && moved.Metric == r.Metric {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanManagedBridges returns what ReplaceManagedBridges would delete, without
+// changing anything
+func PlanManagedBridges(templateName string, bridges []ManagedBridge) ([]string, error) {
+	old, err := GetManagedBridges(templateName)
+	if err != nil {
+		return nil, err
+	}
+	staleBridges, stalePorts, err := staleManaged(old, bridges)
+	if err != nil {
+		return nil, err
+	}
+	var changes []string
+	for _, br := range staleBridges {
+		changes = append(changes, fmt.Sprintf("delete OVS bridge %s", br))
+	}
+	for _, stale := range stalePorts {
+		changes = append(changes, fmt.Sprintf("delete port %s from OVS bridge %s", stale.port, stale.bridge))
+	}
+	return changes, nil
+}
+
+// DeleteManagedBridges deletes everything saved for a template, as on template deletion
+func DeleteManagedBridges(templateName string) error {
+	if err := ReplaceManagedBridges(templateName, nil); err != nil {
+		return err
+	}
+	if err := os.Remove(managedOvsFile(templateName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package ovs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	iputils "hostplumber/pkg/utils/ip"
+	"hostplumber/pkg/utils/persist"
+)
+
+func useStateDir(t *testing.T) {
+	t.Helper()
+	prevDir, prevIpDir, prevPersistDir := StateDir, iputils.StateDir, persist.StateDir
+	StateDir, iputils.StateDir, persist.StateDir = t.TempDir(), t.TempDir(), t.TempDir()
+	if err := persist.SetBackend("none"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		StateDir, iputils.StateDir, persist.StateDir = prevDir, prevIpDir, prevPersistDir
+	})
+}
+
+func TestReplaceManagedBridges(t *testing.T) {
+	server := newServer(t)
+	useStateDir(t)
+
+	// br-ex existed before the template, br0 and br1 were created for it
+	mustAddBridge(t, "br-ex", "")
+	mustAddBridge(t, "br0", "")
+	mustAddBridge(t, "br1", "")
+	for br, port := range map[string]string{"br-ex": "eth1", "br0": "eth2", "br1": "eth3"} {
+		if err := AddPort(br, PortConfig{Name: port, Interfaces: []Interface{{Name: port}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AddPort("br-ex", PortConfig{Name: "patch", Interfaces: []Interface{{Name: "patch"}}}); err != nil {
+		t.Fatal(err)
+	}
+	err := ReplaceManagedBridges("tmpl", []ManagedBridge{
+		{Name: "br-ex", Ports: []string{"eth1"}},
+		{Name: "br0", Created: true, Ports: []string{"eth2"}},
+		{Name: "br1", Created: true, Ports: []string{"eth3"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// br1 and the port on br-ex are no longer in the template
+	err = ReplaceManagedBridges("tmpl", []ManagedBridge{
+		{Name: "br0", Created: true, Ports: []string{"eth2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.RowByName("Bridge", "br1"); ok {
+		t.Errorf("created bridge br1 was not deleted")
+	}
+	if _, ok := server.RowByName("Bridge", "br-ex"); !ok {
+		t.Fatalf("pre-existing bridge br-ex was deleted")
+	}
+	ports, _ := ListPorts("br-ex")
+	if len(ports) != 1 || ports[0] != "patch" {
+		t.Errorf("expected only the foreign port on br-ex, got %v", ports)
+	}
+	if ports, _ := ListPorts("br0"); len(ports) != 1 || ports[0] != "eth2" {
+		t.Errorf("br0 ports changed: %v", ports)
+	}
+
+	saved, err := GetManagedBridges("tmpl")
+	if err != nil || len(saved) != 1 || saved[0].Name != "br0" || !saved[0].Created {
+		t.Errorf("GetManagedBridges = %+v, %v", saved, err)
+	}
+
+	if err := DeleteManagedBridges("tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.RowByName("Bridge", "br0"); ok {
+		t.Errorf("br0 was not deleted with the template")
+	}
+	if saved, _ := GetManagedBridges("tmpl"); len(saved) != 0 {
+		t.Errorf("state left after deletion: %+v", saved)
+	}
+}
+
+func TestReplaceManagedBridgesKeepsMovedPort(t *testing.T) {
+	useStateDir(t)
+	newServer(t)
+	mustAddBridge(t, "br0", "")
+	mustAddBridge(t, "br1", "")
+
+	port := PortConfig{Name: "eth1", Interfaces: []Interface{{Name: "eth1"}}}
+	if err := AddPort("br0", port); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceManagedBridges("tmpl", []ManagedBridge{{Name: "br0", Ports: []string{"eth1"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddPort("br1", port); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceManagedBridges("tmpl", []ManagedBridge{{Name: "br1", Ports: []string{"eth1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if br, err := PortToBridge("eth1"); err != nil || br != "br1" {
+		t.Errorf("PortToBridge(eth1) = %q, %v, the moved port was removed", br, err)
+	}
+}
+
+func TestUpdateManagedBridges(t *testing.T) {
+	useStateDir(t)
+
+	if err := UpdateManagedBridges("tmpl", []ManagedBridge{{Name: "br0", Ports: []string{"eth1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	err := UpdateManagedBridges("tmpl", []ManagedBridge{
+		{Name: "br0", Created: true, Ports: []string{"eth2"}},
+		{Name: "br1", Ports: []string{"eth3"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := GetManagedBridges("tmpl")
+	if err != nil || len(saved) != 2 {
+		t.Fatalf("GetManagedBridges = %+v, %v", saved, err)
+	}
+	if !saved[0].Created || len(saved[0].Ports) != 2 {
+		t.Errorf("br0 not merged: %+v", saved[0])
+	}
+}
+
+func TestWithStale(t *testing.T) {
+	old := []ManagedBridge{
+		{Name: "br-ex", Ports: []string{"eth1", "eth4"}},
+		{Name: "br0", Created: true, Ports: []string{"eth2"}},
+		{Name: "br1", Created: true, Ports: []string{"eth3"}},
+	}
+	bridges := []ManagedBridge{{Name: "br0", Created: true, Ports: []string{"eth2"}}}
+
+	// Deleting br1 failed, the ports on br-ex were not deleted yet
+	got := withStale(bridges, old, []string{"br1"}, []stalePort{{"br-ex", "eth1"}, {"br-ex", "eth4"}})
+	want := []ManagedBridge{
+		{Name: "br0", Created: true, Ports: []string{"eth2"}},
+		{Name: "br1", Created: true, Ports: []string{"eth3"}},
+		{Name: "br-ex", Ports: []string{"eth1", "eth4"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(bridges[0].Ports) != 1 {
+		t.Errorf("bridges changed: %+v", bridges)
+	}
+
+	// Deleting a port of a bridge still in the template failed
+	got = withStale(bridges, old, nil, []stalePort{{"br0", "eth5"}})
+	want = []ManagedBridge{{Name: "br0", Created: true, Ports: []string{"eth2", "eth5"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestHasManagedBridges(t *testing.T) {
+	useStateDir(t)
+
+	if saved, err := HasManagedBridges("tmpl"); err != nil || saved {
+		t.Fatalf("expected no saved bridges, got %v, %v", saved, err)
+	}
+	if err := UpdateManagedBridges("tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := HasManagedBridges("tmpl"); err != nil || !saved {
+		t.Errorf("expected saved bridges, got %v, %v", saved, err)
+	}
+}
+
+func TestUnpersistMigrated(t *testing.T) {
+	useStateDir(t)
+
+	migrations := []iputils.Migration{
+		{Interface: "eth1", Port: "eth1", IPv4: []string{"10.0.0.5/24"},
+			Routes: []iputils.Route{{Dst: "default", Gw: "10.0.0.1", Dev: "eth1"}}},
+		{Interface: "eth2", Port: "eth2", IPv4: []string{"10.1.0.5/24"}},
+	}
+	data, err := json.Marshal(migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(iputils.StateDir, "migrated"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(iputils.StateDir, "migrated", "br-ex"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = persist.Update("br-ex", func(iface *persist.Interface) {
+		iface.Kind = persist.KindOvsBridge
+		iface.IPv4 = []string{"10.0.0.5/24", "10.1.0.5/24"}
+		iface.Routes = []persist.Route{{Dst: "default", Gw: "10.0.0.1"}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nic := range []string{"eth1", "eth2"} {
+		err := persist.Update(nic, func(iface *persist.Interface) {
+			iface.IPv4, iface.Routes = []string{}, []persist.Route{}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleting the port of eth1 gives it back its configuration and takes
+	// what moved off the bridge
+	if err := unpersistMigrated("br-ex", "eth1"); err != nil {
+		t.Fatal(err)
+	}
+	all, err := persist.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []persist.Interface{
+		{Name: "br-ex", Kind: persist.KindOvsBridge, IPv4: []string{"10.1.0.5/24"}, Routes: []persist.Route{}},
+		{Name: "eth2", IPv4: []string{}, Routes: []persist.Route{}},
+	}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("persisted %+v, want %+v", all, want)
+	}
+}
+
+func TestPortMatches(t *testing.T) {
+	newServer(t)
+	mustAddBridge(t, "br0", "")
+	mustAddBridge(t, "br1", "")
+
+	bond := PortConfig{
+		Name:       "bond-br0",
+		Interfaces: []Interface{{Name: "eth1"}, {Name: "eth2"}},
+		BondMode:   "balance-tcp",
+		Lacp:       "active",
+	}
+	if matches, err := PortMatches("br0", bond); err != nil || matches {
+		t.Errorf("missing port matches: %v, %v", matches, err)
+	}
+	if err := AddPort("br0", bond); err != nil {
+		t.Fatal(err)
+	}
+	if matches, err := PortMatches("br0", bond); err != nil || !matches {
+		t.Errorf("PortMatches = %v, %v", matches, err)
+	}
+	if matches, _ := PortMatches("br1", bond); matches {
+		t.Errorf("port matches on the wrong bridge")
+	}
+
+	changed := bond
+	changed.BondMode = "active-backup"
+	if matches, _ := PortMatches("br0", changed); matches {
+		t.Errorf("bond mode change not detected")
+	}
+	changed = bond
+	changed.Interfaces = []Interface{{Name: "eth1"}, {Name: "eth3"}}
+	if matches, _ := PortMatches("br0", changed); matches {
+		t.Errorf("member change not detected")
+	}
+}
+
+func TestAddPortReplacesPortOwningInterface(t *testing.T) {
+	server := newServer(t)
+	mustAddBridge(t, "br0", "")
+	if err := AddPort("br0", PortConfig{Name: "eth1", Interfaces: []Interface{{Name: "eth1"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// eth1 becomes a member of a bond on the same bridge
+	bond := PortConfig{Name: "bond-br0", Interfaces: []Interface{{Name: "eth1"}, {Name: "eth2"}}}
+	if err := AddPort("br0", bond); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.RowByName("Port", "eth1"); ok {
+		t.Errorf("the single interface port was not replaced")
+	}
+	if ports, _ := ListPorts("br0"); len(ports) != 1 || ports[0] != "bond-br0" {
+		t.Errorf("unexpected ports %v", ports)
+	}
+}
+
+func TestInPlaceBridgeChanges(t *testing.T) {
+	server := newServer(t)
+	mustAddBridge(t, "br0", "")
+
+	if err := SetInterfaceMtuRequest("br0", 9000); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetInterfaceMtuRequest("br0", 0); err != nil {
+		t.Fatal(err)
+	}
+	iface, _ := server.RowByName("Interface", "br0")
+	if _, ok := iface.Int("mtu_request"); ok {
+		t.Errorf("mtu_request not cleared")
+	}
+
+	if err := SetBridgeDatapathType("br0", "netdev"); err != nil {
+		t.Fatal(err)
+	}
+	if dp, err := GetBridgeDatapathType("br0"); err != nil || dp != "netdev" {
+		t.Errorf("GetBridgeDatapathType = %q, %v", dp, err)
+	}
+}
+
+func TestPlanManagedBridges(t *testing.T) {
+	useStateDir(t)
+	newServer(t)
+	mustAddBridge(t, "br-ex", "")
+	mustAddBridge(t, "br1", "")
+	for br, port := range map[string]string{"br-ex": "eth1", "br1": "eth3"} {
+		if err := AddPort(br, PortConfig{Name: port, Interfaces: []Interface{{Name: port}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ReplaceManagedBridges("tmpl", []ManagedBridge{
+		{Name: "br-ex", Ports: []string{"eth1"}},
+		{Name: "br1", Created: true, Ports: []string{"eth3"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := PlanManagedBridges("tmpl", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"delete OVS bridge br1", "delete port eth1 from OVS bridge br-ex"}
+	if len(changes) != len(want) || changes[0] != want[0] || changes[1] != want[1] {
+		t.Errorf("PlanManagedBridges = %v, want %v", changes, want)
+	}
+	// Nothing was deleted
+	if ports, _ := ListPorts("br-ex"); len(ports) != 1 {
+		t.Errorf("planning deleted ports of br-ex: %v", ports)
+	}
+
+	port, br, err := InterfacePort("eth3")
+	if err != nil || port != "eth3" || br != "br1" {
+		t.Errorf("InterfacePort(eth3) = %s, %s, %v", port, br, err)
+	}
+	if port, br, err := InterfacePort("eth9"); err != nil || port != "" || br != "" {
+		t.Errorf("InterfacePort(eth9) = %s, %s, %v", port, br, err)
+	}
+}
@@ -1,40 +1,547 @@
 package ovs
 
 import (
-	"os/exec"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
+
+	"hostplumber/pkg/ovsdb"
 )
 
-func GetOvsBrList() ([]string, error) {
+// Socket is the ovsdb-server socket, mounted from the host
+var Socket = ovsdb.DefaultSocket
+
+var (
+	validBondModes = []string{"active-backup", "balance-slb", "balance-tcp"}
+	validLacpModes = []string{"active", "passive", "off"}
+)
+
+// Interface is an OVS Interface row to create along with its Port
+type Interface struct {
+	Name       string
+	Type       string
+	Options    map[string]string
+	MtuRequest int
+}
+
+// PortConfig describes an OVS Port. A port with more than one interface is a bond.
+type PortConfig struct {
+	Name       string
+	Interfaces []Interface
+	BondMode   string
+	Lacp       string
+}
+
+var (
+	clientMu     sync.Mutex
+	client       *ovsdb.Client
+	clientSocket string
+)
 
-	out, err := exec.Command("ovs-vsctl", "list-br").Output()
+// connect returns the connection shared by all calls, dialing Socket the
+// first time or after Socket changed
+func connect() (*ovsdb.Client, error) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if client != nil && clientSocket == Socket {
+		return client, nil
+	}
+	if client != nil {
+		client.Close()
+		client = nil
+	}
+	c, err := ovsdb.Dial(Socket)
 	if err != nil {
 		return nil, err
 	}
-	if len(out) == 0 {
-		return nil, nil
+	client, clientSocket = c, Socket
+	return client, nil
+}
+
+func transact(ops ...ovsdb.Operation) ([]ovsdb.OperationResult, error) {
+	c, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return c.Transact(ops...)
+}
+
+// cfgPollInterval is how often cur_cfg is read while waiting for ovs-vswitchd
+var cfgPollInterval = 50 * time.Millisecond
+
+// write runs a transaction that changes the configuration, then waits for
+// ovs-vswitchd to apply it like ovs-vsctl does without --no-wait: the
+// transaction bumps next_cfg, and ovs-vswitchd copies it to cur_cfg once the
+// bridges, ports and interfaces exist. The wait is bounded by the client
+// Timeout. The results are those of ops.
+func write(ops ...ovsdb.Operation) ([]ovsdb.OperationResult, error) {
+	c, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	n := len(ops)
+	ops = append(ops,
+		ovsdb.Mutate("Open_vSwitch", []ovsdb.Mutation{ovsdb.MutateAdd("next_cfg", 1)}),
+		ovsdb.Select("Open_vSwitch", nil, "next_cfg"),
+	)
+	results, err := c.Transact(ops...)
+	if err != nil {
+		return nil, err
+	}
+	rows := results[n+1].Rows
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no Open_vSwitch row")
+	}
+	nextCfg, _ := rows[0].Int("next_cfg")
+	if err := waitCurCfg(c, nextCfg); err != nil {
+		return nil, err
+	}
+	return results[:n], nil
+}
+
+// waitCurCfg waits for cur_cfg to reach nextCfg
+func waitCurCfg(c *ovsdb.Client, nextCfg int) error {
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		results, err := c.Transact(ovsdb.Select("Open_vSwitch", nil, "cur_cfg"))
+		if err != nil {
+			return err
+		}
+		curCfg := 0
+		if rows := results[0].Rows; len(rows) > 0 {
+			curCfg, _ = rows[0].Int("cur_cfg")
+		}
+		if curCfg >= nextCfg {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for ovs-vswitchd to apply the configuration: cur_cfg %d, next_cfg %d", curCfg, nextCfg)
+		}
+		time.Sleep(cfgPollInterval)
+	}
+}
+
+func selectRows(table string, where ...ovsdb.Condition) ([]ovsdb.Row, error) {
+	results, err := transact(ovsdb.Select(table, where))
+	if err != nil {
+		return nil, err
+	}
+	return results[0].Rows, nil
+}
+
+func selectByName(table, name string) (ovsdb.Row, error) {
+	rows, err := selectRows(table, ovsdb.Equal("name", name))
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+func GetOvsBrList() ([]string, error) {
+	rows, err := selectRows("Bridge")
+	if err != nil {
+		return nil, err
+	}
+	var brList []string
+	for _, row := range rows {
+		brList = append(brList, row.String("name"))
 	}
-	op := string(out[:])
-	output := strings.TrimSuffix(op, "\n")
-	var brList []string = strings.Split(output, "\n")
 	return brList, nil
 }
 
+// GetOvsBrPort returns the ports of a bridge one per line, like ovs-vsctl list-ports
 func GetOvsBrPort(brName string) (string, error) {
+	ports, err := ListPorts(brName)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(ports, "\n"), nil
+}
+
+func BridgeExists(brName string) (bool, error) {
+	row, err := selectByName("Bridge", brName)
+	return row != nil, err
+}
+
+// AddBridge creates a bridge and its internal port, like ovs-vsctl add-br.
+// datapathType is "netdev" for a DPDK bridge, empty for the kernel datapath.
+func AddBridge(brName string, datapathType string) error {
+	_, err := write(
+		ovsdb.Insert("Interface", map[string]interface{}{
+			"name": brName,
+			"type": "internal",
+		}, "iface"),
+		ovsdb.Insert("Port", map[string]interface{}{
+			"name":       brName,
+			"interfaces": ovsdb.Set{ovsdb.NamedUUID("iface")},
+		}, "port"),
+		ovsdb.Insert("Bridge", map[string]interface{}{
+			"name":          brName,
+			"ports":         ovsdb.Set{ovsdb.NamedUUID("port")},
+			"datapath_type": datapathType,
+		}, "bridge"),
+		ovsdb.Mutate("Open_vSwitch", []ovsdb.Mutation{
+			ovsdb.MutateInsert("bridges", ovsdb.Set{ovsdb.NamedUUID("bridge")}),
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add bridge %s: %w", brName, err)
+	}
+	return nil
+}
+
+// SetBridgeDatapathType changes the datapath of an existing bridge in place
+func SetBridgeDatapathType(brName string, datapathType string) error {
+	results, err := write(ovsdb.Update("Bridge", map[string]interface{}{
+		"datapath_type": datapathType,
+	}, ovsdb.Equal("name", brName)))
+	if err != nil {
+		return fmt.Errorf("failed to set datapath_type on %s: %w", brName, err)
+	}
+	if results[0].Count == 0 {
+		return fmt.Errorf("no bridge named %s", brName)
+	}
+	return nil
+}
 
-	out, err := exec.Command("ovs-vsctl", "list-ports", brName).Output()
+// GetBridgeDatapathType returns the datapath of a bridge, "" for the kernel datapath
+func GetBridgeDatapathType(brName string) (string, error) {
+	row, err := selectByName("Bridge", brName)
 	if err != nil {
 		return "", err
 	}
-	if len(out) == 0 {
-		return "", nil
+	if row == nil {
+		return "", fmt.Errorf("no bridge named %s", brName)
 	}
-	op := string(out[:])
-	port := strings.TrimSuffix(op, "\n")
-	return port, nil
+	return row.String("datapath_type"), nil
 }
 
+// DeleteOvsBr deletes a bridge, its ports and interfaces. A missing bridge is not an error.
 func DeleteOvsBr(brName string) error {
-	err := exec.Command("ovs-vsctl", "del-br", brName).Run()
-	return err
+	row, err := selectByName("Bridge", brName)
+	if err != nil || row == nil {
+		return err
+	}
+	// Ports and interfaces are garbage collected once the bridge is gone
+	_, err = write(ovsdb.Mutate("Open_vSwitch", []ovsdb.Mutation{
+		ovsdb.MutateDelete("bridges", ovsdb.Set{ovsdb.UUID(row.UUID())}),
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to delete bridge %s: %w", brName, err)
+	}
+	return nil
+}
+
+// ListPorts returns the ports of a bridge, excluding the bridge's own internal port
+func ListPorts(brName string) ([]string, error) {
+	ports, err := bridgePorts(brName)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, port := range ports {
+		if name := port.String("name"); name != brName {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// ListIfaces returns the interfaces of every port of a bridge, excluding the
+// bridge's own internal interface
+func ListIfaces(brName string) ([]string, error) {
+	ports, err := bridgePorts(brName)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, port := range ports {
+		for _, uuid := range port.UUIDs("interfaces") {
+			rows, err := selectRows("Interface", ovsdb.Equal("_uuid", ovsdb.UUID(uuid)))
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				if name := row.String("name"); name != brName {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	return names, nil
+}
+
+func bridgePorts(brName string) ([]ovsdb.Row, error) {
+	bridge, err := selectByName("Bridge", brName)
+	if err != nil {
+		return nil, err
+	}
+	if bridge == nil {
+		return nil, fmt.Errorf("no bridge named %s", brName)
+	}
+	var ports []ovsdb.Row
+	for _, uuid := range bridge.UUIDs("ports") {
+		rows, err := selectRows("Port", ovsdb.Equal("_uuid", ovsdb.UUID(uuid)))
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, rows...)
+	}
+	return ports, nil
+}
+
+// PortToBridge returns the bridge a port belongs to, or "" if none
+func PortToBridge(portName string) (string, error) {
+	port, err := selectByName("Port", portName)
+	if err != nil || port == nil {
+		return "", err
+	}
+	rows, err := selectRows("Bridge", ovsdb.Includes("ports", ovsdb.UUID(port.UUID())))
+	if err != nil || len(rows) == 0 {
+		return "", err
+	}
+	return rows[0].String("name"), nil
+}
+
+// InterfacePort returns the port holding an interface and its bridge, empty
+// if the interface is not in OVS
+func InterfacePort(ifName string) (string, string, error) {
+	iface, err := selectByName("Interface", ifName)
+	if err != nil || iface == nil {
+		return "", "", err
+	}
+	ports, err := selectRows("Port", ovsdb.Includes("interfaces", ovsdb.UUID(iface.UUID())))
+	if err != nil || len(ports) == 0 {
+		return "", "", err
+	}
+	portName := ports[0].String("name")
+	brName, err := PortToBridge(portName)
+	return portName, brName, err
+}
+
+func validatePortConfig(port PortConfig) error {
+	if port.Name == "" || len(port.Interfaces) == 0 {
+		return fmt.Errorf("port needs a name and at least one interface")
+	}
+	if port.BondMode != "" && !containsString(validBondModes, port.BondMode) {
+		return fmt.Errorf("invalid bond mode %q, must be one of %s", port.BondMode, strings.Join(validBondModes, ", "))
+	}
+	if port.Lacp != "" && !containsString(validLacpModes, port.Lacp) {
+		return fmt.Errorf("invalid lacp mode %q, must be one of %s", port.Lacp, strings.Join(validLacpModes, ", "))
+	}
+	return nil
+}
+
+// GetPort returns the configuration of a port and the bridge it is on, or a
+// nil PortConfig if there is no such port
+func GetPort(portName string) (*PortConfig, string, error) {
+	row, err := selectByName("Port", portName)
+	if err != nil || row == nil {
+		return nil, "", err
+	}
+	port := &PortConfig{
+		Name:     portName,
+		BondMode: row.String("bond_mode"),
+		Lacp:     row.String("lacp"),
+	}
+	for _, uuid := range row.UUIDs("interfaces") {
+		rows, err := selectRows("Interface", ovsdb.Equal("_uuid", ovsdb.UUID(uuid)))
+		if err != nil {
+			return nil, "", err
+		}
+		for _, iface := range rows {
+			mtu, _ := iface.Int("mtu_request")
+			port.Interfaces = append(port.Interfaces, Interface{
+				Name:       iface.String("name"),
+				Type:       iface.String("type"),
+				Options:    iface.StringMap("options"),
+				MtuRequest: mtu,
+			})
+		}
+	}
+	brName, err := PortToBridge(portName)
+	if err != nil {
+		return nil, "", err
+	}
+	return port, brName, nil
+}
+
+// PortMatches returns whether the port exists on the bridge with the same
+// interfaces, bond mode and lacp mode. MtuRequest is not compared, it can be
+// changed in place with SetInterfaceMtuRequest.
+func PortMatches(brName string, want PortConfig) (bool, error) {
+	have, haveBr, err := GetPort(want.Name)
+	if err != nil || have == nil {
+		return false, err
+	}
+	if haveBr != brName || have.BondMode != want.BondMode || have.Lacp != want.Lacp ||
+		len(have.Interfaces) != len(want.Interfaces) {
+		return false, nil
+	}
+	for _, w := range want.Interfaces {
+		found := false
+		for _, h := range have.Interfaces {
+			if h.Name == w.Name && h.Type == w.Type && sameOptions(h.Options, w.Options) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func sameOptions(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// AddPort adds a port and its interfaces to a bridge in one transaction. If a
+// port with the same name, or a port holding one of the interfaces, exists on
+// any bridge it is replaced, so this also moves a port between bridges and
+// changes the members or mode of a bond.
+func AddPort(brName string, port PortConfig) error {
+	if err := validatePortConfig(port); err != nil {
+		return err
+	}
+	bridge, err := selectByName("Bridge", brName)
+	if err != nil {
+		return err
+	}
+	if bridge == nil {
+		return fmt.Errorf("no bridge named %s", brName)
+	}
+
+	var ops []ovsdb.Operation
+	replaced, err := portsToReplace(port)
+	if err != nil {
+		return err
+	}
+	for _, uuid := range replaced {
+		ops = append(ops, ovsdb.Mutate("Bridge", []ovsdb.Mutation{
+			ovsdb.MutateDelete("ports", ovsdb.Set{ovsdb.UUID(uuid)}),
+		}, ovsdb.Includes("ports", ovsdb.UUID(uuid))))
+	}
+
+	var ifaceRefs ovsdb.Set
+	for i, iface := range port.Interfaces {
+		name := fmt.Sprintf("iface%d", i)
+		row := map[string]interface{}{
+			"name":    iface.Name,
+			"type":    iface.Type,
+			"options": ovsdb.Map(iface.Options),
+		}
+		if iface.MtuRequest != 0 {
+			row["mtu_request"] = iface.MtuRequest
+		}
+		ops = append(ops, ovsdb.Insert("Interface", row, name))
+		ifaceRefs = append(ifaceRefs, ovsdb.NamedUUID(name))
+	}
+
+	portRow := map[string]interface{}{
+		"name":       port.Name,
+		"interfaces": ifaceRefs,
+	}
+	if port.BondMode != "" {
+		portRow["bond_mode"] = port.BondMode
+	}
+	if port.Lacp != "" {
+		portRow["lacp"] = port.Lacp
+	}
+	ops = append(ops,
+		ovsdb.Insert("Port", portRow, "port"),
+		ovsdb.Mutate("Bridge", []ovsdb.Mutation{
+			ovsdb.MutateInsert("ports", ovsdb.Set{ovsdb.NamedUUID("port")}),
+		}, ovsdb.Equal("name", brName)),
+	)
+
+	if _, err := write(ops...); err != nil {
+		return fmt.Errorf("failed to add port %s to bridge %s: %w", port.Name, brName, err)
+	}
+	return nil
+}
+
+// portsToReplace returns the UUIDs of the port named like port and of the
+// ports holding any of its interfaces
+func portsToReplace(port PortConfig) ([]string, error) {
+	var uuids []string
+	existing, err := selectByName("Port", port.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		uuids = append(uuids, existing.UUID())
+	}
+	for _, iface := range port.Interfaces {
+		row, err := selectByName("Interface", iface.Name)
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			continue
+		}
+		owners, err := selectRows("Port", ovsdb.Includes("interfaces", ovsdb.UUID(row.UUID())))
+		if err != nil {
+			return nil, err
+		}
+		for _, owner := range owners {
+			if !containsString(uuids, owner.UUID()) {
+				uuids = append(uuids, owner.UUID())
+			}
+		}
+	}
+	return uuids, nil
+}
+
+// DelPort removes a port and its interfaces from a bridge. A missing port is not an error.
+func DelPort(brName string, portName string) error {
+	port, err := selectByName("Port", portName)
+	if err != nil || port == nil {
+		return err
+	}
+	_, err = write(ovsdb.Mutate("Bridge", []ovsdb.Mutation{
+		ovsdb.MutateDelete("ports", ovsdb.Set{ovsdb.UUID(port.UUID())}),
+	}, ovsdb.Equal("name", brName)))
+	if err != nil {
+		return fmt.Errorf("failed to delete port %s from bridge %s: %w", portName, brName, err)
+	}
+	return nil
+}
+
+// SetInterfaceMtuRequest sets mtu_request on an interface. An mtu of 0 clears
+// it, so OVS picks the MTU again.
+func SetInterfaceMtuRequest(ifName string, mtu int) error {
+	var value interface{} = mtu
+	if mtu == 0 {
+		value = ovsdb.Set{}
+	}
+	results, err := write(ovsdb.Update("Interface", map[string]interface{}{
+		"mtu_request": value,
+	}, ovsdb.Equal("name", ifName)))
+	if err != nil {
+		return fmt.Errorf("failed to set mtu_request on %s: %w", ifName, err)
+	}
+	if results[0].Count == 0 {
+		return fmt.Errorf("no interface named %s", ifName)
+	}
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,299 @@
+package ovs
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"hostplumber/pkg/ovsdb"
+	"hostplumber/pkg/ovsdb/ovsdbtest"
+)
+
+func newServer(t *testing.T) *ovsdbtest.Server {
+	t.Helper()
+	server, err := ovsdbtest.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevSocket := Socket
+	Socket = server.Socket
+	t.Cleanup(func() {
+		Socket = prevSocket
+		server.Close()
+	})
+	return server
+}
+
+func mustAddBridge(t *testing.T, name, datapathType string) {
+	t.Helper()
+	if err := AddBridge(name, datapathType); err != nil {
+		t.Fatalf("AddBridge(%s) failed: %v", name, err)
+	}
+}
+
+func TestAddBridge(t *testing.T) {
+	server := newServer(t)
+
+	mustAddBridge(t, "br0", "")
+	mustAddBridge(t, "br-dpdk", "netdev")
+
+	brList, err := GetOvsBrList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(brList)
+	if len(brList) != 2 || brList[0] != "br-dpdk" || brList[1] != "br0" {
+		t.Errorf("unexpected bridges %v", brList)
+	}
+
+	exists, err := BridgeExists("br0")
+	if err != nil || !exists {
+		t.Errorf("BridgeExists(br0) = %v, %v", exists, err)
+	}
+	exists, err = BridgeExists("missing")
+	if err != nil || exists {
+		t.Errorf("BridgeExists(missing) = %v, %v", exists, err)
+	}
+
+	bridge, _ := server.RowByName("Bridge", "br-dpdk")
+	if dp := bridge.String("datapath_type"); dp != "netdev" {
+		t.Errorf("datapath_type = %q", dp)
+	}
+	iface, ok := server.RowByName("Interface", "br0")
+	if !ok || iface.String("type") != "internal" {
+		t.Errorf("bridge internal interface missing: %v", iface)
+	}
+
+	// Bridge names are unique
+	if err := AddBridge("br0", ""); err == nil {
+		t.Errorf("adding a duplicate bridge succeeded")
+	}
+
+	ports, err := ListPorts("br0")
+	if err != nil || len(ports) != 0 {
+		t.Errorf("ListPorts(br0) = %v, %v, the internal port should be hidden", ports, err)
+	}
+}
+
+func TestWriteWaitsForVswitchd(t *testing.T) {
+	server := newServer(t)
+	server.PauseVswitchd()
+
+	done := make(chan error, 1)
+	go func() { done <- AddBridge("br0", "") }()
+	select {
+	case err := <-done:
+		t.Fatalf("AddBridge returned before ovs-vswitchd applied it: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	server.ResumeVswitchd()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("AddBridge failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddBridge did not return once ovs-vswitchd applied it")
+	}
+
+	// The wait is bounded by the client timeout
+	c, err := connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Timeout = 200 * time.Millisecond
+	server.PauseVswitchd()
+	err = AddPort("br0", PortConfig{Name: "eth1", Interfaces: []Interface{{Name: "eth1"}}})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestAddPortMovesBetweenBridges(t *testing.T) {
+	server := newServer(t)
+	mustAddBridge(t, "br0", "")
+	mustAddBridge(t, "br1", "")
+
+	port := PortConfig{Name: "eth1", Interfaces: []Interface{{Name: "eth1"}}}
+	if err := AddPort("br0", port); err != nil {
+		t.Fatal(err)
+	}
+	if br, err := PortToBridge("eth1"); err != nil || br != "br0" {
+		t.Fatalf("PortToBridge(eth1) = %q, %v", br, err)
+	}
+
+	if err := AddPort("br1", port); err != nil {
+		t.Fatal(err)
+	}
+	if br, err := PortToBridge("eth1"); err != nil || br != "br1" {
+		t.Errorf("PortToBridge(eth1) = %q, %v after the move", br, err)
+	}
+	if ports, _ := ListPorts("br0"); len(ports) != 0 {
+		t.Errorf("eth1 still on br0: %v", ports)
+	}
+	// The old port and interface rows were garbage collected
+	if n := len(server.Rows("Port")); n != 3 {
+		t.Errorf("expected 3 ports (2 internal + eth1), got %d", n)
+	}
+	if n := len(server.Rows("Interface")); n != 3 {
+		t.Errorf("expected 3 interfaces, got %d", n)
+	}
+}
+
+func TestAddBond(t *testing.T) {
+	server := newServer(t)
+	mustAddBridge(t, "br0", "")
+
+	bond := PortConfig{
+		Name:       "bond-br0",
+		Interfaces: []Interface{{Name: "eth1"}, {Name: "eth2"}},
+		BondMode:   "balance-tcp",
+		Lacp:       "active",
+	}
+	if err := AddPort("br0", bond); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetInterfaceMtuRequest("br0", 9000); err != nil {
+		t.Fatal(err)
+	}
+
+	ifaces, err := ListIfaces("br0")
+	sort.Strings(ifaces)
+	if err != nil || len(ifaces) != 2 || ifaces[0] != "eth1" || ifaces[1] != "eth2" {
+		t.Errorf("ListIfaces(br0) = %v, %v", ifaces, err)
+	}
+	port, _ := server.RowByName("Port", "bond-br0")
+	if port.String("bond_mode") != "balance-tcp" || port.String("lacp") != "active" {
+		t.Errorf("bond settings not applied: %v", port)
+	}
+	iface, _ := server.RowByName("Interface", "br0")
+	if mtu, ok := iface.Int("mtu_request"); !ok || mtu != 9000 {
+		t.Errorf("mtu_request = %d, %v", mtu, ok)
+	}
+
+	// Replacing the bond changes its members
+	bond.Interfaces = []Interface{{Name: "eth1"}, {Name: "eth3"}}
+	if err := AddPort("br0", bond); err != nil {
+		t.Fatal(err)
+	}
+	ifaces, _ = ListIfaces("br0")
+	sort.Strings(ifaces)
+	if len(ifaces) != 2 || ifaces[1] != "eth3" {
+		t.Errorf("bond members not replaced: %v", ifaces)
+	}
+
+	bond.Lacp = "fast; rm -rf /"
+	if err := AddPort("br0", bond); err == nil {
+		t.Errorf("invalid lacp mode accepted")
+	}
+	if err := SetInterfaceMtuRequest("missing", 1500); err == nil {
+		t.Errorf("setting mtu_request on a missing interface succeeded")
+	}
+}
+
+func TestDeletePortAndBridge(t *testing.T) {
+	server := newServer(t)
+	mustAddBridge(t, "br0", "")
+	if err := AddPort("br0", PortConfig{Name: "eth1", Interfaces: []Interface{{Name: "eth1"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DelPort("br0", "eth1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.RowByName("Interface", "eth1"); ok {
+		t.Errorf("interface of the deleted port still exists")
+	}
+	if err := DelPort("br0", "eth1"); err != nil {
+		t.Errorf("deleting a missing port failed: %v", err)
+	}
+
+	if err := DeleteOvsBr("br0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Rows("Bridge")) != 0 || len(server.Rows("Port")) != 0 || len(server.Rows("Interface")) != 0 {
+		t.Errorf("bridge rows left behind")
+	}
+	if err := DeleteOvsBr("br0"); err != nil {
+		t.Errorf("deleting a missing bridge failed: %v", err)
+	}
+}
+
+func TestGetState(t *testing.T) {
+	newServer(t)
+	mustAddBridge(t, "br0", "")
+	mustAddBridge(t, "br-dpdk", "netdev")
+	bond := PortConfig{
+		Name:       "bond-br0",
+		Interfaces: []Interface{{Name: "eth2"}, {Name: "eth1"}},
+		BondMode:   "balance-tcp",
+		Lacp:       "active",
+	}
+	if err := AddPort("br0", bond); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPort("br-dpdk", PortConfig{Name: "dpdk-br-dpdk", Interfaces: []Interface{{
+		Name: "dpdk-br-dpdk", Type: "dpdk", Options: map[string]string{"dpdk-devargs": "0000:03:00.0"},
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+	// Columns ovs-vswitchd fills in
+	if _, err := transact(
+		ovsdb.Update("Open_vSwitch", map[string]interface{}{
+			"ovs_version":      "3.1.0",
+			"dpdk_initialized": true,
+			"dpdk_version":     "DPDK 22.11.1",
+		}),
+		ovsdb.Update("Interface", map[string]interface{}{
+			"link_state": "up", "lacp_current": true, "mac_in_use": "b4:96:91:70:19:38",
+		}, ovsdb.Equal("name", "eth1")),
+		ovsdb.Update("Interface", map[string]interface{}{
+			"link_state": "down", "lacp_current": false, "mac_in_use": "b4:96:91:70:19:39",
+		}, ovsdb.Equal("name", "eth2")),
+		ovsdb.Update("Port", map[string]interface{}{"bond_active_slave": "b4:96:91:70:19:38"}, ovsdb.Equal("name", "bond-br0")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.OvsVersion != "3.1.0" || !state.DpdkInitialized || state.DpdkVersion != "DPDK 22.11.1" {
+		t.Errorf("unexpected system state %+v", state)
+	}
+	if len(state.Bridges) != 2 || state.Bridges[0].Name != "br-dpdk" || state.Bridges[1].Name != "br0" {
+		t.Fatalf("unexpected bridges %+v", state.Bridges)
+	}
+	if dp := state.Bridges[0].DatapathType; dp != "netdev" {
+		t.Errorf("br-dpdk datapath = %q", dp)
+	}
+	if dp := state.Bridges[1].DatapathType; dp != "system" {
+		t.Errorf("br0 datapath = %q", dp)
+	}
+
+	dpdkPorts := state.Bridges[0].Ports
+	if len(dpdkPorts) != 2 || dpdkPorts[1].Interfaces[0].Type != "dpdk" {
+		t.Errorf("unexpected br-dpdk ports %+v", dpdkPorts)
+	}
+
+	ports := state.Bridges[1].Ports
+	if len(ports) != 2 || ports[0].Name != "bond-br0" || ports[1].Name != "br0" {
+		t.Fatalf("unexpected br0 ports %+v", ports)
+	}
+	if ports[1].Interfaces[0].Type != "internal" {
+		t.Errorf("bridge port type = %q", ports[1].Interfaces[0].Type)
+	}
+	bondState := ports[0]
+	if bondState.BondMode != "balance-tcp" || bondState.Lacp != "active" || bondState.BondActiveMember != "eth1" {
+		t.Errorf("unexpected bond state %+v", bondState)
+	}
+	eth1, eth2 := bondState.Interfaces[0], bondState.Interfaces[1]
+	if eth1.Name != "eth1" || eth1.Type != "system" || eth1.LinkState != "up" || eth1.LacpCurrent == nil || !*eth1.LacpCurrent {
+		t.Errorf("unexpected eth1 state %+v", eth1)
+	}
+	if eth2.LinkState != "down" || eth2.LacpCurrent == nil || *eth2.LacpCurrent {
+		t.Errorf("unexpected eth2 state %+v", eth2)
+	}
+}
@@ -0,0 +1,129 @@
+package ovs
+
+import (
+	"sort"
+	"strings"
+
+	"hostplumber/pkg/ovsdb"
+)
+
+// SystemState is the node wide OVS state from the Open_vSwitch table
+type SystemState struct {
+	OvsVersion      string
+	DpdkInitialized bool
+	DpdkVersion     string
+	Bridges         []BridgeState
+}
+
+type BridgeState struct {
+	Name         string
+	DatapathType string
+	Ports        []PortState
+}
+
+type PortState struct {
+	Name     string
+	BondMode string
+	Lacp     string
+	// BondActiveMember is the name of the interface of an active-backup or
+	// balance-slb bond that carries traffic
+	BondActiveMember string
+	Interfaces       []InterfaceState
+}
+
+type InterfaceState struct {
+	Name string
+	// Type is "system" for a kernel netdev, otherwise the OVS interface type
+	// such as internal, dpdk, dpdkvhostuser or dpdkvhostuserclient
+	Type       string
+	AdminState string
+	LinkState  string
+	Mtu        int
+	// LacpCurrent is nil when LACP does not run on the interface
+	LacpCurrent *bool
+	Error       string
+}
+
+// GetState reads the bridges, ports and interfaces in a single transaction,
+// so they are consistent with each other
+func GetState() (*SystemState, error) {
+	results, err := transact(
+		ovsdb.Select("Open_vSwitch", nil),
+		ovsdb.Select("Bridge", nil),
+		ovsdb.Select("Port", nil),
+		ovsdb.Select("Interface", nil),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &SystemState{}
+	if rows := results[0].Rows; len(rows) > 0 {
+		state.OvsVersion = rows[0].String("ovs_version")
+		state.DpdkInitialized, _ = rows[0].Bool("dpdk_initialized")
+		state.DpdkVersion = rows[0].String("dpdk_version")
+	}
+
+	ifaces := make(map[string]InterfaceState)
+	// bond_active_slave holds the MAC of the active member, not its name
+	ifaceMacs := make(map[string]string)
+	for _, row := range results[3].Rows {
+		iface := InterfaceState{
+			Name:       row.String("name"),
+			Type:       row.String("type"),
+			AdminState: row.String("admin_state"),
+			LinkState:  row.String("link_state"),
+			Error:      row.String("error"),
+		}
+		if iface.Type == "" {
+			iface.Type = "system"
+		}
+		iface.Mtu, _ = row.Int("mtu")
+		if current, ok := row.Bool("lacp_current"); ok {
+			iface.LacpCurrent = &current
+		}
+		ifaces[row.UUID()] = iface
+		ifaceMacs[row.UUID()] = row.String("mac_in_use")
+	}
+
+	ports := make(map[string]PortState)
+	for _, row := range results[2].Rows {
+		port := PortState{
+			Name:     row.String("name"),
+			BondMode: row.String("bond_mode"),
+			Lacp:     row.String("lacp"),
+		}
+		activeMac := row.String("bond_active_slave")
+		for _, uuid := range row.UUIDs("interfaces") {
+			iface, ok := ifaces[uuid]
+			if !ok {
+				continue
+			}
+			port.Interfaces = append(port.Interfaces, iface)
+			if activeMac != "" && strings.EqualFold(ifaceMacs[uuid], activeMac) {
+				port.BondActiveMember = iface.Name
+			}
+		}
+		sort.Slice(port.Interfaces, func(i, j int) bool { return port.Interfaces[i].Name < port.Interfaces[j].Name })
+		ports[row.UUID()] = port
+	}
+
+	for _, row := range results[1].Rows {
+		bridge := BridgeState{
+			Name:         row.String("name"),
+			DatapathType: row.String("datapath_type"),
+		}
+		if bridge.DatapathType == "" {
+			bridge.DatapathType = "system"
+		}
+		for _, uuid := range row.UUIDs("ports") {
+			if port, ok := ports[uuid]; ok {
+				bridge.Ports = append(bridge.Ports, port)
+			}
+		}
+		sort.Slice(bridge.Ports, func(i, j int) bool { return bridge.Ports[i].Name < bridge.Ports[j].Name })
+		state.Bridges = append(state.Bridges, bridge)
+	}
+	sort.Slice(state.Bridges, func(i, j int) bool { return state.Bridges[i].Name < state.Bridges[j].Name })
+	return state, nil
+}
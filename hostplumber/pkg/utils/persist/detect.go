@@ -0,0 +1,47 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+
+	"hostplumber/pkg/consts"
+)
+
+var hostRun = consts.HostRun
+
+// Detect returns the backend for the network configuration of the node:
+// netplan when it has netplan files, whatever renders them, then
+// systemd-networkd when it runs without NetworkManager, then NetworkManager
+// keyfiles unless ifcfg files configure the interfaces, then ifcfg files
+func Detect() Backend {
+	if hasFiles(netplanDir, "*.yaml", netplanFile) {
+		return netplan{}
+	}
+	networkManager := exists(filepath.Join(hostRun, "NetworkManager"))
+	if exists(filepath.Join(hostRun, "systemd", "netif")) && !networkManager {
+		return networkd{}
+	}
+	if networkManager && !hasFiles(ifCfgDir, "ifcfg-*", "ifcfg-lo") {
+		return keyfile{}
+	}
+	if exists(ifCfgDir) {
+		return ifcfg{}
+	}
+	return none{}
+}
+
+// hasFiles returns whether dir has files matching pattern, other than skip
+func hasFiles(dir, pattern, skip string) bool {
+	files, _ := filepath.Glob(filepath.Join(dir, pattern))
+	for _, file := range files {
+		if filepath.Base(file) != skip {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,361 @@
+package persist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"hostplumber/pkg/consts"
+)
+
+var ifCfgDir = consts.RhelNetworkScripts
+
+// ifcfg writes /etc/sysconfig/network-scripts/ifcfg-* files, for RHEL and
+// CentOS before 9 with the network service or NetworkManager's ifcfg-rh plugin
+type ifcfg struct{}
+
+func (ifcfg) Name() string { return "ifcfg" }
+
+func ifCfgFile(name string) string {
+	return filepath.Join(ifCfgDir, "ifcfg-"+name)
+}
+
+// Original ifcfg files of interfaces hostplumber configured, restored when it
+// no longer does. An empty backup means there was no file.
+func ifCfgBackupDir() string {
+	return filepath.Join(StateDir, "ifcfg-backup")
+}
+
+func (ifcfg) Write(iface Interface, all []Interface) error {
+	var lines []string
+	switch iface.Kind {
+	case KindVlan:
+		lines = vlanIfCfg(iface)
+	case KindBond:
+		lines = bondIfCfg(iface)
+	case KindBridge:
+		lines = bridgeIfCfg(iface)
+	case KindOvsBridge:
+		lines = ovsBridgeIfCfg(iface)
+	case KindVxlan:
+		// ifcfg has no VXLAN type, hostplumber creates them again at start
+		return nil
+	default:
+		if err := backupIfFile("ifcfg-" + iface.Name); err != nil {
+			return err
+		}
+		orig, err := ioutil.ReadFile(filepath.Join(ifCfgBackupDir(), "ifcfg-"+iface.Name))
+		if err != nil {
+			return err
+		}
+		lines = splitLines(string(orig))
+		if iface.MasterKind == KindBond {
+			// A bond member keeps none of its own configuration
+			lines = bondMemberIfCfg(iface)
+		}
+	}
+	if err := writeIfFile(iface.Name, withIfCfg(lines, iface)); err != nil {
+		return err
+	}
+	return writeRouteFiles(iface)
+}
+
+func (ifcfg) Delete(iface Interface, all []Interface) error {
+	if err := restoreIfFile("ifcfg-" + iface.Name); err != nil {
+		return err
+	}
+	// Route files are only restored when hostplumber wrote them
+	for _, file := range []string{"route-" + iface.Name, "route6-" + iface.Name} {
+		if _, err := os.Stat(filepath.Join(ifCfgBackupDir(), file)); os.IsNotExist(err) {
+			continue
+		}
+		if err := restoreIfFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRouteFiles writes the routes of the interface to its route- and
+// route6- files, in the ip route format ifup-routes and NetworkManager's
+// ifcfg-rh plugin read. A family without routes gets no file.
+func writeRouteFiles(iface Interface) error {
+	if iface.Routes == nil {
+		return nil
+	}
+	var ipv4, ipv6 []string
+	for _, r := range iface.Routes {
+		if r.ipv6() {
+			ipv6 = append(ipv6, ipRouteLine(r, iface.Name))
+		} else {
+			ipv4 = append(ipv4, ipRouteLine(r, iface.Name))
+		}
+	}
+	for _, routes := range []struct {
+		file  string
+		lines []string
+	}{{"route-" + iface.Name, ipv4}, {"route6-" + iface.Name, ipv6}} {
+		if err := backupIfFile(routes.file); err != nil {
+			return err
+		}
+		path := filepath.Join(ifCfgDir, routes.file)
+		if len(routes.lines) == 0 {
+			if err := removeFile(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeFile(path, []byte(strings.Join(routes.lines, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipRouteLine returns the route as ip route add takes it
+func ipRouteLine(r Route, dev string) string {
+	dst := r.Dst
+	if r.isDefault() {
+		dst = "default"
+	}
+	parts := []string{dst}
+	if r.Gw != "" {
+		parts = append(parts, "via", r.Gw)
+	}
+	parts = append(parts, "dev", dev)
+	if r.Src != "" {
+		parts = append(parts, "src", r.Src)
+	}
+	if r.Metric != 0 {
+		parts = append(parts, "metric", strconv.Itoa(r.Metric))
+	}
+	if r.Table != 0 {
+		parts = append(parts, "table", strconv.Itoa(r.Table))
+	}
+	return strings.Join(parts, " ")
+}
+
+func vlanIfCfg(iface Interface) []string {
+	return []string{
+		fmt.Sprintf("DEVICE=%s", iface.Name),
+		"BOOTPROTO=none",
+		"ONBOOT=yes",
+		"VLAN=yes",
+		fmt.Sprintf("PHYSDEV=%s", iface.Parent),
+	}
+}
+
+func bondIfCfg(iface Interface) []string {
+	return []string{
+		fmt.Sprintf("DEVICE=%s", iface.Name),
+		"TYPE=Bond",
+		"BONDING_MASTER=yes",
+		fmt.Sprintf("BONDING_OPTS=\"%s\"", bondingOpts(iface)),
+		"BOOTPROTO=none",
+		"ONBOOT=yes",
+	}
+}
+
+// bondingOpts returns BONDING_OPTS of the bond's ifcfg file
+func bondingOpts(iface Interface) string {
+	opts := []string{"mode=" + iface.BondMode, "miimon=" + strconv.Itoa(iface.Miimon)}
+	if iface.LacpRate != "" {
+		opts = append(opts, "lacp_rate="+iface.LacpRate)
+	}
+	if iface.XmitHashPolicy != "" {
+		opts = append(opts, "xmit_hash_policy="+iface.XmitHashPolicy)
+	}
+	return strings.Join(opts, " ")
+}
+
+func bondMemberIfCfg(iface Interface) []string {
+	return []string{
+		fmt.Sprintf("DEVICE=%s", iface.Name),
+		fmt.Sprintf("MASTER=%s", iface.Master),
+		"SLAVE=yes",
+		"BOOTPROTO=none",
+		"ONBOOT=yes",
+	}
+}
+
+func bridgeIfCfg(iface Interface) []string {
+	stp := "no"
+	if iface.Stp {
+		stp = "yes"
+	}
+	ifCfg := []string{
+		fmt.Sprintf("DEVICE=%s", iface.Name),
+		"TYPE=Bridge",
+		fmt.Sprintf("STP=%s", stp),
+	}
+	if iface.VlanFiltering {
+		ifCfg = append(ifCfg, "BRIDGING_OPTS=\"vlan_filtering=1\"")
+	}
+	return append(ifCfg, "BOOTPROTO=none", "ONBOOT=yes")
+}
+
+// ovsBridgeIfCfg is read by the ifup-ovs script of openvswitch, which also
+// adds the bridge to OVS when it is missing
+func ovsBridgeIfCfg(iface Interface) []string {
+	return []string{
+		fmt.Sprintf("DEVICE=%s", iface.Name),
+		"DEVICETYPE=ovs",
+		"TYPE=OVSBridge",
+		"BOOTPROTO=none",
+		"ONBOOT=yes",
+	}
+}
+
+var (
+	ipv4Key     = regexp.MustCompile(`^(IPADDR|PREFIX|NETMASK)[0-9]*$`)
+	ipv6InitKey = regexp.MustCompile(`^(IPV6INIT|IPV6_AUTOCONF)$`)
+	ipv6AddrKey = regexp.MustCompile(`^(IPV6ADDR|IPV6ADDR_SECONDARIES)$`)
+	gatewayKey  = regexp.MustCompile(`^(GATEWAY[0-9]*|IPV6_DEFAULTGW|DEFROUTE|IPV6_DEFROUTE)$`)
+)
+
+// withIfCfg sets the master, MTU, addresses and gateways of the interface in
+// its ifcfg lines, keeping the lines the interface does not set. Routes are
+// in files of their own, the gateways in the ifcfg file are removed with them.
+func withIfCfg(lines []string, iface Interface) []string {
+	if len(lines) == 0 {
+		lines = []string{fmt.Sprintf("DEVICE=%s", iface.Name), "ONBOOT=yes"}
+	}
+	switch iface.MasterKind {
+	case KindBridge:
+		lines = withIfCfgOption(lines, iface.Name, "BRIDGE", iface.Master)
+	case KindBond:
+		lines = withIfCfgOption(lines, iface.Name, "MASTER", iface.Master)
+		lines = withIfCfgOption(lines, iface.Name, "SLAVE", "yes")
+	}
+	if iface.MTU != 0 {
+		lines = withIfCfgOption(lines, iface.Name, "MTU", strconv.Itoa(iface.MTU))
+	}
+	if iface.IPv4 != nil {
+		lines = withoutIfCfgKeys(lines, ipv4Key)
+		lines = withIfCfgOption(lines, iface.Name, "BOOTPROTO", "none")
+		for i, addr := range iface.IPv4 {
+			ip, prefix := splitCidr(addr)
+			suffix := ""
+			if i > 0 {
+				suffix = strconv.Itoa(i)
+			}
+			lines = append(lines, "IPADDR"+suffix+"="+ip, "PREFIX"+suffix+"="+prefix)
+		}
+	}
+	if iface.Routes != nil {
+		lines = withoutIfCfgKeys(lines, gatewayKey)
+	}
+	if autoconf, set := iface.autoconf(); set {
+		lines = withoutIfCfgKeys(lines, ipv6InitKey)
+		if iface.IPv6 != nil {
+			lines = withoutIfCfgKeys(lines, ipv6AddrKey)
+		}
+		if !autoconf && len(iface.IPv6) == 0 && !hasIfCfgKey(lines, ipv6AddrKey) {
+			lines = append(lines, "IPV6INIT=no")
+		} else {
+			lines = append(lines, "IPV6INIT=yes", "IPV6_AUTOCONF="+yesNo(autoconf))
+		}
+		if len(iface.IPv6) > 0 {
+			lines = append(lines, "IPV6ADDR="+iface.IPv6[0])
+			if len(iface.IPv6) > 1 {
+				lines = append(lines, fmt.Sprintf("IPV6ADDR_SECONDARIES=\"%s\"", strings.Join(iface.IPv6[1:], " ")))
+			}
+		}
+	}
+	return lines
+}
+
+// withIfCfgOption sets KEY=value in ifcfg lines, starting a minimal file for
+// an interface that has none. An empty value removes the key.
+func withIfCfgOption(lines []string, name, key, value string) []string {
+	var updated []string
+	if len(lines) == 0 {
+		updated = []string{fmt.Sprintf("DEVICE=%s", name), "ONBOOT=yes"}
+	}
+	for _, line := range lines {
+		if line == key+"="+value {
+			// Already set, keeps its place
+			return lines
+		}
+		if !strings.HasPrefix(line, key+"=") {
+			updated = append(updated, line)
+		}
+	}
+	if value != "" {
+		updated = append(updated, key+"="+value)
+	}
+	return updated
+}
+
+func withoutIfCfgKeys(lines []string, key *regexp.Regexp) []string {
+	var kept []string
+	for _, line := range lines {
+		if i := strings.Index(line, "="); i > 0 && key.MatchString(line[:i]) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+func hasIfCfgKey(lines []string, key *regexp.Regexp) bool {
+	return len(withoutIfCfgKeys(lines, key)) != len(lines)
+}
+
+func splitCidr(cidr string) (string, string) {
+	if i := strings.Index(cidr, "/"); i >= 0 {
+		return cidr[:i], cidr[i+1:]
+	}
+	return cidr, "32"
+}
+
+func splitLines(data string) []string {
+	data = strings.TrimRight(data, "\n")
+	if data == "" {
+		return nil
+	}
+	return strings.Split(data, "\n")
+}
+
+func writeIfFile(name string, lines []string) error {
+	return writeFile(ifCfgFile(name), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// backupIfFile saves the original file of an interface in network-scripts,
+// like ifcfg-eth1, the first time hostplumber configures it
+func backupIfFile(file string) error {
+	backup := filepath.Join(ifCfgBackupDir(), file)
+	if _, err := os.Stat(backup); err == nil {
+		return nil
+	}
+	orig, err := ioutil.ReadFile(filepath.Join(ifCfgDir, file))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(ifCfgBackupDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(backup, orig, 0644)
+}
+
+// restoreIfFile puts back the original file of an interface in
+// network-scripts, or removes the one hostplumber wrote if there was none
+func restoreIfFile(file string) error {
+	backup := filepath.Join(ifCfgBackupDir(), file)
+	orig, err := ioutil.ReadFile(backup)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(orig) == 0 {
+		if err := removeFile(filepath.Join(ifCfgDir, file)); err != nil {
+			return err
+		}
+	} else if err := ioutil.WriteFile(filepath.Join(ifCfgDir, file), orig, 0644); err != nil {
+		return err
+	}
+	return removeFile(backup)
+}
@@ -0,0 +1,218 @@
+package persist
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBondIfCfg(t *testing.T) {
+	bond := Interface{Name: "bond0", Kind: KindBond, BondMode: "802.3ad", Miimon: 100, LacpRate: "fast", XmitHashPolicy: "layer3+4"}
+	want := []string{
+		"DEVICE=bond0",
+		"TYPE=Bond",
+		"BONDING_MASTER=yes",
+		`BONDING_OPTS="mode=802.3ad miimon=100 lacp_rate=fast xmit_hash_policy=layer3+4"`,
+		"BOOTPROTO=none",
+		"ONBOOT=yes",
+	}
+	if got := withIfCfg(bondIfCfg(bond), bond); !reflect.DeepEqual(got, want) {
+		t.Errorf("bondIfCfg = %q, want %q", got, want)
+	}
+
+	bond = Interface{Name: "bond1", Kind: KindBond, BondMode: "active-backup", Miimon: 0}
+	if got := bondingOpts(bond); got != "mode=active-backup miimon=0" {
+		t.Errorf("bondingOpts = %q", got)
+	}
+}
+
+func TestBridgeIfCfg(t *testing.T) {
+	bridge := Interface{Name: "br0", Kind: KindBridge, Stp: true, VlanFiltering: true}
+	want := []string{
+		"DEVICE=br0",
+		"TYPE=Bridge",
+		"STP=yes",
+		`BRIDGING_OPTS="vlan_filtering=1"`,
+		"BOOTPROTO=none",
+		"ONBOOT=yes",
+	}
+	if got := withIfCfg(bridgeIfCfg(bridge), bridge); !reflect.DeepEqual(got, want) {
+		t.Errorf("bridgeIfCfg = %q, want %q", got, want)
+	}
+}
+
+func TestBridgePortIfCfg(t *testing.T) {
+	// A bond that is a bridge port keeps its bonding options
+	bond := Interface{Name: "bond0", Kind: KindBond, BondMode: "active-backup", Miimon: 100, Master: "br0", MasterKind: KindBridge}
+	want := []string{
+		"DEVICE=bond0",
+		"TYPE=Bond",
+		"BONDING_MASTER=yes",
+		`BONDING_OPTS="mode=active-backup miimon=100"`,
+		"BOOTPROTO=none",
+		"ONBOOT=yes",
+		"BRIDGE=br0",
+	}
+	if got := withIfCfg(bondIfCfg(bond), bond); !reflect.DeepEqual(got, want) {
+		t.Errorf("bond port = %q, want %q", got, want)
+	}
+
+	vxlan := Interface{Name: "vxlan100", Kind: KindVxlan, Master: "br0", MasterKind: KindBridge}
+	want = []string{"DEVICE=vxlan100", "ONBOOT=yes", "BRIDGE=br0"}
+	if got := withIfCfg(nil, vxlan); !reflect.DeepEqual(got, want) {
+		t.Errorf("new port = %q, want %q", got, want)
+	}
+}
+
+func TestWithIfCfgOption(t *testing.T) {
+	bond := []string{"DEVICE=bond0", "TYPE=Bond", "ONBOOT=yes"}
+	enslaved := withIfCfgOption(bond, "bond0", "BRIDGE", "br0")
+	if want := append(bond[:3:3], "BRIDGE=br0"); !reflect.DeepEqual(enslaved, want) {
+		t.Errorf("setting BRIDGE = %q, want %q", enslaved, want)
+	}
+	if moved := withIfCfgOption(enslaved, "bond0", "BRIDGE", "br1"); moved[len(moved)-1] != "BRIDGE=br1" || len(moved) != 4 {
+		t.Errorf("changing BRIDGE = %q", moved)
+	}
+	if released := withIfCfgOption(enslaved, "bond0", "BRIDGE", ""); !reflect.DeepEqual(released, bond) {
+		t.Errorf("removing BRIDGE = %q, want %q", released, bond)
+	}
+
+	// An interface without an ifcfg file gets a minimal one
+	want := []string{"DEVICE=eth1", "ONBOOT=yes", "BRIDGE=br0"}
+	if got := withIfCfgOption(nil, "eth1", "BRIDGE", "br0"); !reflect.DeepEqual(got, want) {
+		t.Errorf("new ifcfg = %q, want %q", got, want)
+	}
+}
+
+func TestIfCfgWrite(t *testing.T) {
+	setup(t, ifcfg{})
+	orig := "DEVICE=eth1\nBOOTPROTO=dhcp\nIPV6INIT=yes\nONBOOT=yes\n"
+	writeTestFile(t, ifCfgFile("eth1"), orig)
+
+	vlan := Interface{Name: "eth1.100", Kind: KindVlan, Parent: "eth1", VlanID: 100, MTU: 1500,
+		IPv6: []string{"fd00::5/64", "fd00::6/64"}}
+	if err := (ifcfg{}).Write(vlan, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "DEVICE=eth1.100\nBOOTPROTO=none\nONBOOT=yes\nVLAN=yes\nPHYSDEV=eth1\nMTU=1500\n" +
+		"IPV6INIT=yes\nIPV6_AUTOCONF=no\nIPV6ADDR=fd00::5/64\nIPV6ADDR_SECONDARIES=\"fd00::6/64\"\n"
+	if got := readTestFile(t, ifCfgFile("eth1.100")); got != want {
+		t.Errorf("vlan: got %q, want %q", got, want)
+	}
+
+	// A bond member keeps nothing of its original file until released
+	member := Interface{Name: "eth1", Master: "bond0", MasterKind: KindBond}
+	if err := (ifcfg{}).Write(member, nil); err != nil {
+		t.Fatal(err)
+	}
+	want = "DEVICE=eth1\nMASTER=bond0\nSLAVE=yes\nBOOTPROTO=none\nONBOOT=yes\n"
+	if got := readTestFile(t, ifCfgFile("eth1")); got != want {
+		t.Errorf("member: got %q, want %q", got, want)
+	}
+	if err := (ifcfg{}).Delete(member, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, ifCfgFile("eth1")); got != orig {
+		t.Errorf("released: got %q, want %q", got, orig)
+	}
+
+	// A bridge port keeps its original file with BRIDGE added
+	writeTestFile(t, ifCfgFile("eth2"), orig)
+	port := Interface{Name: "eth2", Master: "br0", MasterKind: KindBridge}
+	if err := (ifcfg{}).Write(port, nil); err != nil {
+		t.Fatal(err)
+	}
+	want = orig + "BRIDGE=br0\n"
+	if got := readTestFile(t, ifCfgFile("eth2")); got != want {
+		t.Errorf("bridge port: got %q, want %q", got, want)
+	}
+	if err := (ifcfg{}).Delete(port, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, ifCfgFile("eth2")); got != orig {
+		t.Errorf("released port: got %q, want %q", got, orig)
+	}
+
+	if err := (ifcfg{}).Delete(vlan, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exists(ifCfgFile("eth1.100")) {
+		t.Errorf("ifcfg-eth1.100 not removed")
+	}
+}
+
+func TestWithIfCfgIpv6(t *testing.T) {
+	orig := []string{"DEVICE=eth1", "ONBOOT=yes", "IPV6INIT=yes", "IPV6_AUTOCONF=yes"}
+	static := withIfCfg(orig, Interface{Name: "eth1", IPv6: []string{"2001:db8::5/64", "2001:db8::6/64"}})
+	want := []string{"DEVICE=eth1", "ONBOOT=yes", "IPV6INIT=yes", "IPV6_AUTOCONF=no", "IPV6ADDR=2001:db8::5/64", "IPV6ADDR_SECONDARIES=\"2001:db8::6/64\""}
+	if !reflect.DeepEqual(static, want) {
+		t.Errorf("static = %q, want %q", static, want)
+	}
+
+	autoconf := true
+	slaac := withIfCfg(static, Interface{Name: "eth1", IPv6Autoconf: &autoconf})
+	want = []string{"DEVICE=eth1", "ONBOOT=yes", "IPV6ADDR=2001:db8::5/64", "IPV6ADDR_SECONDARIES=\"2001:db8::6/64\"", "IPV6INIT=yes", "IPV6_AUTOCONF=yes"}
+	if !reflect.DeepEqual(slaac, want) {
+		t.Errorf("slaac = %q, want %q", slaac, want)
+	}
+
+	removed := withIfCfg(orig, Interface{Name: "eth1", IPv6: []string{}})
+	want = []string{"DEVICE=eth1", "ONBOOT=yes", "IPV6INIT=no"}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %q, want %q", removed, want)
+	}
+}
+
+func TestIfCfgRoutes(t *testing.T) {
+	setup(t, ifcfg{})
+	orig := "DEVICE=eth1\nBOOTPROTO=none\nIPADDR=10.0.0.5\nPREFIX=24\nGATEWAY=10.0.0.1\nONBOOT=yes\n"
+	writeTestFile(t, ifCfgFile("eth1"), orig)
+	origRoutes := "10.1.0.0/16 via 10.0.0.254 dev eth1\n"
+	writeTestFile(t, filepath.Join(ifCfgDir, "route-eth1"), origRoutes)
+
+	// The routes moved to the bridge, the NIC keeps none
+	br := Interface{Name: "br-ex", Kind: KindOvsBridge, IPv4: []string{"10.0.0.5/24"}, Routes: []Route{
+		{Dst: "default", Gw: "10.0.0.1", Metric: 100},
+		{Dst: "10.1.0.0/16", Gw: "10.0.0.254", Table: 10},
+		{Dst: "::/0", Gw: "fd00::1"},
+	}}
+	if err := (ifcfg{}).Write(br, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "default via 10.0.0.1 dev br-ex metric 100\n10.1.0.0/16 via 10.0.0.254 dev br-ex table 10\n"
+	if got := readTestFile(t, filepath.Join(ifCfgDir, "route-br-ex")); got != want {
+		t.Errorf("route-br-ex: got %q, want %q", got, want)
+	}
+	if got := readTestFile(t, filepath.Join(ifCfgDir, "route6-br-ex")); got != "default via fd00::1 dev br-ex\n" {
+		t.Errorf("route6-br-ex: got %q", got)
+	}
+
+	nic := Interface{Name: "eth1", IPv4: []string{}, Routes: []Route{}}
+	if err := (ifcfg{}).Write(nic, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, ifCfgFile("eth1")); got != "DEVICE=eth1\nBOOTPROTO=none\nONBOOT=yes\n" {
+		t.Errorf("ifcfg-eth1: got %q", got)
+	}
+	if exists(filepath.Join(ifCfgDir, "route-eth1")) {
+		t.Errorf("route-eth1 not removed")
+	}
+
+	if err := (ifcfg{}).Delete(nic, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(ifCfgDir, "route-eth1")); got != origRoutes {
+		t.Errorf("restored route-eth1: got %q, want %q", got, origRoutes)
+	}
+	if exists(filepath.Join(ifCfgDir, "route6-eth1")) {
+		t.Errorf("route6-eth1 written on restore")
+	}
+	if err := (ifcfg{}).Delete(br, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"ifcfg-br-ex", "route-br-ex", "route6-br-ex"} {
+		if exists(filepath.Join(ifCfgDir, file)) {
+			t.Errorf("%s not removed", file)
+		}
+	}
+}
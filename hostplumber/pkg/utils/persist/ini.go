@@ -0,0 +1,152 @@
+package persist
+
+import (
+	"strings"
+)
+
+// iniFile is a NetworkManager keyfile or systemd-networkd unit, keeping the
+// order of sections and keys and the comments of the files it edits
+type iniFile struct {
+	// head holds the comments before the first section
+	head     []string
+	sections []*iniSection
+}
+
+type iniSection struct {
+	name  string
+	lines []string
+}
+
+func parseIni(data string) *iniFile {
+	f := &iniFile{}
+	var current *iniSection
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = &iniSection{name: trimmed[1 : len(trimmed)-1]}
+			f.sections = append(f.sections, current)
+			continue
+		}
+		if trimmed == "" {
+			continue
+		}
+		if current == nil {
+			f.head = append(f.head, line)
+		} else {
+			current.lines = append(current.lines, trimmed)
+		}
+	}
+	return f
+}
+
+func (f *iniFile) String() string {
+	var b strings.Builder
+	for _, line := range f.head {
+		b.WriteString(line + "\n")
+	}
+	for i, s := range f.sections {
+		if i > 0 || len(f.head) > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("[" + s.name + "]\n")
+		for _, line := range s.lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// section returns the first section with the name, adding it if missing
+func (f *iniFile) section(name string) *iniSection {
+	for _, s := range f.sections {
+		if s.name == name {
+			return s
+		}
+	}
+	s := &iniSection{name: name}
+	f.sections = append(f.sections, s)
+	return s
+}
+
+func (f *iniFile) deleteSection(name string) {
+	var kept []*iniSection
+	for _, s := range f.sections {
+		if s.name != name {
+			kept = append(kept, s)
+		}
+	}
+	f.sections = kept
+}
+
+// get returns the value of the first key in the section
+func (f *iniFile) get(section, key string) string {
+	for _, s := range f.sections {
+		if s.name != section {
+			continue
+		}
+		for _, line := range s.lines {
+			if k, v, ok := splitKey(line); ok && k == key {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// set replaces the key in the section, keeping its place when the value
+// does not change
+func (f *iniFile) set(section, key, value string) {
+	s := f.section(section)
+	for i, line := range s.lines {
+		if k, v, ok := splitKey(line); ok && k == key {
+			if v == value {
+				s.lines = append(s.lines[:i+1], without(s.lines[i+1:], key, nil)...)
+				return
+			}
+		}
+	}
+	s.lines = append(without(s.lines, key, nil), key+"="+value)
+}
+
+// add appends the key to the section unless it already has the value
+func (f *iniFile) add(section, key, value string) {
+	s := f.section(section)
+	for _, line := range s.lines {
+		if k, v, ok := splitKey(line); ok && k == key && v == value {
+			return
+		}
+	}
+	s.lines = append(s.lines, key+"="+value)
+}
+
+// del removes the key from the section, or only the values matching when
+// match is not nil
+func (f *iniFile) del(section, key string, match func(string) bool) {
+	for _, s := range f.sections {
+		if s.name == section {
+			s.lines = without(s.lines, key, match)
+		}
+	}
+}
+
+func without(lines []string, key string, match func(string) bool) []string {
+	var kept []string
+	for _, line := range lines {
+		if k, v, ok := splitKey(line); ok && k == key && (match == nil || match(v)) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+func splitKey(line string) (string, string, bool) {
+	if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+		return "", "", false
+	}
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+}
@@ -0,0 +1,275 @@
+package persist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"hostplumber/pkg/consts"
+)
+
+var keyfileDir = consts.NMConnections
+
+// keyfile writes NetworkManager keyfiles, for RHEL 9 and other distributions
+// where NetworkManager no longer reads ifcfg files. NetworkManager loads them
+// at boot, hostplumber does not activate them.
+type keyfile struct{}
+
+func (keyfile) Name() string { return "keyfile" }
+
+// ownKeyfile is the profile hostplumber writes for an interface that has no
+// profile of its own
+func ownKeyfile(name string) string {
+	return filepath.Join(keyfileDir, "hostplumber-"+name+".nmconnection")
+}
+
+func keyfileBackupDir() string {
+	return filepath.Join(StateDir, "persist-backup")
+}
+
+// profileOf returns the keyfile of the interface: the one hostplumber wrote,
+// or else the one of the distribution with its interface-name
+func profileOf(name string) (string, error) {
+	if _, err := os.Stat(ownKeyfile(name)); err == nil {
+		return ownKeyfile(name), nil
+	}
+	files, err := filepath.Glob(filepath.Join(keyfileDir, "*.nmconnection"))
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		if parseIni(string(data)).get("connection", "interface-name") == name {
+			return file, nil
+		}
+	}
+	return "", nil
+}
+
+func (keyfile) Write(iface Interface, all []Interface) error {
+	if iface.Kind == KindOvsBridge {
+		// NetworkManager only configures OVS bridges it creates itself
+		fmt.Printf("Not persisting addresses of ovs bridge %s in a NetworkManager keyfile\n", iface.Name)
+		return nil
+	}
+	path := ownKeyfile(iface.Name)
+	f := &iniFile{}
+	if iface.Kind == "" {
+		profile, err := profileOf(iface.Name)
+		if err != nil {
+			return err
+		}
+		if profile != "" && profile != path {
+			backup := filepath.Join(keyfileBackupDir(), filepath.Base(profile))
+			if _, err := os.Stat(backup); os.IsNotExist(err) {
+				data, err := ioutil.ReadFile(profile)
+				if err != nil {
+					return err
+				}
+				if err := writeFile(backup, data, 0600); err != nil {
+					return err
+				}
+			}
+			// Always starts from the original, the record holds all that
+			// hostplumber changes
+			data, err := ioutil.ReadFile(backup)
+			if err != nil {
+				return err
+			}
+			path = profile
+			f = parseIni(string(data))
+		}
+	}
+	return writeFile(path, []byte(keyfileFor(f, iface).String()), 0600)
+}
+
+func (keyfile) Delete(iface Interface, all []Interface) error {
+	profile, err := profileOf(iface.Name)
+	if err != nil || profile == "" {
+		return err
+	}
+	if profile == ownKeyfile(iface.Name) {
+		return removeFile(profile)
+	}
+	backup := filepath.Join(keyfileBackupDir(), filepath.Base(profile))
+	data, err := ioutil.ReadFile(backup)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := writeFile(profile, data, 0600); err != nil {
+		return err
+	}
+	return removeFile(backup)
+}
+
+// keyfileFor sets what hostplumber configures on the interface in its
+// profile f, which is empty for a profile of hostplumber's own
+func keyfileFor(f *iniFile, iface Interface) *iniFile {
+	if f.get("connection", "id") == "" {
+		connType := iface.Kind
+		if connType == "" {
+			connType = "ethernet"
+		}
+		f.set("connection", "id", "hostplumber-"+iface.Name)
+		f.set("connection", "type", connType)
+		f.set("connection", "interface-name", iface.Name)
+		f.set("connection", "autoconnect", "true")
+	}
+
+	switch iface.Kind {
+	case KindVlan:
+		f.set("vlan", "parent", iface.Parent)
+		f.set("vlan", "id", strconv.Itoa(iface.VlanID))
+	case KindBond:
+		f.set("bond", "mode", iface.BondMode)
+		f.set("bond", "miimon", strconv.Itoa(iface.Miimon))
+		if iface.LacpRate != "" {
+			f.set("bond", "lacp_rate", iface.LacpRate)
+		}
+		if iface.XmitHashPolicy != "" {
+			f.set("bond", "xmit_hash_policy", iface.XmitHashPolicy)
+		}
+	case KindBridge:
+		f.set("bridge", "stp", strconv.FormatBool(iface.Stp))
+		f.set("bridge", "vlan-filtering", strconv.FormatBool(iface.VlanFiltering))
+	case KindVxlan:
+		f.set("vxlan", "id", strconv.Itoa(iface.Vni))
+		if iface.LocalIP != "" {
+			f.set("vxlan", "local", iface.LocalIP)
+		}
+		// NetworkManager takes a unicast remote or a multicast group in remote
+		if iface.Remote != "" {
+			f.set("vxlan", "remote", iface.Remote)
+		} else if iface.Group != "" {
+			f.set("vxlan", "remote", iface.Group)
+		}
+		if iface.DstPort != 0 {
+			f.set("vxlan", "destination-port", strconv.Itoa(iface.DstPort))
+		}
+		if iface.Device != "" {
+			f.set("vxlan", "parent", iface.Device)
+		}
+	}
+
+	if iface.MTU != 0 {
+		f.set("ethernet", "mtu", strconv.Itoa(iface.MTU))
+	}
+	if iface.Master != "" {
+		// A port has no IP configuration of its own
+		f.set("connection", "master", iface.Master)
+		f.set("connection", "slave-type", iface.MasterKind)
+		f.deleteSection("ipv4")
+		f.deleteSection("ipv6")
+		return f
+	}
+	keyfileAddrs(f, "ipv4", iface.IPv4, iface.Kind != "")
+	keyfileAddrs(f, "ipv6", iface.IPv6, iface.Kind != "")
+	if iface.Routes != nil {
+		keyfileRoutes(f, iface.Routes)
+	}
+	if autoconf, set := iface.autoconf(); set {
+		switch method := f.get("ipv6", "method"); {
+		case autoconf:
+			// auto also keeps the static addresses
+			f.set("ipv6", "method", "auto")
+		case method == "auto" || method == "dhcp":
+			f.set("ipv6", "method", "disabled")
+		}
+	}
+	return f
+}
+
+// keyfileAddrs sets the static addresses of a family. Without addresses to
+// set, the interfaces hostplumber creates get no IP configuration and the
+// others keep theirs.
+func keyfileAddrs(f *iniFile, section string, addrs []string, created bool) {
+	if addrs == nil {
+		if created {
+			f.set(section, "method", "disabled")
+		}
+		return
+	}
+	for _, s := range f.sections {
+		if s.name == section {
+			var kept []string
+			for _, line := range s.lines {
+				if k, _, ok := splitKey(line); ok && (strings.HasPrefix(k, "address") || k == "method") {
+					continue
+				}
+				kept = append(kept, line)
+			}
+			s.lines = kept
+		}
+	}
+	if len(addrs) == 0 {
+		f.set(section, "method", "disabled")
+		return
+	}
+	f.set(section, "method", "manual")
+	for i, addr := range addrs {
+		f.set(section, "address"+strconv.Itoa(i+1), addr)
+	}
+}
+
+var keyfileRouteKey = regexp.MustCompile(`^(gateway|route[0-9]+(_options)?)$`)
+
+// keyfileRoutes replaces the gateways and routes of both families with
+// routes
+func keyfileRoutes(f *iniFile, routes []Route) {
+	for _, s := range f.sections {
+		if s.name != "ipv4" && s.name != "ipv6" {
+			continue
+		}
+		var kept []string
+		for _, line := range s.lines {
+			if k, _, ok := splitKey(line); ok && keyfileRouteKey.MatchString(k) {
+				continue
+			}
+			kept = append(kept, line)
+		}
+		s.lines = kept
+	}
+	count := map[string]int{}
+	for _, r := range routes {
+		section, dst, gw := "ipv4", r.Dst, r.Gw
+		if r.ipv6() {
+			section = "ipv6"
+		}
+		if r.isDefault() {
+			dst = map[string]string{"ipv4": "0.0.0.0/0", "ipv6": "::/0"}[section]
+		}
+		value := dst
+		if gw != "" || r.Metric != 0 {
+			if gw == "" {
+				gw = map[string]string{"ipv4": "0.0.0.0", "ipv6": "::"}[section]
+			}
+			value += "," + gw
+		}
+		if r.Metric != 0 {
+			value += "," + strconv.Itoa(r.Metric)
+		}
+		count[section]++
+		key := "route" + strconv.Itoa(count[section])
+		f.set(section, key, value)
+		var options []string
+		if r.Src != "" {
+			options = append(options, "src="+r.Src)
+		}
+		if r.Table != 0 {
+			options = append(options, "table="+strconv.Itoa(r.Table))
+		}
+		if len(options) > 0 {
+			f.set(section, key+"_options", strings.Join(options, ","))
+		}
+	}
+}
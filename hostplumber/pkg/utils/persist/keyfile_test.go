@@ -0,0 +1,131 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyfileVlan(t *testing.T) {
+	setup(t, keyfile{})
+	vlan := Interface{Name: "eth1.100", Kind: KindVlan, Parent: "eth1", VlanID: 100, MTU: 9000, IPv4: []string{"10.0.0.5/24"}}
+	if err := (keyfile{}).Write(vlan, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `[connection]
+id=hostplumber-eth1.100
+type=vlan
+interface-name=eth1.100
+autoconnect=true
+
+[vlan]
+parent=eth1
+id=100
+
+[ethernet]
+mtu=9000
+
+[ipv4]
+method=manual
+address1=10.0.0.5/24
+
+[ipv6]
+method=disabled
+`
+	path := ownKeyfile("eth1.100")
+	if got := readTestFile(t, path); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("NetworkManager ignores keyfiles readable by others: %v", info.Mode())
+	}
+	if err := (keyfile{}).Delete(vlan, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exists(path) {
+		t.Errorf("%s not removed", path)
+	}
+}
+
+func TestKeyfileExistingProfile(t *testing.T) {
+	setup(t, keyfile{})
+	profile := filepath.Join(keyfileDir, "Wired connection 1.nmconnection")
+	orig := `[connection]
+id=Wired connection 1
+uuid=8c6f6c3a-2f0e-4f62-9d3a-3f1e6c1a9b10
+type=ethernet
+interface-name=eth0
+
+[ipv4]
+method=auto
+
+[ipv6]
+addr-gen-mode=eui64
+method=auto
+`
+	writeTestFile(t, profile, orig)
+
+	// The addresses moved to an OVS bridge
+	nic := Interface{Name: "eth0", MTU: 9000, IPv4: []string{}}
+	if err := (keyfile{}).Write(nic, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `[connection]
+id=Wired connection 1
+uuid=8c6f6c3a-2f0e-4f62-9d3a-3f1e6c1a9b10
+type=ethernet
+interface-name=eth0
+
+[ipv4]
+method=disabled
+
+[ipv6]
+addr-gen-mode=eui64
+method=auto
+
+[ethernet]
+mtu=9000
+`
+	if got := readTestFile(t, profile); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if exists(ownKeyfile("eth0")) {
+		t.Errorf("a second profile was written for eth0")
+	}
+
+	if err := (keyfile{}).Delete(nic, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, profile); got != orig {
+		t.Errorf("not restored, got:\n%s", got)
+	}
+}
+
+func TestKeyfileRoutes(t *testing.T) {
+	f := parseIni(`[ipv4]
+method=manual
+address1=10.0.0.5/24
+gateway=10.0.0.1
+route1=10.2.0.0/16,10.0.0.253
+route-metric=50
+`)
+	keyfileRoutes(f, []Route{
+		{Dst: "default", Gw: "10.0.0.1", Metric: 100},
+		{Dst: "10.1.0.0/16", Src: "10.0.0.5", Table: 10},
+		{Dst: "fd01::/64", Gw: "fd00::1"},
+	})
+	want := `[ipv4]
+method=manual
+address1=10.0.0.5/24
+route-metric=50
+route1=0.0.0.0/0,10.0.0.1,100
+route2=10.1.0.0/16
+route2_options=src=10.0.0.5,table=10
+
+[ipv6]
+route1=fd01::/64,fd00::1
+`
+	if got := f.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+package persist
+
+import (
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+
+	"hostplumber/pkg/consts"
+)
+
+var netplanDir = consts.NetplanDir
+
+// netplanFile sorts after the distribution's files, so its settings amend
+// and override theirs
+const netplanFile = "90-hostplumber.yaml"
+
+// netplan writes one netplan file with every persisted interface. netplan
+// generates the units of its renderer from it at boot, hostplumber does not
+// run netplan apply.
+type netplan struct{}
+
+func (netplan) Name() string { return "netplan" }
+
+func (n netplan) Write(iface Interface, all []Interface) error {
+	return n.sync(all)
+}
+
+func (n netplan) Delete(iface Interface, all []Interface) error {
+	return n.sync(all)
+}
+
+func (netplan) sync(all []Interface) error {
+	path := filepath.Join(netplanDir, netplanFile)
+	if len(all) == 0 {
+		return removeFile(path)
+	}
+	data, err := netplanYaml(all)
+	if err != nil {
+		return err
+	}
+	// netplan warns about files readable by others, they may hold secrets
+	return writeFile(path, data, 0600)
+}
+
+func netplanYaml(all []Interface) ([]byte, error) {
+	sections := map[string]map[string]interface{}{}
+	add := func(section, name string, config map[string]interface{}) {
+		if sections[section] == nil {
+			sections[section] = map[string]interface{}{}
+		}
+		sections[section][name] = config
+	}
+
+	for _, iface := range all {
+		config := map[string]interface{}{}
+		if iface.MTU != 0 {
+			config["mtu"] = iface.MTU
+		}
+		if iface.IPv4 != nil || iface.IPv6 != nil {
+			// Replaces the addresses of the distribution's file, of both
+			// families
+			config["addresses"] = append(append([]string{}, iface.IPv4...), iface.IPv6...)
+		}
+		if iface.IPv4 != nil {
+			config["dhcp4"] = false
+		}
+		if iface.IPv6 != nil {
+			config["dhcp6"] = false
+		}
+		if autoconf, set := iface.autoconf(); set {
+			config["accept-ra"] = autoconf
+		}
+		if iface.Routes != nil {
+			// Replaces the routes and gateways of the distribution's file
+			config["routes"] = netplanRoutes(iface.Routes)
+		}
+
+		switch iface.Kind {
+		case KindVlan:
+			config["id"] = iface.VlanID
+			config["link"] = iface.Parent
+			add("vlans", iface.Name, config)
+		case KindBond:
+			config["interfaces"] = members(iface.Name, all)
+			params := map[string]interface{}{
+				"mode":                 iface.BondMode,
+				"mii-monitor-interval": iface.Miimon,
+			}
+			if iface.LacpRate != "" {
+				params["lacp-rate"] = iface.LacpRate
+			}
+			if iface.XmitHashPolicy != "" {
+				params["transmit-hash-policy"] = iface.XmitHashPolicy
+			}
+			config["parameters"] = params
+			add("bonds", iface.Name, config)
+		case KindBridge:
+			// netplan has no setting for VLAN filtering
+			config["interfaces"] = members(iface.Name, all)
+			config["parameters"] = map[string]interface{}{"stp": iface.Stp}
+			add("bridges", iface.Name, config)
+		case KindVxlan:
+			config["mode"] = "vxlan"
+			config["id"] = iface.Vni
+			if iface.LocalIP != "" {
+				config["local"] = iface.LocalIP
+			}
+			if iface.Remote != "" {
+				config["remote"] = iface.Remote
+			} else if iface.Group != "" {
+				config["remote"] = iface.Group
+			}
+			if iface.DstPort != 0 {
+				config["port"] = iface.DstPort
+			}
+			if iface.Device != "" {
+				config["link"] = iface.Device
+			}
+			add("tunnels", iface.Name, config)
+		default:
+			// The internal interface of an OVS bridge is matched by name
+			// like a NIC, netplan leaves OVS itself alone
+			add("ethernets", iface.Name, config)
+		}
+	}
+
+	network := map[string]interface{}{"version": 2}
+	for section, interfaces := range sections {
+		network[section] = interfaces
+	}
+	return yaml.Marshal(map[string]interface{}{"network": network})
+}
+
+func netplanRoutes(routes []Route) []map[string]interface{} {
+	result := []map[string]interface{}{}
+	for _, r := range routes {
+		route := map[string]interface{}{"to": r.Dst}
+		if r.isDefault() {
+			route["to"] = "default"
+			if r.ipv6() {
+				route["to"] = "::/0"
+			}
+		}
+		if r.Gw != "" {
+			route["via"] = r.Gw
+		}
+		if r.Src != "" {
+			route["from"] = r.Src
+		}
+		if r.Metric != 0 {
+			route["metric"] = r.Metric
+		}
+		if r.Table != 0 {
+			route["table"] = r.Table
+		}
+		result = append(result, route)
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package persist
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNetplan(t *testing.T) {
+	setup(t, netplan{})
+	all := []Interface{
+		{Name: "bond0", Kind: KindBond, BondMode: "802.3ad", Miimon: 100, LacpRate: "fast", MTU: 9000},
+		{Name: "br-ex", Kind: KindOvsBridge, IPv4: []string{"10.0.0.5/24"}},
+		{Name: "eth1", Master: "bond0", MasterKind: KindBond},
+		{Name: "eth2", Master: "bond0", MasterKind: KindBond},
+		{Name: "eth3", IPv4: []string{}},
+		{Name: "vx0", Kind: KindVxlan, Vni: 42, Remote: "10.0.0.9"},
+	}
+	if err := (netplan{}).Write(all[0], all); err != nil {
+		t.Fatal(err)
+	}
+	want := `network:
+  bonds:
+    bond0:
+      interfaces:
+      - eth1
+      - eth2
+      mtu: 9000
+      parameters:
+        lacp-rate: fast
+        mii-monitor-interval: 100
+        mode: 802.3ad
+  ethernets:
+    br-ex:
+      addresses:
+      - 10.0.0.5/24
+      dhcp4: false
+    eth1: {}
+    eth2: {}
+    eth3:
+      addresses: []
+      dhcp4: false
+  tunnels:
+    vx0:
+      id: 42
+      mode: vxlan
+      remote: 10.0.0.9
+  version: 2
+`
+	path := filepath.Join(netplanDir, netplanFile)
+	if got := readTestFile(t, path); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := (netplan{}).Delete(all[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	if exists(path) {
+		t.Errorf("%s not removed", path)
+	}
+}
+
+func TestNetplanRoutes(t *testing.T) {
+	data, err := netplanYaml([]Interface{
+		{Name: "br-ex", Kind: KindOvsBridge, Routes: []Route{
+			{Dst: "default", Gw: "10.0.0.1", Metric: 100},
+			{Dst: "10.1.0.0/16", Src: "10.0.0.5", Table: 10},
+		}},
+		{Name: "eth1", Routes: []Route{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `network:
+  ethernets:
+    br-ex:
+      routes:
+      - metric: 100
+        to: default
+        via: 10.0.0.1
+      - from: 10.0.0.5
+        table: 10
+        to: 10.1.0.0/16
+    eth1:
+      routes: []
+  version: 2
+`
+	if got := string(data); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
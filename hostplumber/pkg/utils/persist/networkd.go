@@ -0,0 +1,292 @@
+package persist
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"hostplumber/pkg/consts"
+)
+
+var (
+	networkdDir = consts.NetworkdDir
+	sysClassNet = consts.SysClassNet
+	// networkdRunDir holds the units generated at boot, like netplan's
+	networkdRunDir = filepath.Join(consts.HostRun, "systemd", "network")
+)
+
+// networkdPrefix sorts hostplumber's units before the distribution's, so
+// systemd-networkd applies them to the interfaces they match
+const networkdPrefix = "09-hostplumber-"
+
+// networkd writes systemd-networkd .netdev and .network units. The units of
+// every interface are rendered again on each change, as a parent lists the
+// VLANs and VXLANs on it in its own .network.
+type networkd struct{}
+
+func (networkd) Name() string { return "networkd" }
+
+func (n networkd) Write(iface Interface, all []Interface) error {
+	return n.sync(all)
+}
+
+func (n networkd) Delete(iface Interface, all []Interface) error {
+	return n.sync(all)
+}
+
+func networkdUnit(name, ext string) string {
+	return filepath.Join(networkdDir, networkdPrefix+name+ext)
+}
+
+// sync writes the units of all interfaces and of the parents of VLANs and
+// VXLANs, and removes the units of interfaces no longer persisted
+func (networkd) sync(all []Interface) error {
+	units := make(map[string][]byte)
+	names := make(map[string]bool)
+	for _, iface := range all {
+		names[iface.Name] = true
+		if iface.Kind == KindVlan {
+			names[iface.Parent] = true
+		}
+		if iface.Kind == KindVxlan && iface.Device != "" {
+			names[iface.Device] = true
+		}
+	}
+	for name := range names {
+		iface := find(name, all)
+		if iface == nil {
+			iface = &Interface{Name: name}
+		}
+		if netdev := netdevFor(*iface); netdev != nil {
+			units[networkdUnit(name, ".netdev")] = []byte(netdev.String())
+		}
+		base, err := distroNetwork(name)
+		if err != nil {
+			return err
+		}
+		units[networkdUnit(name, ".network")] = []byte(networkFor(base, *iface, all).String())
+	}
+
+	for path, data := range units {
+		if err := writeFile(path, data, 0644); err != nil {
+			return err
+		}
+	}
+	existing, err := filepath.Glob(filepath.Join(networkdDir, networkdPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		if _, ok := units[path]; !ok {
+			if err := removeFile(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// distroNetwork returns the .network of the distribution that matches the
+// interface by name or MAC address, which hostplumber's unit starts from as
+// it replaces it
+func distroNetwork(name string) (*iniFile, error) {
+	mac := ""
+	if data, err := ioutil.ReadFile(filepath.Join(sysClassNet, name, "address")); err == nil {
+		mac = strings.ToLower(strings.TrimSpace(string(data)))
+	}
+	var files []string
+	for _, dir := range []string{networkdDir, networkdRunDir} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.network"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range matches {
+			if !strings.HasPrefix(filepath.Base(file), networkdPrefix) {
+				files = append(files, file)
+			}
+		}
+	}
+	// systemd-networkd uses the first unit by file name, whatever its directory
+	sort.Slice(files, func(i, j int) bool { return filepath.Base(files[i]) < filepath.Base(files[j]) })
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		unit := parseIni(string(data))
+		for _, pattern := range strings.Fields(unit.get("Match", "Name")) {
+			if matched, _ := filepath.Match(pattern, name); matched {
+				return unit, nil
+			}
+		}
+		for _, addr := range strings.Fields(unit.get("Match", "MACAddress")) {
+			if mac != "" && strings.ToLower(addr) == mac {
+				return unit, nil
+			}
+		}
+	}
+	return &iniFile{}, nil
+}
+
+func netdevFor(iface Interface) *iniFile {
+	kind := iface.Kind
+	switch kind {
+	case KindVlan, KindBond, KindBridge, KindVxlan:
+	default:
+		return nil
+	}
+	f := &iniFile{}
+	f.set("NetDev", "Name", iface.Name)
+	f.set("NetDev", "Kind", kind)
+	if iface.MTU != 0 {
+		f.set("NetDev", "MTUBytes", strconv.Itoa(iface.MTU))
+	}
+	switch kind {
+	case KindVlan:
+		f.set("VLAN", "Id", strconv.Itoa(iface.VlanID))
+	case KindBond:
+		f.set("Bond", "Mode", iface.BondMode)
+		f.set("Bond", "MIIMonitorSec", strconv.Itoa(iface.Miimon)+"ms")
+		if iface.LacpRate != "" {
+			f.set("Bond", "LACPTransmitRate", iface.LacpRate)
+		}
+		if iface.XmitHashPolicy != "" {
+			f.set("Bond", "TransmitHashPolicy", iface.XmitHashPolicy)
+		}
+	case KindBridge:
+		f.set("Bridge", "STP", yesNo(iface.Stp))
+		f.set("Bridge", "VLANFiltering", yesNo(iface.VlanFiltering))
+	case KindVxlan:
+		f.set("VXLAN", "VNI", strconv.Itoa(iface.Vni))
+		if iface.LocalIP != "" {
+			f.set("VXLAN", "Local", iface.LocalIP)
+		}
+		if iface.Remote != "" {
+			f.set("VXLAN", "Remote", iface.Remote)
+		}
+		if iface.Group != "" {
+			f.set("VXLAN", "Group", iface.Group)
+		}
+		if iface.DstPort != 0 {
+			f.set("VXLAN", "DestinationPort", strconv.Itoa(iface.DstPort))
+		}
+	}
+	return f
+}
+
+// networkFor sets what hostplumber configures on the interface in the
+// .network it starts from
+func networkFor(f *iniFile, iface Interface, all []Interface) *iniFile {
+	f.deleteSection("Match")
+	match := &iniSection{name: "Match", lines: []string{"Name=" + iface.Name}}
+	f.sections = append([]*iniSection{match}, f.sections...)
+
+	if iface.MTU != 0 && netdevFor(iface) == nil {
+		f.set("Link", "MTUBytes", strconv.Itoa(iface.MTU))
+	}
+	if iface.Kind != "" {
+		// Up without an address or carrier, as the kernel has it
+		f.set("Network", "ConfigureWithoutCarrier", "yes")
+	}
+	vlans, vxlans := children(iface.Name, all)
+	for _, vlan := range vlans {
+		f.add("Network", "VLAN", vlan)
+	}
+	for _, vxlan := range vxlans {
+		f.add("Network", "VXLAN", vxlan)
+	}
+
+	ipv4, ipv6 := iface.IPv4, iface.IPv6
+	if iface.Master != "" {
+		switch iface.MasterKind {
+		case KindBond:
+			f.set("Network", "Bond", iface.Master)
+		case KindBridge:
+			f.set("Network", "Bridge", iface.Master)
+		}
+		f.set("Network", "LinkLocalAddressing", "no")
+		ipv4, ipv6 = []string{}, []string{}
+	}
+	if ipv4 != nil {
+		f.del("Network", "Address", isIPv4)
+		f.set("Network", "DHCP", dhcpWithout(f.get("Network", "DHCP"), "ipv4"))
+		for _, addr := range ipv4 {
+			f.add("Network", "Address", addr)
+		}
+	}
+	if ipv6 != nil {
+		f.del("Network", "Address", func(addr string) bool { return !isIPv4(addr) })
+		f.set("Network", "DHCP", dhcpWithout(f.get("Network", "DHCP"), "ipv6"))
+		for _, addr := range ipv6 {
+			f.add("Network", "Address", addr)
+		}
+	}
+	if autoconf, set := iface.autoconf(); set && iface.Master == "" {
+		f.set("Network", "IPv6AcceptRA", yesNo(autoconf))
+	}
+	if iface.Routes != nil {
+		f.del("Network", "Gateway", nil)
+		f.deleteSection("Route")
+		for _, r := range iface.Routes {
+			f.sections = append(f.sections, routeSection(r))
+		}
+	}
+	return f
+}
+
+// routeSection returns the [Route] section of a route
+func routeSection(r Route) *iniSection {
+	dst := r.Dst
+	if r.isDefault() {
+		dst = "0.0.0.0/0"
+		if r.ipv6() {
+			dst = "::/0"
+		}
+	}
+	lines := []string{"Destination=" + dst}
+	if r.Gw != "" {
+		lines = append(lines, "Gateway="+r.Gw)
+	}
+	if r.Src != "" {
+		lines = append(lines, "PreferredSource="+r.Src)
+	}
+	if r.Metric != 0 {
+		lines = append(lines, "Metric="+strconv.Itoa(r.Metric))
+	}
+	if r.Table != 0 {
+		lines = append(lines, "Table="+strconv.Itoa(r.Table))
+	}
+	return &iniSection{name: "Route", lines: lines}
+}
+
+// dhcpWithout returns the DHCP= setting without one of the families
+func dhcpWithout(dhcp, family string) string {
+	other := map[string]string{"ipv4": "ipv6", "ipv6": "ipv4"}[family]
+	switch dhcp {
+	case "yes", "true", "both":
+		return other
+	case other:
+		return other
+	}
+	return "no"
+}
+
+func isIPv4(cidr string) bool {
+	ip, _ := splitCidr(cidr)
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() != nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,125 @@
+package persist
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNetworkd(t *testing.T) {
+	setup(t, networkd{})
+	writeTestFile(t, filepath.Join(networkdRunDir, "10-netplan-eth0.network"), `[Match]
+MACAddress=52:54:00:12:34:56
+
+[Network]
+DHCP=yes
+Address=fd00::5/64
+`)
+	writeTestFile(t, filepath.Join(sysClassNet, "eth0", "address"), "52:54:00:12:34:56\n")
+
+	all := []Interface{
+		{Name: "eth0", IPv4: []string{"10.0.0.5/24"}},
+		{Name: "eth0.100", Kind: KindVlan, Parent: "eth0", VlanID: 100, MTU: 9000},
+	}
+	if err := (networkd{}).Write(all[1], all); err != nil {
+		t.Fatal(err)
+	}
+	want := `[Match]
+Name=eth0
+
+[Network]
+Address=fd00::5/64
+VLAN=eth0.100
+DHCP=ipv6
+Address=10.0.0.5/24
+`
+	if got := readTestFile(t, networkdUnit("eth0", ".network")); got != want {
+		t.Errorf("eth0.network:\n%s\nwant:\n%s", got, want)
+	}
+	want = `[NetDev]
+Name=eth0.100
+Kind=vlan
+MTUBytes=9000
+
+[VLAN]
+Id=100
+`
+	if got := readTestFile(t, networkdUnit("eth0.100", ".netdev")); got != want {
+		t.Errorf("eth0.100.netdev:\n%s\nwant:\n%s", got, want)
+	}
+	want = `[Match]
+Name=eth0.100
+
+[Network]
+ConfigureWithoutCarrier=yes
+`
+	if got := readTestFile(t, networkdUnit("eth0.100", ".network")); got != want {
+		t.Errorf("eth0.100.network:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The distribution's unit applies again once nothing is persisted
+	if err := (networkd{}).Delete(all[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(networkdDir, "*"))
+	if len(matches) != 0 {
+		t.Errorf("units left: %v", matches)
+	}
+}
+
+func TestDhcpWithout(t *testing.T) {
+	for _, tc := range []struct{ dhcp, family, want string }{
+		{"yes", "ipv4", "ipv6"},
+		{"ipv4", "ipv4", "no"},
+		{"ipv4", "ipv6", "ipv4"},
+		{"", "ipv6", "no"},
+	} {
+		if got := dhcpWithout(tc.dhcp, tc.family); got != tc.want {
+			t.Errorf("DHCP=%s without %s: got %s, want %s", tc.dhcp, tc.family, got, tc.want)
+		}
+	}
+}
+
+func TestNetworkdRoutes(t *testing.T) {
+	base := parseIni(`[Network]
+Address=10.0.0.5/24
+Gateway=10.0.0.1
+
+[Route]
+Destination=10.2.0.0/16
+Gateway=10.0.0.253
+`)
+	nic := networkFor(base, Interface{Name: "eth1", IPv4: []string{}, Routes: []Route{}}, nil)
+	want := `[Match]
+Name=eth1
+
+[Network]
+DHCP=no
+`
+	if got := nic.String(); got != want {
+		t.Errorf("eth1:\n%s\nwant:\n%s", got, want)
+	}
+
+	br := networkFor(&iniFile{}, Interface{Name: "br-ex", Kind: KindOvsBridge, Routes: []Route{
+		{Dst: "default", Gw: "10.0.0.1", Metric: 100},
+		{Dst: "::/0", Gw: "fd00::1", Table: 10},
+	}}, nil)
+	want = `[Match]
+Name=br-ex
+
+[Network]
+ConfigureWithoutCarrier=yes
+
+[Route]
+Destination=0.0.0.0/0
+Gateway=10.0.0.1
+Metric=100
+
+[Route]
+Destination=::/0
+Gateway=fd00::1
+Table=10
+`
+	if got := br.String(); got != want {
+		t.Errorf("br-ex:\n%s\nwant:\n%s", got, want)
+	}
+}
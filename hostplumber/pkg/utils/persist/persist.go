@@ -0,0 +1,336 @@
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"hostplumber/pkg/consts"
+)
+
+// StateDir holds the persisted interfaces under persist/, so each section can
+// update its part of an interface and the backend writes all of it. It is on
+// the host, with the original files of the interfaces, so what to restore is
+// known after the pod restarts.
+var StateDir = consts.HostStateDir
+
+// Kinds of interfaces hostplumber creates. An interface that existed before,
+// like a NIC, has no kind.
+const (
+	KindVlan   = "vlan"
+	KindBond   = "bond"
+	KindBridge = "bridge"
+	KindVxlan  = "vxlan"
+	// KindOvsBridge is the internal interface of an OVS bridge. OVS creates
+	// the bridge again at boot, only its addresses are persisted.
+	KindOvsBridge = "ovs-bridge"
+)
+
+// Interface is what hostplumber configured on an interface and persists so
+// it is configured the same way at boot
+type Interface struct {
+	Name string `json:"name"`
+	Kind string `json:"kind,omitempty"`
+
+	// Parent and VlanID of a VLAN
+	Parent string `json:"parent,omitempty"`
+	VlanID int    `json:"vlanId,omitempty"`
+
+	// BondMode, Miimon in ms, LacpRate and XmitHashPolicy of a bond. Empty
+	// LacpRate and XmitHashPolicy keep the kernel default.
+	BondMode       string `json:"bondMode,omitempty"`
+	Miimon         int    `json:"miimon,omitempty"`
+	LacpRate       string `json:"lacpRate,omitempty"`
+	XmitHashPolicy string `json:"xmitHashPolicy,omitempty"`
+
+	// Stp and VlanFiltering of a bridge
+	Stp           bool `json:"stp,omitempty"`
+	VlanFiltering bool `json:"vlanFiltering,omitempty"`
+
+	// Vni, LocalIP, Remote or Group, DstPort and Device of a VXLAN
+	Vni     int    `json:"vni,omitempty"`
+	LocalIP string `json:"localIP,omitempty"`
+	Remote  string `json:"remote,omitempty"`
+	Group   string `json:"group,omitempty"`
+	DstPort int    `json:"dstPort,omitempty"`
+	Device  string `json:"device,omitempty"`
+
+	// Master is the bond or bridge the interface is enslaved to, MasterKind
+	// is KindBond or KindBridge
+	Master     string `json:"master,omitempty"`
+	MasterKind string `json:"masterKind,omitempty"`
+
+	MTU int `json:"mtu,omitempty"`
+	// IPv4 and IPv6 are the static addresses. Nil leaves the addresses as
+	// the distribution configured them, empty removes them.
+	IPv4 []string `json:"ipv4"`
+	IPv6 []string `json:"ipv6"`
+	// IPv6Autoconf enables or disables SLAAC, nil leaves it as the
+	// distribution configured it unless IPv6 is set
+	IPv6Autoconf *bool `json:"ipv6Autoconf,omitempty"`
+	// Routes are the static routes through the interface. Nil leaves the
+	// routes and gateways as the distribution configured them, empty
+	// removes them.
+	Routes []Route `json:"routes"`
+}
+
+// Route is a static route through an interface. Dst is a CIDR or default,
+// which is an IPv4 default route.
+type Route struct {
+	Dst    string `json:"dst"`
+	Gw     string `json:"gw,omitempty"`
+	Src    string `json:"src,omitempty"`
+	Table  int    `json:"table,omitempty"`
+	Metric int    `json:"metric,omitempty"`
+}
+
+func (r Route) ipv6() bool {
+	return strings.Contains(r.Dst, ":")
+}
+
+// isDefault returns whether the route is a default route of its family
+func (r Route) isDefault() bool {
+	return r.Dst == "default" || r.Dst == "0.0.0.0/0" || r.Dst == "::/0"
+}
+
+// empty returns whether nothing is left to persist for an interface that
+// existed before
+func (i Interface) empty() bool {
+	return i.Kind == "" && i.Master == "" && i.MTU == 0 && i.IPv4 == nil && i.IPv6 == nil && i.IPv6Autoconf == nil && i.Routes == nil
+}
+
+// autoconf returns whether SLAAC is enabled, and whether it is set at all.
+// Static IPv6 addresses disable it unless IPv6Autoconf enables it.
+func (i Interface) autoconf() (bool, bool) {
+	if i.IPv6Autoconf != nil {
+		return *i.IPv6Autoconf, true
+	}
+	return false, i.IPv6 != nil
+}
+
+// Backend writes the interfaces to the network configuration of the node
+type Backend interface {
+	// Name is ifcfg, keyfile, networkd, netplan or none
+	Name() string
+	// Write persists iface. all holds every persisted interface, iface included.
+	Write(iface Interface, all []Interface) error
+	// Delete removes what Write persisted for iface, putting back the
+	// configuration the distribution had for it. all no longer holds iface.
+	Delete(iface Interface, all []Interface) error
+}
+
+var backends = map[string]Backend{
+	"ifcfg":    ifcfg{},
+	"keyfile":  keyfile{},
+	"networkd": networkd{},
+	"netplan":  netplan{},
+	"none":     none{},
+}
+
+var (
+	mu      sync.Mutex
+	backend Backend
+)
+
+// SetBackend selects the backend by name, or detects it with "auto"
+func SetBackend(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "auto" {
+		backend = Detect()
+		fmt.Printf("Detected %s network configuration\n", backend.Name())
+		return nil
+	}
+	b, ok := backends[name]
+	if !ok {
+		return fmt.Errorf("unknown persistence backend %q", name)
+	}
+	backend = b
+	return nil
+}
+
+// currentBackend returns the selected backend, detecting it the first time
+// when none was set
+func currentBackend() Backend {
+	if backend == nil {
+		backend = Detect()
+		fmt.Printf("Detected %s network configuration\n", backend.Name())
+	}
+	return backend
+}
+
+func recordDir() string {
+	return filepath.Join(StateDir, "persist")
+}
+
+func recordFile(name string) string {
+	return filepath.Join(recordDir(), name+".json")
+}
+
+// List returns the persisted interfaces, sorted by name
+func List() ([]Interface, error) {
+	files, err := ioutil.ReadDir(recordDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var all []Interface
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(recordDir(), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var iface Interface
+		if err := json.Unmarshal(data, &iface); err != nil {
+			return nil, fmt.Errorf("invalid persisted interface %s: %w", file.Name(), err)
+		}
+		all = append(all, iface)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all, nil
+}
+
+// Update changes what is persisted for an interface and writes it with the
+// backend. An interface that existed before and has nothing left to persist
+// gets its original configuration back.
+func Update(name string, update func(*Interface)) error {
+	mu.Lock()
+	defer mu.Unlock()
+	all, err := List()
+	if err != nil {
+		return err
+	}
+	iface := Interface{Name: name}
+	found := false
+	var others []Interface
+	for _, existing := range all {
+		if existing.Name == name {
+			iface = existing
+			found = true
+		} else {
+			others = append(others, existing)
+		}
+	}
+	update(&iface)
+	if iface.empty() {
+		if !found {
+			return nil
+		}
+		return deleteLocked(name, all)
+	}
+
+	data, err := json.Marshal(iface)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(recordDir(), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(recordFile(name), data, 0644); err != nil {
+		return err
+	}
+	all = append(others, iface)
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	if err := currentBackend().Write(iface, all); err != nil {
+		return fmt.Errorf("failed to persist %s with %s: %w", name, currentBackend().Name(), err)
+	}
+	return nil
+}
+
+// Delete removes what is persisted for an interface, as when it is deleted
+func Delete(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	all, err := List()
+	if err != nil {
+		return err
+	}
+	return deleteLocked(name, all)
+}
+
+func deleteLocked(name string, all []Interface) error {
+	iface := Interface{Name: name}
+	var others []Interface
+	for _, existing := range all {
+		if existing.Name == name {
+			iface = existing
+		} else {
+			others = append(others, existing)
+		}
+	}
+	if err := os.Remove(recordFile(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := currentBackend().Delete(iface, others); err != nil {
+		return fmt.Errorf("failed to remove persisted %s with %s: %w", name, currentBackend().Name(), err)
+	}
+	return nil
+}
+
+// none persists nothing, on nodes without a known network configuration
+type none struct{}
+
+func (none) Name() string                        { return "none" }
+func (none) Write(Interface, []Interface) error  { return nil }
+func (none) Delete(Interface, []Interface) error { return nil }
+
+// writeFile writes a configuration file only when its content changes
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if current, err := ioutil.ReadFile(path); err == nil && string(current) == string(data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, perm)
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// children returns the VLANs and VXLANs that use name as parent or device
+func children(name string, all []Interface) (vlans, vxlans []string) {
+	for _, iface := range all {
+		switch {
+		case iface.Kind == KindVlan && iface.Parent == name:
+			vlans = append(vlans, iface.Name)
+		case iface.Kind == KindVxlan && iface.Device == name:
+			vxlans = append(vxlans, iface.Name)
+		}
+	}
+	return vlans, vxlans
+}
+
+// members returns the interfaces enslaved to a bond or bridge
+func members(name string, all []Interface) []string {
+	var list []string
+	for _, iface := range all {
+		if iface.Master == name {
+			list = append(list, iface.Name)
+		}
+	}
+	return list
+}
+
+func find(name string, all []Interface) *Interface {
+	for i := range all {
+		if all[i].Name == name {
+			return &all[i]
+		}
+	}
+	return nil
+}
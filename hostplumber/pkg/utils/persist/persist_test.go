@@ -0,0 +1,125 @@
+package persist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setup points every directory at a temporary host and selects a backend
+func setup(t *testing.T, b Backend) string {
+	t.Helper()
+	host := t.TempDir()
+	StateDir = filepath.Join(host, "state")
+	ifCfgDir = filepath.Join(host, "network-scripts")
+	keyfileDir = filepath.Join(host, "system-connections")
+	networkdDir = filepath.Join(host, "network")
+	networkdRunDir = filepath.Join(host, "run", "systemd", "network")
+	netplanDir = filepath.Join(host, "netplan")
+	hostRun = filepath.Join(host, "run")
+	sysClassNet = filepath.Join(host, "sys")
+	backend = b
+	return host
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUpdateRestoresOriginal(t *testing.T) {
+	setup(t, ifcfg{})
+	orig := "DEVICE=eth0\nBOOTPROTO=dhcp\nONBOOT=yes\n"
+	writeTestFile(t, ifCfgFile("eth0"), orig)
+
+	if err := Update("eth0", func(iface *Interface) { iface.MTU = 9000 }); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readTestFile(t, ifCfgFile("eth0")), orig+"MTU=9000\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := Update("eth0", func(iface *Interface) { iface.IPv4 = []string{"10.0.0.5/24"} }); err != nil {
+		t.Fatal(err)
+	}
+	want := "DEVICE=eth0\nONBOOT=yes\nMTU=9000\nBOOTPROTO=none\nIPADDR=10.0.0.5\nPREFIX=24\n"
+	if got := readTestFile(t, ifCfgFile("eth0")); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	all, err := List()
+	if err != nil || len(all) != 1 || all[0].MTU != 9000 || all[0].IPv6 != nil {
+		t.Fatalf("List = %+v, %v", all, err)
+	}
+
+	// Nothing left to persist puts the original back
+	err = Update("eth0", func(iface *Interface) {
+		iface.MTU = 0
+		iface.IPv4 = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, ifCfgFile("eth0")); got != orig {
+		t.Errorf("got %q, want the original %q", got, orig)
+	}
+	if all, _ := List(); len(all) != 0 {
+		t.Errorf("record kept: %+v", all)
+	}
+
+	// Clearing an interface never persisted leaves its file alone
+	writeTestFile(t, ifCfgFile("eth1"), orig)
+	if err := Update("eth1", func(iface *Interface) { iface.IPv4 = nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ifCfgFile("eth1")); err != nil {
+		t.Errorf("ifcfg-eth1 removed: %v", err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	host := setup(t, nil)
+	if got := Detect().Name(); got != "none" {
+		t.Errorf("empty host: got %s", got)
+	}
+
+	writeTestFile(t, filepath.Join(ifCfgDir, "ifcfg-lo"), "")
+	writeTestFile(t, filepath.Join(hostRun, "NetworkManager", "NetworkManager.pid"), "1")
+	if got := Detect().Name(); got != "keyfile" {
+		t.Errorf("NetworkManager without ifcfg files: got %s", got)
+	}
+	writeTestFile(t, ifCfgFile("eth0"), "DEVICE=eth0\n")
+	if got := Detect().Name(); got != "ifcfg" {
+		t.Errorf("NetworkManager with ifcfg files: got %s", got)
+	}
+
+	os.RemoveAll(filepath.Join(host, "run"))
+	writeTestFile(t, filepath.Join(hostRun, "systemd", "netif", "state"), "")
+	if got := Detect().Name(); got != "networkd" {
+		t.Errorf("networkd: got %s", got)
+	}
+
+	// Its own file does not make a node a netplan one
+	writeTestFile(t, filepath.Join(netplanDir, netplanFile), "")
+	if got := Detect().Name(); got != "networkd" {
+		t.Errorf("only hostplumber's netplan file: got %s", got)
+	}
+	writeTestFile(t, filepath.Join(netplanDir, "50-cloud-init.yaml"), "")
+	if got := Detect().Name(); got != "netplan" {
+		t.Errorf("netplan: got %s", got)
+	}
+}
@@ -0,0 +1,102 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"hostplumber/pkg/consts"
+	sysctlutils "hostplumber/pkg/utils/sysctl"
+)
+
+// Dirs are the directories hostplumber writes to: its per template state, the
+// network configuration files of every persistence backend and sysctl.d
+var Dirs = []string{
+	consts.HostPlumberCfg,
+	consts.HostStateDir,
+	consts.RhelNetworkScripts,
+	consts.NMConnections,
+	consts.NetworkdDir,
+	consts.NetplanDir,
+	consts.SysctlD,
+}
+
+// restorable returns whether a file is put back by a restore. The state of
+// VFs stays, they are not restored, and only the sysctl.d files hostplumber
+// writes are.
+func restorable(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	if filepath.Clean(dir) == filepath.Clean(sysctlutils.SysctlD) {
+		return strings.HasPrefix(rel, sysctlutils.ConfFilePrefix)
+	}
+	return !strings.HasPrefix(rel, "sriov"+string(filepath.Separator))
+}
+
+// readFiles returns the content of every restorable file under Dirs, by path
+func readFiles() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, dir := range Dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !info.Mode().IsRegular() || !restorable(dir, path) {
+				return nil
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[path] = data
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// restoreFiles writes back the files that changed and removes the ones
+// created since the snapshot
+func restoreFiles(files map[string][]byte) error {
+	current, err := readFiles()
+	if err != nil {
+		return err
+	}
+	for path := range current {
+		if _, ok := files[path]; !ok {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for path, data := range files {
+		if existing, ok := current[path]; ok && string(existing) == string(data) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0766); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, data, fileMode(path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileMode returns the mode of a file to write back. NetworkManager and
+// netplan want their files readable by root only.
+func fileMode(path string) os.FileMode {
+	if strings.HasSuffix(path, ".nmconnection") || strings.HasSuffix(path, ".yaml") {
+		return 0600
+	}
+	return 0644
+}
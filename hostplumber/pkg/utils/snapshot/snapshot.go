@@ -0,0 +1,462 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+
+	ovsutils "hostplumber/pkg/utils/ovs"
+	sysctlutils "hostplumber/pkg/utils/sysctl"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// linkTypes are the kinds of links hostplumber creates, which a restore
+// deletes when they did not exist in the snapshot
+var linkTypes = map[string]bool{"vlan": true, "bond": true, "vxlan": true, "bridge": true}
+
+// Snapshot is the host network state a template can change and a restore
+// puts back: links with their MTU, master and addresses, routes, OVS bridges
+// and ports, the sysctls the template manages, and the files hostplumber
+// writes. VFs are not part of it.
+type Snapshot struct {
+	Links   []Link
+	Routes  []Route
+	Bridges []Bridge
+	// Sysctls are the values of the sysctls taken, by key. A per interface
+	// sysctl of an interface that does not exist yet is left out.
+	Sysctls map[string]string
+	Files   map[string][]byte
+}
+
+// Link is the state of a network interface
+type Link struct {
+	Name   string
+	Type   string
+	MTU    int
+	Up     bool
+	Master string
+	// Addrs are the permanent addresses, without the IPv6 link-local ones
+	Addrs []string
+}
+
+// Route is a route with the name of its link, which keeps its index only
+// as long as the link is not recreated
+type Route struct {
+	netlink.Route
+	LinkName string
+}
+
+// Bridge is an OVS bridge with its ports, the internal port excluded
+type Bridge struct {
+	Name         string
+	DatapathType string
+	Ports        []ovsutils.PortConfig
+}
+
+// Take snapshots the host network state, with the value of sysctls
+func Take(sysctls []string) (*Snapshot, error) {
+	s := &Snapshot{}
+	var err error
+	if s.Sysctls, err = readSysctls(sysctls); err != nil {
+		return nil, err
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string)
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+	for _, link := range links {
+		attrs := link.Attrs()
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %w", attrs.Name, err)
+		}
+		s.Links = append(s.Links, Link{
+			Name:   attrs.Name,
+			Type:   link.Type(),
+			MTU:    attrs.MTU,
+			Up:     attrs.Flags&net.FlagUp != 0,
+			Master: names[attrs.MasterIndex],
+			Addrs:  permanentAddrs(addrs),
+		})
+	}
+
+	routes, err := listRoutes()
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		s.Routes = append(s.Routes, Route{Route: route, LinkName: names[route.LinkIndex]})
+	}
+
+	if s.Bridges, err = ovsBridges(); err != nil {
+		return nil, err
+	}
+	if s.Files, err = readFiles(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Restore puts the host network state back as it was in the snapshot: OVS
+// first, which moves NICs out of the bridges they were added to, then links,
+// addresses, routes, sysctls and files. It goes on after an error, returning the first.
+func (s *Snapshot) Restore() error {
+	var firstErr error
+	keep := func(err error) {
+		if err != nil {
+			fmt.Printf("Restoring snapshot: %v\n", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	keep(s.restoreOvs())
+	keep(s.restoreLinks())
+	keep(s.restoreRoutes())
+	keep(s.restoreSysctls())
+	keep(restoreFiles(s.Files))
+	return firstErr
+}
+
+func permanentAddrs(addrs []netlink.Addr) []string {
+	var list []string
+	for _, addr := range addrs {
+		if addr.Flags&unix.IFA_F_PERMANENT == 0 || addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		list = append(list, addr.IPNet.String())
+	}
+	return list
+}
+
+func ovsBridges() ([]Bridge, error) {
+	brList, err := ovsutils.GetOvsBrList()
+	if err != nil {
+		return nil, err
+	}
+	var bridges []Bridge
+	for _, brName := range brList {
+		datapathType, err := ovsutils.GetBridgeDatapathType(brName)
+		if err != nil {
+			return nil, err
+		}
+		portNames, err := ovsutils.ListPorts(brName)
+		if err != nil {
+			return nil, err
+		}
+		bridge := Bridge{Name: brName, DatapathType: datapathType}
+		for _, portName := range portNames {
+			port, _, err := ovsutils.GetPort(portName)
+			if err != nil {
+				return nil, err
+			}
+			if port != nil {
+				bridge.Ports = append(bridge.Ports, *port)
+			}
+		}
+		bridges = append(bridges, bridge)
+	}
+	return bridges, nil
+}
+
+func (s *Snapshot) restoreOvs() error {
+	current, err := ovsBridges()
+	if err != nil {
+		return err
+	}
+	want := make(map[string]Bridge)
+	for _, bridge := range s.Bridges {
+		want[bridge.Name] = bridge
+	}
+	for _, bridge := range current {
+		if _, ok := want[bridge.Name]; !ok {
+			fmt.Printf("Restoring snapshot: deleting ovs bridge %s\n", bridge.Name)
+			if err := ovsutils.DeleteOvsBr(bridge.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, bridge := range s.Bridges {
+		exists, err := ovsutils.BridgeExists(bridge.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			fmt.Printf("Restoring snapshot: adding ovs bridge %s\n", bridge.Name)
+			if err := ovsutils.AddBridge(bridge.Name, bridge.DatapathType); err != nil {
+				return err
+			}
+		}
+		portNames, err := ovsutils.ListPorts(bridge.Name)
+		if err != nil {
+			return err
+		}
+		for _, portName := range portNames {
+			if !hasPort(bridge.Ports, portName) {
+				fmt.Printf("Restoring snapshot: deleting ovs port %s of %s\n", portName, bridge.Name)
+				if err := ovsutils.DelPort(bridge.Name, portName); err != nil {
+					return err
+				}
+			}
+		}
+		for _, port := range bridge.Ports {
+			matches, err := ovsutils.PortMatches(bridge.Name, port)
+			if err != nil {
+				return err
+			}
+			if !matches {
+				fmt.Printf("Restoring snapshot: adding ovs port %s to %s\n", port.Name, bridge.Name)
+				if err := ovsutils.AddPort(bridge.Name, port); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasPort(ports []ovsutils.PortConfig, name string) bool {
+	for _, port := range ports {
+		if port.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Snapshot) restoreLinks() error {
+	want := make(map[string]Link)
+	for _, link := range s.Links {
+		want[link.Name] = link
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		name := link.Attrs().Name
+		if _, ok := want[name]; !ok && linkTypes[link.Type()] {
+			fmt.Printf("Restoring snapshot: deleting %s %s\n", link.Type(), name)
+			if err := netlink.LinkDel(link); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", name, err)
+			}
+		}
+	}
+
+	for _, old := range s.Links {
+		link, err := netlink.LinkByName(old.Name)
+		if err != nil {
+			// Deleted links, like VLANs, are recreated from their persisted
+			// configuration at boot, but not here
+			fmt.Printf("Restoring snapshot: %s no longer exists\n", old.Name)
+			continue
+		}
+		if err := restoreLink(link, old); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", old.Name, err)
+		}
+	}
+	return nil
+}
+
+func restoreLink(link netlink.Link, old Link) error {
+	attrs := link.Attrs()
+	master := ""
+	if attrs.MasterIndex != 0 {
+		if m, err := netlink.LinkByIndex(attrs.MasterIndex); err == nil {
+			master = m.Attrs().Name
+		}
+	}
+	if master != old.Master {
+		if old.Master == "" {
+			if err := netlink.LinkSetNoMaster(link); err != nil {
+				return err
+			}
+		} else if m, err := netlink.LinkByName(old.Master); err == nil {
+			if err := netlink.LinkSetMaster(link, m); err != nil {
+				return err
+			}
+		}
+	}
+	if attrs.MTU != old.MTU {
+		if err := netlink.LinkSetMTU(link, old.MTU); err != nil {
+			return err
+		}
+	}
+	if old.Up && attrs.Flags&net.FlagUp == 0 {
+		if err := netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	current := permanentAddrs(addrs)
+	for _, addr := range current {
+		if !contains(old.Addrs, addr) {
+			a, err := netlink.ParseAddr(addr)
+			if err != nil {
+				return err
+			}
+			if err := netlink.AddrDel(link, a); err != nil {
+				return fmt.Errorf("failed to delete address %s: %w", addr, err)
+			}
+		}
+	}
+	for _, addr := range old.Addrs {
+		if !contains(current, addr) {
+			a, err := netlink.ParseAddr(addr)
+			if err != nil {
+				return err
+			}
+			if err := netlink.AddrAdd(link, a); err != nil {
+				return fmt.Errorf("failed to add address %s: %w", addr, err)
+			}
+		}
+	}
+	return nil
+}
+
+// listRoutes returns the routes of every table that are not added by the
+// kernel or by router advertisements and DHCP
+func listRoutes() ([]netlink.Route, error) {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	var list []netlink.Route
+	for _, route := range routes {
+		switch route.Protocol {
+		case unix.RTPROT_KERNEL, unix.RTPROT_RA, unix.RTPROT_DHCP:
+			continue
+		}
+		list = append(list, route)
+	}
+	return list, nil
+}
+
+// routeKey identifies a route by what makes it unique in the kernel
+func routeKey(route netlink.Route, linkName string) string {
+	dst := "default"
+	if route.Dst != nil {
+		dst = route.Dst.String()
+	}
+	return fmt.Sprintf("%s via %s dev %s src %s table %d metric %d", dst, route.Gw, linkName, route.Src, route.Table, route.Priority)
+}
+
+func (s *Snapshot) restoreRoutes() error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+	names := make(map[int]string)
+	indexes := make(map[string]int)
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+		indexes[link.Attrs().Name] = link.Attrs().Index
+	}
+
+	want := make(map[string]bool)
+	for _, route := range s.Routes {
+		want[routeKey(route.Route, route.LinkName)] = true
+	}
+	current, err := listRoutes()
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for _, route := range current {
+		key := routeKey(route, names[route.LinkIndex])
+		have[key] = true
+		if !want[key] {
+			fmt.Printf("Restoring snapshot: deleting route %s\n", key)
+			route := route
+			if err := netlink.RouteDel(&route); err != nil {
+				return fmt.Errorf("failed to delete route %s: %w", key, err)
+			}
+		}
+	}
+
+	for _, old := range s.Routes {
+		key := routeKey(old.Route, old.LinkName)
+		if have[key] {
+			continue
+		}
+		route := old.Route
+		if old.LinkName != "" {
+			index, ok := indexes[old.LinkName]
+			if !ok {
+				fmt.Printf("Restoring snapshot: route %s has no link\n", key)
+				continue
+			}
+			route.LinkIndex = index
+		}
+		fmt.Printf("Restoring snapshot: adding route %s\n", key)
+		if err := netlink.RouteReplace(&route); err != nil {
+			return fmt.Errorf("failed to add route %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// readSysctls returns the value of each sysctl that exists
+func readSysctls(keys []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, key := range keys {
+		value, err := sysctlutils.Get(key)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (s *Snapshot) restoreSysctls() error {
+	keys := make([]string, 0, len(s.Sysctls))
+	for key := range s.Sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		current, err := sysctlutils.Get(key)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Restoring snapshot: sysctl %s no longer exists\n", key)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if current == s.Sysctls[key] {
+			continue
+		}
+		fmt.Printf("Restoring snapshot: setting sysctl %s to %s\n", key, s.Sysctls[key])
+		if err := sysctlutils.Set(key, s.Sysctls[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sysctlutils "hostplumber/pkg/utils/sysctl"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestRestoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	sysctlD := t.TempDir()
+	prevSysctlD := sysctlutils.SysctlD
+	sysctlutils.SysctlD = sysctlD
+	t.Cleanup(func() { sysctlutils.SysctlD = prevSysctlD })
+	Dirs = []string{dir, sysctlD}
+	write := func(rel, data string) {
+		t.Helper()
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0766); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConf := func(name, data string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(sysctlD, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("tmpl/ovs", "before")
+	write("tmpl/sysctl", "before")
+	write("sriov/eth0", "before")
+	writeConf("10-distro.conf", "before")
+
+	files, err := readFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("only tmpl/ovs and tmpl/sysctl are restorable, got %v", files)
+	}
+
+	write("tmpl/ovs", "after")
+	write("tmpl/eth1/vlans", "after")
+	write("tmpl/sysctl", "after")
+	write("sriov/eth0", "after")
+	writeConf("10-distro.conf", "after")
+	writeConf("90-hostplumber-tmpl.conf", "after")
+	writeConf("20-other.conf", "after")
+	if err := restoreFiles(files); err != nil {
+		t.Fatal(err)
+	}
+
+	for rel, want := range map[string]string{"tmpl/ovs": "before", "tmpl/sysctl": "before", "sriov/eth0": "after"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: got %q, want %q", rel, data, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tmpl/eth1/vlans")); !os.IsNotExist(err) {
+		t.Errorf("file created after the snapshot not removed")
+	}
+	if _, err := os.Stat(filepath.Join(sysctlD, "90-hostplumber-tmpl.conf")); !os.IsNotExist(err) {
+		t.Errorf("sysctl.d file written after the snapshot not removed")
+	}
+	for _, name := range []string{"10-distro.conf", "20-other.conf"} {
+		if data, err := ioutil.ReadFile(filepath.Join(sysctlD, name)); err != nil || string(data) != "after" {
+			t.Errorf("%s not written by hostplumber changed: %q, %v", name, data, err)
+		}
+	}
+}
+
+func TestRestoreSysctls(t *testing.T) {
+	procSys := t.TempDir()
+	prevProcSys := sysctlutils.ProcSys
+	sysctlutils.ProcSys = procSys
+	t.Cleanup(func() { sysctlutils.ProcSys = prevProcSys })
+	for key, value := range map[string]string{"net/ipv4/ip_forward": "0", "net/core/somaxconn": "4096"} {
+		path := filepath.Join(procSys, key)
+		if err := os.MkdirAll(filepath.Dir(path), 0766); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sysctls, err := readSysctls([]string{"net.ipv4.ip_forward", "net.core.somaxconn", "net.ipv4.conf.eth9.rp_filter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Snapshot{Sysctls: sysctls}
+	if len(s.Sysctls) != 2 {
+		t.Fatalf("sysctls of missing interfaces are left out, got %v", s.Sysctls)
+	}
+
+	if err := sysctlutils.Set("net.ipv4.ip_forward", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.restoreSysctls(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"net.ipv4.ip_forward": "0", "net.core.somaxconn": "4096"} {
+		if got, err := sysctlutils.Get(key); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", key, got, err, want)
+		}
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.1.0.0/16")
+	route := netlink.Route{Dst: dst, Gw: net.ParseIP("192.168.1.1"), Table: 254, Priority: 100}
+	if got, want := routeKey(route, "eth0"), "10.1.0.0/16 via 192.168.1.1 dev eth0 src <nil> table 254 metric 100"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := routeKey(netlink.Route{Gw: net.ParseIP("192.168.1.1"), Table: 254}, "br0"), "default via 192.168.1.1 dev br0 src <nil> table 254 metric 0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+package sriov
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"hostplumber/pkg/consts"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ProfilesDir is where the hostplumber-sriov-profiles ConfigMap is mounted.
+// Each key holds a YAML list of profiles, which are added to the built-in
+// ones, or replace the built-in profile of the same name.
+var ProfilesDir = consts.SriovProfiles
+
+// Profile describes the SR-IOV capabilities of a family of NICs
+type Profile struct {
+	// Name is the PF driver
+	Name     string `json:"name"`
+	VendorID string `json:"vendorId"`
+	// DeviceIDs are the PCI device IDs of the PFs
+	DeviceIDs []string `json:"deviceIds"`
+	// VfDriver is bound to VFs when the template does not set a vfDriver
+	VfDriver string `json:"vfDriver"`
+	// Switchdev is set when the eswitch must be in switchdev mode for VF
+	// representors and OVS hardware offload
+	Switchdev bool `json:"switchdev,omitempty"`
+	// MaxVfs is the firmware limit of VFs per PF, 0 when only
+	// sriov_totalvfs limits it
+	MaxVfs int `json:"maxVfs,omitempty"`
+	// Rdma is set when the VFs can do RDMA
+	Rdma bool `json:"rdma,omitempty"`
+}
+
+// builtinProfiles are the NICs hostplumber knows about
+var builtinProfiles = []Profile{
+	{
+		Name:      "i40e",
+		VendorID:  "8086",
+		DeviceIDs: []string{"1572", "1574", "1580", "1581", "1583", "1584", "1585", "1586", "1587", "1588", "1589", "158a", "158b", "37d0", "37d1", "37d2", "37d3"},
+		VfDriver:  "iavf",
+		MaxVfs:    128,
+		Rdma:      true,
+	},
+	{
+		Name:      "ice",
+		VendorID:  "8086",
+		DeviceIDs: []string{"1591", "1592", "1593", "1599", "159b", "188a", "188b", "188c", "188d", "188e"},
+		VfDriver:  "iavf",
+		Switchdev: true,
+		MaxVfs:    256,
+		Rdma:      true,
+	},
+	{
+		Name:      "ixgbe",
+		VendorID:  "8086",
+		DeviceIDs: []string{"10fb", "10f8", "1528", "1560", "1563", "15ab", "15ad", "15c8"},
+		VfDriver:  "ixgbevf",
+		MaxVfs:    63,
+	},
+	{
+		Name:      "mlx5_core",
+		VendorID:  "15b3",
+		DeviceIDs: []string{"1013", "1015", "1017", "1019", "101b", "101d", "101f", "1021"},
+		VfDriver:  "mlx5_core",
+		Switchdev: true,
+		MaxVfs:    127,
+		Rdma:      true,
+	},
+	{
+		Name:      "bnxt_en",
+		VendorID:  "14e4",
+		DeviceIDs: []string{"16d6", "16d7", "16d8", "1750", "1751", "1752"},
+		VfDriver:  "bnxt_en",
+		MaxVfs:    128,
+		Rdma:      true,
+	},
+}
+
+// GetProfiles returns the built-in profiles, overridden and extended by the
+// profiles of the ConfigMap
+func GetProfiles() ([]Profile, error) {
+	profiles := make(map[string]Profile)
+	for _, profile := range builtinProfiles {
+		profiles[profile.Name] = profile
+	}
+
+	files, err := ioutil.ReadDir(ProfilesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, file := range files {
+		// ConfigMap volumes hold the keys as symlinks, and ..data directories
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(ProfilesDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var custom []Profile
+		if err := yaml.Unmarshal(data, &custom); err != nil {
+			return nil, fmt.Errorf("invalid SR-IOV profiles in %s: %w", file.Name(), err)
+		}
+		for _, profile := range custom {
+			if profile.Name == "" || profile.VendorID == "" {
+				return nil, fmt.Errorf("SR-IOV profile in %s needs a name and a vendorId", file.Name())
+			}
+			profiles[profile.Name] = profile
+		}
+	}
+
+	list := make([]Profile, 0, len(profiles))
+	for _, profile := range profiles {
+		list = append(list, profile)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// FindProfile returns the profile of a PF by its PCI vendor and device ID, or
+// nil if there is none
+func FindProfile(vendor, device string) (*Profile, error) {
+	profiles, err := GetProfiles()
+	if err != nil {
+		return nil, err
+	}
+	for i := range profiles {
+		if profiles[i].matches(vendor, device) {
+			return &profiles[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetProfileForPf returns the profile of the PF, or nil if there is none
+func GetProfileForPf(pfName string) (*Profile, error) {
+	vendor, device, err := GetPciIdsForIf(pfName)
+	if err != nil {
+		return nil, err
+	}
+	return FindProfile(vendor, device)
+}
+
+func (p Profile) matches(vendor, device string) bool {
+	if !strings.EqualFold(p.VendorID, vendor) {
+		return false
+	}
+	for _, id := range p.DeviceIDs {
+		if strings.EqualFold(id, device) {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownPf returns whether a profile lists the vendor and device ID
+func isKnownPf(profiles []Profile, vendor, device string) bool {
+	for _, profile := range profiles {
+		if profile.matches(vendor, device) {
+			return true
+		}
+	}
+	return false
+}
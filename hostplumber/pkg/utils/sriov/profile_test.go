@@ -0,0 +1,69 @@
+package sriov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindProfile(t *testing.T) {
+	ProfilesDir = t.TempDir()
+	profile, err := FindProfile("15b3", "101D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile == nil || profile.Name != "mlx5_core" || !profile.Switchdev || profile.VfDriver != "mlx5_core" {
+		t.Errorf("unexpected profile for a ConnectX-6 Dx: %+v", profile)
+	}
+	if profile, _ := FindProfile("8086", "154c"); profile != nil {
+		t.Errorf("a VF device ID matched profile %s", profile.Name)
+	}
+}
+
+func TestProfilesFromConfigMap(t *testing.T) {
+	ProfilesDir = t.TempDir()
+	// Keys of a mounted ConfigMap are symlinks into a ..data directory
+	dataDir := filepath.Join(ProfilesDir, "..data")
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	custom := `
+- name: ixgbe
+  vendorId: "8086"
+  deviceIds: ["10fb"]
+  vfDriver: vfio-pci
+  maxVfs: 32
+- name: qede
+  vendorId: "1077"
+  deviceIds: ["1656"]
+  vfDriver: qede
+`
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "profiles.yaml"), []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "profiles.yaml"), filepath.Join(ProfilesDir, "profiles.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := FindProfile("8086", "10fb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile == nil || profile.VfDriver != "vfio-pci" || profile.MaxVfs != 32 {
+		t.Errorf("built-in profile not overridden: %+v", profile)
+	}
+	if profile, _ := FindProfile("8086", "1528"); profile != nil {
+		t.Errorf("the overridden profile still lists device 1528")
+	}
+	if profile, _ := FindProfile("1077", "1656"); profile == nil || profile.VfDriver != "qede" {
+		t.Errorf("new profile not added: %+v", profile)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "profiles.yaml"), []byte("- vfDriver: foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetProfiles(); err == nil {
+		t.Errorf("profile without a name accepted")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 )
@@ -113,6 +114,13 @@ func CreateVfsForPfName(pfName string, numVfs int) error {
 	if numVfs > totalVfs {
 		return errors.NewBadRequest("Can't create more VFs than total supported by PF")
 	}
+	profile, err := GetProfileForPf(pfName)
+	if err != nil {
+		return err
+	}
+	if profile != nil && profile.MaxVfs > 0 && numVfs > profile.MaxVfs {
+		return errors.NewBadRequest(fmt.Sprintf("Can't create more than %d VFs on a %s PF", profile.MaxVfs, profile.Name))
+	}
 
 	currVfs, err := GetCurrentNumVfsForPf(pfName)
 	if err != nil {
@@ -247,62 +255,39 @@ func EnableDriverForVfs(pfName string, driver string) error {
 	return err
 }
 
+// GetPfListForVendorAndDevice returns the PFs matching the vendor and device
+// ID. Without a device ID, it returns the PFs of the vendor with an SR-IOV
+// profile. VFs are never returned.
 func GetPfListForVendorAndDevice(vendor, device string) ([]string, error) {
-	// This function will return any IF that matches vendor and device ID
-	// If optional PCI address is given, it must match
+	profiles, err := GetProfiles()
+	if err != nil {
+		return nil, err
+	}
+	links, err := ioutil.ReadDir(consts.SysClassNet)
+	if err != nil {
+		return nil, err
+	}
 	var matchingPfs []string
-	err := filepath.Walk(consts.SysClassNet, func(path string, info os.FileInfo, err error) error {
-		ifName := info.Name()
-		// Everything in this directory is a symlink
-		ifPath, _ := filepath.EvalSymlinks(path)
-		if ifPath == consts.SysClassNet {
-			// Skip the root /sys/class/net folder
-			return nil
-		}
+	for _, link := range links {
+		ifName := link.Name()
 		if ifName == "lo" {
-			return nil
-		}
-
-		devicePathLink := filepath.Join(ifPath, "device")
-		devicePath, _ := filepath.EvalSymlinks(devicePathLink)
-		deviceIdFile := filepath.Join(devicePath, "device")
-
-		if _, err := os.Stat(deviceIdFile); err != nil {
-			return nil
-		}
-		fd, err := os.Open(deviceIdFile)
-		if err != nil {
-			fd.Close()
-			return err
+			continue
 		}
-		var deviceId string
-		_, err = fmt.Fscanf(fd, "0x%s\n", &deviceId)
+		vendorId, deviceId, err := GetPciIdsForIf(ifName)
 		if err != nil {
-			fd.Close()
-			return err
+			// Not a PCI device
+			continue
 		}
-		fd.Close()
-
-		vendorIdFile := filepath.Join(devicePath, "vendor")
-		if _, err := os.Stat(vendorIdFile); err != nil {
-			return nil
+		if _, isVf := GetPfDeviceForVf(filepath.Join(consts.SysClassNet, ifName, "device")); isVf {
+			continue
 		}
-		fd, err = os.Open(vendorIdFile)
-		defer fd.Close()
-		if err != nil {
-			return err
+		if vendor != vendorId {
+			continue
 		}
-		var vendorId string
-		_, err = fmt.Fscanf(fd, "0x%s\n", &vendorId)
-		if err != nil {
-			return err
-		}
-
-		if vendor == vendorId && device == deviceId {
+		if device == deviceId || (device == "" && isKnownPf(profiles, vendorId, deviceId)) {
 			matchingPfs = append(matchingPfs, ifName)
 		}
-		return nil
-	})
+	}
 
 	if len(matchingPfs) == 0 {
 		err = errors.NewBadRequest("Failed to find any devices matching vendor and device ID")
@@ -310,6 +295,21 @@ func GetPfListForVendorAndDevice(vendor, device string) ([]string, error) {
 	return matchingPfs, err
 }
 
+// GetPciIdsForIf returns the PCI vendor and device ID of the device backing a
+// network interface, without 0x
+func GetPciIdsForIf(ifName string) (string, string, error) {
+	devicePath := filepath.Join(consts.SysClassNet, ifName, "device")
+	var ids []string
+	for _, file := range []string{"vendor", "device"} {
+		id, err := ioutil.ReadFile(filepath.Join(devicePath, file))
+		if err != nil {
+			return "", "", err
+		}
+		ids = append(ids, strings.TrimPrefix(strings.TrimSpace(string(id)), "0x"))
+	}
+	return ids[0], ids[1], nil
+}
+
 func GetPfNameForPciAddr(pciAddr string) (string, error) {
 	var matchingPf string
 	err := filepath.Walk(consts.SysClassNet, func(path string, info os.FileInfo, err error) error {
@@ -343,3 +343,12 @@ func GetPfNameForPciAddr(pciAddr string) (string, error) {
 	}
 	return matchingPf, err
 }
+
+// GetPciAddrForIf returns the PCI address of the device backing a network interface
+func GetPciAddrForIf(ifName string) (string, error) {
+	devicePath, err := filepath.EvalSymlinks(filepath.Join(consts.SysClassNet, ifName, "device"))
+	if err != nil {
+		return "", fmt.Errorf("failed to find PCI device of %s: %w", ifName, err)
+	}
+	return filepath.Base(devicePath), nil
+}
@@ -0,0 +1,216 @@
+package sriov
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"hostplumber/pkg/consts"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Eswitch modes of a PF, set through devlink
+const (
+	EswitchModeLegacy    = "legacy"
+	EswitchModeSwitchdev = "switchdev"
+)
+
+const hwTcOffload = "hw-tc-offload"
+
+// repPortName matches the phys_port_name of a VF representor, like pf0vf3 or
+// c1pf0vf3 on multi host NICs
+var repPortName = regexp.MustCompile(`^(?:c\d+)?pf(\d+)vf(\d+)$`)
+
+func devlinkDevice(pfName string) (*netlink.DevlinkDevice, error) {
+	pciAddr, err := GetPciAddrForIf(pfName)
+	if err != nil {
+		return nil, err
+	}
+	dev, err := netlink.DevLinkGetDeviceByName("pci", pciAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devlink device of %s: %w", pfName, err)
+	}
+	return dev, nil
+}
+
+// GetEswitchMode returns the eswitch mode of the PF, empty when its driver
+// has no eswitch
+func GetEswitchMode(pfName string) (string, error) {
+	dev, err := devlinkDevice(pfName)
+	if err != nil {
+		return "", err
+	}
+	return dev.Attrs.Eswitch.Mode, nil
+}
+
+// SetEswitchMode changes the eswitch mode of the PF. The VFs are unbound
+// while the mode changes, then bound back to their driver.
+func SetEswitchMode(pfName, mode string) error {
+	dev, err := devlinkDevice(pfName)
+	if err != nil {
+		return err
+	}
+	if dev.Attrs.Eswitch.Mode == mode {
+		return nil
+	}
+	if dev.Attrs.Eswitch.Mode == "" {
+		return fmt.Errorf("PF %s has no eswitch to set to %s", pfName, mode)
+	}
+
+	devicePath, err := filepath.EvalSymlinks(filepath.Join(consts.SysClassNet, pfName, "device"))
+	if err != nil {
+		return err
+	}
+	vfs, err := filepath.Glob(filepath.Join(devicePath, "virtfn*"))
+	if err != nil {
+		return err
+	}
+	drivers := make(map[string]string)
+	for _, vf := range vfs {
+		vfPath, err := filepath.EvalSymlinks(vf)
+		if err != nil {
+			return err
+		}
+		driverPath, err := filepath.EvalSymlinks(filepath.Join(vfPath, "driver"))
+		if err != nil {
+			// Not bound
+			continue
+		}
+		if err := UnbindOldDriver(vfPath); err != nil {
+			return fmt.Errorf("failed to unbind VF %s: %w", filepath.Base(vfPath), err)
+		}
+		drivers[vfPath] = filepath.Base(driverPath)
+	}
+
+	fmt.Printf("Setting eswitch mode of %s from %s to %s\n", pfName, dev.Attrs.Eswitch.Mode, mode)
+	modeErr := netlink.DevLinkSetEswitchMode(dev, mode)
+	for vfPath, driver := range drivers {
+		if err := BindNewDriver(vfPath, driver); err != nil && modeErr == nil {
+			modeErr = fmt.Errorf("failed to bind VF %s back to %s: %w", filepath.Base(vfPath), driver, err)
+		}
+	}
+	if modeErr != nil {
+		return fmt.Errorf("failed to set eswitch mode of %s to %s: %w", pfName, mode, modeErr)
+	}
+	return nil
+}
+
+// GetHwTcOffload returns whether TC flower rules are offloaded to the NIC. It
+// runs ethtool, which the image must include.
+func GetHwTcOffload(ifName string) (bool, error) {
+	out, err := exec.Command("ethtool", "-k", ifName).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("ethtool -k %s failed: %s: %w", ifName, strings.TrimSpace(string(out)), err)
+	}
+	return parseFeature(string(out), hwTcOffload)
+}
+
+// SetHwTcOffload turns the hw-tc-offload feature of the interface on or off
+func SetHwTcOffload(ifName string, on bool) error {
+	current, err := GetHwTcOffload(ifName)
+	if err != nil {
+		return err
+	}
+	if current == on {
+		return nil
+	}
+	state := "off"
+	if on {
+		state = "on"
+	}
+	fmt.Printf("Setting %s %s on %s\n", hwTcOffload, state, ifName)
+	out, err := exec.Command("ethtool", "-K", ifName, hwTcOffload, state).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ethtool -K %s %s %s failed: %s: %w", ifName, hwTcOffload, state, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// parseFeature returns the state of a feature in the output of ethtool -k,
+// lines like "hw-tc-offload: on [fixed]"
+func parseFeature(out, feature string) (bool, error) {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		name, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found || name != feature {
+			continue
+		}
+		fields := strings.Fields(value)
+		return len(fields) > 0 && fields[0] == "on", nil
+	}
+	return false, fmt.Errorf("ethtool does not report %s", feature)
+}
+
+func readNetAttr(ifName, attr string) (string, error) {
+	value, err := ioutil.ReadFile(filepath.Join(consts.SysClassNet, ifName, attr))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// parseRepPortName returns the PF and VF number of a VF representor port name
+func parseRepPortName(portName string) (int, int, bool) {
+	match := repPortName.FindStringSubmatch(portName)
+	if match == nil {
+		return 0, 0, false
+	}
+	pf, _ := strconv.Atoi(match[1])
+	vf, _ := strconv.Atoi(match[2])
+	return pf, vf, true
+}
+
+// IsVfRepresentor returns whether the interface is the representor of a VF
+func IsVfRepresentor(ifName string) bool {
+	portName, err := readNetAttr(ifName, "phys_port_name")
+	if err != nil {
+		return false
+	}
+	_, _, ok := parseRepPortName(portName)
+	return ok
+}
+
+// GetVfRepresentors returns the representor netdev of each VF of a PF in
+// switchdev mode, by VF ID. Representors share the phys_switch_id of their PF.
+func GetVfRepresentors(pfName string) (map[int]string, error) {
+	switchID, err := readNetAttr(pfName, "phys_switch_id")
+	if err != nil || switchID == "" {
+		return nil, fmt.Errorf("PF %s has no switch ID, its eswitch is not in switchdev mode", pfName)
+	}
+	// The uplink representor, the PF, is named p<pf number>
+	pfNum := -1
+	if portName, err := readNetAttr(pfName, "phys_port_name"); err == nil && strings.HasPrefix(portName, "p") {
+		if n, err := strconv.Atoi(portName[1:]); err == nil {
+			pfNum = n
+		}
+	}
+
+	links, err := ioutil.ReadDir(consts.SysClassNet)
+	if err != nil {
+		return nil, err
+	}
+	reps := make(map[int]string)
+	for _, link := range links {
+		ifName := link.Name()
+		if ifName == pfName {
+			continue
+		}
+		if id, err := readNetAttr(ifName, "phys_switch_id"); err != nil || id != switchID {
+			continue
+		}
+		portName, err := readNetAttr(ifName, "phys_port_name")
+		if err != nil {
+			continue
+		}
+		if pf, vf, ok := parseRepPortName(portName); ok && (pfNum < 0 || pf == pfNum) {
+			reps[vf] = ifName
+		}
+	}
+	return reps, nil
+}
@@ -0,0 +1,42 @@
+package sriov
+
+import "testing"
+
+func TestParseFeature(t *testing.T) {
+	out := `Features for enp59s0f0np0:
+rx-checksumming: on
+tx-checksumming: on
+	tx-checksum-ipv4: off [fixed]
+hw-tc-offload: on
+esp-hw-offload: off [fixed]
+`
+	if on, err := parseFeature(out, "hw-tc-offload"); err != nil || !on {
+		t.Errorf("hw-tc-offload: got %v, %v", on, err)
+	}
+	if on, err := parseFeature(out, "tx-checksum-ipv4"); err != nil || on {
+		t.Errorf("tx-checksum-ipv4: got %v, %v", on, err)
+	}
+	if _, err := parseFeature(out, "rx-gro-hw"); err == nil {
+		t.Errorf("missing feature found")
+	}
+}
+
+func TestParseRepPortName(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		pf, vf int
+		ok     bool
+	}{
+		{"pf0vf3", 0, 3, true},
+		{"pf1vf12", 1, 12, true},
+		{"c1pf0vf7", 0, 7, true},
+		{"p0", 0, 0, false},
+		{"pf0sf1", 0, 0, false},
+		{"", 0, 0, false},
+	} {
+		pf, vf, ok := parseRepPortName(tc.name)
+		if ok != tc.ok || pf != tc.pf || vf != tc.vf {
+			t.Errorf("%q: got pf %d vf %d %v", tc.name, pf, vf, ok)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package sriov
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// VfSettings are the settings of a VF the PF driver applies. Nil fields and an
+// empty Mac or LinkState are left as they are.
+type VfSettings struct {
+	Vlan     *int
+	Qos      *int
+	Mac      string
+	Trust    *bool
+	Spoofchk *bool
+	// MinTxRate and MaxTxRate are in Mbps, 0 means no limit
+	MinTxRate *int
+	MaxTxRate *int
+	// LinkState is auto, enable or disable
+	LinkState string
+}
+
+var linkStates = map[string]uint32{
+	"auto":    nl.IFLA_VF_LINK_STATE_AUTO,
+	"enable":  nl.IFLA_VF_LINK_STATE_ENABLE,
+	"disable": nl.IFLA_VF_LINK_STATE_DISABLE,
+}
+
+// LinkStateString returns the name of a VF link state
+func LinkStateString(state uint32) string {
+	for name, value := range linkStates {
+		if value == state {
+			return name
+		}
+	}
+	return strconv.Itoa(int(state))
+}
+
+// ParseVfRange returns the VF IDs of a range "first-last", or of a single ID,
+// checking they exist on a PF with numVfs VFs
+func ParseVfRange(vfRange string, numVfs int) ([]int, error) {
+	first, last := vfRange, vfRange
+	if i := strings.Index(vfRange, "-"); i >= 0 {
+		first, last = vfRange[:i], vfRange[i+1:]
+	}
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VF range %q", vfRange)
+	}
+	to, err := strconv.Atoi(last)
+	if err != nil || from < 0 || to < from {
+		return nil, fmt.Errorf("invalid VF range %q", vfRange)
+	}
+	if to >= numVfs {
+		return nil, fmt.Errorf("VF range %q exceeds the %d VFs of the PF", vfRange, numVfs)
+	}
+	ids := make([]int, 0, to-from+1)
+	for id := from; id <= to; id++ {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s VfSettings) validate(numIds int) error {
+	if s.Mac != "" {
+		if numIds > 1 {
+			return fmt.Errorf("a MAC can only be set on a single VF")
+		}
+		if _, err := net.ParseMAC(s.Mac); err != nil {
+			return err
+		}
+	}
+	if s.Qos != nil && *s.Qos != 0 && s.Vlan == nil {
+		return fmt.Errorf("qos needs a vlan")
+	}
+	if s.LinkState != "" {
+		if _, ok := linkStates[s.LinkState]; !ok {
+			return fmt.Errorf("invalid VF link state %q", s.LinkState)
+		}
+	}
+	if s.MinTxRate != nil && s.MaxTxRate != nil && *s.MaxTxRate != 0 && *s.MinTxRate > *s.MaxTxRate {
+		return fmt.Errorf("minTxRate %d is above maxTxRate %d", *s.MinTxRate, *s.MaxTxRate)
+	}
+	return nil
+}
+
+// ConfigureVfs applies the settings to the VFs of the PF, only changing what
+// differs from the current state. Trust is not reported by netlink, so it is
+// always set.
+func ConfigureVfs(pfName string, ids []int, settings VfSettings) error {
+	if err := settings.validate(len(ids)); err != nil {
+		return fmt.Errorf("PF %s VFs %v: %w", pfName, ids, err)
+	}
+	pf, err := netlink.LinkByName(pfName)
+	if err != nil {
+		return err
+	}
+	current := make(map[int]netlink.VfInfo)
+	for _, vf := range pf.Attrs().Vfs {
+		current[vf.ID] = vf
+	}
+	for _, id := range ids {
+		vf, ok := current[id]
+		if !ok {
+			return fmt.Errorf("PF %s has no VF %d", pfName, id)
+		}
+		if err := configureVf(pf, vf, settings); err != nil {
+			return fmt.Errorf("PF %s VF %d: %w", pfName, id, err)
+		}
+	}
+	return nil
+}
+
+func configureVf(pf netlink.Link, vf netlink.VfInfo, s VfSettings) error {
+	if s.Vlan != nil {
+		qos := vf.Qos
+		if s.Qos != nil {
+			qos = *s.Qos
+		}
+		if vf.Vlan != *s.Vlan || vf.Qos != qos {
+			if err := netlink.LinkSetVfVlanQos(pf, vf.ID, *s.Vlan, qos); err != nil {
+				return fmt.Errorf("failed to set vlan %d qos %d: %w", *s.Vlan, qos, err)
+			}
+		}
+	}
+	if s.Mac != "" {
+		mac, _ := net.ParseMAC(s.Mac)
+		if vf.Mac.String() != mac.String() {
+			if err := netlink.LinkSetVfHardwareAddr(pf, vf.ID, mac); err != nil {
+				return fmt.Errorf("failed to set mac %s: %w", s.Mac, err)
+			}
+		}
+	}
+	if s.Spoofchk != nil && vf.Spoofchk != *s.Spoofchk {
+		if err := netlink.LinkSetVfSpoofchk(pf, vf.ID, *s.Spoofchk); err != nil {
+			return fmt.Errorf("failed to set spoofchk: %w", err)
+		}
+	}
+	if s.Trust != nil {
+		if err := netlink.LinkSetVfTrust(pf, vf.ID, *s.Trust); err != nil {
+			return fmt.Errorf("failed to set trust: %w", err)
+		}
+	}
+	if s.MinTxRate != nil || s.MaxTxRate != nil {
+		minRate, maxRate := int(vf.MinTxRate), int(vf.MaxTxRate)
+		if s.MinTxRate != nil {
+			minRate = *s.MinTxRate
+		}
+		if s.MaxTxRate != nil {
+			maxRate = *s.MaxTxRate
+		}
+		if uint32(minRate) != vf.MinTxRate || uint32(maxRate) != vf.MaxTxRate {
+			if err := netlink.LinkSetVfRate(pf, vf.ID, minRate, maxRate); err != nil {
+				return fmt.Errorf("failed to set tx rate %d-%d: %w", minRate, maxRate, err)
+			}
+		}
+	}
+	if s.LinkState != "" && vf.LinkState != linkStates[s.LinkState] {
+		if err := netlink.LinkSetVfState(pf, vf.ID, linkStates[s.LinkState]); err != nil {
+			return fmt.Errorf("failed to set link state %s: %w", s.LinkState, err)
+		}
+	}
+	return nil
+}
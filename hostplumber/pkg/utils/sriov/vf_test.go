@@ -0,0 +1,53 @@
+package sriov
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVfRange(t *testing.T) {
+	for vfRange, want := range map[string][]int{
+		"3":   {3},
+		"0-3": {0, 1, 2, 3},
+		"7-7": {7},
+	} {
+		got, err := ParseVfRange(vfRange, 8)
+		if err != nil {
+			t.Errorf("%s: %v", vfRange, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", vfRange, got, want)
+		}
+	}
+
+	for _, vfRange := range []string{"", "a", "3-1", "-1", "0-8", "8", "1-"} {
+		if ids, err := ParseVfRange(vfRange, 8); err == nil {
+			t.Errorf("%q accepted as %v", vfRange, ids)
+		}
+	}
+}
+
+func TestVfSettingsValidate(t *testing.T) {
+	vlan, qos, minRate, maxRate := 100, 3, 1000, 500
+	for name, test := range map[string]struct {
+		settings VfSettings
+		numIds   int
+		valid    bool
+	}{
+		"vlan qos":         {VfSettings{Vlan: &vlan, Qos: &qos}, 4, true},
+		"mac":              {VfSettings{Mac: "02:00:00:00:00:01"}, 1, true},
+		"mac on a range":   {VfSettings{Mac: "02:00:00:00:00:01"}, 2, false},
+		"invalid mac":      {VfSettings{Mac: "02:00:00"}, 1, false},
+		"qos without vlan": {VfSettings{Qos: &qos}, 1, false},
+		"link state":       {VfSettings{LinkState: "disable"}, 1, true},
+		"bad link state":   {VfSettings{LinkState: "down"}, 1, false},
+		"min above max":    {VfSettings{MinTxRate: &minRate, MaxTxRate: &maxRate}, 1, false},
+	} {
+		if err := test.settings.validate(test.numIds); (err == nil) != test.valid {
+			t.Errorf("%s: valid = %v, err = %v", name, test.valid, err)
+		}
+	}
+
+	if got := LinkStateString(2); got != "disable" {
+		t.Errorf("LinkStateString(2) = %s", got)
+	}
+}
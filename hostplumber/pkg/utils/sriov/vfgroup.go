@@ -0,0 +1,140 @@
+package sriov
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"hostplumber/pkg/consts"
+)
+
+// StateDir holds the VF groups saved per PF, under sriov/
+var StateDir = consts.HostPlumberCfg
+
+// VfGroup is a range of VFs of a PF bound to one driver, which the SR-IOV
+// device plugin can advertise under one resource name
+type VfGroup struct {
+	VfRange      string `json:"vfRange"`
+	Ids          []int  `json:"-"`
+	VfDriver     string `json:"vfDriver"`
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// VfDrivers returns the driver of each VF of a PF with numVfs VFs: the driver
+// of its group, or defaultDriver. A VF can only be in one group.
+func VfDrivers(numVfs int, defaultDriver string, groups []VfGroup) ([]string, error) {
+	drivers := make([]string, numVfs)
+	group := make([]string, numVfs)
+	for _, g := range groups {
+		for _, id := range g.Ids {
+			if id < 0 || id >= numVfs {
+				return nil, fmt.Errorf("VF %d of range %s does not exist", id, g.VfRange)
+			}
+			if group[id] != "" {
+				return nil, fmt.Errorf("VF %d is in ranges %s and %s", id, group[id], g.VfRange)
+			}
+			group[id] = g.VfRange
+			drivers[id] = g.VfDriver
+		}
+	}
+	for id := range drivers {
+		if drivers[id] == "" {
+			drivers[id] = defaultDriver
+		}
+	}
+	return drivers, nil
+}
+
+// EnableDriversForVfs binds each VF of the PF to its driver, drivers being
+// indexed by VF ID. VFs already bound to their driver, or without a driver,
+// are left alone.
+func EnableDriversForVfs(pfName string, drivers []string) error {
+	devicePath, err := filepath.EvalSymlinks(filepath.Join(consts.SysClassNet, pfName, "device"))
+	if err != nil {
+		return err
+	}
+	for id, driver := range drivers {
+		if driver == "" {
+			continue
+		}
+		vfPath, err := filepath.EvalSymlinks(filepath.Join(devicePath, fmt.Sprintf("virtfn%d", id)))
+		if err != nil {
+			return fmt.Errorf("PF %s has no VF %d: %w", pfName, id, err)
+		}
+		if isDriverSetOnVf(vfPath, driver) {
+			continue
+		}
+		fmt.Printf("Setting VF driver %s for VF %d of %s\n", driver, id, pfName)
+		if err := SetDriverForVf(vfPath, driver); err != nil {
+			return fmt.Errorf("failed to bind VF %d of %s to %s: %w", id, pfName, driver, err)
+		}
+	}
+	return nil
+}
+
+// VfDriverChanges returns the VFs of the PF bound to another driver than the
+// one at their index in drivers. VFs without a driver in drivers are left out.
+func VfDriverChanges(pfName string, drivers []string) ([]int, error) {
+	devicePath, err := filepath.EvalSymlinks(filepath.Join(consts.SysClassNet, pfName, "device"))
+	if err != nil {
+		return nil, err
+	}
+	var changed []int
+	for id, driver := range drivers {
+		if driver == "" {
+			continue
+		}
+		vfPath, err := filepath.EvalSymlinks(filepath.Join(devicePath, fmt.Sprintf("virtfn%d", id)))
+		if err != nil {
+			return nil, fmt.Errorf("PF %s has no VF %d: %w", pfName, id, err)
+		}
+		current := ""
+		if driverPath, err := filepath.EvalSymlinks(filepath.Join(vfPath, "driver")); err == nil {
+			current = filepath.Base(driverPath)
+		}
+		if current != driver {
+			changed = append(changed, id)
+		}
+	}
+	return changed, nil
+}
+
+func vfGroupsFile(pfName string) string {
+	return filepath.Join(StateDir, "sriov", pfName)
+}
+
+// SaveVfGroups saves the VF groups of a PF, reported in its SriovStatus
+func SaveVfGroups(pfName string, groups []VfGroup) error {
+	if len(groups) == 0 {
+		if err := os.Remove(vfGroupsFile(pfName)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(vfGroupsFile(pfName)), 0766); err != nil {
+		return err
+	}
+	data, err := json.Marshal(groups)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(vfGroupsFile(pfName), data, 0644)
+}
+
+// GetVfGroups returns the VF groups saved for a PF
+func GetVfGroups(pfName string) ([]VfGroup, error) {
+	data, err := ioutil.ReadFile(vfGroupsFile(pfName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var groups []VfGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("invalid saved VF groups of %s: %w", pfName, err)
+	}
+	return groups, nil
+}
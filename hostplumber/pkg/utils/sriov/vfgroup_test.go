@@ -0,0 +1,55 @@
+package sriov
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVfDrivers(t *testing.T) {
+	groups := []VfGroup{
+		{VfRange: "0-3", Ids: []int{0, 1, 2, 3}, VfDriver: "vfio-pci"},
+		{VfRange: "6", Ids: []int{6}, VfDriver: "iavf"},
+	}
+	drivers, err := VfDrivers(8, "iavf", groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"vfio-pci", "vfio-pci", "vfio-pci", "vfio-pci", "iavf", "iavf", "iavf", "iavf"}
+	if !reflect.DeepEqual(drivers, want) {
+		t.Errorf("VfDrivers = %v, want %v", drivers, want)
+	}
+
+	overlapping := append(groups, VfGroup{VfRange: "3-4", Ids: []int{3, 4}, VfDriver: "iavf"})
+	if _, err := VfDrivers(8, "iavf", overlapping); err == nil {
+		t.Errorf("overlapping ranges accepted")
+	}
+}
+
+func TestSaveVfGroups(t *testing.T) {
+	StateDir = t.TempDir()
+	groups := []VfGroup{
+		{VfRange: "0-3", Ids: []int{0, 1, 2, 3}, VfDriver: "vfio-pci", ResourceName: "intel_sriov_dpdk"},
+		{VfRange: "4-7", Ids: []int{4, 5, 6, 7}, VfDriver: "iavf", ResourceName: "intel_sriov_netdevice"},
+	}
+	if err := SaveVfGroups("eno2", groups); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := GetVfGroups("eno2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IDs are not saved, the range is enough
+	for i := range groups {
+		groups[i].Ids = nil
+	}
+	if !reflect.DeepEqual(saved, groups) {
+		t.Errorf("GetVfGroups = %+v, want %+v", saved, groups)
+	}
+
+	if err := SaveVfGroups("eno2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := GetVfGroups("eno2"); err != nil || saved != nil {
+		t.Errorf("groups not removed: %+v %v", saved, err)
+	}
+}
@@ -0,0 +1,342 @@
+package sysctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"hostplumber/pkg/consts"
+)
+
+// Set to other directories by tests
+var (
+	ProcSys  = consts.ProcSys
+	SysctlD  = consts.SysctlD
+	StateDir = consts.HostStateDir
+)
+
+// allowed are the sysctls a template can set, * matches an interface name or
+// "all" and "default"
+var allowed = []string{
+	"net.core.netdev_max_backlog",
+	"net.core.optmem_max",
+	"net.core.rmem_default",
+	"net.core.rmem_max",
+	"net.core.somaxconn",
+	"net.core.wmem_default",
+	"net.core.wmem_max",
+	"net.ipv4.ip_forward",
+	"net.ipv4.ip_local_port_range",
+	"net.ipv4.tcp_rmem",
+	"net.ipv4.tcp_wmem",
+	"net.ipv4.conf.*.accept_local",
+	"net.ipv4.conf.*.accept_redirects",
+	"net.ipv4.conf.*.arp_announce",
+	"net.ipv4.conf.*.arp_filter",
+	"net.ipv4.conf.*.arp_ignore",
+	"net.ipv4.conf.*.forwarding",
+	"net.ipv4.conf.*.proxy_arp",
+	"net.ipv4.conf.*.rp_filter",
+	"net.ipv4.conf.*.send_redirects",
+	"net.ipv4.neigh.*.gc_thresh1",
+	"net.ipv4.neigh.*.gc_thresh2",
+	"net.ipv4.neigh.*.gc_thresh3",
+	"net.ipv6.conf.*.accept_ra",
+	"net.ipv6.conf.*.accept_redirects",
+	"net.ipv6.conf.*.autoconf",
+	"net.ipv6.conf.*.disable_ipv6",
+	"net.ipv6.conf.*.forwarding",
+	"net.ipv6.neigh.*.gc_thresh1",
+	"net.ipv6.neigh.*.gc_thresh2",
+	"net.ipv6.neigh.*.gc_thresh3",
+	"net.bridge.bridge-nf-call-arptables",
+	"net.bridge.bridge-nf-call-ip6tables",
+	"net.bridge.bridge-nf-call-iptables",
+}
+
+// Allowed returns whether a template can set the sysctl. As with the sysctl
+// command, a dot in an interface name is written as a slash, e.g.
+// net.ipv4.conf.eth0/100.rp_filter.
+func Allowed(key string) bool {
+	parts := strings.Split(key, ".")
+	for _, pattern := range allowed {
+		patternParts := strings.Split(pattern, ".")
+		if len(patternParts) != len(parts) {
+			continue
+		}
+		match := true
+		for i := range parts {
+			if parts[i] == "" || (patternParts[i] != "*" && patternParts[i] != parts[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// path returns the file of the sysctl under ProcSys
+func path(key string) string {
+	parts := strings.Split(key, ".")
+	for i := range parts {
+		parts[i] = strings.ReplaceAll(parts[i], "/", ".")
+	}
+	return filepath.Join(append([]string{ProcSys}, parts...)...)
+}
+
+// normalize makes values with several fields comparable, the kernel separates
+// them with tabs
+func normalize(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// Get returns the current value of the sysctl
+func Get(key string) (string, error) {
+	value, err := ioutil.ReadFile(path(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to read sysctl %s: %w", key, err)
+	}
+	return normalize(string(value)), nil
+}
+
+// Set writes the sysctl if its value differs
+func Set(key, value string) error {
+	current, err := Get(key)
+	if err != nil {
+		return err
+	}
+	if current == normalize(value) {
+		return nil
+	}
+	if err := ioutil.WriteFile(path(key), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to set sysctl %s=%s: %w", key, value, err)
+	}
+	return nil
+}
+
+func stateFile(templateName string) string {
+	return filepath.Join(StateDir, templateName, "sysctl")
+}
+
+// ConfFilePrefix starts the name of the sysctl.d files hostplumber writes
+const ConfFilePrefix = "90-hostplumber-"
+
+func confFile(templateName string) string {
+	return filepath.Join(SysctlD, ConfFilePrefix+templateName+".conf")
+}
+
+// GetManaged returns the sysctls a template set, with their value from
+// before it first set them
+func GetManaged(templateName string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(stateFile(templateName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	original := make(map[string]string)
+	if err := json.Unmarshal(data, &original); err != nil {
+		return nil, fmt.Errorf("invalid saved sysctls of template %s: %w", templateName, err)
+	}
+	return original, nil
+}
+
+func saveManaged(templateName string, original map[string]string) error {
+	if err := os.MkdirAll(filepath.Join(StateDir, templateName), 0766); err != nil {
+		return err
+	}
+	data, err := json.Marshal(original)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stateFile(templateName), data, 0644)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Apply sets the sysctls of a template and persists them in a sysctl.d file.
+// The value of each sysctl before the template first set it is saved, and
+// restored once the template no longer sets it. A sysctl another template
+// sets is rejected, as each would save the other's value as the original.
+func Apply(templateName string, want map[string]string) error {
+	for key := range want {
+		if !Allowed(key) {
+			return fmt.Errorf("sysctl %s is not allowed", key)
+		}
+	}
+
+	owners, err := owners(templateName)
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(want) {
+		if owner, set := owners[key]; set {
+			return fmt.Errorf("sysctl %s is already set by template %s", key, owner)
+		}
+	}
+
+	original, err := GetManaged(templateName)
+	if err != nil {
+		return err
+	}
+	if original == nil {
+		original = make(map[string]string)
+	}
+	// Save the original values before changing anything
+	for _, key := range sortedKeys(want) {
+		if _, saved := original[key]; saved {
+			continue
+		}
+		if original[key], err = Get(key); err != nil {
+			return err
+		}
+	}
+	if err := saveManaged(templateName, original); err != nil {
+		return err
+	}
+
+	for _, key := range sortedKeys(want) {
+		if err := Set(key, want[key]); err != nil {
+			return err
+		}
+	}
+	if err := persist(templateName, want); err != nil {
+		return err
+	}
+
+	for _, key := range sortedKeys(original) {
+		if _, wanted := want[key]; wanted {
+			continue
+		}
+		fmt.Printf("Restoring sysctl %s=%s no longer in template %s\n", key, original[key], templateName)
+		// The interface of a per interface sysctl may be gone
+		if err := Set(key, original[key]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		delete(original, key)
+		if err := saveManaged(templateName, original); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Plan returns the sysctls Apply would set and restore, without changing
+// anything. It fails like Apply for sysctls that are not allowed or that
+// another template sets.
+func Plan(templateName string, want map[string]string) ([]string, error) {
+	for key := range want {
+		if !Allowed(key) {
+			return nil, fmt.Errorf("sysctl %s is not allowed", key)
+		}
+	}
+	owners, err := owners(templateName)
+	if err != nil {
+		return nil, err
+	}
+	original, err := GetManaged(templateName)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	for _, key := range sortedKeys(want) {
+		if owner, set := owners[key]; set {
+			return changes, fmt.Errorf("sysctl %s is already set by template %s", key, owner)
+		}
+		current, err := Get(key)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// The interface of a per interface sysctl may not exist yet
+			changes = append(changes, fmt.Sprintf("set sysctl %s to %s", key, want[key]))
+		case err != nil:
+			return changes, err
+		case current != normalize(want[key]):
+			changes = append(changes, fmt.Sprintf("set sysctl %s from %s to %s", key, current, want[key]))
+		}
+	}
+	for _, key := range sortedKeys(original) {
+		if _, wanted := want[key]; !wanted {
+			changes = append(changes, fmt.Sprintf("restore sysctl %s to %s", key, original[key]))
+		}
+	}
+	return changes, nil
+}
+
+// persist writes the sysctl.d file of the template, applied at boot
+func persist(templateName string, want map[string]string) error {
+	if len(want) == 0 {
+		if err := os.Remove(confFile(templateName)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	lines := []string{"# Written by hostplumber for HostNetworkTemplate " + templateName}
+	for _, key := range sortedKeys(want) {
+		lines = append(lines, fmt.Sprintf("%s = %s", key, want[key]))
+	}
+	if err := os.MkdirAll(SysctlD, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(confFile(templateName), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// owners returns the template that sets each sysctl, but for templateName
+func owners(templateName string) (map[string]string, error) {
+	dirs, err := ioutil.ReadDir(StateDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	keys := make(map[string]string)
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == templateName {
+			continue
+		}
+		managed, err := GetManaged(dir.Name())
+		if err != nil {
+			return nil, err
+		}
+		for key := range managed {
+			keys[key] = dir.Name()
+		}
+	}
+	return keys, nil
+}
+
+// Effective returns "key=value" for each sysctl set by any template, with its
+// current value
+func Effective() ([]string, error) {
+	keys, err := owners("")
+	if err != nil {
+		return nil, err
+	}
+
+	var effective []string
+	for _, key := range sortedKeys(keys) {
+		value, err := Get(key)
+		if err != nil {
+			continue
+		}
+		effective = append(effective, key+"="+value)
+	}
+	return effective, nil
+}
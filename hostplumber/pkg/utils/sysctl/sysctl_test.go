@@ -0,0 +1,180 @@
+package sysctl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeHost points the package at a fake /proc/sys with the given values
+func fakeHost(t *testing.T, values map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+	ProcSys = filepath.Join(root, "proc/sys")
+	SysctlD = filepath.Join(root, "etc/sysctl.d")
+	StateDir = filepath.Join(root, "hostplumber")
+	for key, value := range values {
+		if err := os.MkdirAll(filepath.Dir(path(key)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path(key), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func mustGet(t *testing.T, key string) string {
+	t.Helper()
+	value, err := Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestAllowed(t *testing.T) {
+	for _, key := range []string{"net.ipv4.ip_forward", "net.ipv4.conf.all.rp_filter", "net.ipv4.conf.bond0/1000.arp_ignore", "net.core.rmem_max"} {
+		if !Allowed(key) {
+			t.Errorf("%s not allowed", key)
+		}
+	}
+	for _, key := range []string{"kernel.panic", "net.ipv4.conf.rp_filter", "net.ipv4.conf..rp_filter", "vm.swappiness"} {
+		if Allowed(key) {
+			t.Errorf("%s allowed", key)
+		}
+	}
+	if got := path("net.ipv4.conf.bond0/1000.rp_filter"); got != filepath.Join(ProcSys, "net/ipv4/conf/bond0.1000/rp_filter") {
+		t.Errorf("path = %s", got)
+	}
+}
+
+func TestApplyAndRevert(t *testing.T) {
+	fakeHost(t, map[string]string{
+		"net.ipv4.ip_forward":          "0",
+		"net.ipv4.conf.eth1.rp_filter": "1",
+		"net.ipv4.tcp_rmem":            "4096\t131072\t6291456",
+	})
+
+	want := map[string]string{
+		"net.ipv4.ip_forward":          "1",
+		"net.ipv4.conf.eth1.rp_filter": "2",
+		"net.ipv4.tcp_rmem":            "4096 131072 6291456",
+	}
+	if err := Apply("tmpl", want); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range want {
+		if got := mustGet(t, key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	conf, err := ioutil.ReadFile(confFile("tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConf := "# Written by hostplumber for HostNetworkTemplate tmpl\n" +
+		"net.ipv4.conf.eth1.rp_filter = 2\nnet.ipv4.ip_forward = 1\nnet.ipv4.tcp_rmem = 4096 131072 6291456\n"
+	if string(conf) != wantConf {
+		t.Errorf("sysctl.d file:\n%s\nwant:\n%s", conf, wantConf)
+	}
+	effective, err := Effective()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEffective := []string{"net.ipv4.conf.eth1.rp_filter=2", "net.ipv4.ip_forward=1", "net.ipv4.tcp_rmem=4096 131072 6291456"}
+	if !reflect.DeepEqual(effective, wantEffective) {
+		t.Errorf("Effective = %q, want %q", effective, wantEffective)
+	}
+
+	// Changing a value keeps the original from before the template
+	if err := Apply("tmpl", map[string]string{"net.ipv4.ip_forward": "0", "net.ipv4.conf.eth1.rp_filter": "0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply("tmpl", map[string]string{"net.ipv4.ip_forward": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, "net.ipv4.conf.eth1.rp_filter"); got != "1" {
+		t.Errorf("rp_filter not restored, got %s", got)
+	}
+
+	if err := Apply("tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, "net.ipv4.ip_forward"); got != "0" {
+		t.Errorf("ip_forward not restored, got %s", got)
+	}
+	if _, err := os.Stat(confFile("tmpl")); !os.IsNotExist(err) {
+		t.Errorf("sysctl.d file not removed: %v", err)
+	}
+	if managed, err := GetManaged("tmpl"); err != nil || len(managed) != 0 {
+		t.Errorf("sysctls still managed: %v %v", managed, err)
+	}
+}
+
+func TestApplyRejectsDisallowed(t *testing.T) {
+	fakeHost(t, map[string]string{"net.ipv4.ip_forward": "0"})
+	if err := Apply("tmpl", map[string]string{"net.ipv4.ip_forward": "1", "kernel.panic": "10"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := mustGet(t, "net.ipv4.ip_forward"); got != "0" {
+		t.Errorf("sysctl set although the template was rejected")
+	}
+}
+
+func TestApplyRejectsKeyOfOtherTemplate(t *testing.T) {
+	fakeHost(t, map[string]string{"net.ipv4.ip_forward": "0", "net.ipv4.conf.all.rp_filter": "1"})
+	if err := Apply("tmpl-a", map[string]string{"net.ipv4.ip_forward": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply("tmpl-b", map[string]string{"net.ipv4.ip_forward": "1", "net.ipv4.conf.all.rp_filter": "2"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := mustGet(t, "net.ipv4.conf.all.rp_filter"); got != "1" {
+		t.Errorf("sysctl set although the template was rejected")
+	}
+
+	// Free again once the first template no longer sets it
+	if err := Apply("tmpl-a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply("tmpl-b", map[string]string{"net.ipv4.ip_forward": "1"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	fakeHost(t, map[string]string{
+		"net.ipv4.ip_forward":          "0",
+		"net.ipv4.conf.eth1.rp_filter": "1",
+		"net.ipv4.tcp_rmem":            "4096\t131072\t6291456",
+	})
+	if err := Apply("tmpl", map[string]string{"net.ipv4.conf.eth1.rp_filter": "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Plan("tmpl", map[string]string{
+		"net.ipv4.ip_forward":             "1",
+		"net.ipv4.tcp_rmem":               "4096 131072 6291456",
+		"net.ipv4.conf.vlan10.arp_filter": "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"set sysctl net.ipv4.conf.vlan10.arp_filter to 1",
+		"set sysctl net.ipv4.ip_forward from 0 to 1",
+		"restore sysctl net.ipv4.conf.eth1.rp_filter to 1",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got %q, want %q", changes, want)
+	}
+	if got := mustGet(t, "net.ipv4.ip_forward"); got != "0" {
+		t.Errorf("planning set net.ipv4.ip_forward to %s", got)
+	}
+
+	if _, err := Plan("other", map[string]string{"net.ipv4.conf.eth1.rp_filter": "0"}); err == nil {
+		t.Errorf("expected the sysctl of template tmpl to be rejected")
+	}
+}